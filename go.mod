@@ -1,3 +1,10 @@
 module go-nc-client
 
 go 1.25.5
+
+require (
+	go.etcd.io/bbolt v1.4.3
+	golang.org/x/text v0.28.0
+)
+
+require golang.org/x/sys v0.29.0 // indirect
@@ -0,0 +1,58 @@
+// Package activation takes over the sockets systemd passes to services it
+// starts on demand (socket activation)
+package activation
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// listenFDsStart is the first file descriptor passed by systemd, after stdin, stdout and stderr
+const listenFDsStart = 3
+
+// Listeners returns the sockets systemd passed to this process, nil when it
+// wasn't started through a socket unit
+// The LISTEN_* variables are unset, so child processes don't take them for theirs.
+func Listeners() ([]net.Listener, error) {
+	fds, pid := os.Getenv("LISTEN_FDS"), os.Getenv("LISTEN_PID")
+	names := os.Getenv("LISTEN_FDNAMES")
+	os.Unsetenv("LISTEN_FDS")
+	os.Unsetenv("LISTEN_PID")
+	os.Unsetenv("LISTEN_FDNAMES")
+	if fds == "" {
+		return nil, nil
+	}
+	// Set for another process, e.g. inherited through a wrapper script
+	if pid != "" && pid != strconv.Itoa(os.Getpid()) {
+		return nil, nil
+	}
+
+	n, err := strconv.Atoi(fds)
+	if err != nil || n < 1 {
+		return nil, fmt.Errorf("invalid LISTEN_FDS %q", fds)
+	}
+	fdNames := strings.Split(names, ":")
+	listeners := make([]net.Listener, 0, n)
+	for i := range n {
+		fd := listenFDsStart + i
+		name := "LISTEN_FD_" + strconv.Itoa(fd)
+		if i < len(fdNames) && fdNames[i] != "" {
+			name = fdNames[i]
+		}
+		f := os.NewFile(uintptr(fd), name)
+		l, err := net.FileListener(f)
+		// FileListener works on a duplicate, closed on exec
+		f.Close()
+		if err != nil {
+			for _, l := range listeners {
+				l.Close()
+			}
+			return nil, fmt.Errorf("socket %s (fd %d) is not a stream listener: %w", name, fd, err)
+		}
+		listeners = append(listeners, l)
+	}
+	return listeners, nil
+}
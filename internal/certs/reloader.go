@@ -0,0 +1,87 @@
+package certs
+
+import (
+	"crypto/tls"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+)
+
+// checkInterval is how often the files are checked for changes, at most
+const checkInterval = 10 * time.Second
+
+// Reloader serves a certificate from files, reloading them once they change
+// so renewed certificates (e.g. from Let's Encrypt) are picked up without a restart
+type Reloader struct {
+	certFile string
+	keyFile  string
+
+	mu        sync.Mutex
+	cert      *tls.Certificate
+	modTime   time.Time
+	lastCheck time.Time
+}
+
+// NewReloader loads the certificate and key, failing when they are unusable
+func NewReloader(certFile, keyFile string) (*Reloader, error) {
+	r := &Reloader{certFile: certFile, keyFile: keyFile}
+	if err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load reads the key pair and remembers when the files were last modified
+func (r *Reloader) load() error {
+	modTime, err := r.latestModTime()
+	if err != nil {
+		return err
+	}
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load certificate %s: %w", r.certFile, err)
+	}
+	r.cert = &cert
+	r.modTime = modTime
+	return nil
+}
+
+// latestModTime returns the later modification time of the two files
+func (r *Reloader) latestModTime() (time.Time, error) {
+	var latest time.Time
+	for _, name := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(name)
+		if err != nil {
+			return time.Time{}, err
+		}
+		if info.ModTime().After(latest) {
+			latest = info.ModTime()
+		}
+	}
+	return latest, nil
+}
+
+// GetCertificate implements tls.Config.GetCertificate
+// A renewal that fails to load keeps the previous certificate in use.
+func (r *Reloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if time.Since(r.lastCheck) < checkInterval {
+		return r.cert, nil
+	}
+	r.lastCheck = time.Now()
+
+	modTime, err := r.latestModTime()
+	if err != nil || modTime.Equal(r.modTime) {
+		return r.cert, nil
+	}
+	if err := r.load(); err != nil {
+		slog.Error("Failed to reload TLS certificate, keeping the current one", "error", err)
+		return r.cert, nil
+	}
+	slog.Info("Reloaded TLS certificate", "path", r.certFile)
+	return r.cert, nil
+}
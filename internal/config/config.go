@@ -1,8 +1,17 @@
 package config
 
 import (
+	"bytes"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
 	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"go-nc-client/internal/secrets"
 )
 
 type Config struct {
@@ -10,30 +19,428 @@ type Config struct {
 	Username  string `json:"username"`
 	Password  string `json:"password"`
 	StateFile string `json:"state_file"`
+
+	// PasswordFile reads password from a file instead, e.g. a Docker secret;
+	// it is read again when it changes
+	PasswordFile string `json:"password_file"`
+	// PasswordProvider reads password from an external store instead, e.g.
+	// Vault; it is read again every refresh_seconds
+	PasswordProvider *SecretProviderConfig `json:"password_provider"`
+
+	// StateBackend selects how state_file is stored: "json" (default), "sharded"
+	// (state_file is a directory holding one JSON file per tracked directory) or "bolt"
+	StateBackend string `json:"state_backend"`
+	// StateCompression is "gzip" to store the JSON state as state_file + ".gz"
+	StateCompression string `json:"state_compression"`
+
+	// StateEncryptionKey is a base64 32-byte key to encrypt the state with
+	// AES-256-GCM; StateEncryptionKeyFile reads it from a file instead, and the
+	// STATE_ENCRYPTION_KEY environment variable is used when neither is set
+	StateEncryptionKey     string `json:"state_encryption_key"`
+	StateEncryptionKeyFile string `json:"state_encryption_key_file"`
+
+	// ConfigEncryptionKeyFile holds the base64 32-byte key the secrets of
+	// config.json are encrypted with ("enc:v1:" values); the
+	// CONFIG_ENCRYPTION_KEY environment variable is used when it is unset
+	ConfigEncryptionKeyFile string `json:"config_encryption_key_file"`
+	// sealer encrypts the secrets Save writes (nil = no key configured)
+	sealer *secrets.Sealer
+
+	// OnAccountChange is what a diff does with state scanned with another
+	// webdav_url or username: "refuse" (default) or "reset" it with a warning
+	OnAccountChange string `json:"on_account_change"`
+
+	// PathPrefix is where user files live below webdav_url; "{username}" is substituted.
+	// Empty means the Nextcloud layout ("/files/{username}"), "/" means the server root.
+	PathPrefix string `json:"path_prefix"`
+
+	// CircuitBreakerThreshold is the number of consecutive WebDAV failures that
+	// trip the breaker (0 = default of 5, negative disables it)
+	CircuitBreakerThreshold int `json:"circuit_breaker_threshold"`
+	// CircuitBreakerCooldownSeconds is how long the breaker fails fast once tripped (0 = 30s)
+	CircuitBreakerCooldownSeconds int `json:"circuit_breaker_cooldown_seconds"`
+
+	// DisableSessionCookies sends basic auth alone on every request instead of
+	// reusing the Nextcloud session cookie
+	DisableSessionCookies bool `json:"disable_session_cookies"`
+
+	// UploadChunkSize is the size of the chunks /upload sends big files in, in
+	// bytes (0 = 10 MiB, negative = single PUT)
+	UploadChunkSize int64 `json:"upload_chunk_size"`
+
+	// Include and Exclude are glob patterns, relative to each tracked directory,
+	// selecting what scans and diffs cover (see README for the syntax)
+	Include []string `json:"include"`
+	Exclude []string `json:"exclude"`
+	// IgnoreFile is a local file of gitignore-style rules applied to every tracked
+	// directory, before the .ncignore found at the root of the directory itself
+	IgnoreFile string `json:"ignore_file"`
+
+	// TransientPatterns match short-lived files (e.g. "~$*", ".~lock.*") whose
+	// creation is held back until they persisted for TransientMinScans scans
+	TransientPatterns []string `json:"transient_patterns"`
+	// TransientMinScans is how many consecutive scans a transient file must be
+	// seen in before it is reported (0 = 2 with patterns; applies to every file
+	// when no patterns are set)
+	TransientMinScans int `json:"transient_min_scans"`
+
+	// Directories holds per tracked directory settings (key: directory path)
+	Directories map[string]DirectoryConfig `json:"directories"`
+	// RestrictToDirectories limits the paths the API accepts to those under
+	// Directories, e.g. so /ls can't browse the rest of the account
+	RestrictToDirectories bool `json:"restrict_to_directories"`
+
+	// Schedule diffs every directory under Directories without a schedule of
+	// its own on a cron expression, e.g. "*/15 * * * *" or "@hourly" (empty = never)
+	Schedule string `json:"schedule"`
+
+	// Webhooks are notified of the changes of every diff run that finds some
+	Webhooks []WebhookConfig `json:"webhooks"`
+
+	// ScanParallelism is how many tracked directories a diff scans at once (0 = 4)
+	ScanParallelism int `json:"scan_parallelism"`
+
+	// AutoDiscover probes the server at startup to fix the DAV base URL and detect features
+	AutoDiscover bool `json:"auto_discover"`
+
+	// CheckConnection stops the server at startup when an account's server
+	// can't be reached or rejects its credentials
+	CheckConnection bool `json:"check_connection"`
+
+	// UseSyncTokens switches the detector to RFC 6578 sync-collection reports
+	UseSyncTokens bool `json:"use_sync_tokens"`
+
+	// NormalizeUnicode compares file paths in NFC so NFD names from macOS clients match
+	NormalizeUnicode bool `json:"normalize_unicode"`
+
+	// ConfirmChecksums verifies suspected updates (new ETag, same size and mtime)
+	// with oc:checksums or by hashing the content before reporting them
+	ConfirmChecksums bool `json:"confirm_checksums"`
+
+	// ContentDiff adds unified diffs to updates of small text files when set
+	ContentDiff *ContentDiffConfig `json:"content_diff"`
+
+	// JournalFile is an append-only JSON Lines log of every reported change (empty = disabled)
+	JournalFile string `json:"journal_file"`
+	// JournalRetentionDays drops journal entries older than this (0 = 30 days, negative keeps everything)
+	JournalRetentionDays int `json:"journal_retention_days"`
+
+	// SnapshotDir holds named snapshots (empty = "snapshots" next to the state file)
+	SnapshotDir string `json:"snapshot_dir"`
+
+	// Profiles names the consumers that keep their own state, selected with
+	// /diff?profile=<name>; each is stored next to state_file
+	Profiles []string `json:"profiles"`
+
+	// CursorHistory is how many diff results are kept for replay with ?cursor=
+	// (0 = 10, negative disables cursors); they are stored in cursors.json next to the state file
+	CursorHistory int `json:"cursor_history"`
+
+	// JobHistory is how many background diffs of /diff?async=true are kept
+	// (0 = 50, negative disables async diffs); they are stored in jobs/ next to the state file
+	JobHistory int `json:"job_history"`
+
+	// AckFile stores the file versions consumers acknowledged, used to flag
+	// conflicts (empty = "acks.json" next to the state file)
+	AckFile string `json:"ack_file"`
+
+	// TLSCert and TLSKey are PEM files to serve HTTPS with; they are reloaded
+	// when they change, so renewals don't need a restart
+	TLSCert string `json:"tls_cert"`
+	TLSKey  string `json:"tls_key"`
+
+	// ShutdownTimeoutSeconds is how long a SIGTERM waits for requests and a
+	// diff in progress before exiting (0 = 30)
+	ShutdownTimeoutSeconds int `json:"shutdown_timeout_seconds"`
+
+	// PprofAddr serves net/http/pprof on this address when set, e.g.
+	// "127.0.0.1:6060"; keep it off public interfaces
+	PprofAddr string `json:"pprof_addr"`
+
+	// APIKeys protects the API when set: every request but the health checks needs one
+	// of these keys, with the admin scope for endpoints that change state
+	APIKeys []APIKeyConfig `json:"api_keys"`
+
+	// RateLimits limits requests per API key (or remote IP without keys) on
+	// "METHOD /path", "/path" or "*" for all other routes
+	RateLimits map[string]RateLimitConfig `json:"rate_limits"`
+
+	// MaxBodyBytes bounds request bodies but those of uploads and state
+	// imports (0 = 1 MiB, negative = no limit)
+	MaxBodyBytes int64 `json:"max_body_bytes"`
+	// MaxUploadBytes bounds the bodies of /upload and POST /state/import (0 = no limit)
+	MaxUploadBytes int64 `json:"max_upload_bytes"`
+
+	// Accounts are further Nextcloud accounts served by the same API, picked
+	// with the account query parameter (none = the account above)
+	Accounts []AccountConfig `json:"accounts"`
+
+	// Tenants lets requests bring their own Nextcloud credentials, for the
+	// same server as webdav_url (nil = only the configured account)
+	Tenants *TenantsConfig `json:"tenants"`
+
+	// Timeouts bounds how long the server waits on clients (nil = defaults)
+	Timeouts *TimeoutsConfig `json:"timeouts"`
+
+	// CORS lets browser apps on other origins call the API (nil = no CORS headers)
+	CORS *CORSConfig `json:"cors"`
+
+	// CompressionMinSize is the smallest response gzipped for clients accepting
+	// it, in bytes (0 = 1024, negative = no compression)
+	CompressionMinSize int `json:"compression_min_size"`
+
+	// LogLevel is "debug", "info" (default), "warn" or "error"; LOG_LEVEL overrides it
+	LogLevel string `json:"log_level"`
+	// LogFormat is "text" (default) or "json"; LOG_FORMAT overrides it
+	LogFormat string `json:"log_format"`
+
+	// LogWebDAVRequests logs method, path, status, duration and size of every WebDAV request
+	LogWebDAVRequests bool `json:"log_webdav_requests"`
+}
+
+// APIKeyConfig is a key clients of the API authenticate with
+type APIKeyConfig struct {
+	// Name identifies the key in audit logs
+	Name string `json:"name"`
+	Key  string `json:"key"`
+	// TokenFile reads key, the token clients send, from a file instead; it
+	// is read again when it changes
+	TokenFile string `json:"token_file"`
+	// Scope is "read" (listing and diffing) or "admin" (everything)
+	Scope string `json:"scope"`
+}
+
+// RateLimitConfig is a token bucket limiting requests to a route
+type RateLimitConfig struct {
+	RequestsPerMinute float64 `json:"requests_per_minute"`
+	// Burst is how many requests may be made at once (0 = requests_per_minute)
+	Burst int `json:"burst"`
+}
+
+// AccountConfig is a Nextcloud account served next to the top-level one
+type AccountConfig struct {
+	// Name picks the account with ?account=<name>
+	Name string `json:"name"`
+	// WebDAVURL is the account's server (empty = the top-level webdav_url)
+	WebDAVURL string `json:"webdav_url"`
+	Username  string `json:"username"`
+	Password  string `json:"password"`
+	// PasswordFile reads password from a file instead, like the top-level one
+	PasswordFile string `json:"password_file"`
+	// PasswordProvider reads password from an external store instead, like the top-level one
+	PasswordProvider *SecretProviderConfig `json:"password_provider"`
+	// StateFile is where the account's state is kept, in a directory of its
+	// own (empty = "accounts/<name>/" next to the top-level state_file)
+	StateFile string `json:"state_file"`
+	// Directories holds per tracked directory settings of the account;
+	// schedules are only supported for the top-level account
+	Directories map[string]DirectoryConfig `json:"directories"`
+}
+
+// SecretProviderConfig reads a secret from an external store, of which
+// exactly one must be set
+type SecretProviderConfig struct {
+	Vault *VaultConfig `json:"vault"`
+	// RefreshSeconds is how often the secret is read again, so a rotation
+	// is picked up (0 = 300)
+	RefreshSeconds int `json:"refresh_seconds"`
+}
+
+// VaultConfig reads a secret from a KV engine of HashiCorp Vault
+// Empty address, namespace and token fall back to VAULT_ADDR, VAULT_NAMESPACE
+// and VAULT_TOKEN.
+type VaultConfig struct {
+	Address string `json:"address"`
+	// Path of the secret below /v1/, e.g. "secret/data/nextcloud" for KV version 2
+	Path string `json:"path"`
+	// Field of the secret holding the value (empty = "password")
+	Field     string `json:"field"`
+	Namespace string `json:"namespace"`
+	Token     string `json:"token"`
+	TokenFile string `json:"token_file"`
+	// RoleID and SecretID (or SecretIDFile) log in with AppRole instead of a token
+	RoleID       string `json:"role_id"`
+	SecretID     string `json:"secret_id"`
+	SecretIDFile string `json:"secret_id_file"`
+}
+
+// defaultSecretRefresh is how often a secret provider is read again by default
+const defaultSecretRefresh = 5 * time.Minute
+
+// Refresh returns how often the secret of p is read again
+func (p *SecretProviderConfig) Refresh() time.Duration {
+	if p.RefreshSeconds > 0 {
+		return time.Duration(p.RefreshSeconds) * time.Second
+	}
+	return defaultSecretRefresh
+}
+
+// Provider returns the provider p configures, reading the files of its credentials
+func (p *SecretProviderConfig) Provider() (secrets.Provider, error) {
+	if err := p.validate(); err != nil {
+		return nil, err
+	}
+	v := p.Vault
+	token, secretID := v.Token, v.SecretID
+	if err := readSecretFile("token", &token, v.TokenFile); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	if err := readSecretFile("secret_id", &secretID, v.SecretIDFile); err != nil {
+		return nil, fmt.Errorf("vault: %w", err)
+	}
+	return secrets.NewVault(secrets.VaultOptions{
+		Address:   v.Address,
+		Path:      v.Path,
+		Field:     v.Field,
+		Namespace: v.Namespace,
+		Token:     token,
+		RoleID:    v.RoleID,
+		SecretID:  secretID,
+	})
+}
+
+// validate checks the settings of p that need neither the network nor the file system
+func (p *SecretProviderConfig) validate() error {
+	switch {
+	case p.Vault == nil:
+		return errors.New("no provider set, expected \"vault\"")
+	case p.Vault.Path == "":
+		return errors.New("vault: path is required")
+	case p.RefreshSeconds < 0:
+		return errors.New("refresh_seconds: must not be negative")
+	}
+	return nil
+}
+
+// TenantsConfig sets where the accounts of per-request credentials keep their state
+type TenantsConfig struct {
+	// StateDir holds a directory per account (default: "tenants" next to state_file)
+	StateDir string `json:"state_dir"`
+	// MaxCached is how many accounts keep a client and detector in memory (0 = 100)
+	MaxCached int `json:"max_cached"`
+}
+
+// TimeoutsConfig bounds the server's reads and writes, in seconds
+type TimeoutsConfig struct {
+	// ReadHeaderSeconds bounds reading the headers of a request (0 = 10)
+	ReadHeaderSeconds int `json:"read_header_seconds"`
+	// ReadSeconds bounds reading a whole request, body included (0 = no limit)
+	ReadSeconds int `json:"read_seconds"`
+	// WriteSeconds bounds handling a request and writing its response (0 = no limit)
+	WriteSeconds int `json:"write_seconds"`
+	// IdleSeconds is how long a kept-alive connection waits for its next request (0 = 120)
+	IdleSeconds int `json:"idle_seconds"`
+	// Routes overrides read_seconds and write_seconds on "METHOD /path" or
+	// "/path", counted from when the request's handler starts
+	Routes map[string]RouteTimeoutConfig `json:"routes"`
+}
+
+// RouteTimeoutConfig is the timeout of one route, in seconds (0 = the
+// server's, -1 = no limit)
+type RouteTimeoutConfig struct {
+	ReadSeconds  int `json:"read_seconds"`
+	WriteSeconds int `json:"write_seconds"`
+}
+
+// CORSConfig lists the origins, methods and headers browsers may use
+type CORSConfig struct {
+	// AllowedOrigins are e.g. "https://dash.example.com", or "*" for any origin
+	AllowedOrigins []string `json:"allowed_origins"`
+	// AllowedMethods defaults to GET, POST, DELETE and OPTIONS
+	AllowedMethods []string `json:"allowed_methods"`
+	// AllowedHeaders defaults to Content-Type, Authorization, X-API-Key and X-Request-ID
+	AllowedHeaders []string `json:"allowed_headers"`
+	// MaxAgeSeconds is how long browsers may cache a preflight (0 = browser default)
+	MaxAgeSeconds int `json:"max_age_seconds"`
+}
+
+// WebhookConfig is a URL the changes of diff runs are POSTed to
+type WebhookConfig struct {
+	// Name identifies the webhook in logs and /webhooks/deliveries (empty = its position, from 1)
+	Name string `json:"name"`
+	URL  string `json:"url"`
+	// Secret signs each delivery with HMAC-SHA256 in X-Webhook-Signature (empty = unsigned)
+	Secret string `json:"secret"`
+	// MaxAttempts bounds the tries of a delivery, retried with exponential backoff (0 = 5)
+	MaxAttempts int `json:"max_attempts"`
+	// TimeoutSeconds bounds each attempt (0 = 10)
+	TimeoutSeconds int `json:"timeout_seconds"`
+	// PathPrefixes, Types and Extensions only send the matching changes; runs
+	// without any are not sent (empty = all)
+	PathPrefixes []string `json:"path_prefixes"`
+	Types        []string `json:"types"`
+	Extensions   []string `json:"extensions"`
+	// Template is a Go text/template rendering the body (empty = the JSON
+	// payload); TemplateFile reads it from a file instead
+	Template     string `json:"template"`
+	TemplateFile string `json:"template_file"`
+	// ContentType of the body (empty = application/json)
+	ContentType string `json:"content_type"`
+}
+
+// DirectoryConfig holds the settings of a single tracked directory
+type DirectoryConfig struct {
+	// MaxDepth limits how deep the directory is walked (1 = direct children only, 0 = unlimited)
+	MaxDepth int `json:"max_depth"`
+	// MoveDetection tunes how moves are detected in the directory (nil = defaults)
+	MoveDetection *MoveDetectionConfig `json:"move_detection"`
+	// Schedule diffs the directory alone on a cron expression of its own (empty = global schedule)
+	Schedule string `json:"schedule"`
 }
 
+// MoveDetectionConfig tunes the move detection of a tracked directory
+type MoveDetectionConfig struct {
+	// Mode is "heuristic" (default: ETag, then unique size and close
+	// modification time), "etag" (ETag only) or "off"
+	Mode string `json:"mode"`
+	// WindowSeconds is how close modification times of a size match must be (0 = 60)
+	WindowSeconds int `json:"window_seconds"`
+	// MinSize is the smallest file, in bytes, matched by size alone (0 = any)
+	MinSize int64 `json:"min_size"`
+}
+
+// ContentDiffConfig selects which files get content diffs
+type ContentDiffConfig struct {
+	// MaxSize in bytes (0 = 64 KiB)
+	MaxSize int64 `json:"max_size"`
+	// Extensions to diff (empty = .md and .txt)
+	Extensions []string `json:"extensions"`
+	// CacheDir keeps the last seen content (empty = "content-cache" next to the state file)
+	CacheDir string `json:"cache_dir"`
+}
+
+// Load reads the configuration in filename, which must exist; see Validate
+// for checking its settings
 func Load(filename string) (*Config, error) {
 	data, err := os.ReadFile(filename)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%s not found: copy config.json.example to it and set webdav_url, username and password", filename)
+	}
 	if err != nil {
-		// Return default config if file doesn't exist
-		// Default to data/state.json if /app/data exists (Docker environment)
-		// Otherwise default to state.json (local development)
-		defaultStateFile := "state.json"
-		if _, err := os.Stat("/app/data"); err == nil {
-			defaultStateFile = "data/state.json"
-		}
-		
-		return &Config{
-			WebDAVURL: "",
-			Username:  "",
-			Password:  "",
-			StateFile: defaultStateFile,
-		}, nil
+		return nil, err
 	}
 
 	var cfg Config
 	if err := json.Unmarshal(data, &cfg); err != nil {
-		return nil, err
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line := 1 + bytes.Count(data[:syntaxErr.Offset], []byte("\n"))
+			return nil, fmt.Errorf("%s line %d: %w", filename, line, err)
+		}
+		var typeErr *json.UnmarshalTypeError
+		if errors.As(err, &typeErr) {
+			// Keys holding a slash, like those of directories, come escaped as in JSON pointers
+			field := strings.NewReplacer("~1", "/", "~0", "~").Replace(typeErr.Field)
+			return nil, fmt.Errorf("%s: %s: expected %s, got %s", filename, field, typeErr.Type, typeErr.Value)
+		}
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+
+	if err := cfg.openSealed(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
+	}
+	if err := cfg.readSecretFiles(); err != nil {
+		return nil, fmt.Errorf("%s: %w", filename, err)
 	}
 
 	if cfg.StateFile == "" {
@@ -49,10 +456,222 @@ func Load(filename string) (*Config, error) {
 	return &cfg, nil
 }
 
+// secretField is a secret kept in config.json, named as in error messages
+type secretField struct {
+	name  string
+	value *string
+}
+
+// inlineSecrets returns the secrets of cfg that may be kept in config.json,
+// which the config encryption key encrypts
+func (cfg *Config) inlineSecrets() []secretField {
+	fields := []secretField{
+		{"password", &cfg.Password},
+		{"state_encryption_key", &cfg.StateEncryptionKey},
+	}
+	fields = append(fields, cfg.PasswordProvider.secrets("password_provider")...)
+	for i := range cfg.Accounts {
+		prefix := fmt.Sprintf("accounts[%d].", i)
+		fields = append(fields, secretField{prefix + "password", &cfg.Accounts[i].Password})
+		fields = append(fields, cfg.Accounts[i].PasswordProvider.secrets(prefix+"password_provider")...)
+	}
+	for i := range cfg.APIKeys {
+		fields = append(fields, secretField{fmt.Sprintf("api_keys[%d].key", i), &cfg.APIKeys[i].Key})
+	}
+	for i := range cfg.Webhooks {
+		fields = append(fields, secretField{fmt.Sprintf("webhooks[%d].secret", i), &cfg.Webhooks[i].Secret})
+	}
+	return fields
+}
+
+// secrets returns the credentials of the store of p, see inlineSecrets
+func (p *SecretProviderConfig) secrets(name string) []secretField {
+	if p == nil || p.Vault == nil {
+		return nil
+	}
+	return []secretField{
+		{name + ".vault.token", &p.Vault.Token},
+		{name + ".vault.secret_id", &p.Vault.SecretID},
+	}
+}
+
+// openSealed decrypts the secrets of cfg encrypted with the config encryption
+// key, which it keeps so Save encrypts them again
+func (cfg *Config) openSealed() error {
+	var key []byte
+	switch {
+	case cfg.ConfigEncryptionKeyFile != "":
+		data, err := os.ReadFile(cfg.ConfigEncryptionKeyFile)
+		if err != nil {
+			return fmt.Errorf("failed to read config encryption key: %w", err)
+		}
+		key = data
+	case os.Getenv("CONFIG_ENCRYPTION_KEY") != "":
+		key = []byte(os.Getenv("CONFIG_ENCRYPTION_KEY"))
+	}
+	if key != nil {
+		sealer, err := secrets.NewSealer(key)
+		if err != nil {
+			return err
+		}
+		cfg.sealer = sealer
+	}
+
+	for _, field := range cfg.inlineSecrets() {
+		if !secrets.IsSealed(*field.value) {
+			continue
+		}
+		if cfg.sealer == nil {
+			return fmt.Errorf("%s is encrypted but no key is configured: set config_encryption_key_file or CONFIG_ENCRYPTION_KEY", field.name)
+		}
+		secret, err := cfg.sealer.Open(*field.value)
+		if err != nil {
+			return fmt.Errorf("%s: %w", field.name, err)
+		}
+		*field.value = secret
+	}
+	return nil
+}
+
+// Encrypts reports whether Save encrypts the secrets of cfg
+func (cfg *Config) Encrypts() bool {
+	return cfg.sealer != nil
+}
+
+// readSecretFiles sets the secrets of cfg kept in files, see secrets.Read
+func (cfg *Config) readSecretFiles() error {
+	if err := readSecretFile("password", &cfg.Password, cfg.PasswordFile); err != nil {
+		return err
+	}
+	for i := range cfg.Accounts {
+		acc := &cfg.Accounts[i]
+		if err := readSecretFile("password", &acc.Password, acc.PasswordFile); err != nil {
+			return fmt.Errorf("accounts[%d]: %w", i, err)
+		}
+	}
+	for i := range cfg.APIKeys {
+		key := &cfg.APIKeys[i]
+		if err := readSecretFile("key", &key.Key, key.TokenFile); err != nil {
+			return fmt.Errorf("api_keys[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// readSecretFile sets the secret field from file when file is set
+func readSecretFile(field string, secret *string, file string) error {
+	if file == "" {
+		return nil
+	}
+	if *secret != "" {
+		return fmt.Errorf("%s is set and read from a file, keep only one", field)
+	}
+	value, err := secrets.Read(file)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", field, err)
+	}
+	*secret = value
+	return nil
+}
+
+// Save writes cfg to filename, replacing it in one step so a crash can't
+// leave half a config behind; it is readable by the owner alone since it
+// holds secrets
+// Secrets read from files or providers are left out, so they stay there alone,
+// and the others are encrypted when a config encryption key is configured.
 func Save(cfg *Config, filename string) error {
-	data, err := json.MarshalIndent(cfg, "", "  ")
+	out := *cfg
+	if out.PasswordFile != "" || out.PasswordProvider != nil {
+		out.Password = ""
+	}
+	out.PasswordProvider = cloneProvider(cfg.PasswordProvider)
+	out.Accounts = slices.Clone(cfg.Accounts)
+	for i := range out.Accounts {
+		if out.Accounts[i].PasswordFile != "" || out.Accounts[i].PasswordProvider != nil {
+			out.Accounts[i].Password = ""
+		}
+		out.Accounts[i].PasswordProvider = cloneProvider(cfg.Accounts[i].PasswordProvider)
+	}
+	out.APIKeys = slices.Clone(cfg.APIKeys)
+	for i := range out.APIKeys {
+		if out.APIKeys[i].TokenFile != "" {
+			out.APIKeys[i].Key = ""
+		}
+	}
+	out.Webhooks = slices.Clone(cfg.Webhooks)
+	if cfg.sealer != nil {
+		for _, field := range out.inlineSecrets() {
+			if *field.value == "" {
+				continue
+			}
+			sealed, err := cfg.sealer.Seal(*field.value)
+			if err != nil {
+				return fmt.Errorf("failed to encrypt %s: %w", field.name, err)
+			}
+			*field.value = sealed
+		}
+	}
+	data, err := json.MarshalIndent(&out, "", "  ")
 	if err != nil {
 		return err
 	}
-	return os.WriteFile(filename, data, 0644)
+	tmp := filename + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, filename)
+}
+
+// Redacted is what secrets are replaced with by Redact
+const Redacted = "[redacted]"
+
+// Redact returns a copy of cfg without its secrets: the passwords, the state
+// encryption key, API keys and webhook secrets
+func Redact(cfg *Config) Config {
+	out := *cfg
+	redact(&out.Password)
+	redact(&out.StateEncryptionKey)
+	out.PasswordProvider = redactProvider(cfg.PasswordProvider)
+	out.Accounts = slices.Clone(cfg.Accounts)
+	for i := range out.Accounts {
+		redact(&out.Accounts[i].Password)
+		out.Accounts[i].PasswordProvider = redactProvider(cfg.Accounts[i].PasswordProvider)
+	}
+	out.APIKeys = slices.Clone(cfg.APIKeys)
+	for i := range out.APIKeys {
+		redact(&out.APIKeys[i].Key)
+	}
+	out.Webhooks = slices.Clone(cfg.Webhooks)
+	for i := range out.Webhooks {
+		redact(&out.Webhooks[i].Secret)
+	}
+	return out
+}
+
+// redactProvider returns a copy of p without the credentials of its store
+func redactProvider(p *SecretProviderConfig) *SecretProviderConfig {
+	out := cloneProvider(p)
+	for _, field := range out.secrets("") {
+		redact(field.value)
+	}
+	return out
+}
+
+// cloneProvider returns a copy of p whose credentials can be changed
+func cloneProvider(p *SecretProviderConfig) *SecretProviderConfig {
+	if p == nil {
+		return nil
+	}
+	out := *p
+	if p.Vault != nil {
+		vault := *p.Vault
+		out.Vault = &vault
+	}
+	return &out
+}
+
+func redact(secret *string) {
+	if *secret != "" {
+		*secret = Redacted
+	}
 }
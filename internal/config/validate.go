@@ -0,0 +1,185 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// accountNamePattern matches the names of accounts, which name their state directory
+var accountNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// ValidationError lists what is wrong with a configuration, one message per setting
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return strings.Join(e, "; ")
+}
+
+// Validate checks the settings of cfg that need neither the network nor the
+// file system, so mistakes stop the server at startup rather than on first use
+// It returns a ValidationError listing every problem found.
+func (cfg *Config) Validate() error {
+	var problems ValidationError
+	add := func(format string, args ...any) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	if err := checkURL(cfg.WebDAVURL); err != nil {
+		add("webdav_url: %v", err)
+	}
+	if cfg.Username == "" {
+		add("username: required")
+	}
+	switch {
+	case cfg.PasswordProvider != nil:
+		if cfg.Password != "" || cfg.PasswordFile != "" {
+			add("password_provider: set with password or password_file, keep only one")
+		}
+		if err := cfg.PasswordProvider.validate(); err != nil {
+			add("password_provider: %v", err)
+		}
+	case cfg.Password == "":
+		add("password: required, or password_file or password_provider (use an app password, see Nextcloud's security settings)")
+	}
+
+	if cfg.StateFile == "" {
+		add("state_file: required")
+	}
+	switch cfg.StateBackend {
+	case "", "json", "sharded", "bolt":
+	default:
+		add("state_backend: unknown %q (expected \"json\", \"sharded\" or \"bolt\")", cfg.StateBackend)
+	}
+	switch cfg.StateCompression {
+	case "", "none", "gzip":
+	default:
+		add("state_compression: unknown %q (expected \"gzip\" or \"none\")", cfg.StateCompression)
+	}
+	switch cfg.OnAccountChange {
+	case "", "refuse", "reset":
+	default:
+		add("on_account_change: unknown %q (expected \"refuse\" or \"reset\")", cfg.OnAccountChange)
+	}
+	if (cfg.TLSCert == "") != (cfg.TLSKey == "") {
+		add("tls_cert and tls_key must be set together")
+	}
+
+	for _, dir := range slices.Sorted(maps.Keys(cfg.Directories)) {
+		if err := checkDirectory(dir); err != nil {
+			add("directories: %q %v", dir, err)
+		}
+	}
+	if cfg.Schedule != "" && len(cfg.Directories) == 0 {
+		add("schedule: set, but no directories are configured to diff")
+	}
+
+	if cfg.Tenants != nil && cfg.StateBackend == "bolt" {
+		add("tenants: not supported with the bolt state backend")
+	}
+	problems = append(problems, cfg.validateAccounts()...)
+
+	if len(problems) > 0 {
+		return problems
+	}
+	return nil
+}
+
+// validateAccounts checks the accounts of cfg, which must not share their
+// state with each other or the top-level account
+func (cfg *Config) validateAccounts() ValidationError {
+	var problems ValidationError
+	stateDirs := map[string]string{filepath.Clean(filepath.Dir(cfg.StateFile)): "the top-level account"}
+	names := make(map[string]bool, len(cfg.Accounts))
+	for i, acc := range cfg.Accounts {
+		prefix := fmt.Sprintf("accounts[%d]", i)
+		if !accountNamePattern.MatchString(acc.Name) {
+			problems = append(problems, fmt.Sprintf("%s: invalid name %q: use letters, digits, '.', '_' and '-'", prefix, acc.Name))
+			continue
+		}
+		if names[acc.Name] {
+			problems = append(problems, fmt.Sprintf("%s: name %s is used twice", prefix, acc.Name))
+			continue
+		}
+		names[acc.Name] = true
+		prefix = "account " + acc.Name
+
+		if acc.WebDAVURL != "" {
+			if err := checkURL(acc.WebDAVURL); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: webdav_url: %v", prefix, err))
+			}
+		}
+		switch {
+		case acc.PasswordProvider != nil:
+			if acc.Password != "" || acc.PasswordFile != "" {
+				problems = append(problems, prefix+": password_provider: set with password or password_file, keep only one")
+			}
+			if err := acc.PasswordProvider.validate(); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: password_provider: %v", prefix, err))
+			}
+		case acc.Password == "":
+			problems = append(problems, prefix+": password (or password_file or password_provider) is required")
+		}
+		if acc.Username == "" {
+			problems = append(problems, prefix+": username is required")
+		}
+		for _, dir := range slices.Sorted(maps.Keys(acc.Directories)) {
+			if err := checkDirectory(dir); err != nil {
+				problems = append(problems, fmt.Sprintf("%s: directories: %q %v", prefix, dir, err))
+			}
+			if acc.Directories[dir].Schedule != "" {
+				problems = append(problems, fmt.Sprintf("%s: directory %s has a schedule, only the top-level account supports them", prefix, dir))
+			}
+		}
+		stateDir := filepath.Clean(filepath.Dir(cfg.AccountStateFile(acc)))
+		if other, ok := stateDirs[stateDir]; ok {
+			problems = append(problems, fmt.Sprintf("%s: state_file is in the directory of %s, it needs one of its own", prefix, other))
+		}
+		stateDirs[stateDir] = prefix
+	}
+	return problems
+}
+
+// AccountStateFile returns where an account of cfg keeps its state
+func (cfg *Config) AccountStateFile(acc AccountConfig) string {
+	if acc.StateFile != "" {
+		return acc.StateFile
+	}
+	return filepath.Join(filepath.Dir(cfg.StateFile), "accounts", acc.Name, filepath.Base(cfg.StateFile))
+}
+
+// checkURL rejects WebDAV URLs requests can't be sent to
+func checkURL(raw string) error {
+	if raw == "" {
+		return errors.New(`required, e.g. "https://cloud.example.com/remote.php/dav"`)
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%q must start with https:// or http://", raw)
+	}
+	if u.Host == "" {
+		return fmt.Errorf("%q has no host", raw)
+	}
+	return nil
+}
+
+// checkDirectory rejects tracked directories that aren't absolute paths on the server
+func checkDirectory(dir string) error {
+	switch {
+	case !strings.HasPrefix(dir, "/"):
+		return fmt.Errorf("must be an absolute path, e.g. \"/%s\"", strings.TrimLeft(dir, "./"))
+	case strings.Contains(dir, `\`):
+		return errors.New("must use forward slashes")
+	case strings.Contains(dir+"/", "/../"):
+		return errors.New("must not contain '..'")
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+)
+
+// ErrAccountChanged is returned when the stored state of a directory was
+// scanned with another server or user than the detector's
+var ErrAccountChanged = errors.New("state belongs to another account")
+
+// What DetectChanges does with state of another account, see Options.OnAccountChange
+const (
+	// AccountChangeRefuse fails the run until the state is reset
+	AccountChangeRefuse = "refuse"
+	// AccountChangeReset discards the directory's state, as if it was never scanned
+	AccountChangeReset = "reset"
+)
+
+// AccountFingerprint identifies the account state is scanned with, without
+// storing the URL or user name themselves
+func AccountFingerprint(webdavURL, username string) string {
+	sum := sha256.Sum256([]byte(strings.TrimSuffix(webdavURL, "/") + "\n" + username))
+	return hex.EncodeToString(sum[:8])
+}
+
+// checkAccount compares the account prevState's directories were scanned
+// with to Options.Account, resetting them or failing depending on
+// Options.OnAccountChange; state without a fingerprint is adopted as is
+func (d *Detector) checkAccount(prevState *State, dirs []string) error {
+	if d.options.Account == "" {
+		return nil
+	}
+	for _, dir := range dirs {
+		stored := prevState.Accounts[dir]
+		if stored == "" || stored == d.options.Account {
+			continue
+		}
+		if d.options.OnAccountChange != AccountChangeReset {
+			return fmt.Errorf("%w: %s was scanned with another server URL or username; reset its state to start over", ErrAccountChanged, dir)
+		}
+		slog.Warn("State was scanned with another server URL or username, discarding it", "directory", dir)
+		prevState.removeDirectories([]string{dir})
+	}
+	return nil
+}
@@ -0,0 +1,189 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// LocalMeta is a consumer's metadata of its local copy of a file
+// Zero fields are not compared.
+type LocalMeta struct {
+	Size     int64     `json:"size,omitempty"`
+	Modified time.Time `json:"modified,omitempty"`
+	Hash     string    `json:"hash,omitempty"`
+}
+
+// differs reports whether m describes a different local copy than base
+func (m LocalMeta) differs(base LocalMeta) bool {
+	if m.Size != 0 && base.Size != 0 && m.Size != base.Size {
+		return true
+	}
+	if !m.Modified.IsZero() && !base.Modified.IsZero() && !m.Modified.Equal(base.Modified) {
+		return true
+	}
+	return m.Hash != "" && base.Hash != "" && m.Hash != base.Hash
+}
+
+// Ack records the version of a file a consumer has processed: the server
+// ETag it synced and the metadata of its local copy at that point
+type Ack struct {
+	Path         string    `json:"path"`
+	ETag         string    `json:"etag"`
+	Local        LocalMeta `json:"local"`
+	Acknowledged time.Time `json:"acknowledged"`
+}
+
+// AckStore persists acknowledgements in a JSON file keyed by path
+type AckStore struct {
+	path string
+
+	mu   sync.Mutex
+	acks map[string]Ack
+}
+
+// OpenAckStore loads the acknowledgements at path, starting empty if the file doesn't exist
+func OpenAckStore(path string) (*AckStore, error) {
+	s := &AckStore{path: path, acks: make(map[string]Ack)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return s, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var acks []Ack
+	if err := json.Unmarshal(data, &acks); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	for _, ack := range acks {
+		s.acks[ack.Path] = ack
+	}
+	return s, nil
+}
+
+// Acknowledge records acks, replacing earlier ones for the same paths
+func (s *AckStore) Acknowledge(acks []Ack) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	for _, ack := range acks {
+		ack.Path = normalizeDirectory(ack.Path)
+		if ack.Acknowledged.IsZero() {
+			ack.Acknowledged = now
+		}
+		s.acks[ack.Path] = ack
+	}
+	return s.save()
+}
+
+// Remove forgets the acknowledgements of paths
+func (s *AckStore) Remove(paths []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, p := range paths {
+		delete(s.acks, normalizeDirectory(p))
+	}
+	return s.save()
+}
+
+// Get returns the acknowledgement of a path
+func (s *AckStore) Get(p string) (Ack, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	ack, ok := s.acks[p]
+	return ack, ok
+}
+
+// List returns the acknowledgements at or below dir, sorted by path
+func (s *AckStore) List(dir string) []Ack {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	dir = normalizeDirectory(dir)
+	acks := []Ack{}
+	for p, ack := range s.acks {
+		if dir == "/" || withinDirectory(p, dir) {
+			acks = append(acks, ack)
+		}
+	}
+	sort.Slice(acks, func(i, j int) bool { return acks[i].Path < acks[j].Path })
+	return acks
+}
+
+// save writes the store atomically; the caller holds mu
+func (s *AckStore) save() error {
+	acks := make([]Ack, 0, len(s.acks))
+	for _, ack := range s.acks {
+		acks = append(acks, ack)
+	}
+	sort.Slice(acks, func(i, j int) bool { return acks[i].Path < acks[j].Path })
+
+	data, err := json.MarshalIndent(acks, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// Acks returns the acknowledgement store, nil when disabled
+func (d *Detector) Acks() *AckStore {
+	return d.options.Acks
+}
+
+// flagConflicts marks changes to files that changed on the server since the
+// consumer acknowledged them while the consumer's local copy changed too
+func (d *Detector) flagConflicts(changes []Change, local map[string]LocalMeta) {
+	if d.options.Acks == nil || len(local) == 0 {
+		return
+	}
+	for i := range changes {
+		c := &changes[i]
+		if c.IsDir || c.Type == "created" {
+			continue
+		}
+		// A move is checked against the path the consumer knows the file by
+		p := c.Path
+		if c.Type == "moved" {
+			p = c.OldPath
+		}
+		ack, ok := d.options.Acks.Get(p)
+		if !ok {
+			continue
+		}
+		if c.Type != "deleted" && c.ETag == ack.ETag {
+			// The server still has the acknowledged version
+			continue
+		}
+		if meta, ok := local[p]; ok && meta.differs(ack.Local) {
+			c.Conflict = true
+		}
+	}
+}
+
+// attachETags sets the current ETag on changes to files that still exist
+func attachETags(dir string, changes []Change, state *State) {
+	for i := range changes {
+		if changes[i].Type == "deleted" {
+			continue
+		}
+		if f, ok := state.Files[dir+":"+changes[i].Path]; ok {
+			changes[i].ETag = f.ETag
+		}
+	}
+}
@@ -0,0 +1,334 @@
+package diff
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Bucket layout of a BoltStore:
+//
+//	meta                     last_update, schema_version
+//	dirs/<tracked dir>/files <path> -> FileState JSON
+//	dirs/<tracked dir>/etags <path> -> directory ETag
+//	dirs/<tracked dir>       sync_token, scan_settings, account, scanned_at
+var (
+	boltMetaBucket  = []byte("meta")
+	boltDirsBucket  = []byte("dirs")
+	boltFilesBucket = []byte("files")
+	boltETagsBucket = []byte("etags")
+	boltLastUpdate  = []byte("last_update")
+	boltSchema      = []byte("schema_version")
+	boltSyncToken   = []byte("sync_token")
+	boltSettings    = []byte("scan_settings")
+	boltAccount     = []byte("account")
+	boltScannedAt   = []byte("scanned_at")
+)
+
+// BoltStore keeps state in a bbolt database with one bucket per tracked
+// directory, so a diff only reads and rewrites the directories it covers
+type BoltStore struct {
+	db   *bolt.DB
+	path string
+}
+
+// OpenBoltStore opens or creates the database at path
+// The file is locked for as long as the store is open
+func OpenBoltStore(path string) (*BoltStore, error) {
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := bolt.Open(path, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state database %s: %w", path, err)
+	}
+	s := &BoltStore{db: db, path: path}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// migrate upgrades the whole database to SchemaVersion
+// A database without a version is treated as version 0 if it holds any state
+func (s *BoltStore) migrate() error {
+	version := SchemaVersion
+	err := s.db.View(func(tx *bolt.Tx) error {
+		meta := tx.Bucket(boltMetaBucket)
+		if meta == nil {
+			if tx.Bucket(boltDirsBucket) != nil {
+				version = 0
+			}
+			return nil
+		}
+		raw := meta.Get(boltSchema)
+		if raw == nil {
+			version = 0
+			return nil
+		}
+		v, err := strconv.Atoi(string(raw))
+		if err != nil {
+			return fmt.Errorf("invalid schema_version %q in %s", raw, s.path)
+		}
+		version = v
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if version == SchemaVersion {
+		return nil
+	}
+
+	state, err := s.Load(nil)
+	if err != nil {
+		return err
+	}
+	state.SchemaVersion = version
+	if _, err := migrateState(state); err != nil {
+		return err
+	}
+
+	var dirs []string
+	err = s.db.View(func(tx *bolt.Tx) error {
+		if root := tx.Bucket(boltDirsBucket); root != nil {
+			return root.ForEachBucket(func(name []byte) error {
+				dirs = append(dirs, string(name))
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if err := s.Save(dirs, state); err != nil {
+		return err
+	}
+
+	slog.Info("Migrated state", "path", s.path, "from_version", version, "to_version", SchemaVersion)
+	return nil
+}
+
+func (s *BoltStore) Close() error {
+	return s.db.Close()
+}
+
+// CheckWritable commits an empty write transaction
+func (s *BoltStore) CheckWritable() error {
+	return s.db.Update(func(tx *bolt.Tx) error { return nil })
+}
+
+func (s *BoltStore) Load(dirs []string) (*State, error) {
+	slog.Debug("Loading state", "path", s.path)
+
+	state := newState()
+	err := s.db.View(func(tx *bolt.Tx) error {
+		if meta := tx.Bucket(boltMetaBucket); meta != nil {
+			if raw := meta.Get(boltLastUpdate); raw != nil {
+				if err := state.LastUpdate.UnmarshalText(raw); err != nil {
+					return fmt.Errorf("invalid last_update: %w", err)
+				}
+			}
+		}
+
+		root := tx.Bucket(boltDirsBucket)
+		if root == nil {
+			return nil
+		}
+
+		if len(dirs) == 0 {
+			return root.ForEachBucket(func(name []byte) error {
+				return loadBoltDirectory(root.Bucket(name), string(name), state)
+			})
+		}
+		for _, dir := range dirs {
+			if bucket := root.Bucket([]byte(dir)); bucket != nil {
+				if err := loadBoltDirectory(bucket, dir, state); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return state, nil
+}
+
+func loadBoltDirectory(bucket *bolt.Bucket, dir string, state *State) error {
+	dirPrefix := dir + ":"
+
+	if files := bucket.Bucket(boltFilesBucket); files != nil {
+		err := files.ForEach(func(k, v []byte) error {
+			var file FileState
+			if err := json.Unmarshal(v, &file); err != nil {
+				return fmt.Errorf("invalid entry %s in %s: %w", k, dir, err)
+			}
+			state.Files[dirPrefix+string(k)] = file
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if etags := bucket.Bucket(boltETagsBucket); etags != nil {
+		etags.ForEach(func(k, v []byte) error {
+			state.DirectoryETags[string(k)] = string(v)
+			return nil
+		})
+	}
+
+	if token := bucket.Get(boltSyncToken); token != nil {
+		state.SyncTokens[dir] = string(token)
+	}
+	if settings := bucket.Get(boltSettings); settings != nil {
+		state.ScanSettings[dir] = string(settings)
+	}
+	if account := bucket.Get(boltAccount); account != nil {
+		state.Accounts[dir] = string(account)
+	}
+	if scanned := bucket.Get(boltScannedAt); scanned != nil {
+		var t time.Time
+		if err := t.UnmarshalText(scanned); err != nil {
+			return fmt.Errorf("invalid scan time of %s: %w", dir, err)
+		}
+		state.ScannedAt[dir] = t
+	}
+	return nil
+}
+
+func (s *BoltStore) Save(dirs []string, state *State) error {
+	start := time.Now()
+	err := s.db.Update(func(tx *bolt.Tx) error {
+		meta, err := tx.CreateBucketIfNotExists(boltMetaBucket)
+		if err != nil {
+			return err
+		}
+		lastUpdate, err := state.LastUpdate.MarshalText()
+		if err != nil {
+			return err
+		}
+		if err := meta.Put(boltLastUpdate, lastUpdate); err != nil {
+			return err
+		}
+		if err := meta.Put(boltSchema, []byte(strconv.Itoa(SchemaVersion))); err != nil {
+			return err
+		}
+
+		root, err := tx.CreateBucketIfNotExists(boltDirsBucket)
+		if err != nil {
+			return err
+		}
+		for _, dir := range dirs {
+			if err := saveBoltDirectory(root, dir, state); err != nil {
+				return fmt.Errorf("failed to save %s: %w", dir, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		slog.Error("Failed to write state database", "path", s.path, "error", err)
+		return err
+	}
+
+	slog.Debug("State saved", "path", s.path, "directories", len(dirs), "duration", time.Since(start))
+	return nil
+}
+
+// Remove deletes the buckets of dirs
+func (s *BoltStore) Remove(dirs []string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		root := tx.Bucket(boltDirsBucket)
+		if root == nil {
+			return nil
+		}
+		for _, dir := range dirs {
+			if root.Bucket([]byte(dir)) == nil {
+				continue
+			}
+			if err := root.DeleteBucket([]byte(dir)); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", dir, err)
+			}
+		}
+		return nil
+	})
+}
+
+// saveBoltDirectory replaces the bucket of dir with its entries in state
+func saveBoltDirectory(root *bolt.Bucket, dir string, state *State) error {
+	if root.Bucket([]byte(dir)) != nil {
+		if err := root.DeleteBucket([]byte(dir)); err != nil {
+			return err
+		}
+	}
+	bucket, err := root.CreateBucket([]byte(dir))
+	if err != nil {
+		return err
+	}
+
+	subset := newState()
+	state.copyDirectories(subset, []string{dir})
+
+	files, err := bucket.CreateBucket(boltFilesBucket)
+	if err != nil {
+		return err
+	}
+	dirPrefix := dir + ":"
+	for key, file := range subset.Files {
+		data, err := json.Marshal(file)
+		if err != nil {
+			return err
+		}
+		if err := files.Put([]byte(key[len(dirPrefix):]), data); err != nil {
+			return err
+		}
+	}
+
+	etags, err := bucket.CreateBucket(boltETagsBucket)
+	if err != nil {
+		return err
+	}
+	for p, etag := range subset.DirectoryETags {
+		if err := etags.Put([]byte(p), []byte(etag)); err != nil {
+			return err
+		}
+	}
+
+	if token := subset.SyncTokens[dir]; token != "" {
+		if err := bucket.Put(boltSyncToken, []byte(token)); err != nil {
+			return err
+		}
+	}
+	if settings := subset.ScanSettings[dir]; settings != "" {
+		if err := bucket.Put(boltSettings, []byte(settings)); err != nil {
+			return err
+		}
+	}
+	if account := subset.Accounts[dir]; account != "" {
+		if err := bucket.Put(boltAccount, []byte(account)); err != nil {
+			return err
+		}
+	}
+	if scanned, ok := subset.ScannedAt[dir]; ok {
+		data, err := scanned.MarshalText()
+		if err != nil {
+			return err
+		}
+		return bucket.Put(boltScannedAt, data)
+	}
+	return nil
+}
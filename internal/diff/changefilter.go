@@ -0,0 +1,122 @@
+package diff
+
+import (
+	"fmt"
+
+	"go-nc-client/internal/filter"
+)
+
+// ChangeTypes lists the change types the detector reports
+var ChangeTypes = []string{"created", "updated", "moved", "deleted"}
+
+// ChangeFilter narrows the changes returned to a caller
+// It only affects what is reported: the state is saved in full, so filtered
+// out changes are not reported again by later runs.
+type ChangeFilter struct {
+	// Types keeps only these change types (empty = all)
+	Types []string
+	// Patterns keeps only paths matching one of these globs, relative to the
+	// tracked directory, with the include pattern syntax (empty = all)
+	Patterns []string
+	// MinSize and MaxSize bound the size of files in bytes (0 = no bound)
+	MinSize int64
+	MaxSize int64
+	// FilesOnly and DirsOnly keep only changes to files or to directories
+	FilesOnly bool
+	DirsOnly  bool
+
+	patterns *filter.Filter
+}
+
+// Validate checks the settings and compiles the patterns
+func (cf *ChangeFilter) Validate() error {
+	if cf == nil {
+		return nil
+	}
+	for _, t := range cf.Types {
+		known := false
+		for _, changeType := range ChangeTypes {
+			known = known || t == changeType
+		}
+		if !known {
+			return fmt.Errorf("unknown change type %q (expected one of %v)", t, ChangeTypes)
+		}
+	}
+	if cf.FilesOnly && cf.DirsOnly {
+		return fmt.Errorf("files-only and dirs-only cannot be combined")
+	}
+	if cf.MinSize < 0 || cf.MaxSize < 0 {
+		return fmt.Errorf("size bounds must not be negative")
+	}
+	if cf.MaxSize > 0 && cf.MinSize > cf.MaxSize {
+		return fmt.Errorf("min-size %d is larger than max-size %d", cf.MinSize, cf.MaxSize)
+	}
+	patterns, err := filter.New(cf.Patterns, nil)
+	if err != nil {
+		return err
+	}
+	cf.patterns = patterns
+	return nil
+}
+
+// Apply keeps the changes below the tracked directory dir that pass the filter
+// The filter must have been validated.
+func (cf *ChangeFilter) Apply(dir string, changes []Change) []Change {
+	if cf == nil {
+		return changes
+	}
+	result := []Change{}
+	for _, c := range changes {
+		if cf.keep(dir, c) {
+			result = append(result, c)
+		}
+	}
+	return result
+}
+
+// ApplyResults returns a copy of results with Apply run on each directory,
+// leaving results as they are for the journal, observers and cursors
+func (cf *ChangeFilter) ApplyResults(results []Changes) []Changes {
+	if cf == nil {
+		return results
+	}
+	filtered := make([]Changes, len(results))
+	for i, result := range results {
+		if result.Error == "" {
+			result.Changes = cf.Apply(result.Directory, result.Changes)
+		}
+		if result.Stats != nil {
+			stats := *result.Stats
+			stats.ChangedBytes = changedBytes(result.Changes)
+			result.Stats = &stats
+		}
+		filtered[i] = result
+	}
+	return filtered
+}
+
+func (cf *ChangeFilter) keep(dir string, c Change) bool {
+	if len(cf.Types) > 0 {
+		wanted := false
+		for _, t := range cf.Types {
+			wanted = wanted || c.Type == t
+		}
+		if !wanted {
+			return false
+		}
+	}
+	if (cf.FilesOnly && c.IsDir) || (cf.DirsOnly && !c.IsDir) {
+		return false
+	}
+	// Directories have no meaningful size
+	if !c.IsDir {
+		if c.Size < cf.MinSize || (cf.MaxSize > 0 && c.Size > cf.MaxSize) {
+			return false
+		}
+	}
+	if cf.patterns.Matches(relativeTo(dir, c.Path), c.IsDir) {
+		return true
+	}
+	// A move into or out of the matching paths counts
+	return c.OldPath != "" && cf.patterns.Matches(relativeTo(dir, c.OldPath), c.IsDir)
+}
@@ -0,0 +1,67 @@
+package diff
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"strings"
+
+	"go-nc-client/internal/webdav"
+)
+
+// suspectedUpdate reports whether an ETag change may be churn rather than an edit
+func (d *Detector) suspectedUpdate(prev, current FileState) bool {
+	return !current.IsDir &&
+		current.Size == prev.Size &&
+		current.ModifiedTime.Equal(prev.ModifiedTime)
+}
+
+// confirmUpdate reports whether the content of current really differs from prev
+// Server checksums are compared when both sides have one in common; otherwise
+// the file is downloaded and its SHA256 recorded in current for later runs.
+// Anything that cannot be verified counts as an update.
+func (d *Detector) confirmUpdate(client Client, prev FileState, current *FileState) bool {
+	if equal, known := webdav.CompareChecksums(prev.Checksum, current.Checksum); known {
+		return !equal
+	}
+
+	sum, err := hashContent(client, current.Path)
+	if err != nil {
+		slog.Warn("Could not hash file to confirm update", "path", current.Path, "error", err)
+		return true
+	}
+	current.Checksum = mergeChecksums(current.Checksum, sum)
+
+	equal, known := webdav.CompareChecksums(prev.Checksum, current.Checksum)
+	return !known || !equal
+}
+
+// hashContent downloads a file and returns "SHA256:<hex digest>"
+func hashContent(client Client, filePath string) (string, error) {
+	file, err := client.Open(filePath, webdav.DownloadOptions{})
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, file); err != nil {
+		return "", err
+	}
+	return "SHA256:" + hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// mergeChecksums adds the algorithms of extra that checksums lacks
+func mergeChecksums(checksums, extra string) string {
+	have := webdav.ParseChecksums(checksums)
+	result := strings.Fields(checksums)
+	for _, field := range strings.Fields(extra) {
+		algo, _, _ := strings.Cut(field, ":")
+		if _, ok := have[strings.ToUpper(algo)]; !ok {
+			result = append(result, field)
+			have[strings.ToUpper(algo)] = ""
+		}
+	}
+	return strings.Join(result, " ")
+}
@@ -0,0 +1,62 @@
+package diff
+
+import "path"
+
+// collapseDeletions folds deletions below a deleted directory into the
+// directory's own change, which then carries how many entries it held and
+// their total size
+func collapseDeletions(changes []Change) []Change {
+	deletedDirs := make(map[string]int) // path -> index in changes
+	for i, c := range changes {
+		if c.Type == "deleted" && c.IsDir {
+			deletedDirs[c.Path] = i
+		}
+	}
+	if len(deletedDirs) == 0 {
+		return changes
+	}
+
+	// The topmost deleted ancestor absorbs every deletion below it
+	absorbedBy := make(map[int]int)
+	for i, c := range changes {
+		if c.Type != "deleted" {
+			continue
+		}
+		top := -1
+		for p := path.Dir(c.Path); ; p = path.Dir(p) {
+			if j, ok := deletedDirs[p]; ok {
+				top = j
+			}
+			if p == "/" || p == "." {
+				break
+			}
+		}
+		if top >= 0 {
+			absorbedBy[i] = top
+		}
+	}
+	if len(absorbedBy) == 0 {
+		return changes
+	}
+
+	result := make([]Change, 0, len(changes)-len(absorbedBy))
+	collapsed := make(map[int]int) // index in changes -> index in result
+	for i, c := range changes {
+		if _, ok := absorbedBy[i]; ok {
+			continue
+		}
+		collapsed[i] = len(result)
+		result = append(result, c)
+	}
+	for i, top := range absorbedBy {
+		dir := &result[collapsed[top]]
+		dir.Count++
+		if !changes[i].IsDir {
+			dir.TotalSize += changes[i].Size
+		}
+		if changes[i].Conflict {
+			dir.Conflict = true
+		}
+	}
+	return result
+}
@@ -0,0 +1,180 @@
+package diff
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+)
+
+// CompactResult reports what a state compaction dropped
+type CompactResult struct {
+	Kept                  []string `json:"kept"`
+	RemovedDirectories    []string `json:"removed_directories"`
+	RemovedFiles          int      `json:"removed_files"`
+	RemovedDirectoryETags int      `json:"removed_directory_etags"`
+	DryRun                bool     `json:"dry_run,omitempty"`
+}
+
+// CompactState shrinks the stored state to the tracked directories in keep
+// Entries of every other tracked directory are removed, as are directory ETags
+// that no longer belong to a directory of a kept tree. With dryRun the result
+// is computed but nothing is written.
+func (d *Detector) CompactState(keep []string, dryRun bool) (*CompactResult, error) {
+	kept := make(map[string]bool, len(keep))
+	for _, dir := range keep {
+		kept[normalizeDirectory(dir)] = true
+	}
+
+	release, err := d.acquireRun(keep, "", false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	state, err := d.store.Load(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+
+	result := &CompactResult{Kept: []string{}, RemovedDirectories: []string{}, DryRun: dryRun}
+	for _, dir := range stateDirectories(state) {
+		if kept[dir] {
+			result.Kept = append(result.Kept, dir)
+		} else {
+			result.RemovedDirectories = append(result.RemovedDirectories, dir)
+		}
+	}
+
+	compacted := newState()
+	compacted.LastUpdate = state.LastUpdate
+	state.copyDirectories(compacted, result.Kept)
+	result.RemovedFiles = len(state.Files) - len(compacted.Files)
+
+	// Keep the ETags of tracked directories and of subdirectories still in their trees
+	for p := range compacted.DirectoryETags {
+		if !kept[p] && !trackedSubdirectory(compacted, result.Kept, p) {
+			delete(compacted.DirectoryETags, p)
+		}
+	}
+	result.RemovedDirectoryETags = len(state.DirectoryETags) - len(compacted.DirectoryETags)
+
+	if dryRun {
+		return result, nil
+	}
+
+	// ETags outside every tracked tree are removed with the directories
+	remove := append([]string{}, result.RemovedDirectories...)
+	for p := range state.DirectoryETags {
+		if !withinAny(p, result.Kept) && !withinAny(p, result.RemovedDirectories) {
+			remove = append(remove, p)
+		}
+	}
+	err = d.persist(func() error {
+		if err := d.store.Remove(remove); err != nil {
+			return fmt.Errorf("failed to remove directories from state: %w", err)
+		}
+		if len(result.Kept) > 0 {
+			if err := d.store.Save(result.Kept, compacted); err != nil {
+				return fmt.Errorf("failed to save state: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	slog.Info("Compacted state", "removed_directories", len(result.RemovedDirectories),
+		"removed_files", result.RemovedFiles, "removed_directory_etags", result.RemovedDirectoryETags)
+	return result, nil
+}
+
+// ResetResult reports what a state reset dropped
+type ResetResult struct {
+	Directory             string `json:"directory"`
+	Profile               string `json:"profile,omitempty"`
+	RemovedFiles          int    `json:"removed_files"`
+	RemovedDirectoryETags int    `json:"removed_directory_etags"`
+}
+
+// ResetState drops the stored state of the tracked directory dir in profile,
+// so its next diff starts from a clean baseline; other directories are untouched
+func (d *Detector) ResetState(dir, profile string) (*ResetResult, error) {
+	dir = normalizeDirectory(dir)
+	store, err := d.stateStore(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	release, err := d.acquireRun([]string{dir}, "", false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	state, err := store.Load([]string{dir})
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	if err := d.persist(func() error { return store.Remove([]string{dir}) }); err != nil {
+		return nil, fmt.Errorf("failed to remove %s from state: %w", dir, err)
+	}
+
+	// Don't let the next scan build on the dropped one
+	d.scansMu.Lock()
+	delete(d.scans, dir)
+	d.scansMu.Unlock()
+
+	slog.Info("Reset state", "directory", dir, "removed_files", len(state.Files), "removed_directory_etags", len(state.DirectoryETags))
+	return &ResetResult{
+		Directory:             dir,
+		Profile:               profile,
+		RemovedFiles:          len(state.Files),
+		RemovedDirectoryETags: len(state.DirectoryETags),
+	}, nil
+}
+
+// stateDirectories returns the tracked directories state holds entries for, sorted
+func stateDirectories(state *State) []string {
+	seen := make(map[string]bool)
+	for dir := range state.ScanSettings {
+		seen[dir] = true
+	}
+	for dir := range state.SyncTokens {
+		seen[dir] = true
+	}
+	for key := range state.Files {
+		// Keys are "<tracked dir>:<path>" and paths start with a slash
+		if i := strings.Index(key, ":/"); i > 0 {
+			seen[key[:i]] = true
+		}
+	}
+
+	dirs := make([]string, 0, len(seen))
+	for dir := range seen {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// trackedSubdirectory reports whether p is a directory in the stored tree of one of dirs
+func trackedSubdirectory(state *State, dirs []string, p string) bool {
+	for _, dir := range dirs {
+		if f, ok := state.Files[dir+":"+p]; ok && f.IsDir {
+			return true
+		}
+	}
+	return false
+}
+
+// withinAny reports whether p lies in or below one of dirs
+func withinAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if withinDirectory(p, dir) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,143 @@
+package diff
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"log/slog"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+
+	"go-nc-client/internal/webdav"
+)
+
+// ContentDiffOptions enables unified diffs in "updated" changes of small text files
+// The previous content comes from a local cache of the files seen so far, so
+// a file's first update after enabling this only fills the cache.
+type ContentDiffOptions struct {
+	// MaxSize is the largest file, in bytes, whose content is diffed
+	MaxSize int64
+	// Extensions lists the file extensions to diff, e.g. ".md" (case-insensitive)
+	Extensions []string
+	// CacheDir holds the last seen content of eligible files
+	CacheDir string
+}
+
+// eligible reports whether a file qualifies for content diffs
+func (o *ContentDiffOptions) eligible(filePath string, size int64) bool {
+	if size > o.MaxSize {
+		return false
+	}
+	ext := strings.ToLower(path.Ext(filePath))
+	for _, allowed := range o.Extensions {
+		if strings.ToLower(allowed) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// cachePath returns where the content of filePath is cached
+func (o *ContentDiffOptions) cachePath(filePath string) string {
+	sum := sha256.Sum256([]byte(filePath))
+	return filepath.Join(o.CacheDir, hex.EncodeToString(sum[:]))
+}
+
+// forProfile returns the options of a named profile, which caches the
+// content it last saw separately from the default state
+func (o *ContentDiffOptions) forProfile(profile string) *ContentDiffOptions {
+	if profile == "" {
+		return o
+	}
+	p := *o
+	p.CacheDir = filepath.Join(o.CacheDir, "profiles", profile)
+	return &p
+}
+
+// attachContentDiffs fills Change.Diff for updated text files and keeps the content cache current
+// With dryRun the cache is only read, so the same diffs are produced by the next real run.
+func (d *Detector) attachContentDiffs(client Client, changes []Change, profile string, dryRun bool) {
+	if d.options.ContentDiff == nil {
+		return
+	}
+	opts := d.options.ContentDiff.forProfile(profile)
+	if !dryRun {
+		if err := os.MkdirAll(opts.CacheDir, 0755); err != nil {
+			slog.Error("Content diffs disabled, cannot create cache", "path", opts.CacheDir, "error", err)
+			return
+		}
+	}
+
+	for i := range changes {
+		c := &changes[i]
+		if c.IsDir {
+			continue
+		}
+		if dryRun && c.Type != "updated" {
+			continue
+		}
+
+		switch c.Type {
+		case "deleted":
+			os.Remove(opts.cachePath(c.Path))
+		case "moved":
+			if err := os.Rename(opts.cachePath(c.OldPath), opts.cachePath(c.Path)); err != nil && !os.IsNotExist(err) {
+				slog.Warn("Could not move cached content", "path", c.OldPath, "error", err)
+			}
+		case "created", "updated":
+			if !opts.eligible(c.Path, c.Size) {
+				if !dryRun {
+					os.Remove(opts.cachePath(c.Path))
+				}
+				continue
+			}
+			content, err := readText(client, c.Path, opts.MaxSize)
+			if err != nil {
+				slog.Warn("Could not fetch file for content diff", "path", c.Path, "error", err)
+				continue
+			}
+			if content == nil {
+				// Not text after all
+				if !dryRun {
+					os.Remove(opts.cachePath(c.Path))
+				}
+				continue
+			}
+
+			if c.Type == "updated" {
+				if old, err := os.ReadFile(opts.cachePath(c.Path)); err == nil {
+					c.Diff = unifiedDiff(c.Path, string(old), string(content))
+				}
+			}
+			if dryRun {
+				continue
+			}
+			if err := os.WriteFile(opts.cachePath(c.Path), content, 0644); err != nil {
+				slog.Warn("Could not cache content", "path", c.Path, "error", err)
+			}
+		}
+	}
+}
+
+// readText downloads a file, returning nil content if it is not UTF-8 text
+func readText(client Client, filePath string, maxSize int64) ([]byte, error) {
+	file, err := client.Open(filePath, webdav.DownloadOptions{})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	// The size may have grown since the PROPFIND
+	content, err := io.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(content)) > maxSize || bytes.IndexByte(content, 0) >= 0 || !utf8.Valid(content) {
+		return nil, nil
+	}
+	return content, nil
+}
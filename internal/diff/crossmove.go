@@ -0,0 +1,76 @@
+package diff
+
+import "log/slog"
+
+// matchCrossDirectoryMoves turns a deletion in one tracked directory and a
+// creation with the same ETag in another into a single move
+// The move is reported with the destination directory. Nextcloud keeps the
+// ETag of a moved file, so only ETags unique among this run's deletions and
+// creations are matched. Directories with move detection off are left out.
+func (d *Detector) matchCrossDirectoryMoves(results []Changes, prevState *State, local map[string]LocalMeta) {
+	type ref struct {
+		result, change int
+	}
+	deleted := make(map[string][]ref)
+	created := make(map[string][]ref)
+	for i, result := range results {
+		if result.Error != "" {
+			continue
+		}
+		for j, c := range result.Changes {
+			if c.IsDir {
+				continue
+			}
+			switch c.Type {
+			case "deleted":
+				if f, ok := prevState.Files[result.Directory+":"+c.Path]; ok && f.ETag != "" {
+					deleted[f.ETag] = append(deleted[f.ETag], ref{i, j})
+				}
+			case "created":
+				if c.ETag != "" {
+					created[c.ETag] = append(created[c.ETag], ref{i, j})
+				}
+			}
+		}
+	}
+
+	removed := make(map[ref]bool)
+	for etag, dels := range deleted {
+		crs := created[etag]
+		if len(dels) != 1 || len(crs) != 1 || dels[0].result == crs[0].result {
+			continue
+		}
+		if d.moveDetection(results[dels[0].result].Directory).Mode == MoveModeOff ||
+			d.moveDetection(results[crs[0].result].Directory).Mode == MoveModeOff {
+			continue
+		}
+		del := results[dels[0].result].Changes[dels[0].change]
+		cr := &results[crs[0].result].Changes[crs[0].change]
+		if del.Size != cr.Size {
+			continue
+		}
+
+		slog.Debug("Detected move across directories", "from", del.Path, "to", cr.Path)
+		cr.Type = "moved"
+		cr.OldPath = del.Path
+		cr.Conflict = false
+		cr.Diff = ""
+		moved := []Change{*cr}
+		d.flagConflicts(moved, local)
+		*cr = moved[0]
+		removed[dels[0]] = true
+	}
+
+	if len(removed) == 0 {
+		return
+	}
+	for i := range results {
+		var kept []Change
+		for j, c := range results[i].Changes {
+			if !removed[ref{i, j}] {
+				kept = append(kept, c)
+			}
+		}
+		results[i].Changes = kept
+	}
+}
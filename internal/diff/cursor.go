@@ -0,0 +1,147 @@
+package diff
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// ErrCursorNotFound is returned when replaying a cursor that is unknown or no longer kept
+var ErrCursorNotFound = errors.New("cursor not found or expired")
+
+// CursorStore keeps the results of the last diff runs, each under an
+// increasing cursor, so a consumer can fetch a batch again after a crash
+type CursorStore struct {
+	path   string
+	keep   int
+	cipher *StateCipher
+
+	mu sync.Mutex
+}
+
+type cursorLog struct {
+	Last int64       `json:"last"`
+	Runs []cursorRun `json:"runs"`
+}
+
+type cursorRun struct {
+	Cursor   int64     `json:"cursor"`
+	Profile  string    `json:"profile,omitempty"`
+	Recorded time.Time `json:"recorded"`
+	Results  []Changes `json:"results"`
+}
+
+// NewCursorStore keeps the results of the last keep runs at path
+func NewCursorStore(path string, keep int) *CursorStore {
+	return &CursorStore{path: path, keep: keep}
+}
+
+// Encrypt makes the store encrypt the results it keeps with c, like the state
+func (s *CursorStore) Encrypt(c *StateCipher) *CursorStore {
+	s.cipher = c
+	return s
+}
+
+// record stores the results of a run and sets their cursor
+func (s *CursorStore) record(profile string, results []Changes) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.load()
+	if err != nil {
+		return 0, err
+	}
+	l.Last++
+	for i := range results {
+		results[i].Cursor = l.Last
+	}
+	l.Runs = append(l.Runs, cursorRun{Cursor: l.Last, Profile: profile, Recorded: time.Now(), Results: results})
+	if len(l.Runs) > s.keep {
+		l.Runs = l.Runs[len(l.Runs)-s.keep:]
+	}
+	if err := s.save(l); err != nil {
+		return 0, err
+	}
+	return l.Last, nil
+}
+
+// get returns the results recorded under cursor for profile
+func (s *CursorStore) get(cursor int64, profile string) ([]Changes, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	l, err := s.load()
+	if err != nil {
+		return nil, err
+	}
+	for _, run := range l.Runs {
+		if run.Cursor == cursor && run.Profile == profile {
+			return run.Results, nil
+		}
+	}
+	return nil, fmt.Errorf("%w: %d", ErrCursorNotFound, cursor)
+}
+
+func (s *CursorStore) load() (*cursorLog, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return &cursorLog{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if isEncrypted(data) {
+		if s.cipher == nil {
+			return nil, ErrStateEncrypted
+		}
+		if data, err = s.cipher.open(data); err != nil {
+			return nil, err
+		}
+	}
+	var l cursorLog
+	if err := json.Unmarshal(data, &l); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", s.path, err)
+	}
+	return &l, nil
+}
+
+// save writes the log atomically
+func (s *CursorStore) save(l *cursorLog) error {
+	data, err := json.Marshal(l)
+	if err != nil {
+		return err
+	}
+	if s.cipher != nil {
+		if data, err = s.cipher.seal(data); err != nil {
+			return err
+		}
+	}
+	if dir := filepath.Dir(s.path); dir != "." {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// CursorsEnabled reports whether runs are recorded for Replay
+func (d *Detector) CursorsEnabled() bool {
+	return d.options.Cursors != nil
+}
+
+// Replay returns the results of the run that was given cursor, as they were
+// first returned; profile must be the one the run used
+func (d *Detector) Replay(cursor int64, profile string) ([]Changes, error) {
+	if d.options.Cursors == nil {
+		return nil, ErrCursorNotFound
+	}
+	return d.options.Cursors.get(cursor, profile)
+}
@@ -1,20 +1,188 @@
 package diff
 
 import (
-	"encoding/json"
+	"context"
+	"errors"
 	"fmt"
-	"log"
-	"os"
-	"path/filepath"
+	"log/slog"
+	"path"
 	"strings"
+	"sync"
 	"time"
 
+	"go-nc-client/internal/filter"
 	"go-nc-client/internal/webdav"
 )
 
+// Client is the subset of the WebDAV client the detector depends on
+// WebDAVClient adapts a *webdav.Client to it; webdavtest.Client provides an
+// in-memory fake.
+type Client interface {
+	// WithContext returns the client making its requests with ctx, see DetectOptions.Context
+	WithContext(ctx context.Context) Client
+	Stat(filePath string) (*webdav.FileInfo, error)
+	ListFilesWithETagOptimization(dirPath string, includeHidden bool, etagChecker webdav.SubdirETagChecker, etagStorer webdav.SubdirETagStorer, hook webdav.ProgressHook, walk webdav.WalkOptions) ([]webdav.FileInfo, error)
+	SyncCollection(dirPath, syncToken string) (*webdav.SyncResult, error)
+	SyncToken(dirPath string) (string, error)
+	Open(filePath string, opts webdav.DownloadOptions) (*webdav.RemoteFile, error)
+}
+
+// webdavClient adapts *webdav.Client, whose WithContext returns the concrete type
+type webdavClient struct {
+	*webdav.Client
+}
+
+// WebDAVClient returns client as the Client of a Detector
+func WebDAVClient(client *webdav.Client) Client {
+	return webdavClient{client}
+}
+
+func (c webdavClient) WithContext(ctx context.Context) Client {
+	return webdavClient{c.Client.WithContext(ctx)}
+}
+
 type Detector struct {
-	client    *webdav.Client
-	stateFile string
+	client  Client
+	store   StateStore
+	options Options
+	// settingsMu guards the fields of options that UpdateSettings replaces
+	settingsMu sync.RWMutex
+
+	ignoreMu    sync.Mutex
+	ignoreCache map[string]cachedIgnore // key: tracked directory
+
+	// runMu is held for the whole of a DetectChanges run
+	runMu      sync.Mutex
+	runInfoMu  sync.Mutex
+	currentRun *RunInfo
+	// closing is set by Shutdown, under runInfoMu
+	closing bool
+	// saveMu is held while the state is written; aborted is set when
+	// Shutdown stopped waiting, so no further writes start
+	saveMu  sync.Mutex
+	aborted bool
+
+	profilesMu    sync.Mutex
+	profileStores map[string]StateStore
+	// scans holds the latest scan of each tracked directory, shared by profiles
+	scansMu sync.Mutex
+	scans   map[string]*State
+
+	observerMu sync.Mutex
+}
+
+// Options tunes how the detector gathers changes
+type Options struct {
+	// UseSyncTokens enables RFC 6578 sync-collection reports instead of
+	// rescanning changed directories, falling back to the ETag walk when
+	// the server rejects the stored token
+	UseSyncTokens bool
+	// NormalizeUnicode stores and compares paths in Unicode NFC so names
+	// uploaded as NFD (macOS) match their NFC equivalents across scans
+	NormalizeUnicode bool
+	// Filter leaves matching paths out of scans and reported changes
+	// Patterns are relative to each tracked directory; nil covers everything
+	Filter *filter.Filter
+	// Ignore holds global gitignore-style rules, applied before the
+	// .ncignore found at the root of each tracked directory
+	Ignore *filter.Ignore
+	// ConfirmChecksums double-checks files whose ETag changed while size and
+	// modification time did not, comparing oc:checksums or hashing the content,
+	// so server-side ETag churn is not reported as an update
+	ConfirmChecksums bool
+	// ContentDiff adds unified diffs to updates of small text files when set
+	ContentDiff *ContentDiffOptions
+	// MaxDepths limits how deep each tracked directory is walked
+	// (key: tracked directory, 1 = direct children only)
+	MaxDepths map[string]int
+	// MoveDetection tunes move detection per tracked directory (key: tracked
+	// directory); directories without an entry use the heuristic defaults
+	MoveDetection map[string]MoveDetection
+	// Journal records every reported change when set
+	Journal *Journal
+	// Snapshots stores named snapshots; nil disables them
+	Snapshots *SnapshotStore
+	// LockFile is locked during each run so processes sharing the state don't race
+	LockFile string
+	// Parallelism is how many tracked directories are scanned at once (0 or 1 = one at a time)
+	Parallelism int
+	// Acks holds the versions consumers acknowledged, for conflict detection
+	Acks *AckStore
+	// Transient holds back creations of short-lived files when set
+	Transient *TransientFilter
+	// Profiles opens the state store of a named profile; nil disables profiles
+	// Profiles keep their own state but reuse each other's scans.
+	Profiles func(name string) (StateStore, error)
+	// ProfileNames lists the configured profiles, whose states MoveState
+	// rewrites along with the default one
+	ProfileNames []string
+	// Cursors keeps the results of the last runs for replay; nil disables cursors
+	Cursors *CursorStore
+	// Jobs runs asynchronous diffs and keeps their results; nil disables them
+	Jobs *JobStore
+	// Observers are notified as DetectChanges runs progress
+	Observers []Observer
+	// Account is the AccountFingerprint of the server and user scanned; when
+	// set, it is stored with each directory's state and checked on load
+	Account string
+	// OnAccountChange is AccountChangeRefuse (default) or AccountChangeReset
+	OnAccountChange string
+}
+
+// DetectOptions are the per-call settings of DetectChanges
+type DetectOptions struct {
+	IncludeHidden bool
+	// FavoritesOnly restricts reported changes to favorited items and
+	// anything below a favorited directory
+	FavoritesOnly bool
+	// Progress is notified while directories are walked
+	Progress webdav.ProgressHook
+	// MaxDepth overrides Options.MaxDepths for this call when positive
+	MaxDepth int
+	// DryRun computes and returns changes without saving the new state, so
+	// the same changes are reported again by the next run
+	DryRun bool
+	// Wait queues behind a run in progress instead of failing with ErrDiffInProgress
+	Wait bool
+	// ChangeFilter narrows the reported changes when set
+	ChangeFilter *ChangeFilter
+	// Local is the consumer's metadata of its local copies (key: path),
+	// compared with Options.Acks to flag conflicts
+	Local map[string]LocalMeta
+	// Profile selects a named state, so each consumer gets the changes since
+	// it last asked (empty = default state)
+	Profile string
+	// CollapseDeletes reports a deleted directory as one change instead of
+	// one per deleted entry below it
+	CollapseDeletes bool
+	// RequestID ties the run to the API request that started it, see RunInfo
+	RequestID string
+	// Context cancels the run, which then saves nothing; nil never cancels
+	Context context.Context
+
+	// client is the detector's client bound to Context, set by bind
+	client Client
+}
+
+// bind returns opts with the client every request of the run is made with,
+// so they all stop once its Context ends
+func (d *Detector) bind(opts DetectOptions) DetectOptions {
+	opts.client = d.client
+	if opts.Context != nil {
+		opts.client = d.client.WithContext(opts.Context)
+	}
+	return opts
+}
+
+// ErrCancelled is returned by runs whose DetectOptions.Context ended
+var ErrCancelled = errors.New("diff cancelled")
+
+// cancelled returns ErrCancelled once the context of the run ended
+func (opts DetectOptions) cancelled() error {
+	if opts.Context == nil || opts.Context.Err() == nil {
+		return nil
+	}
+	return fmt.Errorf("%w: %w", ErrCancelled, context.Cause(opts.Context))
 }
 
 type FileState struct {
@@ -23,11 +191,46 @@ type FileState struct {
 	Size         int64     `json:"size"`
 	ModifiedTime time.Time `json:"modified_time"`
 	ETag         string    `json:"etag"`
+	Favorite     bool      `json:"favorite,omitempty"`
+	Checksum     string    `json:"checksum,omitempty"` // oc:checksums plus any computed SHA256
+	// PendingScans counts the scans a transient file has been seen in while
+	// its creation is held back (0 = reported)
+	PendingScans int `json:"pending_scans,omitempty"`
+}
+
+func newFileState(file webdav.FileInfo) FileState {
+	return FileState{
+		Path:         file.Path,
+		IsDir:        file.IsDir,
+		Size:         file.Size,
+		ModifiedTime: file.ModifiedTime,
+		ETag:         file.ETag,
+		Favorite:     file.Favorite,
+		Checksum:     file.Checksum,
+	}
+}
+
+// fileInfo converts a stored FileState back to a FileInfo
+func (fs FileState) fileInfo() webdav.FileInfo {
+	return webdav.FileInfo{
+		Path:         fs.Path,
+		IsDir:        fs.IsDir,
+		Size:         fs.Size,
+		ModifiedTime: fs.ModifiedTime,
+		ETag:         fs.ETag,
+		Favorite:     fs.Favorite,
+		Checksum:     fs.Checksum,
+	}
 }
 
 type State struct {
-	Files          map[string]FileState `json:"files"`           // key: directory+path
-	DirectoryETags map[string]string    `json:"directory_etags"` // key: directory path, value: ETag
+	SchemaVersion  int                  `json:"schema_version"`
+	Files          map[string]FileState `json:"files"`                   // key: directory+path
+	DirectoryETags map[string]string    `json:"directory_etags"`         // key: directory path, value: ETag
+	SyncTokens     map[string]string    `json:"sync_tokens,omitempty"`   // key: tracked directory, value: sync token
+	ScanSettings   map[string]string    `json:"scan_settings,omitempty"` // key: tracked directory, value: fingerprint of the filters used
+	Accounts       map[string]string    `json:"accounts,omitempty"`      // key: tracked directory, value: AccountFingerprint of the account scanned
+	ScannedAt      map[string]time.Time `json:"scanned_at,omitempty"`    // key: tracked directory, value: when it was last scanned
 	LastUpdate     time.Time            `json:"last_update"`
 }
 
@@ -38,247 +241,472 @@ type Change struct {
 	IsDir    bool      `json:"is_dir"`
 	Size     int64     `json:"size"`
 	Modified time.Time `json:"modified"`
+	Diff     string    `json:"diff,omitempty"` // unified diff of text content, see ContentDiffOptions
+	ETag     string    `json:"etag,omitempty"` // current ETag, empty for deletions
+	// Previous metadata, set on updates
+	OldSize     *int64     `json:"old_size,omitempty"`
+	OldModified *time.Time `json:"old_modified,omitempty"`
+	OldETag     string     `json:"old_etag,omitempty"`
+	// Set on a deleted directory whose deleted contents were collapsed into it
+	Count     int   `json:"count,omitempty"`
+	TotalSize int64 `json:"total_size,omitempty"`
+	// Conflict is set when both the server and the consumer's local copy
+	// changed since the consumer last acknowledged the file
+	Conflict bool `json:"conflict,omitempty"`
 }
 
 type Changes struct {
 	Directory string    `json:"directory"`
 	Changes   []Change  `json:"changes"`
 	Timestamp time.Time `json:"timestamp"`
+	// Error is set when the directory could not be scanned; its state is left as it was
+	Error string `json:"error,omitempty"`
+	// Cursor identifies the run, to fetch the same results again (see Detector.Replay)
+	Cursor int64 `json:"cursor,omitempty"`
+	// Strategy is how the directory's current state was gathered (Strategy* constants)
+	Strategy string `json:"strategy,omitempty"`
+	// Stats describes the work the scan did; it is only set by DetectChanges
+	Stats *ScanStats `json:"stats,omitempty"`
+}
+
+// ScanStats is the work done to scan one tracked directory
+type ScanStats struct {
+	// DirsVisited is the number of directories listed
+	DirsVisited int `json:"dirs_visited"`
+	// PropfindRequests counts the stat, listing and sync-token PROPFINDs
+	PropfindRequests int `json:"propfind_requests"`
+	// ETagCacheHits is the number of directories whose previous state was
+	// reused because their ETag was unchanged
+	ETagCacheHits int   `json:"etag_cache_hits"`
+	DurationMS    int64 `json:"duration_ms"`
+	// ChangedBytes is the total size of the created and updated files reported
+	ChangedBytes int64 `json:"changed_bytes"`
 }
 
-func NewDetector(client *webdav.Client, stateFile string) *Detector {
+func NewDetector(client Client, store StateStore, options Options) *Detector {
+	options.MaxDepths, options.MoveDetection = normalizeDirectorySettings(options.MaxDepths, options.MoveDetection)
+
 	return &Detector{
-		client:    client,
-		stateFile: stateFile,
+		client:        client,
+		store:         store,
+		options:       options,
+		ignoreCache:   make(map[string]cachedIgnore),
+		profileStores: make(map[string]StateStore),
+		scans:         make(map[string]*State),
 	}
 }
 
-func (d *Detector) DetectChanges(directories []string, includeHidden bool) ([]Changes, error) {
-	absPath, _ := filepath.Abs(d.stateFile)
-	log.Printf("Loading previous state from %s (absolute: %s)", d.stateFile, absPath)
+func (d *Detector) DetectChanges(directories []string, opts DetectOptions) ([]Changes, error) {
+	if err := opts.ChangeFilter.Validate(); err != nil {
+		return nil, err
+	}
+	opts = d.bind(opts)
 
-	// Load previous state
-	prevState, err := d.loadState()
-	if err != nil {
-		log.Printf("[DIFF] No previous state found or error loading: %v", err)
-		prevState = &State{
-			Files:          make(map[string]FileState),
-			DirectoryETags: make(map[string]string),
-			LastUpdate:     time.Time{},
+	dirs := make([]string, len(directories))
+	for i, dir := range directories {
+		dirs[i] = normalizeDirectory(dir)
+	}
+
+	if len(opts.Local) > 0 {
+		local := make(map[string]LocalMeta, len(opts.Local))
+		for p, meta := range opts.Local {
+			local[normalizeDirectory(p)] = meta
 		}
-	} else {
-		log.Printf("[DIFF] Loaded previous state: %d files tracked, last update: %v",
-			len(prevState.Files), prevState.LastUpdate)
+		opts.Local = local
+	}
+
+	store, err := d.stateStore(opts.Profile)
+	if err != nil {
+		return nil, err
 	}
 
-	// Ensure DirectoryETags map exists
-	if prevState.DirectoryETags == nil {
-		prevState.DirectoryETags = make(map[string]string)
+	release, err := d.acquireRun(dirs, opts.RequestID, opts.Wait)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+	run := d.CurrentRun()
+	// Cancelled while queued behind another run
+	if err := opts.cancelled(); err != nil {
+		return nil, err
+	}
+
+	// Load previous state of the requested directories
+	prevState, err := store.Load(dirs)
+	if errors.Is(err, ErrUnsupportedSchema) {
+		err = fmt.Errorf("failed to load state: %w", err)
+		d.notify(func(o Observer) { o.OnError(run, "", err) })
+		return nil, err
+	}
+	if err == nil && d.options.NormalizeUnicode {
+		normalizeState(prevState)
+	}
+	if err != nil {
+		slog.Info("No previous state found or error loading it", "error", err)
+		prevState = newState()
+	} else {
+		slog.Debug("Loaded previous state", "files", len(prevState.Files), "last_update", prevState.LastUpdate)
+	}
+	prevState.init()
+	if err := d.checkAccount(prevState, dirs); err != nil {
+		d.notify(func(o Observer) { o.OnError(run, "", err) })
+		return nil, err
 	}
 
 	// Get current state
-	currentState := &State{
-		Files:          make(map[string]FileState),
-		DirectoryETags: make(map[string]string),
-		LastUpdate:     time.Now(),
+	currentState := newState()
+	currentState.LastUpdate = time.Now()
+
+	scans := make([]*dirScan, len(dirs))
+	errs := make([]error, len(dirs))
+	if opts.Progress != nil {
+		opts.Progress = &syncProgress{hook: opts.Progress}
+	}
+
+	// Directories are independent, so scan several at once
+	sem := make(chan struct{}, max(d.options.Parallelism, 1))
+	var wg sync.WaitGroup
+	for i, dir := range dirs {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			d.notify(func(o Observer) { o.OnScanStart(run, dir) })
+			scans[i], errs[i] = d.detectDirectory(dir, prevState, opts)
+		}()
 	}
+	wg.Wait()
 
+	// A cancelled run keeps the previous state of every directory, even
+	// those scanned completely
+	if err := opts.cancelled(); err != nil {
+		slog.Info("Run cancelled, state not saved", "run_id", run.ID)
+		d.notify(func(o Observer) { o.OnError(run, "", err) })
+		return nil, err
+	}
+
+	// Merge in request order so overlapping directories resolve the same way every run
+	// A failed directory is reported but doesn't discard the others
 	var allChanges []Changes
+	var succeeded []string
+	var firstErr error
+	for i, dir := range dirs {
+		if errs[i] != nil {
+			if firstErr == nil {
+				firstErr = errs[i]
+			}
+			d.notify(func(o Observer) { o.OnError(run, dir, errs[i]) })
+			allChanges = append(allChanges, Changes{
+				Directory: dir,
+				Changes:   []Change{},
+				Timestamp: time.Now(),
+				Error:     errs[i].Error(),
+			})
+			continue
+		}
+		scans[i].state.copyDirectories(currentState, []string{dir})
+		d.rememberScan(dir, scans[i].state)
+		succeeded = append(succeeded, dir)
+		allChanges = append(allChanges, Changes{
+			Directory: dir,
+			Changes:   scans[i].changes,
+			Timestamp: time.Now(),
+			Strategy:  scans[i].strategy,
+			Stats:     scans[i].stats,
+		})
+	}
+	if len(succeeded) == 0 {
+		return nil, firstErr
+	}
 
-	for _, dir := range directories {
-		dir = strings.TrimPrefix(dir, "/")
-		if dir == "" {
-			dir = "/"
+	if len(succeeded) > 1 {
+		d.matchCrossDirectoryMoves(allChanges, prevState, opts.Local)
+	}
+	for i, dir := range dirs {
+		if errs[i] != nil {
+			continue
 		}
-		if !strings.HasPrefix(dir, "/") {
-			dir = "/" + dir
+		if opts.FavoritesOnly {
+			allChanges[i].Changes = filterFavorites(allChanges[i].Changes, dir, prevState, scans[i].state)
 		}
+		allChanges[i].Stats.ChangedBytes = changedBytes(allChanges[i].Changes)
+		d.notify(func(o Observer) { o.OnScanComplete(run, dir, allChanges[i].Changes) })
+	}
+	if len(succeeded) < len(dirs) {
+		slog.Warn("Some directories failed, keeping their previous state", "failed", len(dirs)-len(succeeded), "directories", len(dirs))
+	}
 
-		dirInfo, err := d.client.Stat(dir)
-		if err != nil {
-			log.Printf("Error statting directory %s: %v", dir, err)
-			return nil, fmt.Errorf("failed to stat directory %s: %w", dir, err)
-		}
+	if opts.DryRun {
+		slog.Debug("Dry run, state not saved")
+		return opts.ChangeFilter.ApplyResults(collapseResults(allChanges, opts)), nil
+	}
 
-		prevDirETag := prevState.DirectoryETags[dir]
-		currentDirETag := dirInfo.ETag
-		directoryUnchanged := prevDirETag != "" && prevDirETag == currentDirETag
+	// Save new state of the directories that scanned fine
+	if err := d.persist(func() error { return store.Save(succeeded, currentState) }); err != nil {
+		slog.Error("Failed to save state", "error", err)
+		err = fmt.Errorf("failed to save state: %w", err)
+		d.notify(func(o Observer) { o.OnError(run, "", err) })
+		return nil, err
+	}
 
-		if directoryUnchanged {
-			log.Printf("Directory %s unchanged, reusing state", dir)
+	// The journal is a convenience; failing to write it doesn't fail the run
+	// Profiles report the same changes again, so only the default state is journaled.
+	if d.options.Journal != nil && opts.Profile == "" {
+		if err := d.options.Journal.Append(successful(allChanges)); err != nil {
+			slog.Error("Failed to append to journal", "error", err)
 		}
+	}
 
-		var files []webdav.FileInfo
-		if directoryUnchanged {
-			// Directory hasn't changed, reuse previous state
-			dirKey := dir
-			dirPrefix := dirKey + ":"
-			fileCount := 0
-			// Pre-allocate slice with estimated capacity
-			for key, fileState := range prevState.Files {
-				if strings.HasPrefix(key, dirPrefix) {
-					// Filter hidden files if not including them
-					if !includeHidden && isHidden(fileState.Path) {
-						continue
-					}
-					// Copy file from previous state
-					currentState.Files[key] = fileState
-					// Convert FileState back to FileInfo for consistency
-					files = append(files, webdav.FileInfo{
-						Path:         fileState.Path,
-						IsDir:        fileState.IsDir,
-						Size:         fileState.Size,
-						ModifiedTime: fileState.ModifiedTime,
-						ETag:         fileState.ETag,
-					})
-					fileCount++
-				}
+	allChanges = collapseResults(allChanges, opts)
+	d.notify(func(o Observer) {
+		results := successful(allChanges)
+		for _, result := range results {
+			for _, change := range result.Changes {
+				o.OnChange(run, result.Directory, change)
 			}
+		}
+		o.OnRunComplete(run, results)
+	})
+	if d.options.Cursors != nil {
+		// The changes are consumed already, so a failure only costs the replay
+		if cursor, err := d.options.Cursors.record(opts.Profile, allChanges); err != nil {
+			slog.Error("Failed to record cursor", "error", err)
 		} else {
-			// Directory has changed or first scan, do full recursive scan with ETag optimization
-			scanStartTime := time.Now()
-
-			// Pre-filter files for this directory to avoid repeated scans
-			dirKey := dir
-			dirPrefix := dirKey + ":"
-			prevFilesForDir := make(map[string]FileState)
-			for key, fileState := range prevState.Files {
-				if strings.HasPrefix(key, dirPrefix) {
-					prevFilesForDir[key] = fileState
-				}
-			}
+			slog.Debug("Results recorded", "cursor", cursor)
+		}
+	}
 
-			// Create ETag checker callback for subdirectories
-			etagChecker := func(subdirPath string) (bool, string, []webdav.FileInfo, error) {
-				// Normalize subdirectory path
-				normalizedSubdir := subdirPath
-				if !strings.HasPrefix(normalizedSubdir, "/") {
-					normalizedSubdir = "/" + normalizedSubdir
-				}
+	// Only the caller's view is filtered, the journal, observers and cursors get every change
+	return opts.ChangeFilter.ApplyResults(allChanges), nil
+}
 
-				// Try to get ETag from DirectoryETags map first (fastest path)
-				prevETag, hasETag := prevState.DirectoryETags[normalizedSubdir]
-				subdirKey := dirPrefix + normalizedSubdir
-				
-				// Check if directory itself exists in state (for fallback ETag)
-				if !hasETag {
-					if dirState, exists := prevFilesForDir[subdirKey]; exists && dirState.IsDir && dirState.ETag != "" {
-						prevETag = dirState.ETag
-						hasETag = true
-					}
-				}
+// collapseResults applies DetectOptions.CollapseDeletes; the journal keeps every deletion
+func collapseResults(results []Changes, opts DetectOptions) []Changes {
+	if !opts.CollapseDeletes {
+		return results
+	}
+	for i := range results {
+		results[i].Changes = collapseDeletions(results[i].Changes)
+	}
+	return results
+}
 
-				if !hasETag {
-					return false, "", nil, nil
-				}
+// History returns the journaled changes matching q, oldest first
+func (d *Detector) History(q HistoryQuery) ([]JournalEntry, error) {
+	if d.options.Journal == nil {
+		return nil, ErrNoJournal
+	}
+	if q.Path != "" {
+		q.Path = strings.TrimSuffix(normalizeDirectory(q.Path), "/")
+		if q.Path == "" {
+			q.Path = "/"
+		}
+	}
+	return d.options.Journal.Query(q)
+}
 
-				// Only collect files if we have a valid previous ETag
-				// Note: The actual ETag comparison happens in walkDirWithProgress
-				// We return files here so they can be reused if ETag matches
-				var prevFiles []webdav.FileInfo
-				subdirPrefix := normalizedSubdir + "/"
-				for _, fileState := range prevFilesForDir {
-					filePath := fileState.Path
-					// Check if this file belongs to the subdirectory
-					if filePath == normalizedSubdir || strings.HasPrefix(filePath, subdirPrefix) {
-						prevFiles = append(prevFiles, webdav.FileInfo{
-							Path:         fileState.Path,
-							IsDir:        fileState.IsDir,
-							Size:         fileState.Size,
-							ModifiedTime: fileState.ModifiedTime,
-							ETag:         fileState.ETag,
-						})
-					}
-				}
+// successful returns the results of the directories that scanned without error
+func successful(results []Changes) []Changes {
+	var ok []Changes
+	for _, result := range results {
+		if result.Error == "" {
+			ok = append(ok, result)
+		}
+	}
+	return ok
+}
 
-				return true, prevETag, prevFiles, nil
-			}
+// Failed reports how many directories of a DetectChanges result could not be scanned
+func Failed(results []Changes) int {
+	failed := 0
+	for _, result := range results {
+		if result.Error != "" {
+			failed++
+		}
+	}
+	return failed
+}
 
-			// Create ETag storer callback to store subdirectory ETags as we encounter them
-			etagStorer := func(subdirPath string, etag string) {
-				// Normalize subdirectory path
-				normalizedSubdir := subdirPath
-				if !strings.HasPrefix(normalizedSubdir, "/") {
-					normalizedSubdir = "/" + normalizedSubdir
-				}
-				currentState.DirectoryETags[normalizedSubdir] = etag
-			}
+// dirScan is the outcome of scanning one tracked directory
+type dirScan struct {
+	state    *State
+	changes  []Change
+	strategy string
+	stats    *ScanStats
+}
 
-			files, err = d.client.ListFilesWithETagOptimization(dir, includeHidden, etagChecker, etagStorer)
-			if err != nil {
-				log.Printf("Error listing files in %s: %v", dir, err)
-				return nil, fmt.Errorf("failed to list files in %s: %w", dir, err)
-			}
-			log.Printf("Scanned %d files in %s (%v)", len(files), dir, time.Since(scanStartTime))
-
-			// Build current state for this directory
-			for _, file := range files {
-				key := dirKey + ":" + file.Path
-				currentState.Files[key] = FileState{
-					Path:         file.Path,
-					IsDir:        file.IsDir,
-					Size:         file.Size,
-					ModifiedTime: file.ModifiedTime,
-					ETag:         file.ETag,
+// detectDirectory scans one tracked directory and compares it with its previous state
+// It returns the directory's new state; prevState is only read, so directories
+// can be scanned concurrently.
+func (d *Detector) detectDirectory(dir string, prevState *State, opts DetectOptions) (*dirScan, error) {
+	start := time.Now()
+	currentState := newState()
+	stats := &ScanStats{PropfindRequests: 1}
+
+	dirInfo, err := opts.client.Stat(dir)
+	if err != nil {
+		slog.Error("Failed to stat directory", "directory", dir, "error", err)
+		return nil, fmt.Errorf("failed to stat directory %s: %w", dir, err)
+	}
+
+	dirFilter, maxDepth, err := d.scanFilter(dir, dirInfo.ETag, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	// State scanned with other filters is incomplete or has extra entries,
+	// so none of it can be reused as-is
+	settings := scanSettings(dirFilter, opts.IncludeHidden)
+	currentState.ScanSettings[dir] = settings
+	if d.options.Account != "" {
+		currentState.Accounts[dir] = d.options.Account
+	}
+	currentState.ScannedAt[dir] = time.Now()
+	// The walk reuses the latest scan, which may come from another profile;
+	// changes are still computed against prevState
+	base := d.scanBase(dir, settings, prevState)
+	settingsChanged := base.ScanSettings[dir] != settings
+	if settingsChanged && len(base.DirectoryETags) > 0 {
+		slog.Info("Scan settings changed since last run, rescanning", "directory", dir)
+	}
+
+	prevDirETag := base.DirectoryETags[dir]
+	sc := &scanContext{
+		dir:             dir,
+		opts:            opts,
+		dirFilter:       dirFilter,
+		maxDepth:        maxDepth,
+		settings:        settings,
+		prevState:       prevState,
+		base:            base,
+		currentState:    currentState,
+		settingsChanged: settingsChanged,
+		unchanged:       !settingsChanged && prevDirETag != "" && prevDirETag == dirInfo.ETag,
+		stats:           stats,
+	}
+	strategy, err := d.gatherState(sc)
+	if err != nil {
+		return nil, err
+	}
+
+	// Store directory ETag
+	currentState.DirectoryETags[dir] = dirInfo.ETag
+
+	// Keep a sync token for the next run
+	if d.options.UseSyncTokens && strategy != StrategySyncToken {
+		if sc.unchanged && prevState.SyncTokens[dir] != "" {
+			currentState.SyncTokens[dir] = prevState.SyncTokens[dir]
+		} else {
+			stats.PropfindRequests++
+			if token, err := opts.client.SyncToken(dir); err == nil {
+				currentState.SyncTokens[dir] = token
+			} else {
+				slog.Warn("Could not fetch sync token", "directory", dir, "error", err)
+				// A token the report failed with for other reasons is still valid
+				if !sc.tokenExpired && prevState.SyncTokens[dir] != "" {
+					currentState.SyncTokens[dir] = prevState.SyncTokens[dir]
 				}
 			}
 		}
+	}
 
-		// Store directory ETag
-		currentState.DirectoryETags[dir] = currentDirETag
+	// Detect changes
+	changes := d.compareStates(opts.client, dir, prevState, currentState)
+	changes = filterChanges(dirFilter, dir, changes)
+	changes = d.options.Transient.debounce(dir, changes, prevState, currentState)
+	attachETags(dir, changes, currentState)
+	d.flagConflicts(changes, opts.Local)
+	d.attachContentDiffs(opts.client, changes, opts.Profile, opts.DryRun)
+
+	changeCounts := make(map[string]int)
+	for _, change := range changes {
+		changeCounts[change.Type]++
+	}
+	if len(changes) > 0 {
+		slog.Info("Detected changes", "directory", dir, "changes", len(changes), "by_type", changeCounts)
+	}
 
-		// Detect changes
-		changes := d.compareStates(dir, prevState, currentState)
+	stats.DurationMS = time.Since(start).Milliseconds()
+	return &dirScan{state: currentState, changes: changes, strategy: strategy, stats: stats}, nil
+}
 
-		changeCounts := make(map[string]int)
-		for _, change := range changes {
-			changeCounts[change.Type]++
-		}
-		if len(changes) > 0 {
-			log.Printf("Detected %d changes in %s: %v", len(changes), dir, changeCounts)
+// changedBytes sums the sizes of the files created or updated by changes
+func changedBytes(changes []Change) int64 {
+	var total int64
+	for _, change := range changes {
+		if !change.IsDir && (change.Type == "created" || change.Type == "updated") {
+			total += change.Size
 		}
+	}
+	return total
+}
 
-		allChanges = append(allChanges, Changes{
-			Directory: dir,
-			Changes:   changes,
-			Timestamp: time.Now(),
-		})
+// applySyncResult builds the current state of a directory from its previous
+// state plus the members reported by a sync-collection REPORT
+func (d *Detector) applySyncResult(dir string, prevState, currentState *State, result *webdav.SyncResult, includeHidden bool, dirFilter *filter.Filter) {
+	dirPrefix := dir + ":"
 
+	for key, fileState := range prevState.Files {
+		if strings.HasPrefix(key, dirPrefix) {
+			if !includeHidden && isHidden(fileState.Path) {
+				continue
+			}
+			if skipped(dirFilter, dir, fileState.Path, fileState.IsDir) {
+				continue
+			}
+			currentState.Files[key] = fileState
+		}
 	}
 
-	// Save new state
-	if err := d.saveState(currentState); err != nil {
-		log.Printf("Error saving state: %v", err)
-		return nil, fmt.Errorf("failed to save state: %w", err)
+	// Carry over subdirectory ETags; changed ones are overwritten below
+	subdirPrefix := strings.TrimSuffix(dir, "/") + "/"
+	for path, etag := range prevState.DirectoryETags {
+		if strings.HasPrefix(path, subdirPrefix) {
+			currentState.DirectoryETags[path] = etag
+		}
 	}
 
-	return allChanges, nil
-}
-
-func (d *Detector) compareStates(directory string, prevState, currentState *State) []Change {
-	var changes []Change
-	dirKey := directory
-	dirPrefix := dirKey + ":"
-
-	// Pre-filter files for this directory to avoid repeated prefix checks
-	prevFilesForDir := make(map[string]FileState)
-	currentFilesForDir := make(map[string]FileState)
-	
-	for key, file := range prevState.Files {
-		if strings.HasPrefix(key, dirPrefix) {
-			prevFilesForDir[key] = file
+	for _, deletedPath := range result.Deleted {
+		// A deleted directory takes its whole subtree with it
+		delete(currentState.Files, dirPrefix+deletedPath)
+		delete(currentState.DirectoryETags, deletedPath)
+		descendantPrefix := dirPrefix + deletedPath + "/"
+		for key := range currentState.Files {
+			if strings.HasPrefix(key, descendantPrefix) {
+				delete(currentState.Files, key)
+			}
 		}
 	}
-	
-	for key, file := range currentState.Files {
-		if strings.HasPrefix(key, dirPrefix) {
-			currentFilesForDir[key] = file
+
+	for _, file := range result.Changed {
+		if !includeHidden && isHidden(file.Path) {
+			continue
+		}
+		if skipped(dirFilter, dir, file.Path, file.IsDir) {
+			continue
+		}
+		currentState.Files[dirPrefix+file.Path] = newFileState(file)
+		if file.IsDir && file.ETag != "" {
+			currentState.DirectoryETags[file.Path] = file.ETag
 		}
 	}
+}
+
+func (d *Detector) compareStates(client Client, directory string, prevState, currentState *State) []Change {
+	var changes []Change
+	dirPrefix := directory + ":"
+
+	// Both states are keyed the same way, so entries are looked up in place
+	// rather than copied into per-directory maps; peak memory stays at the
+	// two states plus the changes
 
 	// Check for created and updated files
-	for key, currentFile := range currentFilesForDir {
-		prevFile, exists := prevFilesForDir[key]
+	for key, currentFile := range currentState.Files {
+		if !strings.HasPrefix(key, dirPrefix) {
+			continue
+		}
+		prevFile, exists := prevState.Files[key]
 		if !exists {
 			// New file
 			changes = append(changes, Change{
@@ -291,28 +719,31 @@ func (d *Detector) compareStates(directory string, prevState, currentState *Stat
 		} else {
 			// Check if updated - ETag comparison is fastest, so check it first
 			if currentFile.ETag != prevFile.ETag {
-				changes = append(changes, Change{
-					Type:     "updated",
-					Path:     currentFile.Path,
-					IsDir:    currentFile.IsDir,
-					Size:     currentFile.Size,
-					Modified: currentFile.ModifiedTime,
-				})
+				if d.options.ConfirmChecksums && d.suspectedUpdate(prevFile, currentFile) {
+					confirmed := d.confirmUpdate(client, prevFile, &currentFile)
+					currentState.Files[key] = currentFile
+					if !confirmed {
+						slog.Debug("Ignoring ETag change, content unchanged", "path", currentFile.Path)
+						continue
+					}
+				}
+				changes = append(changes, updatedChange(prevFile, currentFile))
 			} else if currentFile.Size != prevFile.Size || !currentFile.ModifiedTime.Equal(prevFile.ModifiedTime) {
-				changes = append(changes, Change{
-					Type:     "updated",
-					Path:     currentFile.Path,
-					IsDir:    currentFile.IsDir,
-					Size:     currentFile.Size,
-					Modified: currentFile.ModifiedTime,
-				})
+				changes = append(changes, updatedChange(prevFile, currentFile))
+			} else if d.options.ConfirmChecksums && prevFile.Checksum != currentFile.Checksum {
+				// Same content, keep checksums computed in earlier runs
+				currentFile.Checksum = mergeChecksums(currentFile.Checksum, prevFile.Checksum)
+				currentState.Files[key] = currentFile
 			}
 		}
 	}
 
 	// Check for deleted files
-	for key, prevFile := range prevFilesForDir {
-		if _, exists := currentFilesForDir[key]; !exists {
+	for key, prevFile := range prevState.Files {
+		if !strings.HasPrefix(key, dirPrefix) {
+			continue
+		}
+		if _, exists := currentState.Files[key]; !exists {
 			changes = append(changes, Change{
 				Type:     "deleted",
 				Path:     prevFile.Path,
@@ -324,203 +755,247 @@ func (d *Detector) compareStates(directory string, prevState, currentState *Stat
 	}
 
 	// Detect moved files (same size and similar timestamp, different path)
-	changes = d.detectMoves(changes, directory, prevFilesForDir, currentFilesForDir)
+	changes = d.detectMoves(changes, directory, prevState, currentState)
 
 	return changes
 }
 
-func (d *Detector) detectMoves(changes []Change, directory string, prevFilesForDir, currentFilesForDir map[string]FileState) []Change {
-	// Find deleted files that might have been moved
-	deletedFiles := make(map[string]FileState)
-	for key, prevFile := range prevFilesForDir {
-		if _, exists := currentFilesForDir[key]; !exists {
-			deletedFiles[key] = prevFile
-		}
+// updatedChange reports an update of prev to cur, with the previous metadata
+func updatedChange(prev, cur FileState) Change {
+	return Change{
+		Type:        "updated",
+		Path:        cur.Path,
+		IsDir:       cur.IsDir,
+		Size:        cur.Size,
+		Modified:    cur.ModifiedTime,
+		OldSize:     &prev.Size,
+		OldModified: &prev.ModifiedTime,
+		OldETag:     prev.ETag,
 	}
+}
 
-	// Find created files that might be moves
-	createdFiles := make(map[string]FileState)
-	for key, currentFile := range currentFilesForDir {
-		if _, exists := prevFilesForDir[key]; !exists {
-			createdFiles[key] = currentFile
-		}
+// detectMoves turns deletions and creations of the same file into moves, as
+// tuned by the directory's MoveDetection
+func (d *Detector) detectMoves(changes []Change, directory string, prevState, currentState *State) []Change {
+	settings := d.moveDetection(directory)
+	if settings.Mode == MoveModeOff {
+		return changes
 	}
-
-	// Build indexes for faster lookup
-	// Index by ETag for O(1) lookup
-	deletedByETag := make(map[string]string) // ETag -> key
-	createdByETag := make(map[string]string) // ETag -> key
-	
-	// Index by size for size-based matching
-	deletedBySize := make(map[int64][]string) // size -> []keys
-	createdBySize := make(map[int64][]string) // size -> []keys
-
-	for key, delFile := range deletedFiles {
-		if !delFile.IsDir && delFile.Size > 0 {
-			if delFile.ETag != "" {
-				deletedByETag[delFile.ETag] = key
-			}
-			deletedBySize[delFile.Size] = append(deletedBySize[delFile.Size], key)
+	dirPrefix := directory + ":"
+
+	// Index the created and deleted files that might be moves by position in
+	// changes; only these are looked at, not the whole directory
+	// ETag -> index, for O(1) lookup
+	deletedByETag := make(map[string]int)
+	createdByETag := make(map[string]int)
+	// size -> indexes, for size-based matching
+	deletedBySize := make(map[int64][]int)
+	createdBySize := make(map[int64][]int)
+
+	for i, c := range changes {
+		if c.IsDir || c.Size <= 0 {
+			continue
 		}
-	}
-
-	for key, crFile := range createdFiles {
-		if !crFile.IsDir && crFile.Size > 0 {
-			if crFile.ETag != "" {
-				createdByETag[crFile.ETag] = key
+		switch c.Type {
+		case "deleted":
+			if etag := prevState.Files[dirPrefix+c.Path].ETag; etag != "" {
+				deletedByETag[etag] = i
+			}
+			deletedBySize[c.Size] = append(deletedBySize[c.Size], i)
+		case "created":
+			if etag := currentState.Files[dirPrefix+c.Path].ETag; etag != "" {
+				createdByETag[etag] = i
 			}
-			createdBySize[crFile.Size] = append(createdBySize[crFile.Size], key)
+			createdBySize[c.Size] = append(createdBySize[c.Size], i)
 		}
 	}
 
+	// movedFrom maps the index of a created change to the deleted one it replaces
+	movedFrom := make(map[int]int)
+	matched := make(map[int]bool)
+
 	// Priority 1: ETag matching (most reliable - same ETag = same file)
 	// This is O(n) instead of O(n²)
-	matchedKeys := make(map[string]bool)
-	for etag, delKey := range deletedByETag {
-		if crKey, exists := createdByETag[etag]; exists {
-			delFile := deletedFiles[delKey]
-			crFile := createdFiles[crKey]
-			
-			changes = removeChange(changes, "created", crFile.Path)
-			changes = removeChange(changes, "deleted", delFile.Path)
-
-			changes = append(changes, Change{
-				Type:     "moved",
-				Path:     crFile.Path,
-				OldPath:  delFile.Path,
-				IsDir:    crFile.IsDir,
-				Size:     crFile.Size,
-				Modified: crFile.ModifiedTime,
-			})
-
-			matchedKeys[delKey] = true
-			matchedKeys[crKey] = true
+	for etag, del := range deletedByETag {
+		if cr, exists := createdByETag[etag]; exists {
+			movedFrom[cr] = del
+			matched[del] = true
+			matched[cr] = true
 		}
 	}
 
-	// Remove matched files from consideration
-	for key := range matchedKeys {
-		delete(deletedFiles, key)
-		delete(createdFiles, key)
-	}
-
 	// Priority 2: Size matching with uniqueness check and time constraint
 	// Only check sizes that have exactly one deleted and one created file
-	for size, delKeys := range deletedBySize {
-		crKeys, exists := createdBySize[size]
-		if !exists || len(delKeys) != 1 || len(crKeys) != 1 {
+	for size, dels := range deletedBySize {
+		if settings.Mode == MoveModeETag || size < settings.MinSize {
 			continue
 		}
+		crs, exists := createdBySize[size]
+		if !exists || len(dels) != 1 || len(crs) != 1 {
+			continue
+		}
+		del, cr := dels[0], crs[0]
 
-		delKey := delKeys[0]
-		crKey := crKeys[0]
-		
 		// Skip if already matched
-		if matchedKeys[delKey] || matchedKeys[crKey] {
+		if matched[del] || matched[cr] {
 			continue
 		}
 
-		delFile := deletedFiles[delKey]
-		crFile := createdFiles[crKey]
-
-		// Check if times are within 1 minute
-		timeDiff := crFile.ModifiedTime.Sub(delFile.ModifiedTime)
-		if timeDiff < 1*time.Minute && timeDiff > -1*time.Minute {
+		// Check if times are within the window
+		timeDiff := changes[cr].Modified.Sub(changes[del].Modified)
+		if timeDiff < settings.Window && timeDiff > -settings.Window {
 			// Unique size match with close timestamps - very likely a move
-			changes = removeChange(changes, "created", crFile.Path)
-			changes = removeChange(changes, "deleted", delFile.Path)
+			movedFrom[cr] = del
+			matched[del] = true
+			matched[cr] = true
+		}
+	}
 
-			changes = append(changes, Change{
+	if len(movedFrom) == 0 {
+		return changes
+	}
+
+	// Rebuild in one pass, with the moves after the remaining changes
+	result := make([]Change, 0, len(changes)-len(movedFrom))
+	var moves []Change
+	for i, c := range changes {
+		if !matched[i] {
+			result = append(result, c)
+			continue
+		}
+		if del, ok := movedFrom[i]; ok {
+			moves = append(moves, Change{
 				Type:     "moved",
-				Path:     crFile.Path,
-				OldPath:  delFile.Path,
-				IsDir:    crFile.IsDir,
-				Size:     crFile.Size,
-				Modified: crFile.ModifiedTime,
+				Path:     c.Path,
+				OldPath:  changes[del].Path,
+				IsDir:    c.IsDir,
+				Size:     c.Size,
+				Modified: c.Modified,
 			})
 		}
 	}
+	return append(result, moves...)
+}
 
-	return changes
+// scanFilter returns the filter and depth limit a scan of the tracked directory dir uses
+func (d *Detector) scanFilter(dir, dirETag string, opts DetectOptions) (*filter.Filter, int, error) {
+	dirFilter, err := d.filterFor(opts.client, dir, dirETag)
+	if err != nil {
+		return nil, 0, err
+	}
+	d.settingsMu.RLock()
+	maxDepth := d.options.MaxDepths[dir]
+	d.settingsMu.RUnlock()
+	if opts.MaxDepth > 0 {
+		maxDepth = opts.MaxDepth
+	}
+	if maxDepth > 0 {
+		dirFilter = dirFilter.WithMaxDepth(maxDepth)
+	}
+	return dirFilter, maxDepth, nil
 }
 
-// isHidden checks if a file or directory path contains hidden components
-// Hidden files/directories are those starting with "."
-func isHidden(path string) bool {
-	parts := strings.Split(strings.Trim(path, "/"), "/")
-	for _, part := range parts {
-		if part != "" && strings.HasPrefix(part, ".") {
-			return true
-		}
+// scanSettings identifies the settings a directory was scanned with
+func scanSettings(dirFilter *filter.Filter, includeHidden bool) string {
+	settings := dirFilter.Fingerprint()
+	if includeHidden {
+		settings += "+hidden"
 	}
-	return false
+	return settings
 }
 
-func removeChange(changes []Change, changeType, path string) []Change {
+// skipped reports whether f leaves filePath, below the tracked directory dir, out of the scan
+func skipped(f *filter.Filter, dir, filePath string, isDir bool) bool {
+	return f.Skip(relativeTo(dir, filePath), isDir)
+}
+
+// filterChanges drops changes f excludes from reporting
+// This also hides entries that only disappeared because a pattern was added
+func filterChanges(f *filter.Filter, dir string, changes []Change) []Change {
+	if f == nil {
+		return changes
+	}
+
 	var result []Change
 	for _, c := range changes {
-		if c.Type != changeType || c.Path != path {
+		skip := f.SkipChange(relativeTo(dir, c.Path), c.IsDir)
+		if c.OldPath != "" {
+			// A move out of or into the covered paths still matters
+			skip = skip && f.SkipChange(relativeTo(dir, c.OldPath), c.IsDir)
+		}
+		if !skip {
 			result = append(result, c)
 		}
 	}
 	return result
 }
 
-func (d *Detector) loadState() (*State, error) {
-	data, err := os.ReadFile(d.stateFile)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &State{
-				Files:          make(map[string]FileState),
-				DirectoryETags: make(map[string]string),
-				LastUpdate:     time.Time{},
-			}, nil
-		}
-		return nil, err
-	}
+// relativeTo returns filePath relative to the tracked directory dir
+func relativeTo(dir, filePath string) string {
+	return strings.TrimPrefix(filePath, strings.TrimSuffix(dir, "/"))
+}
 
-	var state State
-	if err := json.Unmarshal(data, &state); err != nil {
-		return nil, err
+// filterFavorites keeps only changes on favorited items or below a favorited directory
+// Favorites are taken from both states so deletions of favorites are still reported
+func filterFavorites(changes []Change, directory string, prevState, currentState *State) []Change {
+	dirPrefix := directory + ":"
+	favorites := make(map[string]bool)
+	for _, state := range []*State{prevState, currentState} {
+		for key, file := range state.Files {
+			if file.Favorite && strings.HasPrefix(key, dirPrefix) {
+				favorites[file.Path] = true
+			}
+		}
 	}
 
-	if state.Files == nil {
-		state.Files = make(map[string]FileState)
-	}
-	if state.DirectoryETags == nil {
-		state.DirectoryETags = make(map[string]string)
+	var result []Change
+	for _, c := range changes {
+		if underFavorite(c.Path, favorites) || (c.OldPath != "" && underFavorite(c.OldPath, favorites)) {
+			result = append(result, c)
+		}
 	}
-
-	return &state, nil
+	return result
 }
 
-func (d *Detector) saveState(state *State) error {
-	// Resolve absolute path for logging and to ensure correct location
-	absPath, err := filepath.Abs(d.stateFile)
-	if err != nil {
-		absPath = d.stateFile // Fallback to original if Abs fails
+// underFavorite reports whether path or one of its ancestors is a favorite
+func underFavorite(filePath string, favorites map[string]bool) bool {
+	for p := filePath; p != "/" && p != "." && p != ""; p = path.Dir(p) {
+		if favorites[p] {
+			return true
+		}
 	}
+	return false
+}
 
-	// Create directory if it doesn't exist
-	dir := filepath.Dir(d.stateFile)
-	if dir != "." && dir != "" {
-		if err := os.MkdirAll(dir, 0755); err != nil {
-			log.Printf("Error creating state file directory %s: %v", dir, err)
-			return err
+// isHidden checks if a file or directory path contains hidden components
+// Hidden files/directories are those starting with "."
+func isHidden(path string) bool {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	for _, part := range parts {
+		if part != "" && strings.HasPrefix(part, ".") {
+			return true
 		}
 	}
+	return false
+}
 
-	// Use Marshal instead of MarshalIndent for better performance with large files
-	data, err := json.Marshal(state)
-	if err != nil {
-		return err
+// normalizeDirectory turns "Documents", "/Documents" and "" into "/Documents" and "/"
+func normalizeDirectory(dir string) string {
+	dir = strings.TrimPrefix(dir, "/")
+	if dir == "" {
+		return "/"
 	}
+	return "/" + dir
+}
 
-	if err := os.WriteFile(d.stateFile, data, 0644); err != nil {
-		log.Printf("Error writing state file to %s (absolute: %s): %v", d.stateFile, absPath, err)
-		return err
-	}
+// syncProgress serializes a progress hook shared by concurrent directory scans
+type syncProgress struct {
+	mu   sync.Mutex
+	hook webdav.ProgressHook
+}
 
-	log.Printf("State saved to %s (absolute: %s)", d.stateFile, absPath)
-	return nil
+func (p *syncProgress) OnProgress(event webdav.ProgressEvent) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hook.OnProgress(event)
 }
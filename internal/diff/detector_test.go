@@ -0,0 +1,83 @@
+package diff_test
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/webdav/webdavtest"
+)
+
+func TestDetectChanges(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		options  diff.Options
+		strategy string
+	}{
+		{"etag walk", diff.Options{}, diff.StrategyDirectoryETag},
+		{"sync tokens", diff.Options{UseSyncTokens: true}, diff.StrategySyncToken},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			modified := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+			client := webdavtest.New()
+			client.PutFile("/Docs/a.txt", 10, modified)
+			client.PutFile("/Docs/b.txt", 20, modified)
+			client.PutFile("/Docs/c.txt", 30, modified)
+
+			store := diff.NewJSONStore(filepath.Join(t.TempDir(), "state.json"), false)
+			detector := diff.NewDetector(client, store, tt.options)
+			results, err := detector.DetectChanges([]string{"/Docs"}, diff.DetectOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(results[0].Changes); got != 3 {
+				t.Fatalf("first run reported %d changes, want the 3 files created", got)
+			}
+
+			client.PutFile("/Docs/a.txt", 11, modified.Add(time.Hour))
+			client.Remove("/Docs/b.txt")
+			client.Move("/Docs/c.txt", "/Docs/Archive/c.txt")
+			client.PutFile("/Docs/d.txt", 40, modified)
+
+			results, err = detector.DetectChanges([]string{"/Docs"}, diff.DetectOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if results[0].Strategy != tt.strategy {
+				t.Errorf("strategy = %q, want %q", results[0].Strategy, tt.strategy)
+			}
+
+			want := map[string]diff.Change{
+				"/Docs/a.txt":         {Type: "updated", Size: 11},
+				"/Docs/b.txt":         {Type: "deleted", Size: 20},
+				"/Docs/Archive":       {Type: "created", IsDir: true},
+				"/Docs/Archive/c.txt": {Type: "moved", Size: 30, OldPath: "/Docs/c.txt"},
+				"/Docs/d.txt":         {Type: "created", Size: 40},
+			}
+			for _, change := range results[0].Changes {
+				w, ok := want[change.Path]
+				if !ok {
+					t.Errorf("unexpected %s change of %s", change.Type, change.Path)
+					continue
+				}
+				delete(want, change.Path)
+				if change.Type != w.Type || change.IsDir != w.IsDir || change.Size != w.Size || change.OldPath != w.OldPath {
+					t.Errorf("change of %s = %s (dir %t, size %d, from %q), want %s (dir %t, size %d, from %q)",
+						change.Path, change.Type, change.IsDir, change.Size, change.OldPath, w.Type, w.IsDir, w.Size, w.OldPath)
+				}
+			}
+			for p, w := range want {
+				t.Errorf("missing %s change of %s", w.Type, p)
+			}
+
+			results, err = detector.DetectChanges([]string{"/Docs"}, diff.DetectOptions{})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(results[0].Changes); got != 0 {
+				t.Errorf("run without modifications reported %d changes, want none", got)
+			}
+		})
+	}
+}
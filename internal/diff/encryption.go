@@ -0,0 +1,80 @@
+package diff
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// encryptedMagic starts every encrypted state file, followed by the nonce and the sealed data
+var encryptedMagic = []byte("NCSTATE-AESGCM1\n")
+
+// ErrStateEncrypted is returned when an encrypted state file is read without a key
+var ErrStateEncrypted = errors.New("state file is encrypted but no key is configured")
+
+// StateCipher encrypts persisted state with AES-256-GCM
+type StateCipher struct {
+	aead cipher.AEAD
+}
+
+// NewStateCipher returns a cipher for a 32-byte key
+func NewStateCipher(key []byte) (*StateCipher, error) {
+	if len(key) != 32 {
+		return nil, fmt.Errorf("state encryption key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &StateCipher{aead: aead}, nil
+}
+
+// ParseStateKey decodes a key given as base64 (e.g. from "openssl rand -base64 32")
+// A raw 32-byte value, as read from a binary key file, is used as is.
+func ParseStateKey(value []byte) ([]byte, error) {
+	if key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(value))); err == nil && len(key) == 32 {
+		return key, nil
+	}
+	if len(value) == 32 {
+		return value, nil
+	}
+	return nil, errors.New("state encryption key must be 32 bytes, base64-encoded")
+}
+
+func (c *StateCipher) seal(plain []byte) ([]byte, error) {
+	nonce := make([]byte, c.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), encryptedMagic...)
+	out = append(out, nonce...)
+	// The magic is authenticated too, so a tampered header is detected
+	return c.aead.Seal(out, nonce, plain, encryptedMagic), nil
+}
+
+func (c *StateCipher) open(data []byte) ([]byte, error) {
+	data = bytes.TrimPrefix(data, encryptedMagic)
+	if len(data) < c.aead.NonceSize() {
+		return nil, errors.New("encrypted state file is truncated")
+	}
+	nonce, sealed := data[:c.aead.NonceSize()], data[c.aead.NonceSize():]
+	plain, err := c.aead.Open(nil, nonce, sealed, encryptedMagic)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt state (wrong key?): %w", err)
+	}
+	return plain, nil
+}
+
+// isEncrypted reports whether data starts like an encrypted state file
+func isEncrypted(data []byte) bool {
+	return bytes.HasPrefix(data, encryptedMagic)
+}
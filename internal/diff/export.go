@@ -0,0 +1,157 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+)
+
+// ErrInvalidState is returned when importing a state that can't be used
+var ErrInvalidState = errors.New("invalid state")
+
+// StateSummary describes the stored state without listing its entries
+type StateSummary struct {
+	Profile       string             `json:"profile,omitempty"`
+	SchemaVersion int                `json:"schema_version"`
+	LastUpdate    time.Time          `json:"last_update"`
+	Files         int                `json:"files"`
+	Directories   int                `json:"directories"`
+	TotalSize     int64              `json:"total_size"`
+	Tracked       []DirectorySummary `json:"tracked"`
+}
+
+// DirectorySummary describes the stored state of one tracked directory
+type DirectorySummary struct {
+	Directory   string `json:"directory"`
+	Files       int    `json:"files"`
+	Directories int    `json:"directories"`
+	TotalSize   int64  `json:"total_size"`
+	// LastScan is unset for state saved before scan times were recorded
+	LastScan  *time.Time `json:"last_scan,omitempty"`
+	SyncToken bool       `json:"sync_token"`
+}
+
+// ImportResult reports what a state import replaced
+type ImportResult struct {
+	Profile            string   `json:"profile,omitempty"`
+	Directories        []string `json:"directories"`
+	Files              int      `json:"files"`
+	RemovedDirectories []string `json:"removed_directories"`
+}
+
+// StateSummary counts the entries of the stored state of profile, per
+// tracked directory
+func (d *Detector) StateSummary(profile string) (*StateSummary, error) {
+	state, err := d.ExportState(profile)
+	if err != nil {
+		return nil, err
+	}
+
+	summary := &StateSummary{
+		Profile:       profile,
+		SchemaVersion: state.SchemaVersion,
+		LastUpdate:    state.LastUpdate,
+		Tracked:       []DirectorySummary{},
+	}
+	index := make(map[string]int)
+	for _, dir := range stateDirectories(state) {
+		index[dir] = len(summary.Tracked)
+		dirSummary := DirectorySummary{Directory: dir, SyncToken: state.SyncTokens[dir] != ""}
+		if scanned, ok := state.ScannedAt[dir]; ok {
+			dirSummary.LastScan = &scanned
+		}
+		summary.Tracked = append(summary.Tracked, dirSummary)
+	}
+	for key, file := range state.Files {
+		i := strings.Index(key, ":/")
+		if i <= 0 {
+			continue
+		}
+		dirSummary := &summary.Tracked[index[key[:i]]]
+		if file.IsDir {
+			dirSummary.Directories++
+			summary.Directories++
+		} else {
+			dirSummary.Files++
+			dirSummary.TotalSize += file.Size
+			summary.Files++
+			summary.TotalSize += file.Size
+		}
+	}
+	return summary, nil
+}
+
+// ExportState returns the whole stored state of profile, as ImportState takes it
+func (d *Detector) ExportState(profile string) (*State, error) {
+	store, err := d.stateStore(profile)
+	if err != nil {
+		return nil, err
+	}
+	state, err := store.Load(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	return state, nil
+}
+
+// ImportState replaces the stored state of profile with state, e.g. one
+// exported on another host
+// Tracked directories missing from state are removed, so the next diff of
+// each directory compares against the imported baseline.
+func (d *Detector) ImportState(profile string, state *State) (*ImportResult, error) {
+	store, err := d.stateStore(profile)
+	if err != nil {
+		return nil, err
+	}
+	state.init()
+	if _, err := migrateState(state); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidState, err)
+	}
+	for key := range state.Files {
+		if strings.Index(key, ":/") <= 0 {
+			return nil, fmt.Errorf("%w: file key %q is not <tracked directory>:<path>", ErrInvalidState, key)
+		}
+	}
+	dirs := stateDirectories(state)
+
+	release, err := d.acquireRun(dirs, "", false)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	current, err := store.Load(nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load state: %w", err)
+	}
+	result := &ImportResult{Profile: profile, Directories: dirs, Files: len(state.Files), RemovedDirectories: []string{}}
+	imported := make(map[string]bool, len(dirs))
+	for _, dir := range dirs {
+		imported[dir] = true
+	}
+	for _, dir := range stateDirectories(current) {
+		if !imported[dir] {
+			result.RemovedDirectories = append(result.RemovedDirectories, dir)
+		}
+	}
+
+	err = d.persist(func() error {
+		if err := store.Remove(stateDirectories(current)); err != nil {
+			return err
+		}
+		return store.Save(dirs, state)
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to save imported state: %w", err)
+	}
+
+	// Scans kept in memory belong to the replaced state
+	d.scansMu.Lock()
+	clear(d.scans)
+	d.scansMu.Unlock()
+
+	slog.Info("Imported state", "profile", profile, "directories", dirs, "files", len(state.Files), "removed_directories", result.RemovedDirectories)
+	return result, nil
+}
@@ -0,0 +1,65 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"path"
+
+	"go-nc-client/internal/filter"
+	"go-nc-client/internal/webdav"
+)
+
+type cachedIgnore struct {
+	dirETag string
+	rules   *filter.Ignore
+}
+
+// filterFor returns the filter for a tracked directory: the configured
+// patterns plus the global ignore rules and the directory's own .ncignore
+// The .ncignore is only refetched when the directory ETag changed.
+func (d *Detector) filterFor(client Client, dir, dirETag string) (*filter.Filter, error) {
+	d.ignoreMu.Lock()
+	cached, ok := d.ignoreCache[dir]
+	d.ignoreMu.Unlock()
+
+	if !ok || cached.dirETag == "" || cached.dirETag != dirETag {
+		rules, err := loadIgnoreFile(client, dir)
+		if err != nil {
+			return nil, err
+		}
+		cached = cachedIgnore{dirETag: dirETag, rules: rules}
+
+		d.ignoreMu.Lock()
+		d.ignoreCache[dir] = cached
+		d.ignoreMu.Unlock()
+	}
+
+	d.settingsMu.RLock()
+	pathFilter := d.options.Filter
+	d.settingsMu.RUnlock()
+	if d.options.Ignore == nil && cached.rules == nil {
+		return pathFilter, nil
+	}
+	return pathFilter.WithIgnore(filter.Combine(d.options.Ignore, cached.rules)), nil
+}
+
+// loadIgnoreFile reads the .ncignore at the root of dir, returning nil if there is none
+func loadIgnoreFile(client Client, dir string) (*filter.Ignore, error) {
+	ignorePath := path.Join(dir, filter.IgnoreFileName)
+	file, err := client.Open(ignorePath, webdav.DownloadOptions{})
+	if errors.Is(err, webdav.ErrNotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ignorePath, err)
+	}
+	defer file.Close()
+
+	rules, err := filter.ParseIgnore(file)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ignorePath, err)
+	}
+	slog.Debug("Using ignore rules", "path", ignorePath)
+	return rules, nil
+}
@@ -0,0 +1,390 @@
+package diff
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/webdav"
+)
+
+// Job statuses
+const (
+	JobRunning   = "running"
+	JobSucceeded = "succeeded"
+	JobFailed    = "failed"
+	JobCancelled = "cancelled"
+)
+
+const (
+	// jobProgressInterval is how often the progress of a running job is saved
+	jobProgressInterval = 5 * time.Second
+	// jobCancelWait bounds how long Cancel waits for the job to stop
+	jobCancelWait = 10 * time.Second
+)
+
+var (
+	// ErrJobNotFound is returned for job IDs that are unknown or no longer kept
+	ErrJobNotFound = errors.New("job not found")
+	// ErrJobNotFinished is returned for the result of a job that is still running
+	ErrJobNotFinished = errors.New("job has not finished")
+	// ErrJobFailed is returned for the result of a job that failed
+	ErrJobFailed = errors.New("job failed")
+	// ErrJobCancelled is returned for the result of a cancelled job
+	ErrJobCancelled = errors.New("job was cancelled")
+	// ErrJobFinished is returned when cancelling a job that is no longer running
+	ErrJobFinished = errors.New("job has already finished")
+)
+
+// Job is a diff running in the background, or its outcome
+type Job struct {
+	ID          string      `json:"id"`
+	Status      string      `json:"status"`
+	Directories []string    `json:"directories"`
+	RequestID   string      `json:"request_id,omitempty"`
+	Created     time.Time   `json:"created"`
+	Finished    time.Time   `json:"finished,omitzero"`
+	Progress    JobProgress `json:"progress"`
+	// Changes counts the changes of a succeeded job, FailedDirectories the
+	// directories of its result that failed to scan
+	Changes           int    `json:"changes"`
+	FailedDirectories int    `json:"failed_directories,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// JobProgress is the latest scan progress of a job
+type JobProgress struct {
+	DirsVisited int    `json:"dirs_visited"`
+	FilesFound  int    `json:"files_found"`
+	Path        string `json:"path,omitempty"`
+}
+
+// JobFunc runs the work of a job, reporting scan progress to progress
+type JobFunc func(ctx context.Context, progress webdav.ProgressHook) ([]Changes, error)
+
+// JobStore runs diffs in the background and keeps the status and results of
+// the last jobs as files in a directory, so they survive restarts
+type JobStore struct {
+	dir    string
+	keep   int
+	cipher *StateCipher
+
+	mu      sync.Mutex
+	running map[string]*runningJob
+}
+
+// runningJob is a job this process runs
+type runningJob struct {
+	job    *Job
+	cancel context.CancelFunc
+	// done is closed once the outcome of the job is saved
+	done chan struct{}
+}
+
+// NewJobStore keeps the last keep jobs in dir
+func NewJobStore(dir string, keep int) *JobStore {
+	return &JobStore{dir: dir, keep: keep, running: make(map[string]*runningJob)}
+}
+
+// Encrypt makes the store encrypt the jobs and results it keeps with c, like the state
+func (s *JobStore) Encrypt(c *StateCipher) *JobStore {
+	s.cipher = c
+	return s
+}
+
+// Start runs fn in the background as a new job
+func (s *JobStore) Start(directories []string, requestID string, fn JobFunc) (*Job, error) {
+	job := &Job{
+		ID:          newRunID(),
+		Status:      JobRunning,
+		Directories: directories,
+		RequestID:   requestID,
+		Created:     time.Now(),
+	}
+	if err := s.saveJob(job); err != nil {
+		return nil, fmt.Errorf("failed to save job: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	running := &runningJob{job: job, cancel: cancel, done: make(chan struct{})}
+	s.mu.Lock()
+	s.running[job.ID] = running
+	snapshot := *job
+	s.mu.Unlock()
+	s.prune()
+
+	go s.run(ctx, running, fn)
+	return &snapshot, nil
+}
+
+// run executes fn and records its outcome
+func (s *JobStore) run(ctx context.Context, running *runningJob, fn JobFunc) {
+	job := running.job
+	defer close(running.done)
+	defer running.cancel()
+	logger := slog.With("job_id", job.ID)
+	if job.RequestID != "" {
+		logger = logger.With("request_id", job.RequestID)
+	}
+	logger.Info("Job started", "directories", job.Directories)
+
+	var lastSave time.Time
+	progress := webdav.ProgressFunc(func(event webdav.ProgressEvent) {
+		s.mu.Lock()
+		job.Progress = JobProgress{DirsVisited: event.DirsVisited, FilesFound: event.FilesFound, Path: event.Path}
+		save := time.Since(lastSave) >= jobProgressInterval
+		if save {
+			lastSave = time.Now()
+		}
+		snapshot := *job
+		s.mu.Unlock()
+		if save {
+			if err := s.saveJob(&snapshot); err != nil {
+				logger.Warn("Failed to save job progress", "error", err)
+			}
+		}
+	})
+
+	results, err := fn(ctx, progress)
+
+	s.mu.Lock()
+	job.Finished = time.Now()
+	if err == nil {
+		err = s.saveResult(job.ID, results)
+	}
+	if err != nil && ctx.Err() != nil {
+		// A run finishing despite the cancellation keeps its results
+		job.Status = JobCancelled
+	} else if err != nil {
+		job.Status = JobFailed
+		job.Error = err.Error()
+	} else {
+		job.Status = JobSucceeded
+		for _, result := range results {
+			job.Changes += len(result.Changes)
+		}
+		job.FailedDirectories = Failed(results)
+	}
+	snapshot := *job
+	s.mu.Unlock()
+
+	// Saved before it is forgotten, so Get never reads it as interrupted
+	if err := s.saveJob(&snapshot); err != nil {
+		logger.Error("Failed to save job", "error", err)
+	}
+	s.mu.Lock()
+	delete(s.running, job.ID)
+	s.mu.Unlock()
+	switch snapshot.Status {
+	case JobFailed:
+		logger.Error("Job failed", "error", snapshot.Error)
+	case JobCancelled:
+		logger.Info("Job cancelled", "duration", snapshot.Finished.Sub(snapshot.Created))
+	default:
+		logger.Info("Job succeeded", "changes", snapshot.Changes, "duration", snapshot.Finished.Sub(snapshot.Created))
+	}
+}
+
+// Cancel stops a running job, whose run saves nothing, and returns the job
+// once it stopped; after jobCancelWait or when ctx ends, the job is returned
+// still running, and stops later
+func (s *JobStore) Cancel(ctx context.Context, id string) (*Job, error) {
+	s.mu.Lock()
+	running, ok := s.running[id]
+	s.mu.Unlock()
+	if !ok {
+		job, err := s.Get(id)
+		if err != nil {
+			return nil, err
+		}
+		return job, fmt.Errorf("%w: %s is %s", ErrJobFinished, id, job.Status)
+	}
+
+	running.cancel()
+	slog.Info("Job cancellation requested", "job_id", id)
+	select {
+	case <-running.done:
+	case <-time.After(jobCancelWait):
+	case <-ctx.Done():
+	}
+	return s.Get(id)
+}
+
+// Get returns a job
+// A job saved as running that this process doesn't run was interrupted by a
+// restart, and is reported as failed.
+func (s *JobStore) Get(id string) (*Job, error) {
+	if !validJobID(id) {
+		return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+	}
+	s.mu.Lock()
+	if running, ok := s.running[id]; ok {
+		snapshot := *running.job
+		s.mu.Unlock()
+		return &snapshot, nil
+	}
+	s.mu.Unlock()
+
+	var job Job
+	if err := s.read(s.jobPath(id), &job); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: %s", ErrJobNotFound, id)
+		}
+		return nil, err
+	}
+	if job.Status == JobRunning {
+		job.Status = JobFailed
+		job.Error = "interrupted by a restart"
+		if err := s.saveJob(&job); err != nil {
+			slog.Warn("Failed to save interrupted job", "job_id", id, "error", err)
+		}
+	}
+	return &job, nil
+}
+
+// Result returns the results of a succeeded job
+func (s *JobStore) Result(id string) ([]Changes, error) {
+	job, err := s.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case JobRunning:
+		return nil, fmt.Errorf("%w: %s is %s", ErrJobNotFinished, id, job.Status)
+	case JobCancelled:
+		return nil, fmt.Errorf("%w: %s", ErrJobCancelled, id)
+	case JobSucceeded:
+	default:
+		return nil, fmt.Errorf("%w: %s: %s", ErrJobFailed, id, job.Error)
+	}
+
+	var results []Changes
+	if err := s.read(s.resultPath(id), &results); err != nil {
+		if os.IsNotExist(err) {
+			return nil, fmt.Errorf("%w: result of %s", ErrJobNotFound, id)
+		}
+		return nil, err
+	}
+	return results, nil
+}
+
+// prune deletes the files of the oldest finished jobs beyond keep
+func (s *JobStore) prune() {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		return
+	}
+	type saved struct {
+		id      string
+		modTime time.Time
+	}
+	var jobs []saved
+	for _, entry := range entries {
+		id, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || !validJobID(id) {
+			continue
+		}
+		if info, err := entry.Info(); err == nil {
+			jobs = append(jobs, saved{id: id, modTime: info.ModTime()})
+		}
+	}
+	if len(jobs) <= s.keep {
+		return
+	}
+	sort.Slice(jobs, func(i, j int) bool { return jobs[i].modTime.After(jobs[j].modTime) })
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range jobs[s.keep:] {
+		if _, ok := s.running[job.id]; ok {
+			continue
+		}
+		os.Remove(s.jobPath(job.id))
+		os.Remove(s.resultPath(job.id))
+	}
+}
+
+func (s *JobStore) jobPath(id string) string {
+	return filepath.Join(s.dir, id+".json")
+}
+
+func (s *JobStore) resultPath(id string) string {
+	return filepath.Join(s.dir, id+".result")
+}
+
+func (s *JobStore) saveJob(job *Job) error {
+	return s.write(s.jobPath(job.ID), job)
+}
+
+func (s *JobStore) saveResult(id string, results []Changes) error {
+	if err := s.write(s.resultPath(id), results); err != nil {
+		return fmt.Errorf("failed to save job result: %w", err)
+	}
+	return nil
+}
+
+// write saves v as JSON atomically, encrypted when a cipher is set
+func (s *JobStore) write(path string, v any) error {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	if s.cipher != nil {
+		if data, err = s.cipher.seal(data); err != nil {
+			return err
+		}
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// read loads the JSON saved at path into v
+func (s *JobStore) read(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if isEncrypted(data) {
+		if s.cipher == nil {
+			return ErrStateEncrypted
+		}
+		if data, err = s.cipher.open(data); err != nil {
+			return err
+		}
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("failed to parse %s: %w", path, err)
+	}
+	return nil
+}
+
+// validJobID accepts the hex IDs of newRunID, so IDs from URLs can't escape the directory
+func validJobID(id string) bool {
+	if len(id) != 16 {
+		return false
+	}
+	for _, c := range id {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+// Jobs returns the background job store, nil when async diffs are disabled
+func (d *Detector) Jobs() *JobStore {
+	return d.options.Jobs
+}
@@ -0,0 +1,248 @@
+package diff
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNoJournal is returned by history queries when no journal is configured
+var ErrNoJournal = errors.New("change journal is disabled")
+
+// JournalEntry is one recorded change
+type JournalEntry struct {
+	Recorded  time.Time `json:"recorded"`
+	Directory string    `json:"directory"`
+	Change
+}
+
+// HistoryQuery selects journal entries; zero fields match everything
+type HistoryQuery struct {
+	// Path matches changes to this path, below it, or moved from it
+	Path  string
+	Since time.Time
+	Until time.Time
+}
+
+func (q HistoryQuery) matches(entry JournalEntry) bool {
+	if !q.Since.IsZero() && entry.Recorded.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && entry.Recorded.After(q.Until) {
+		return false
+	}
+	if q.Path != "" && q.Path != "/" {
+		return withinDirectory(entry.Path, q.Path) || (entry.OldPath != "" && withinDirectory(entry.OldPath, q.Path))
+	}
+	return true
+}
+
+// Journal is an append-only JSON Lines log of every reported change
+// Entries older than the retention period are dropped when the file is compacted.
+type Journal struct {
+	path      string
+	retention time.Duration
+
+	mu            sync.Mutex
+	lastCompacted time.Time
+}
+
+// journalCompactInterval is how often appends trigger a retention pass
+const journalCompactInterval = time.Hour
+
+// OpenJournal opens or creates the journal at path
+// retention of 0 keeps entries forever
+func OpenJournal(path string, retention time.Duration) (*Journal, error) {
+	dir := filepath.Dir(path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return nil, err
+		}
+	}
+
+	j := &Journal{path: path, retention: retention}
+	if err := j.compact(); err != nil {
+		return nil, fmt.Errorf("failed to compact journal %s: %w", path, err)
+	}
+	return j, nil
+}
+
+// Append records the changes of one diff run
+func (j *Journal) Append(results []Changes) error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	f, err := os.OpenFile(j.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, result := range results {
+		for _, change := range result.Changes {
+			entry := JournalEntry{Recorded: result.Timestamp, Directory: result.Directory, Change: change}
+			// Content diffs can be large and are only meaningful at report time
+			entry.Diff = ""
+			if err := enc.Encode(entry); err != nil {
+				f.Close()
+				return err
+			}
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	if time.Since(j.lastCompacted) > journalCompactInterval {
+		if err := j.compactLocked(); err != nil {
+			slog.Error("Failed to compact journal", "path", j.path, "error", err)
+		}
+	}
+	return nil
+}
+
+// ErrRunNotJournaled is returned when replaying a run the journal no longer holds
+var ErrRunNotJournaled = errors.New("run not found in the journal or expired")
+
+// JournalEnabled reports whether diff runs without a profile are journaled
+func (d *Detector) JournalEnabled() bool {
+	return d.options.Journal != nil
+}
+
+// ReplayJournal returns the results of the run whose directories were
+// timestamped between from and until, read back from the journal, like Replay
+// does for cursors; deletions are collapsed again with opts.CollapseDeletes
+// The journal only knows the directories with changes, and not their scan
+// statistics or strategy.
+func (d *Detector) ReplayJournal(from, until time.Time, opts DetectOptions) ([]Changes, error) {
+	if d.options.Journal == nil {
+		return nil, ErrNoJournal
+	}
+	entries, err := d.options.Journal.Query(HistoryQuery{Since: from, Until: until})
+	if err != nil {
+		return nil, err
+	}
+	if len(entries) == 0 {
+		return nil, ErrRunNotJournaled
+	}
+
+	var results []Changes
+	for _, entry := range entries {
+		if n := len(results); n == 0 || results[n-1].Directory != entry.Directory || !results[n-1].Timestamp.Equal(entry.Recorded) {
+			results = append(results, Changes{Directory: entry.Directory, Changes: []Change{}, Timestamp: entry.Recorded})
+		}
+		last := &results[len(results)-1]
+		last.Changes = append(last.Changes, entry.Change)
+	}
+	return collapseResults(results, opts), nil
+}
+
+// Query returns the matching entries in the order they were recorded
+func (j *Journal) Query(q HistoryQuery) ([]JournalEntry, error) {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+
+	var entries []JournalEntry
+	err := j.scan(func(entry JournalEntry) {
+		if q.matches(entry) {
+			entries = append(entries, entry)
+		}
+	})
+	return entries, err
+}
+
+// scan calls fn for every entry, skipping lines that fail to parse
+func (j *Journal) scan(fn func(entry JournalEntry)) error {
+	f, err := os.Open(j.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var entry JournalEntry
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			// A crash mid-append can leave a truncated last line
+			slog.Warn("Skipping invalid journal line", "path", j.path, "error", err)
+			continue
+		}
+		fn(entry)
+	}
+	return scanner.Err()
+}
+
+func (j *Journal) compact() error {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	return j.compactLocked()
+}
+
+// compactLocked rewrites the journal without entries older than the retention period
+func (j *Journal) compactLocked() error {
+	j.lastCompacted = time.Now()
+	if j.retention <= 0 {
+		return nil
+	}
+
+	cutoff := time.Now().Add(-j.retention)
+	var kept []JournalEntry
+	dropped := 0
+	err := j.scan(func(entry JournalEntry) {
+		if entry.Recorded.Before(cutoff) {
+			dropped++
+			return
+		}
+		kept = append(kept, entry)
+	})
+	if err != nil || dropped == 0 {
+		return err
+	}
+
+	tmp := j.path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	enc := json.NewEncoder(w)
+	for _, entry := range kept {
+		if err := enc.Encode(entry); err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, j.path); err != nil {
+		return err
+	}
+
+	slog.Info("Compacted journal", "path", j.path, "dropped", dropped, "retention", j.retention)
+	return nil
+}
@@ -0,0 +1,119 @@
+package diff
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// ErrDiffInProgress is returned by DetectChanges when another run holds the state
+var ErrDiffInProgress = errors.New("a diff is already running")
+
+// errFileLocked is returned by lockFile when another process holds the lock
+var errFileLocked = errors.New("state lock held by another process")
+
+// RunInfo describes a DetectChanges run
+type RunInfo struct {
+	ID          string    `json:"id"`
+	Started     time.Time `json:"started"`
+	Directories []string  `json:"directories"`
+	// RequestID is the ID of the API request that started the run, if any
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// RunInProgressError reports the run that currently holds the state
+// Run is nil when the state is locked by another process.
+type RunInProgressError struct {
+	Run *RunInfo
+}
+
+func (e *RunInProgressError) Error() string {
+	if e.Run == nil {
+		return fmt.Sprintf("%v in another process", ErrDiffInProgress)
+	}
+	return fmt.Sprintf("%v (run %s, started %s)", ErrDiffInProgress, e.Run.ID, e.Run.Started.Format(time.RFC3339))
+}
+
+func (e *RunInProgressError) Unwrap() error {
+	return ErrDiffInProgress
+}
+
+// acquireRun serializes runs that read and save the state, across goroutines
+// and, with Options.LockFile, across processes
+// With wait the call queues behind the current run instead of failing.
+func (d *Detector) acquireRun(dirs []string, requestID string, wait bool) (release func(), err error) {
+	if d.isClosing() {
+		return nil, ErrShuttingDown
+	}
+	if wait {
+		d.runMu.Lock()
+	} else if !d.runMu.TryLock() {
+		d.runInfoMu.Lock()
+		current := d.currentRun
+		d.runInfoMu.Unlock()
+		return nil, &RunInProgressError{Run: current}
+	}
+
+	var unlockFile func()
+	if d.options.LockFile != "" {
+		unlockFile, err = lockFile(d.options.LockFile, wait)
+		if err != nil {
+			d.runMu.Unlock()
+			if errors.Is(err, errFileLocked) {
+				return nil, &RunInProgressError{}
+			}
+			return nil, fmt.Errorf("failed to lock state: %w", err)
+		}
+	}
+
+	run := &RunInfo{ID: newRunID(), Started: time.Now(), Directories: dirs, RequestID: requestID}
+	d.runInfoMu.Lock()
+	if d.closing {
+		d.runInfoMu.Unlock()
+		if unlockFile != nil {
+			unlockFile()
+		}
+		d.runMu.Unlock()
+		return nil, ErrShuttingDown
+	}
+	d.currentRun = run
+	d.runInfoMu.Unlock()
+	logger := slog.With("run_id", run.ID)
+	if requestID != "" {
+		logger = logger.With("request_id", requestID)
+	}
+	logger.Info("Run started", "directories", dirs)
+
+	return func() {
+		d.runInfoMu.Lock()
+		d.currentRun = nil
+		d.runInfoMu.Unlock()
+		if unlockFile != nil {
+			unlockFile()
+		}
+		d.runMu.Unlock()
+	}, nil
+}
+
+// isClosing reports whether Shutdown was called
+func (d *Detector) isClosing() bool {
+	d.runInfoMu.Lock()
+	defer d.runInfoMu.Unlock()
+	return d.closing
+}
+
+// CurrentRun returns the run in progress in this process, nil when idle
+func (d *Detector) CurrentRun() *RunInfo {
+	d.runInfoMu.Lock()
+	defer d.runInfoMu.Unlock()
+	return d.currentRun
+}
+
+func newRunID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
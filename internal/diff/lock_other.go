@@ -0,0 +1,8 @@
+//go:build !unix
+
+package diff
+
+// lockFile is a no-op where flock is unavailable; runs within the process are still serialized
+func lockFile(path string, wait bool) (unlock func(), err error) {
+	return func() {}, nil
+}
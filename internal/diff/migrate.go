@@ -0,0 +1,39 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+)
+
+// SchemaVersion is the State layout written by this build
+// Bump it and append a migration whenever FileState or the key format changes
+const SchemaVersion = 1
+
+// ErrUnsupportedSchema means the state was written by a newer build
+// It is never treated as "no previous state", which would overwrite it
+var ErrUnsupportedSchema = errors.New("unsupported state schema version")
+
+// migrations[i] upgrades a state from schema version i to i+1
+var migrations = []func(state *State) error{
+	// 0 -> 1: state written before schema_version existed; the layout is unchanged
+	func(state *State) error { return nil },
+}
+
+// migrateState upgrades state to SchemaVersion and reports whether it changed
+// A state written by a newer build is rejected rather than misparsed
+func migrateState(state *State) (bool, error) {
+	if state.SchemaVersion > SchemaVersion {
+		return false, fmt.Errorf("%w: %d is newer than %d", ErrUnsupportedSchema, state.SchemaVersion, SchemaVersion)
+	}
+	if state.SchemaVersion == SchemaVersion {
+		return false, nil
+	}
+
+	for v := state.SchemaVersion; v < SchemaVersion; v++ {
+		if err := migrations[v](state); err != nil {
+			return false, fmt.Errorf("failed to migrate state from schema version %d: %w", v, err)
+		}
+		state.SchemaVersion = v + 1
+	}
+	return true, nil
+}
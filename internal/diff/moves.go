@@ -0,0 +1,58 @@
+package diff
+
+import (
+	"fmt"
+	"time"
+)
+
+// Ways moves are matched, see MoveDetection
+const (
+	// MoveModeHeuristic matches deletions and creations by ETag, then by a
+	// unique size with close modification times
+	MoveModeHeuristic = "heuristic"
+	// MoveModeETag only matches identical ETags; Nextcloud keeps the ETag of
+	// moved files but not of copies
+	MoveModeETag = "etag"
+	// MoveModeOff reports moves as a deletion and a creation
+	MoveModeOff = "off"
+)
+
+// defaultMoveWindow is how close the modification times of a size match must be by default
+const defaultMoveWindow = time.Minute
+
+// MoveDetection tunes how moves are detected in a tracked directory
+type MoveDetection struct {
+	// Mode is one of the MoveMode* constants ("" = MoveModeHeuristic)
+	Mode string
+	// Window is how close the modification times of a size match must be (0 = 1 minute)
+	Window time.Duration
+	// MinSize is the smallest file matched by size alone (0 = any non-empty file)
+	MinSize int64
+}
+
+// Validate reports an unknown mode or negative limits
+func (m MoveDetection) Validate() error {
+	switch m.Mode {
+	case "", MoveModeHeuristic, MoveModeETag, MoveModeOff:
+	default:
+		return fmt.Errorf("unknown move detection mode %q (use %s, %s or %s)", m.Mode, MoveModeHeuristic, MoveModeETag, MoveModeOff)
+	}
+	if m.Window < 0 || m.MinSize < 0 {
+		return fmt.Errorf("move detection window and min size must not be negative")
+	}
+	return nil
+}
+
+// moveDetection returns the move settings of the tracked directory dir
+func (d *Detector) moveDetection(dir string) MoveDetection {
+	d.settingsMu.RLock()
+	m := d.options.MoveDetection[dir]
+	d.settingsMu.RUnlock()
+	if m.Mode == "" {
+		m.Mode = MoveModeHeuristic
+	}
+	if m.Window == 0 {
+		m.Window = defaultMoveWindow
+	}
+	return m
+}
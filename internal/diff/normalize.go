@@ -0,0 +1,52 @@
+package diff
+
+import (
+	"strings"
+
+	"golang.org/x/text/unicode/norm"
+
+	"go-nc-client/internal/webdav"
+)
+
+// normalizePath returns p in NFC when Unicode normalization is enabled
+func (d *Detector) normalizePath(p string) string {
+	if !d.options.NormalizeUnicode {
+		return p
+	}
+	return norm.NFC.String(p)
+}
+
+func (d *Detector) normalizeSyncResult(result *webdav.SyncResult) {
+	for i := range result.Changed {
+		result.Changed[i].Path = d.normalizePath(result.Changed[i].Path)
+	}
+	for i := range result.Deleted {
+		result.Deleted[i] = d.normalizePath(result.Deleted[i])
+	}
+}
+
+// normalizeState rewrites the keys and paths of a state loaded from disk to NFC
+// State files written before normalization was enabled may contain NFD names
+func normalizeState(state *State) {
+	files := make(map[string]FileState, len(state.Files))
+	for key, file := range state.Files {
+		file.Path = norm.NFC.String(file.Path)
+		files[normalizeKey(key)] = file
+	}
+	state.Files = files
+
+	etags := make(map[string]string, len(state.DirectoryETags))
+	for path, etag := range state.DirectoryETags {
+		etags[norm.NFC.String(path)] = etag
+	}
+	state.DirectoryETags = etags
+}
+
+// normalizeKey normalizes the path half of a "directory:path" state key
+func normalizeKey(key string) string {
+	dir, path, found := strings.Cut(key, ":")
+	if !found {
+		return norm.NFC.String(key)
+	}
+	return dir + ":" + norm.NFC.String(path)
+}
@@ -0,0 +1,42 @@
+package diff
+
+// Observer is notified by DetectChanges as a run progresses
+// Calls are serialized, so implementations don't need their own locking, and
+// should return quickly since the run waits for them.
+type Observer interface {
+	// OnScanStart is called before a tracked directory is scanned
+	OnScanStart(run *RunInfo, dir string)
+	// OnScanComplete is called with the changes of a directory that scanned fine,
+	// once filters are applied
+	OnScanComplete(run *RunInfo, dir string, changes []Change)
+	// OnChange is called for every reported change once the state is saved,
+	// so it is not called in dry runs
+	OnChange(run *RunInfo, dir string, change Change)
+	// OnRunComplete is called once per saved run, after OnChange, with the
+	// results of the directories that scanned fine
+	OnRunComplete(run *RunInfo, results []Changes)
+	// OnError is called for a directory that failed to scan, or with an empty
+	// dir when the run as a whole failed
+	OnError(run *RunInfo, dir string, err error)
+}
+
+// NopObserver implements Observer doing nothing; embed it to implement only some methods
+type NopObserver struct{}
+
+func (NopObserver) OnScanStart(*RunInfo, string)              {}
+func (NopObserver) OnScanComplete(*RunInfo, string, []Change) {}
+func (NopObserver) OnChange(*RunInfo, string, Change)         {}
+func (NopObserver) OnRunComplete(*RunInfo, []Changes)         {}
+func (NopObserver) OnError(*RunInfo, string, error)           {}
+
+// notify calls fn for every observer
+func (d *Detector) notify(fn func(o Observer)) {
+	if len(d.options.Observers) == 0 {
+		return
+	}
+	d.observerMu.Lock()
+	defer d.observerMu.Unlock()
+	for _, o := range d.options.Observers {
+		fn(o)
+	}
+}
@@ -0,0 +1,74 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+var (
+	// ErrNoProfiles is returned for a named profile when profiles are disabled
+	ErrNoProfiles = errors.New("state profiles are disabled")
+	// ErrUnknownProfile is returned for profiles that are not configured
+	ErrUnknownProfile = errors.New("unknown profile")
+	// ErrInvalidProfileName is returned for profile names that can't be used as a file name
+	ErrInvalidProfileName = errors.New("invalid profile name: use letters, digits, '.', '_' and '-'")
+)
+
+// ProfileStatePath returns where the state of a named profile is kept, next
+// to the default state: "data/state.json" becomes "data/state.indexer.json"
+func ProfileStatePath(path, profile string) string {
+	ext := filepath.Ext(path)
+	return strings.TrimSuffix(path, ext) + "." + profile + ext
+}
+
+// stateStore returns the store of a profile, opening it on first use
+// The empty profile is the default state.
+func (d *Detector) stateStore(profile string) (StateStore, error) {
+	if profile == "" {
+		return d.store, nil
+	}
+	if d.options.Profiles == nil {
+		return nil, ErrNoProfiles
+	}
+	if !snapshotNamePattern.MatchString(profile) {
+		return nil, ErrInvalidProfileName
+	}
+
+	d.profilesMu.Lock()
+	defer d.profilesMu.Unlock()
+	if store, ok := d.profileStores[profile]; ok {
+		return store, nil
+	}
+	store, err := d.options.Profiles(profile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open state of profile %s: %w", profile, err)
+	}
+	d.profileStores[profile] = store
+	return store, nil
+}
+
+// scanBase returns the state a scan of dir can reuse unchanged subtrees from:
+// the latest scan of any profile when it used the same settings, else prevState
+func (d *Detector) scanBase(dir, settings string, prevState *State) *State {
+	if d.options.Profiles == nil {
+		return prevState
+	}
+	d.scansMu.Lock()
+	defer d.scansMu.Unlock()
+	if latest, ok := d.scans[dir]; ok && latest.ScanSettings[dir] == settings {
+		return latest
+	}
+	return prevState
+}
+
+// rememberScan keeps the scan of dir for the other profiles to build on
+func (d *Detector) rememberScan(dir string, state *State) {
+	if d.options.Profiles == nil {
+		return
+	}
+	d.scansMu.Lock()
+	defer d.scansMu.Unlock()
+	d.scans[dir] = state
+}
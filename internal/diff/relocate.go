@@ -0,0 +1,116 @@
+package diff
+
+import (
+	"fmt"
+	"log/slog"
+	"path"
+	"strings"
+)
+
+// MoveState rewrites the stored entries at from and below it to to, in the
+// default state and the state of every profile, after the same move was made
+// on the server, so the next diff doesn't report it as deletions and creations
+// Entries already at to are replaced, as the move overwrote them. It returns
+// how many entries of the default state were moved.
+func (d *Detector) MoveState(from, to string) (int, error) {
+	from = d.normalizePath(path.Clean("/" + from))
+	to = d.normalizePath(path.Clean("/" + to))
+
+	// Waits for a diff in progress, which would otherwise save the old paths over ours
+	release, err := d.acquireRun(nil, "", true)
+	if err != nil {
+		return 0, err
+	}
+	defer release()
+
+	moved := 0
+	for _, profile := range append([]string{""}, d.options.ProfileNames...) {
+		store, err := d.stateStore(profile)
+		if err != nil {
+			return 0, err
+		}
+		n, err := d.moveInStore(store, from, to)
+		if err != nil {
+			if profile != "" {
+				err = fmt.Errorf("profile %s: %w", profile, err)
+			}
+			return 0, err
+		}
+		if profile == "" {
+			moved = n
+		}
+	}
+
+	// Don't let the next scan build on one holding the old paths
+	d.scansMu.Lock()
+	clear(d.scans)
+	d.scansMu.Unlock()
+
+	slog.Info("Moved state entries", "from", from, "to", to, "entries", moved)
+	return moved, nil
+}
+
+// moveInStore applies a move to the state of one store, returning the number of entries moved
+func (d *Detector) moveInStore(store StateStore, from, to string) (int, error) {
+	state, err := store.Load(nil)
+	if err != nil {
+		return 0, fmt.Errorf("failed to load state: %w", err)
+	}
+	dirs := stateDirectories(state)
+	rename := func(p string) string {
+		return to + strings.TrimPrefix(p, from)
+	}
+
+	// An entry can be stored under several tracked directories when they nest
+	moved := make(map[string]FileState)
+	changed := false
+	for key, file := range state.Files {
+		switch {
+		case withinDirectory(file.Path, from):
+			newPath := rename(file.Path)
+			file.Path = newPath
+			moved[newPath] = file
+			delete(state.Files, key)
+			changed = true
+		case withinDirectory(file.Path, to):
+			delete(state.Files, key)
+			changed = true
+		}
+	}
+	movedETags := make(map[string]string)
+	for p, etag := range state.DirectoryETags {
+		switch {
+		case withinDirectory(p, from):
+			movedETags[rename(p)] = etag
+			delete(state.DirectoryETags, p)
+			changed = true
+		case withinDirectory(p, to):
+			delete(state.DirectoryETags, p)
+			changed = true
+		}
+	}
+	if !changed {
+		return 0, nil
+	}
+
+	// Entries are kept by the tracked directories they now lie in, and
+	// dropped from those they left
+	for _, dir := range dirs {
+		for p, file := range moved {
+			if withinDirectory(p, dir) && p != dir {
+				state.Files[dir+":"+p] = file
+			}
+		}
+	}
+	for p, etag := range movedETags {
+		if withinAny(p, dirs) {
+			state.DirectoryETags[p] = etag
+		}
+	}
+
+	err = d.persist(func() error { return store.Save(dirs, state) })
+	if err != nil {
+		return 0, fmt.Errorf("failed to save state: %w", err)
+	}
+	return len(moved), nil
+}
@@ -0,0 +1,41 @@
+package diff
+
+import "go-nc-client/internal/filter"
+
+// Settings are the options that can change while the detector runs, e.g.
+// from PUT /config; each scan uses those current when it starts
+type Settings struct {
+	Filter        *filter.Filter
+	MaxDepths     map[string]int
+	MoveDetection map[string]MoveDetection
+}
+
+// UpdateSettings replaces the filter and the per directory settings
+func (d *Detector) UpdateSettings(s Settings) {
+	maxDepths, moves := normalizeDirectorySettings(s.MaxDepths, s.MoveDetection)
+
+	d.settingsMu.Lock()
+	defer d.settingsMu.Unlock()
+	d.options.Filter = s.Filter
+	d.options.MaxDepths = maxDepths
+	d.options.MoveDetection = moves
+}
+
+// normalizeDirectorySettings keys the per directory settings by normalized path
+func normalizeDirectorySettings(maxDepths map[string]int, moves map[string]MoveDetection) (map[string]int, map[string]MoveDetection) {
+	var normalizedDepths map[string]int
+	if maxDepths != nil {
+		normalizedDepths = make(map[string]int, len(maxDepths))
+		for dir, depth := range maxDepths {
+			normalizedDepths[normalizeDirectory(dir)] = depth
+		}
+	}
+	var normalizedMoves map[string]MoveDetection
+	if moves != nil {
+		normalizedMoves = make(map[string]MoveDetection, len(moves))
+		for dir, m := range moves {
+			normalizedMoves[normalizeDirectory(dir)] = m
+		}
+	}
+	return normalizedDepths, normalizedMoves
+}
@@ -0,0 +1,155 @@
+package diff
+
+import (
+	"log/slog"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// ShardedStore keeps one JSON state file per tracked directory below a
+// state directory, so a diff of one directory never touches the others
+type ShardedStore struct {
+	dir      string
+	compress bool
+	cipher   *StateCipher
+
+	mu     sync.Mutex
+	shards map[string]*shard
+}
+
+// shard serializes access to a single state file; different shards never block each other
+type shard struct {
+	mu    sync.Mutex
+	store *JSONStore
+}
+
+func NewShardedStore(dir string, compress bool) *ShardedStore {
+	return &ShardedStore{
+		dir:      dir,
+		compress: compress,
+		shards:   make(map[string]*shard),
+	}
+}
+
+// Encrypt makes every shard encrypt what it writes with c, see JSONStore.Encrypt
+func (s *ShardedStore) Encrypt(c *StateCipher) *ShardedStore {
+	s.cipher = c
+	return s
+}
+
+// shard returns the shard for a tracked directory
+// "/Documents/Work" is stored in "Documents%2FWork.json", "/" in "_root.json"
+func (s *ShardedStore) shard(dir string) *shard {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if sh, ok := s.shards[dir]; ok {
+		return sh
+	}
+	name := url.PathEscape(strings.TrimPrefix(dir, "/"))
+	if name == "" {
+		name = "_root"
+	}
+	sh := &shard{store: NewJSONStore(filepath.Join(s.dir, name+".json"), s.compress).Encrypt(s.cipher)}
+	s.shards[dir] = sh
+	return sh
+}
+
+func (s *ShardedStore) Load(dirs []string) (*State, error) {
+	if len(dirs) == 0 {
+		var err error
+		if dirs, err = s.trackedDirectories(); err != nil {
+			return nil, err
+		}
+	}
+
+	state := newState()
+	for _, dir := range dirs {
+		sh := s.shard(dir)
+		sh.mu.Lock()
+		shardState, err := sh.store.Load([]string{dir})
+		sh.mu.Unlock()
+		if err != nil {
+			return nil, err
+		}
+
+		shardState.copyDirectories(state, []string{dir})
+		if shardState.LastUpdate.After(state.LastUpdate) {
+			state.LastUpdate = shardState.LastUpdate
+		}
+	}
+	return state, nil
+}
+
+func (s *ShardedStore) Save(dirs []string, state *State) error {
+	for _, dir := range dirs {
+		sh := s.shard(dir)
+		sh.mu.Lock()
+		err := sh.store.Save([]string{dir}, state)
+		sh.mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Remove deletes the shard files of dirs
+func (s *ShardedStore) Remove(dirs []string) error {
+	for _, dir := range dirs {
+		sh := s.shard(dir)
+		sh.mu.Lock()
+		for _, p := range []string{sh.store.path, sh.store.alternatePath()} {
+			if err := os.Remove(p); err != nil && !os.IsNotExist(err) {
+				sh.mu.Unlock()
+				return err
+			}
+		}
+		sh.mu.Unlock()
+	}
+	return nil
+}
+
+// CheckWritable creates and removes a file in the state directory
+func (s *ShardedStore) CheckWritable() error {
+	return checkWritableDir(s.dir)
+}
+
+// trackedDirectories lists the directories that have a shard on disk
+func (s *ShardedStore) trackedDirectories() ([]string, error) {
+	entries, err := os.ReadDir(s.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	seen := make(map[string]bool)
+	var dirs []string
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".gz")
+		if entry.IsDir() || !strings.HasSuffix(name, ".json") {
+			continue
+		}
+		name = strings.TrimSuffix(name, ".json")
+
+		dir := "/"
+		if name != "_root" {
+			unescaped, err := url.PathUnescape(name)
+			if err != nil {
+				slog.Warn("Skipping unrecognized state shard", "name", entry.Name())
+				continue
+			}
+			dir = "/" + unescaped
+		}
+		if !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs, nil
+}
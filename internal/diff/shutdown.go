@@ -0,0 +1,48 @@
+package diff
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+)
+
+// ErrShuttingDown is returned for runs started, or saves attempted, once Shutdown was called
+var ErrShuttingDown = errors.New("detector is shutting down")
+
+// Shutdown stops new runs and waits for the one in progress to finish
+// If ctx ends first, a save already being written is still waited for, but a
+// run that has yet to save is made to abort without touching the state, so
+// the process can exit without leaving a half-written state behind.
+func (d *Detector) Shutdown(ctx context.Context) error {
+	d.runInfoMu.Lock()
+	d.closing = true
+	d.runInfoMu.Unlock()
+
+	idle := make(chan struct{})
+	go func() {
+		d.runMu.Lock()
+		close(idle)
+	}()
+
+	select {
+	case <-idle:
+		return nil
+	case <-ctx.Done():
+	}
+
+	d.saveMu.Lock()
+	d.aborted = true
+	d.saveMu.Unlock()
+	slog.Warn("Shutdown timed out, run in progress will not save its state")
+	return ctx.Err()
+}
+
+// persist runs fn, which writes the state, unless Shutdown gave up waiting for the run
+func (d *Detector) persist(fn func() error) error {
+	d.saveMu.Lock()
+	defer d.saveMu.Unlock()
+	if d.aborted {
+		return ErrShuttingDown
+	}
+	return fn()
+}
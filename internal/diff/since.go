@@ -0,0 +1,176 @@
+package diff
+
+import (
+	"fmt"
+	"log/slog"
+	"sort"
+	"time"
+
+	"go-nc-client/internal/filter"
+	"go-nc-client/internal/webdav"
+)
+
+// ChangesSince reports what changed in the directories since the given time,
+// without reading or saving the state used by DetectChanges
+//
+// Changes recorded in the journal since then are folded into one net change per
+// path. Files whose modification time is newer but that the journal doesn't
+// mention yet (e.g. changed after the last diff run) are reported as updated,
+// since a listing can't tell a new file from a modified one. Deletions are only
+// known from the journal.
+func (d *Detector) ChangesSince(directories []string, since time.Time, opts DetectOptions) ([]Changes, error) {
+	if err := opts.ChangeFilter.Validate(); err != nil {
+		return nil, err
+	}
+	opts = d.bind(opts)
+
+	var allChanges []Changes
+
+	for _, directory := range directories {
+		dir := normalizeDirectory(directory)
+
+		var journaled []Change
+		if d.options.Journal != nil {
+			entries, err := d.options.Journal.Query(HistoryQuery{Path: dir, Since: since})
+			if err != nil {
+				return nil, fmt.Errorf("failed to read journal: %w", err)
+			}
+			journaled = netChanges(entries)
+		}
+
+		// The live listing catches changes not diffed (and so not journaled) yet
+		liveState := newState()
+		dirFilter, err := d.scanLive(dir, liveState, opts)
+		if err != nil {
+			return nil, err
+		}
+
+		seen := make(map[string]bool, len(journaled))
+		for _, change := range journaled {
+			seen[change.Path] = true
+		}
+		var modified []Change
+		for _, file := range liveState.Files {
+			if file.IsDir || seen[file.Path] || file.ModifiedTime.Before(since) {
+				continue
+			}
+			modified = append(modified, Change{
+				Type:     "updated",
+				Path:     file.Path,
+				Size:     file.Size,
+				Modified: file.ModifiedTime,
+				ETag:     file.ETag,
+			})
+		}
+		sort.Slice(modified, func(i, j int) bool { return modified[i].Path < modified[j].Path })
+
+		var changes []Change
+		for _, change := range append(journaled, modified...) {
+			if !opts.IncludeHidden && (isHidden(change.Path) || (change.OldPath != "" && isHidden(change.OldPath))) {
+				continue
+			}
+			changes = append(changes, change)
+		}
+		changes = filterChanges(dirFilter, dir, changes)
+		if opts.FavoritesOnly {
+			changes = filterFavorites(changes, dir, liveState, liveState)
+		}
+		changes = opts.ChangeFilter.Apply(dir, changes)
+
+		allChanges = append(allChanges, Changes{
+			Directory: dir,
+			Changes:   changes,
+			Timestamp: time.Now(),
+		})
+	}
+
+	return collapseResults(allChanges, opts), nil
+}
+
+// scanLive walks the tracked directory dir without reusing any stored state
+// and adds what it finds to state, returning the filter the walk applied
+func (d *Detector) scanLive(dir string, state *State, opts DetectOptions) (*filter.Filter, error) {
+	dirInfo, err := opts.client.Stat(dir)
+	if err != nil {
+		slog.Error("Failed to stat directory", "directory", dir, "error", err)
+		return nil, fmt.Errorf("failed to stat directory %s: %w", dir, err)
+	}
+	dirFilter, maxDepth, err := d.scanFilter(dir, dirInfo.ETag, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	skip := func(filePath string, isDir bool) bool {
+		return skipped(dirFilter, dir, d.normalizePath(filePath), isDir)
+	}
+	files, err := opts.client.ListFilesWithETagOptimization(dir, opts.IncludeHidden, nil, nil, opts.Progress, webdav.WalkOptions{
+		Skip:     skip,
+		MaxDepth: maxDepth,
+		Context:  opts.Context,
+	})
+	if err != nil {
+		slog.Error("Failed to list files", "directory", dir, "error", err)
+		return nil, fmt.Errorf("failed to list files in %s: %w", dir, err)
+	}
+
+	for _, file := range files {
+		file.Path = d.normalizePath(file.Path)
+		state.Files[dir+":"+file.Path] = newFileState(file)
+	}
+	state.DirectoryETags[dir] = dirInfo.ETag
+	state.ScanSettings[dir] = scanSettings(dirFilter, opts.IncludeHidden)
+	return dirFilter, nil
+}
+
+// netChanges folds journal entries into one change per path, in the order
+// paths were first touched
+// A file created and later updated stays created; one created and later
+// deleted drops out entirely.
+func netChanges(entries []JournalEntry) []Change {
+	var order []string
+	net := make(map[string]Change)
+	for _, entry := range entries {
+		change := entry.Change
+		prev, exists := net[change.Path]
+		if !exists {
+			order = append(order, change.Path)
+		}
+		if exists && prev.Type == "created" {
+			switch change.Type {
+			case "updated":
+				change.Type = "created"
+				change.OldSize, change.OldModified, change.OldETag = nil, nil, ""
+			case "deleted":
+				delete(net, change.Path)
+				continue
+			}
+		}
+		if exists && prev.Type == "updated" && change.Type == "updated" {
+			// Report the whole span, from before the first update
+			change.OldSize, change.OldModified, change.OldETag = prev.OldSize, prev.OldModified, prev.OldETag
+		}
+		if change.Type == "moved" {
+			// The old path no longer holds anything new
+			if moved, ok := net[change.OldPath]; ok {
+				delete(net, change.OldPath)
+				if moved.Type == "created" {
+					change.Type = "created"
+					change.OldPath = ""
+				} else if moved.Type == "moved" {
+					change.OldPath = moved.OldPath
+				}
+			}
+		}
+		net[change.Path] = change
+	}
+
+	var changes []Change
+	for _, p := range order {
+		if change, ok := net[p]; ok {
+			changes = append(changes, change)
+			// Report a path only once even if it was touched again after a move
+			delete(net, p)
+		}
+	}
+	return changes
+}
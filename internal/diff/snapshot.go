@@ -0,0 +1,228 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrSnapshotNotFound is returned for snapshot names that don't exist
+	ErrSnapshotNotFound = errors.New("snapshot not found")
+	// ErrSnapshotExists is returned when taking a snapshot under a name already in use
+	ErrSnapshotExists = errors.New("snapshot already exists")
+	// ErrInvalidSnapshotName is returned for names that can't be used as a file name
+	ErrInvalidSnapshotName = errors.New("invalid snapshot name: use letters, digits, '.', '_' and '-'")
+	// ErrNoSnapshots is returned when no snapshot directory is configured
+	ErrNoSnapshots = errors.New("snapshots are disabled")
+)
+
+var snapshotNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-][A-Za-z0-9._-]*$`)
+
+// SnapshotInfo describes a stored snapshot
+type SnapshotInfo struct {
+	Name        string    `json:"name"`
+	Created     time.Time `json:"created"`
+	Directories []string  `json:"directories"`
+	Files       int       `json:"files"`
+}
+
+// SnapshotStore keeps named snapshots of the remote state, one JSON file each
+// Snapshots are independent of the rolling state used by DetectChanges.
+type SnapshotStore struct {
+	dir string
+}
+
+// NewSnapshotStore stores snapshots in dir, which is created on first use
+func NewSnapshotStore(dir string) *SnapshotStore {
+	return &SnapshotStore{dir: dir}
+}
+
+func (s *SnapshotStore) path(name string) string {
+	return filepath.Join(s.dir, name+".json")
+}
+
+func (s *SnapshotStore) load(name string) (*State, error) {
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+	if _, err := os.Stat(s.path(name)); os.IsNotExist(err) {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	return NewJSONStore(s.path(name), false).Load(nil)
+}
+
+func (s *SnapshotStore) save(name string, state *State) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return ErrInvalidSnapshotName
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+	if _, err := os.Stat(s.path(name)); err == nil {
+		return fmt.Errorf("%w: %s", ErrSnapshotExists, name)
+	}
+	return NewJSONStore(s.path(name), false).Save(snapshotDirectories(state), state)
+}
+
+// List returns the stored snapshots, oldest first
+func (s *SnapshotStore) List() ([]SnapshotInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if os.IsNotExist(err) {
+		return []SnapshotInfo{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	snapshots := []SnapshotInfo{}
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".json")
+		if !ok || entry.IsDir() || !snapshotNamePattern.MatchString(name) {
+			continue
+		}
+		state, err := s.load(name)
+		if err != nil {
+			slog.Warn("Skipping unreadable snapshot", "name", name, "error", err)
+			continue
+		}
+		snapshots = append(snapshots, snapshotInfo(name, state))
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].Created.Before(snapshots[j].Created) })
+	return snapshots, nil
+}
+
+// Delete removes a snapshot
+func (s *SnapshotStore) Delete(name string) error {
+	if !snapshotNamePattern.MatchString(name) {
+		return ErrInvalidSnapshotName
+	}
+	err := os.Remove(s.path(name))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("%w: %s", ErrSnapshotNotFound, name)
+	}
+	return err
+}
+
+func snapshotInfo(name string, state *State) SnapshotInfo {
+	return SnapshotInfo{
+		Name:        name,
+		Created:     state.LastUpdate,
+		Directories: snapshotDirectories(state),
+		Files:       len(state.Files),
+	}
+}
+
+// snapshotDirectories returns the tracked directories a snapshot covers
+func snapshotDirectories(state *State) []string {
+	dirs := make([]string, 0, len(state.ScanSettings))
+	for dir := range state.ScanSettings {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	return dirs
+}
+
+// TakeSnapshot walks the directories and stores what it finds under name
+func (d *Detector) TakeSnapshot(name string, directories []string, opts DetectOptions) (*SnapshotInfo, error) {
+	if d.options.Snapshots == nil {
+		return nil, ErrNoSnapshots
+	}
+	if !snapshotNamePattern.MatchString(name) {
+		return nil, ErrInvalidSnapshotName
+	}
+	opts = d.bind(opts)
+
+	state := newState()
+	state.LastUpdate = time.Now()
+	for _, directory := range directories {
+		if _, err := d.scanLive(normalizeDirectory(directory), state, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := d.options.Snapshots.save(name, state); err != nil {
+		return nil, err
+	}
+	info := snapshotInfo(name, state)
+	slog.Info("Took snapshot", "name", name, "files", info.Files, "directories", info.Directories)
+	return &info, nil
+}
+
+// Snapshots returns the snapshot store, nil when snapshots are disabled
+func (d *Detector) Snapshots() *SnapshotStore {
+	return d.options.Snapshots
+}
+
+// DiffSnapshots reports the changes between snapshot from and snapshot to,
+// or between from and the live remote state when to is empty
+// directories restricts the comparison; empty means every directory in from.
+func (d *Detector) DiffSnapshots(from, to string, directories []string, opts DetectOptions) ([]Changes, error) {
+	if d.options.Snapshots == nil {
+		return nil, ErrNoSnapshots
+	}
+	if err := opts.ChangeFilter.Validate(); err != nil {
+		return nil, err
+	}
+	opts = d.bind(opts)
+	fromState, err := d.options.Snapshots.load(from)
+	if err != nil {
+		return nil, err
+	}
+
+	dirs := snapshotDirectories(fromState)
+	if len(directories) > 0 {
+		dirs = make([]string, len(directories))
+		for i, dir := range directories {
+			dirs[i] = normalizeDirectory(dir)
+			if _, ok := fromState.ScanSettings[dirs[i]]; !ok {
+				return nil, fmt.Errorf("snapshot %s does not cover %s", from, dirs[i])
+			}
+		}
+	}
+
+	var toState *State
+	if to != "" {
+		if toState, err = d.options.Snapshots.load(to); err != nil {
+			return nil, err
+		}
+		for _, dir := range dirs {
+			if _, ok := toState.ScanSettings[dir]; !ok {
+				return nil, fmt.Errorf("snapshot %s does not cover %s", to, dir)
+			}
+		}
+	} else {
+		toState = newState()
+		toState.LastUpdate = time.Now()
+		for _, dir := range dirs {
+			// Match the snapshot so hidden files don't show up as created or deleted
+			liveOpts := opts
+			liveOpts.IncludeHidden = strings.HasSuffix(fromState.ScanSettings[dir], "+hidden")
+			if _, err := d.scanLive(dir, toState, liveOpts); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var allChanges []Changes
+	for _, dir := range dirs {
+		changes := d.compareStates(opts.client, dir, fromState, toState)
+		attachETags(dir, changes, toState)
+		if opts.FavoritesOnly {
+			changes = filterFavorites(changes, dir, fromState, toState)
+		}
+		changes = opts.ChangeFilter.Apply(dir, changes)
+		allChanges = append(allChanges, Changes{
+			Directory: dir,
+			Changes:   changes,
+			Timestamp: toState.LastUpdate,
+		})
+	}
+	return collapseResults(allChanges, opts), nil
+}
@@ -0,0 +1,340 @@
+package diff
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// StateStore persists the detector state between runs
+// State is partitioned by tracked directory so a backend only has to read
+// and write the directories a diff actually covers
+type StateStore interface {
+	// Load returns the stored state of the given tracked directories,
+	// or of every directory when dirs is empty
+	// A store that has never been saved returns an empty state
+	Load(dirs []string) (*State, error)
+	// Save replaces the stored state of the given tracked directories with
+	// their entries in state; other directories are left untouched
+	Save(dirs []string, state *State) error
+	// Remove deletes everything stored for the given directories
+	Remove(dirs []string) error
+}
+
+// WritableChecker is implemented by stores that can tell, without saving
+// anything, whether a save would be possible
+type WritableChecker interface {
+	CheckWritable() error
+}
+
+// CheckState reports whether the default state store can currently be saved
+// to, for readiness checks; stores that can't tell are assumed writable
+func (d *Detector) CheckState() error {
+	if checker, ok := d.store.(WritableChecker); ok {
+		return checker.CheckWritable()
+	}
+	return nil
+}
+
+// JSONStore keeps the whole state in a single JSON file
+type JSONStore struct {
+	path     string
+	compress bool
+	cipher   *StateCipher
+}
+
+// NewJSONStore stores state at path, or at path+".gz" gzip-compressed when compress is set
+// Loading accepts either form, so toggling compression keeps existing state
+func NewJSONStore(path string, compress bool) *JSONStore {
+	if compress && !strings.HasSuffix(path, ".gz") {
+		path += ".gz"
+	}
+	return &JSONStore{path: path, compress: compress}
+}
+
+// Encrypt makes the store encrypt what it writes with c
+// Unencrypted files are still read, and encrypted on the next save.
+func (s *JSONStore) Encrypt(c *StateCipher) *JSONStore {
+	s.cipher = c
+	return s
+}
+
+func (s *JSONStore) Load(dirs []string) (*State, error) {
+	slog.Debug("Loading state", "path", s.path)
+
+	state, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	if len(dirs) == 0 {
+		return state, nil
+	}
+
+	result := newState()
+	state.copyDirectories(result, dirs)
+	result.LastUpdate = state.LastUpdate
+	return result, nil
+}
+
+func (s *JSONStore) Save(dirs []string, state *State) error {
+	// The file holds every directory, so merge into what is already there
+	stored, err := s.read()
+	if errors.Is(err, ErrUnsupportedSchema) {
+		return err
+	}
+	if err != nil {
+		slog.Warn("Could not read existing state, overwriting it", "path", s.path, "error", err)
+		stored = newState()
+	}
+	stored.removeDirectories(dirs)
+	state.copyDirectories(stored, dirs)
+	stored.LastUpdate = state.LastUpdate
+
+	if err := s.write(stored); err != nil {
+		return err
+	}
+
+	// The other form would be stale from now on
+	if alternate := s.alternatePath(); alternate != s.path {
+		if err := os.Remove(alternate); err == nil {
+			slog.Info("Removed superseded state file", "path", alternate)
+		}
+	}
+	return nil
+}
+
+func (s *JSONStore) Remove(dirs []string) error {
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	stored.removeDirectories(dirs)
+	return s.write(stored)
+}
+
+// CheckWritable creates and removes a file next to the state file
+func (s *JSONStore) CheckWritable() error {
+	return checkWritableDir(filepath.Dir(s.path))
+}
+
+// checkWritableDir creates dir if needed and a scratch file in it, then removes the file
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(dir, ".write-check-*")
+	if err != nil {
+		return err
+	}
+	f.Close()
+	return os.Remove(f.Name())
+}
+
+// alternatePath is the file name used with the opposite compression setting
+func (s *JSONStore) alternatePath() string {
+	if s.compress {
+		return strings.TrimSuffix(s.path, ".gz")
+	}
+	return s.path + ".gz"
+}
+
+func (s *JSONStore) read() (*State, error) {
+	f, err := os.Open(s.path)
+	if os.IsNotExist(err) {
+		// Fall back to state written with the other compression setting
+		f, err = os.Open(s.alternatePath())
+	}
+	if err != nil {
+		if os.IsNotExist(err) {
+			return newState(), nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	// Detect encryption and gzip by their magic bytes rather than by file name
+	br := bufio.NewReader(f)
+	if magic, _ := br.Peek(len(encryptedMagic)); isEncrypted(magic) {
+		if s.cipher == nil {
+			return nil, ErrStateEncrypted
+		}
+		data, err := io.ReadAll(br)
+		if err != nil {
+			return nil, err
+		}
+		plain, err := s.cipher.open(data)
+		if err != nil {
+			return nil, err
+		}
+		br = bufio.NewReader(bytes.NewReader(plain))
+	}
+	var r io.Reader = br
+	if magic, _ := br.Peek(2); bytes.Equal(magic, []byte{0x1f, 0x8b}) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return nil, err
+		}
+		defer zr.Close()
+		r = zr
+	}
+
+	var state State
+	if err := json.NewDecoder(r).Decode(&state); err != nil {
+		return nil, err
+	}
+	state.init()
+
+	fromVersion := state.SchemaVersion
+	migrated, err := migrateState(&state)
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		// Loading stays read-only; the next Save writes the migrated state
+		slog.Info("Migrated state", "path", s.path, "from_version", fromVersion, "to_version", state.SchemaVersion)
+	}
+
+	return &state, nil
+}
+
+func (s *JSONStore) write(state *State) error {
+	// Create directory if it doesn't exist
+	dir := filepath.Dir(s.path)
+	if dir != "." && dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			slog.Error("Failed to create state directory", "path", dir, "error", err)
+			return err
+		}
+	}
+
+	// Use Marshal instead of MarshalIndent for better performance with large files
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+
+	if s.compress {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(data); err != nil {
+			return err
+		}
+		if err := zw.Close(); err != nil {
+			return err
+		}
+		data = buf.Bytes()
+	}
+
+	if s.cipher != nil {
+		if data, err = s.cipher.seal(data); err != nil {
+			return err
+		}
+	}
+
+	// Write to a temporary file and rename it over the state, so an
+	// interrupted write never leaves a truncated state behind
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		slog.Error("Failed to write state", "path", tmp, "error", err)
+		return err
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		slog.Error("Failed to replace state", "path", s.path, "error", err)
+		return err
+	}
+
+	slog.Debug("State saved", "path", s.path)
+	return nil
+}
+
+// newState returns an empty state with all maps allocated
+func newState() *State {
+	state := &State{SchemaVersion: SchemaVersion}
+	state.init()
+	return state
+}
+
+// init allocates any nil maps, e.g. after decoding an older state file
+func (s *State) init() {
+	if s.Files == nil {
+		s.Files = make(map[string]FileState)
+	}
+	if s.DirectoryETags == nil {
+		s.DirectoryETags = make(map[string]string)
+	}
+	if s.SyncTokens == nil {
+		s.SyncTokens = make(map[string]string)
+	}
+	if s.ScanSettings == nil {
+		s.ScanSettings = make(map[string]string)
+	}
+	if s.Accounts == nil {
+		s.Accounts = make(map[string]string)
+	}
+	if s.ScannedAt == nil {
+		s.ScannedAt = make(map[string]time.Time)
+	}
+}
+
+// copyDirectories copies the entries belonging to the tracked directories dirs into dst
+func (s *State) copyDirectories(dst *State, dirs []string) {
+	for _, dir := range dirs {
+		dirPrefix := dir + ":"
+		for key, file := range s.Files {
+			if strings.HasPrefix(key, dirPrefix) {
+				dst.Files[key] = file
+			}
+		}
+		for p, etag := range s.DirectoryETags {
+			if withinDirectory(p, dir) {
+				dst.DirectoryETags[p] = etag
+			}
+		}
+		if token, ok := s.SyncTokens[dir]; ok {
+			dst.SyncTokens[dir] = token
+		}
+		if settings, ok := s.ScanSettings[dir]; ok {
+			dst.ScanSettings[dir] = settings
+		}
+		if account, ok := s.Accounts[dir]; ok {
+			dst.Accounts[dir] = account
+		}
+		if scanned, ok := s.ScannedAt[dir]; ok {
+			dst.ScannedAt[dir] = scanned
+		}
+	}
+}
+
+// removeDirectories deletes the entries belonging to the tracked directories dirs
+func (s *State) removeDirectories(dirs []string) {
+	for _, dir := range dirs {
+		dirPrefix := dir + ":"
+		for key := range s.Files {
+			if strings.HasPrefix(key, dirPrefix) {
+				delete(s.Files, key)
+			}
+		}
+		for p := range s.DirectoryETags {
+			if withinDirectory(p, dir) {
+				delete(s.DirectoryETags, p)
+			}
+		}
+		delete(s.SyncTokens, dir)
+		delete(s.ScanSettings, dir)
+		delete(s.Accounts, dir)
+		delete(s.ScannedAt, dir)
+	}
+}
+
+// withinDirectory reports whether p is dir itself or lies below it
+func withinDirectory(p, dir string) bool {
+	return p == dir || strings.HasPrefix(p, strings.TrimSuffix(dir, "/")+"/")
+}
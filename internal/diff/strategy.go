@@ -0,0 +1,237 @@
+package diff
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"time"
+
+	"go-nc-client/internal/filter"
+	"go-nc-client/internal/webdav"
+)
+
+// Names of the ways a tracked directory's current state is gathered, in the
+// order they are tried
+const (
+	// StrategySyncToken applies an RFC 6578 sync-collection report to the previous state
+	StrategySyncToken = "sync-token"
+	// StrategyDirectoryETag reuses the previous state of subtrees whose ETag didn't change
+	StrategyDirectoryETag = "directory-etag"
+	// StrategyFullWalk lists the whole tree
+	StrategyFullWalk = "full-walk"
+)
+
+// scanContext is what the strategies know about the directory being scanned
+type scanContext struct {
+	dir       string
+	opts      DetectOptions
+	dirFilter *filter.Filter
+	maxDepth  int
+	settings  string
+
+	// prevState is what changes are computed against, base what scans may
+	// reuse (see scanBase)
+	prevState *State
+	base      *State
+	// currentState receives the directory's entries
+	currentState *State
+
+	// settingsChanged is set when base was scanned with other filters
+	settingsChanged bool
+	// unchanged is set when the directory's own ETag matches base
+	unchanged bool
+	// tokenExpired is set when the server rejected the stored sync token
+	tokenExpired bool
+
+	// stats receives the work done by the strategy
+	stats *ScanStats
+}
+
+// scanStrategy fills currentState, returning false when it doesn't apply so
+// the next one is tried
+type scanStrategy struct {
+	name string
+	scan func(sc *scanContext) (bool, error)
+}
+
+func (d *Detector) strategies() []scanStrategy {
+	return []scanStrategy{
+		{StrategySyncToken, d.syncTokenScan},
+		{StrategyDirectoryETag, d.directoryETagScan},
+		{StrategyFullWalk, d.fullWalkScan},
+	}
+}
+
+// gatherState runs the strategies in order until one applies, returning its name
+func (d *Detector) gatherState(sc *scanContext) (string, error) {
+	for _, strategy := range d.strategies() {
+		ok, err := strategy.scan(sc)
+		if err != nil {
+			return "", err
+		}
+		if ok {
+			slog.Debug("Scanned directory", "directory", sc.dir, "strategy", strategy.name)
+			return strategy.name, nil
+		}
+	}
+	// The full walk always applies
+	return "", fmt.Errorf("no scan strategy applies to %s", sc.dir)
+}
+
+// syncTokenScan applies the changes a sync-collection report lists since the
+// previous token; it doesn't apply without a token, with other settings or
+// when the directory is unchanged anyway
+func (d *Detector) syncTokenScan(sc *scanContext) (bool, error) {
+	if !d.options.UseSyncTokens || sc.unchanged || sc.prevState.ScanSettings[sc.dir] != sc.settings {
+		return false, nil
+	}
+	prevToken := sc.prevState.SyncTokens[sc.dir]
+	if prevToken == "" {
+		return false, nil
+	}
+
+	result, err := sc.opts.client.SyncCollection(sc.dir, prevToken)
+	if errors.Is(err, webdav.ErrInvalidSyncToken) {
+		slog.Info("Sync token expired, resetting it after an ETag walk", "directory", sc.dir)
+		sc.tokenExpired = true
+		return false, nil
+	}
+	if err != nil {
+		slog.Warn("Sync-collection failed, falling back to ETag walk", "directory", sc.dir, "error", err)
+		return false, nil
+	}
+	slog.Debug("Sync-collection", "directory", sc.dir, "changed", len(result.Changed), "deleted", len(result.Deleted))
+	d.normalizeSyncResult(result)
+	d.applySyncResult(sc.dir, sc.prevState, sc.currentState, result, sc.opts.IncludeHidden, sc.dirFilter)
+	sc.currentState.SyncTokens[sc.dir] = result.Token
+	return true, nil
+}
+
+// directoryETagScan reuses base for the directory when its ETag is unchanged,
+// and otherwise walks it reusing every subtree whose ETag is unchanged
+func (d *Detector) directoryETagScan(sc *scanContext) (bool, error) {
+	if sc.settingsChanged || sc.base.DirectoryETags[sc.dir] == "" {
+		return false, nil
+	}
+
+	dirPrefix := sc.dir + ":"
+	if sc.unchanged {
+		slog.Debug("Directory unchanged, reusing state", "directory", sc.dir)
+		sc.stats.ETagCacheHits++
+		for key, fileState := range sc.base.Files {
+			if !strings.HasPrefix(key, dirPrefix) {
+				continue
+			}
+			// Filter hidden files if not including them
+			if !sc.opts.IncludeHidden && isHidden(fileState.Path) {
+				continue
+			}
+			if skipped(sc.dirFilter, sc.dir, fileState.Path, fileState.IsDir) {
+				continue
+			}
+			// Copy file from previous state; transient files are re-counted later
+			fileState.PendingScans = 0
+			sc.currentState.Files[key] = fileState
+		}
+		return true, nil
+	}
+
+	// Sorted keys of this directory's files, so each subdirectory's files
+	// are a contiguous range instead of a scan over the whole state
+	var prevKeys []string
+	for key := range sc.base.Files {
+		if strings.HasPrefix(key, dirPrefix) {
+			prevKeys = append(prevKeys, key)
+		}
+	}
+	sort.Strings(prevKeys)
+
+	// Create ETag checker callback for subdirectories
+	etagChecker := func(subdirPath string) (bool, string, []webdav.FileInfo, error) {
+		normalizedSubdir := d.normalizeSubdir(subdirPath)
+
+		// Try to get ETag from DirectoryETags map first (fastest path)
+		prevETag, hasETag := sc.base.DirectoryETags[normalizedSubdir]
+		subdirKey := dirPrefix + normalizedSubdir
+		
+		// Check if directory itself exists in state (for fallback ETag)
+		if !hasETag {
+			if dirState, exists := sc.base.Files[subdirKey]; exists && dirState.IsDir && dirState.ETag != "" {
+				prevETag = dirState.ETag
+				hasETag = true
+			}
+		}
+
+		if !hasETag {
+			return false, "", nil, nil
+		}
+
+		// Only collect files if we have a valid previous ETag
+		// Note: The actual ETag comparison happens in walkDirWithProgress
+		// We return files here so they can be reused if ETag matches
+		var prevFiles []webdav.FileInfo
+		if dirState, exists := sc.base.Files[subdirKey]; exists {
+			prevFiles = append(prevFiles, dirState.fileInfo())
+		}
+		subdirPrefix := subdirKey + "/"
+		for i := sort.SearchStrings(prevKeys, subdirPrefix); i < len(prevKeys) && strings.HasPrefix(prevKeys[i], subdirPrefix); i++ {
+			prevFiles = append(prevFiles, sc.base.Files[prevKeys[i]].fileInfo())
+		}
+
+		return true, prevETag, prevFiles, nil
+	}
+
+	return true, d.walk(sc, etagChecker)
+}
+
+// fullWalkScan lists the whole tree
+func (d *Detector) fullWalkScan(sc *scanContext) (bool, error) {
+	return true, d.walk(sc, nil)
+}
+
+// walk lists the directory into currentState, asking etagChecker (if any)
+// which subtrees can be reused
+func (d *Detector) walk(sc *scanContext, etagChecker webdav.SubdirETagChecker) error {
+	scanStartTime := time.Now()
+
+	// Store subdirectory ETags as we encounter them
+	etagStorer := func(subdirPath string, etag string) {
+		sc.currentState.DirectoryETags[d.normalizeSubdir(subdirPath)] = etag
+	}
+	skip := func(filePath string, isDir bool) bool {
+		return skipped(sc.dirFilter, sc.dir, d.normalizePath(filePath), isDir)
+	}
+
+	var walkStats webdav.WalkStats
+	files, err := sc.opts.client.ListFilesWithETagOptimization(sc.dir, sc.opts.IncludeHidden, etagChecker, etagStorer, sc.opts.Progress, webdav.WalkOptions{
+		Skip:     skip,
+		MaxDepth: sc.maxDepth,
+		Stats:    &walkStats,
+		Context:  sc.opts.Context,
+	})
+	sc.stats.DirsVisited += walkStats.Listed
+	sc.stats.PropfindRequests += walkStats.Listed
+	sc.stats.ETagCacheHits += walkStats.Reused
+	if err != nil {
+		slog.Error("Failed to list files", "directory", sc.dir, "error", err)
+		return fmt.Errorf("failed to list files in %s: %w", sc.dir, err)
+	}
+	slog.Debug("Listed files", "directory", sc.dir, "files", len(files), "duration", time.Since(scanStartTime))
+
+	for _, file := range files {
+		file.Path = d.normalizePath(file.Path)
+		sc.currentState.Files[sc.dir+":"+file.Path] = newFileState(file)
+	}
+	return nil
+}
+
+// normalizeSubdir normalizes a subdirectory path reported by the walk
+func (d *Detector) normalizeSubdir(subdirPath string) string {
+	normalized := d.normalizePath(subdirPath)
+	if !strings.HasPrefix(normalized, "/") {
+		normalized = "/" + normalized
+	}
+	return normalized
+}
@@ -0,0 +1,137 @@
+package diff
+
+import (
+	"fmt"
+	"log/slog"
+
+	"go-nc-client/internal/filter"
+)
+
+// TransientFilter holds back creations of files that may be short-lived,
+// such as office lock and temp files, until they have persisted for a number
+// of scans
+// A file that disappears before then is reported neither as created nor as deleted.
+type TransientFilter struct {
+	patterns *filter.Filter
+	minScans int
+}
+
+// NewTransientFilter debounces files matching patterns (include pattern
+// syntax; empty = every file) until they were seen in minScans consecutive scans
+// With patterns and minScans below 1, matching files are never reported.
+func NewTransientFilter(patterns []string, minScans int) (*TransientFilter, error) {
+	if len(patterns) == 0 && minScans < 2 {
+		return nil, fmt.Errorf("transient filtering needs patterns or a minimum of at least 2 scans")
+	}
+	f, err := filter.New(patterns, nil)
+	if err != nil {
+		return nil, err
+	}
+	return &TransientFilter{patterns: f, minScans: minScans}, nil
+}
+
+func (t *TransientFilter) matches(dir, filePath string) bool {
+	return t.patterns.Matches(relativeTo(dir, filePath), false)
+}
+
+// settled reports whether a file seen in scans consecutive scans is reported
+func (t *TransientFilter) settled(scans int) bool {
+	return t.minScans > 0 && scans >= t.minScans
+}
+
+// debounce drops changes of pending transient files and reports the creation
+// of those that persisted long enough, tracking the scan count in currentState
+func (t *TransientFilter) debounce(dir string, changes []Change, prevState, currentState *State) []Change {
+	if t == nil {
+		return changes
+	}
+	dirPrefix := dir + ":"
+
+	pendingBefore := func(p string) int {
+		return prevState.Files[dirPrefix+p].PendingScans
+	}
+
+	var result []Change
+	for _, c := range changes {
+		if c.IsDir {
+			result = append(result, c)
+			continue
+		}
+		switch c.Type {
+		case "created":
+			if !t.matches(dir, c.Path) || t.settled(1) {
+				result = append(result, c)
+				continue
+			}
+			t.markPending(currentState, dirPrefix+c.Path, 1)
+		case "deleted":
+			if pendingBefore(c.Path) > 0 {
+				slog.Debug("Transient file disappeared before it was reported", "path", c.Path)
+				continue
+			}
+			result = append(result, c)
+		case "moved":
+			// A pending file keeps waiting under its new name
+			if scans := pendingBefore(c.OldPath); scans > 0 {
+				if created, ok := t.advance(currentState, dirPrefix+c.Path, scans+1); ok {
+					result = append(result, created)
+				}
+				continue
+			}
+			result = append(result, c)
+		case "updated":
+			// Pending files are handled below, with the ones that didn't change
+			if pendingBefore(c.Path) > 0 {
+				continue
+			}
+			result = append(result, c)
+		default:
+			result = append(result, c)
+		}
+	}
+
+	// Pending files still present have now been seen in one more scan
+	for key, prev := range prevState.Files {
+		if prev.PendingScans == 0 {
+			continue
+		}
+		if created, ok := t.advance(currentState, key, prev.PendingScans+1); ok {
+			result = append(result, created)
+		}
+	}
+	return result
+}
+
+// advance records that a pending file has been seen in scans scans, returning
+// its creation once it has settled
+func (t *TransientFilter) advance(state *State, key string, scans int) (Change, bool) {
+	if _, ok := state.Files[key]; !ok {
+		return Change{}, false
+	}
+	t.markPending(state, key, scans)
+	f := state.Files[key]
+	if f.PendingScans > 0 {
+		return Change{}, false
+	}
+	return Change{
+		Type:     "created",
+		Path:     f.Path,
+		Size:     f.Size,
+		Modified: f.ModifiedTime,
+	}, true
+}
+
+// markPending records how many scans a file has been seen in, clearing the
+// count once it has settled
+func (t *TransientFilter) markPending(state *State, key string, scans int) {
+	f, ok := state.Files[key]
+	if !ok {
+		return
+	}
+	if t.settled(scans) {
+		f.PendingScans = 0
+	} else {
+		f.PendingScans = scans
+	}
+	state.Files[key] = f
+}
@@ -0,0 +1,159 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// unifiedContext is the number of unchanged lines shown around each change
+const unifiedContext = 3
+
+type lineOp struct {
+	kind byte // ' ', '-' or '+'
+	text string
+}
+
+// unifiedDiff returns a unified diff of two texts, empty if they are equal
+func unifiedDiff(name, oldText, newText string) string {
+	if oldText == newText {
+		return ""
+	}
+	ops := diffLines(splitLines(oldText), splitLines(newText))
+
+	// Line numbers (1-based) each op starts at in the old and new text
+	oldAt := make([]int, len(ops)+1)
+	newAt := make([]int, len(ops)+1)
+	oldAt[0], newAt[0] = 1, 1
+	for i, op := range ops {
+		oldAt[i+1], newAt[i+1] = oldAt[i], newAt[i]
+		if op.kind != '+' {
+			oldAt[i+1]++
+		}
+		if op.kind != '-' {
+			newAt[i+1]++
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "--- a%s\n+++ b%s\n", name, name)
+
+	// Changes closer than two contexts apart share a hunk
+	for i := 0; i < len(ops); i++ {
+		if ops[i].kind == ' ' {
+			continue
+		}
+		start := max(0, i-unifiedContext)
+		end := min(len(ops), i+1+unifiedContext)
+		for j := i + 1; j < len(ops) && j <= end+unifiedContext; j++ {
+			if ops[j].kind != ' ' {
+				end = min(len(ops), j+1+unifiedContext)
+			}
+		}
+
+		hunk := ops[start:end]
+		oldCount, newCount := 0, 0
+		for _, op := range hunk {
+			if op.kind != '+' {
+				oldCount++
+			}
+			if op.kind != '-' {
+				newCount++
+			}
+		}
+		fmt.Fprintf(&b, "@@ -%s +%s @@\n", hunkRange(oldAt[start], oldCount), hunkRange(newAt[start], newCount))
+		for _, op := range hunk {
+			b.WriteByte(op.kind)
+			b.WriteString(op.text)
+			b.WriteByte('\n')
+		}
+		i = end - 1
+	}
+	return b.String()
+}
+
+func hunkRange(start, count int) string {
+	if count == 0 {
+		return fmt.Sprintf("%d,0", start-1)
+	}
+	if count == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, count)
+}
+
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+	return strings.Split(strings.TrimSuffix(text, "\n"), "\n")
+}
+
+// diffLines computes a shortest edit script with Myers' algorithm
+func diffLines(a, b []string) []lineOp {
+	n, m := len(a), len(b)
+	maxEdits := n + m
+	offset := maxEdits + 1
+	v := make([]int, 2*maxEdits+2)
+	var trace [][]int
+
+	for d := 0; d <= maxEdits; d++ {
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				return backtrack(trace, a, b, offset, d)
+			}
+		}
+	}
+	return nil
+}
+
+func backtrack(trace [][]int, a, b []string, offset, d int) []lineOp {
+	var ops []lineOp
+	x, y := len(a), len(b)
+	for ; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+		for x > prevX && y > prevY {
+			x--
+			y--
+			ops = append(ops, lineOp{' ', a[x]})
+		}
+		if x == prevX {
+			y--
+			ops = append(ops, lineOp{'+', b[y]})
+		} else {
+			x--
+			ops = append(ops, lineOp{'-', a[x]})
+		}
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		ops = append(ops, lineOp{' ', a[x]})
+	}
+
+	for i, j := 0, len(ops)-1; i < j; i, j = i+1, j-1 {
+		ops[i], ops[j] = ops[j], ops[i]
+	}
+	return ops
+}
@@ -0,0 +1,232 @@
+// Package filter decides which paths a scan or diff covers, based on
+// include and exclude glob patterns.
+package filter
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Filter matches paths relative to a tracked directory
+//
+// A pattern without a slash matches the name of a file or directory at any
+// depth ("*.tmp", "node_modules"). A pattern with a slash is matched against
+// the whole relative path, where "**" stands for any number of directories
+// ("docs/*.md", "**/build/**"). A trailing slash restricts a pattern to
+// directories.
+type Filter struct {
+	include  []pattern
+	exclude  []pattern
+	ignore   *Ignore
+	maxDepth int
+}
+
+type pattern struct {
+	raw      string
+	segments []string
+	basename bool // no slash: match the last path element only
+	dirOnly  bool
+}
+
+// New compiles include and exclude patterns
+// With no include patterns every file is included
+func New(include, exclude []string) (*Filter, error) {
+	f := &Filter{}
+	for _, raw := range include {
+		p, err := compile(raw)
+		if err != nil {
+			return nil, err
+		}
+		f.include = append(f.include, p)
+	}
+	for _, raw := range exclude {
+		p, err := compile(raw)
+		if err != nil {
+			return nil, err
+		}
+		f.exclude = append(f.exclude, p)
+	}
+	return f, nil
+}
+
+func compile(raw string) (pattern, error) {
+	p := pattern{raw: raw}
+	s := strings.TrimSpace(raw)
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimSuffix(s, "/")
+	}
+	if s == "" {
+		return p, fmt.Errorf("invalid pattern %q: empty", raw)
+	}
+	p.basename = !strings.Contains(s, "/")
+	p.segments = strings.Split(strings.TrimPrefix(s, "/"), "/")
+	for _, segment := range p.segments {
+		if _, err := path.Match(segment, ""); err != nil {
+			return p, fmt.Errorf("invalid pattern %q: %w", raw, err)
+		}
+	}
+	return p, nil
+}
+
+// WithIgnore returns a copy of f that also skips paths ignored by ig
+// f may be nil
+func (f *Filter) WithIgnore(ig *Ignore) *Filter {
+	result := f.clone()
+	result.ignore = ig
+	return result
+}
+
+// WithMaxDepth returns a copy of f that skips paths more than depth levels
+// deep (1 = direct children only, 0 = unlimited)
+// f may be nil
+func (f *Filter) WithMaxDepth(depth int) *Filter {
+	result := f.clone()
+	result.maxDepth = depth
+	return result
+}
+
+func (f *Filter) clone() *Filter {
+	if f == nil {
+		return &Filter{}
+	}
+	result := *f
+	return &result
+}
+
+// Skip reports whether a scan should leave out relPath
+// Excluded directories are skipped together with everything below them.
+// Include patterns only apply to files so the walk still descends into directories.
+func (f *Filter) Skip(relPath string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	segments := split(relPath)
+	if len(segments) == 0 {
+		return false
+	}
+	if f.maxDepth > 0 && len(segments) > f.maxDepth {
+		return true
+	}
+
+	// An excluded ancestor excludes the whole subtree
+	for i := 1; i <= len(segments); i++ {
+		if matchAny(f.exclude, segments[:i], isDir || i < len(segments)) {
+			return true
+		}
+	}
+	if f.ignore.Ignored(relPath, isDir) {
+		return true
+	}
+
+	if !isDir && len(f.include) > 0 {
+		return !matchAny(f.include, segments, false)
+	}
+	return false
+}
+
+// SkipChange reports whether a change to relPath should be left out of diff results
+// When include patterns are set, directory changes are not reported since
+// only the matching files are of interest
+func (f *Filter) SkipChange(relPath string, isDir bool) bool {
+	if f == nil {
+		return false
+	}
+	if isDir && len(f.include) > 0 {
+		return true
+	}
+	return f.Skip(relPath, isDir)
+}
+
+// Matches reports whether relPath matches one of the include patterns
+// Unlike Skip it ignores exclusions and parent directories; with no include
+// patterns everything matches.
+func (f *Filter) Matches(relPath string, isDir bool) bool {
+	if f == nil || len(f.include) == 0 {
+		return true
+	}
+	segments := split(relPath)
+	if len(segments) == 0 {
+		return false
+	}
+	return matchAny(f.include, segments, isDir)
+}
+
+// Fingerprint identifies the filter settings, so a caller can tell when
+// previously stored scan results were produced with different ones
+func (f *Filter) Fingerprint() string {
+	if f == nil {
+		f = &Filter{}
+	}
+	h := sha256.New()
+	for _, p := range f.include {
+		fmt.Fprintf(h, "include %q\n", p.raw)
+	}
+	for _, p := range f.exclude {
+		fmt.Fprintf(h, "exclude %q\n", p.raw)
+	}
+	if f.ignore != nil {
+		for _, r := range f.ignore.rules {
+			fmt.Fprintf(h, "ignore %v %q\n", r.negate, r.raw)
+		}
+	}
+	fmt.Fprintf(h, "max-depth %d\n", f.maxDepth)
+	return hex.EncodeToString(h.Sum(nil)[:8])
+}
+
+func matchAny(patterns []pattern, segments []string, isDir bool) bool {
+	for _, p := range patterns {
+		if p.matches(segments, isDir) {
+			return true
+		}
+	}
+	return false
+}
+
+func (p pattern) matches(segments []string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if p.basename {
+		ok, _ := path.Match(p.segments[0], segments[len(segments)-1])
+		return ok
+	}
+	return matchSegments(p.segments, segments)
+}
+
+// matchSegments matches path segments against pattern segments, "**" matching zero or more
+func matchSegments(pat, name []string) bool {
+	for len(pat) > 0 {
+		if pat[0] == "**" {
+			pat = pat[1:]
+			if len(pat) == 0 {
+				return true
+			}
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(pat, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pat[0], name[0]); !ok {
+			return false
+		}
+		pat, name = pat[1:], name[1:]
+	}
+	return len(name) == 0
+}
+
+func split(relPath string) []string {
+	relPath = strings.Trim(relPath, "/")
+	if relPath == "" {
+		return nil
+	}
+	return strings.Split(relPath, "/")
+}
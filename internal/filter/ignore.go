@@ -0,0 +1,91 @@
+package filter
+
+import (
+	"bufio"
+	"io"
+	"strings"
+)
+
+// IgnoreFileName is the per-directory ignore file looked up at the root of each tracked directory
+const IgnoreFileName = ".ncignore"
+
+// Ignore is a list of gitignore-style rules
+//
+// Rules are evaluated in order and the last matching rule wins, so a later
+// "!pattern" re-includes what an earlier pattern ignored. As with git, a file
+// cannot be re-included once one of its parent directories is ignored.
+type Ignore struct {
+	rules []rule
+}
+
+type rule struct {
+	pattern
+	negate bool
+}
+
+// ParseIgnore reads rules in gitignore syntax: one pattern per line, blank
+// lines and "#" comments skipped, "!" negates, "\#" and "\!" escape
+func ParseIgnore(r io.Reader) (*Ignore, error) {
+	ig := &Ignore{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if strings.TrimSpace(line) == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimRight(line, " ")
+
+		negate := false
+		if strings.HasPrefix(line, "!") {
+			negate = true
+			line = line[1:]
+		} else if strings.HasPrefix(line, "\\!") || strings.HasPrefix(line, "\\#") {
+			line = line[1:]
+		}
+
+		p, err := compile(line)
+		if err != nil {
+			return nil, err
+		}
+		ig.rules = append(ig.rules, rule{pattern: p, negate: negate})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return ig, nil
+}
+
+// Ignored reports whether relPath, or one of its parent directories, is ignored
+func (ig *Ignore) Ignored(relPath string, isDir bool) bool {
+	if ig == nil || len(ig.rules) == 0 {
+		return false
+	}
+	segments := split(relPath)
+	for i := 1; i <= len(segments); i++ {
+		if ig.match(segments[:i], isDir || i < len(segments)) {
+			return true
+		}
+	}
+	return false
+}
+
+func (ig *Ignore) match(segments []string, isDir bool) bool {
+	ignored := false
+	for _, r := range ig.rules {
+		if r.matches(segments, isDir) {
+			ignored = !r.negate
+		}
+	}
+	return ignored
+}
+
+// Combine returns the rules of all non-nil lists in order, so later lists take precedence
+func Combine(lists ...*Ignore) *Ignore {
+	combined := &Ignore{}
+	for _, ig := range lists {
+		if ig != nil {
+			combined.rules = append(combined.rules, ig.rules...)
+		}
+	}
+	return combined
+}
@@ -0,0 +1,124 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/diff"
+)
+
+// AckRequest is the body of POST /ack
+type AckRequest struct {
+	Files  []diff.Ack `json:"files"`
+	Remove []string   `json:"remove"`
+}
+
+// AcksResponse is the body of GET /ack
+type AcksResponse struct {
+	Acks []diff.Ack `json:"acks"`
+}
+
+// AckResult is the body of POST /ack
+type AckResult struct {
+	Acknowledged int `json:"acknowledged"`
+	Removed      int `json:"removed"`
+}
+
+// Ack lists (GET), records (POST) or removes (DELETE) the file versions a consumer has processed
+func (h *Handlers) Ack(w http.ResponseWriter, r *http.Request) {
+	acks := h.detectorFor(r).Acks()
+	if acks == nil {
+		httpError(w, r, "Acknowledgements are disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		path := "/"
+		if p := r.URL.Query().Get("path"); p != "" {
+			var err error
+			if path, err = h.resolvePath(r, "path", p); err != nil {
+				pathError(w, r, err)
+				return
+			}
+		}
+		// Without a path, only the acknowledgements under the allowed roots
+		allowed := []diff.Ack{}
+		for _, ack := range acks.List(path) {
+			if h.allowPath(r, ack.Path) == nil {
+				allowed = append(allowed, ack)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AcksResponse{
+			Acks: allowed,
+		})
+
+	case http.MethodPost:
+		var req AckRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+		for i, ack := range req.Files {
+			if ack.Path == "" || ack.ETag == "" {
+				httpError(w, r, "every acknowledged file needs a 'path' and an 'etag'", http.StatusBadRequest)
+				return
+			}
+			var err error
+			if req.Files[i].Path, err = h.resolvePath(r, fmt.Sprintf("files[%d].path", i), ack.Path); err != nil {
+				pathError(w, r, err)
+				return
+			}
+		}
+		for i, p := range req.Remove {
+			var err error
+			if req.Remove[i], err = h.resolvePath(r, fmt.Sprintf("remove[%d]", i), p); err != nil {
+				pathError(w, r, err)
+				return
+			}
+		}
+
+		if len(req.Files) > 0 {
+			if err := acks.Acknowledge(req.Files); err != nil {
+				logger(r).Error("Failed to save acknowledgements", "error", err)
+				httpError(w, r, fmt.Sprintf("Failed to save acknowledgements: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		if len(req.Remove) > 0 {
+			if err := acks.Remove(req.Remove); err != nil {
+				logger(r).Error("Failed to remove acknowledgements", "error", err)
+				httpError(w, r, fmt.Sprintf("Failed to remove acknowledgements: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(AckResult{
+			Acknowledged: len(req.Files),
+			Removed:      len(req.Remove),
+		})
+
+	case http.MethodDelete:
+		path, err := h.pathParam(r)
+		if err != nil {
+			pathError(w, r, err)
+			return
+		}
+		if err := acks.Remove([]string{path}); err != nil {
+			logger(r).Error("Failed to remove acknowledgement", "path", path, "error", err)
+			httpError(w, r, fmt.Sprintf("Failed to remove acknowledgement: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatusResponse{
+			Status: "removed",
+			Path:   path,
+		})
+
+	}
+}
@@ -0,0 +1,187 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"slices"
+	"strings"
+	"sync"
+
+	"go-nc-client/internal/config"
+)
+
+// configFields are the fields PUT /config may set; the others, secrets
+// included, only change by editing the file and restarting
+var configFields = []string{"directories", "schedule", "include", "exclude"}
+
+// ConfigUpdate is the body of PUT /config; fields left out are kept
+type ConfigUpdate struct {
+	Directories *map[string]config.DirectoryConfig `json:"directories,omitempty"`
+	Schedule    *string                            `json:"schedule,omitempty"`
+	Include     *[]string                          `json:"include,omitempty"`
+	Exclude     *[]string                          `json:"exclude,omitempty"`
+}
+
+// PrepareFunc checks a configuration and returns the function that puts it
+// into effect, which cannot fail
+type PrepareFunc func(cfg *config.Config) (apply func(), err error)
+
+// errInvalidConfig marks updates rejected by the PrepareFunc
+var errInvalidConfig = errors.New("invalid config")
+
+// ConfigManager holds the running configuration for /config: updates are
+// checked and prepared by prepare, saved to path, then applied
+type ConfigManager struct {
+	path    string
+	prepare PrepareFunc
+
+	mu  sync.Mutex
+	cfg *config.Config
+}
+
+// NewConfigManager serves cfg, loaded from path
+func NewConfigManager(path string, cfg *config.Config, prepare PrepareFunc) *ConfigManager {
+	return &ConfigManager{path: path, prepare: prepare, cfg: cfg}
+}
+
+// Current returns the running configuration without its secrets
+func (m *ConfigManager) Current() config.Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return config.Redact(m.cfg)
+}
+
+// AllowedRoots returns the directories API paths of an account ("" = the
+// top-level one) must lie under, nil when any path is allowed (no manager,
+// or restrict_to_directories unset)
+// The roots follow directories as PUT /config changes them.
+func (m *ConfigManager) AllowedRoots(account string) []string {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if !m.cfg.RestrictToDirectories {
+		return nil
+	}
+	directories := m.cfg.Directories
+	if account != "" {
+		i := slices.IndexFunc(m.cfg.Accounts, func(acc config.AccountConfig) bool { return acc.Name == account })
+		if i < 0 {
+			return []string{}
+		}
+		directories = m.cfg.Accounts[i].Directories
+	}
+	roots := make([]string, 0, len(directories))
+	for dir := range directories {
+		roots = append(roots, path.Clean("/"+dir))
+	}
+	return roots
+}
+
+// AllowedOrigins returns the CORS origins, whose pages may also open /ws
+func (m *ConfigManager) AllowedOrigins() []string {
+	if m == nil {
+		return nil
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.cfg.CORS == nil {
+		return nil
+	}
+	return m.cfg.CORS.AllowedOrigins
+}
+
+// Update puts the fields set in update into effect and saves them, returning
+// the new configuration without its secrets
+// Nothing changes when the new configuration is invalid or can't be saved.
+func (m *ConfigManager) Update(update ConfigUpdate) (config.Config, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	next := *m.cfg
+	if update.Directories != nil {
+		next.Directories = *update.Directories
+	}
+	if update.Schedule != nil {
+		next.Schedule = *update.Schedule
+	}
+	if update.Include != nil {
+		next.Include = *update.Include
+	}
+	if update.Exclude != nil {
+		next.Exclude = *update.Exclude
+	}
+
+	apply, err := m.prepare(&next)
+	if err != nil {
+		return config.Config{}, fmt.Errorf("%w: %v", errInvalidConfig, err)
+	}
+	if err := config.Save(&next, m.path); err != nil {
+		return config.Config{}, fmt.Errorf("failed to save %s: %w", m.path, err)
+	}
+	apply()
+	m.cfg = &next
+	return config.Redact(m.cfg), nil
+}
+
+// Config returns the running configuration, or updates its directories,
+// schedule and filters
+func (h *Handlers) Config(w http.ResponseWriter, r *http.Request) {
+	if h.configs == nil {
+		httpError(w, r, "Config management is disabled", http.StatusNotFound)
+		return
+	}
+	if r.Method == http.MethodGet || r.Method == http.MethodHead {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(h.configs.Current())
+		return
+	}
+
+	update, err := parseConfigUpdate(r.Body)
+	if err != nil {
+		invalidBody(w, r, err, err.Error())
+		return
+	}
+	cfg, err := h.configs.Update(update)
+	if err != nil {
+		status := http.StatusInternalServerError
+		if errors.Is(err, errInvalidConfig) {
+			status = http.StatusBadRequest
+		}
+		logger(r).Error("Failed to update config", "error", err)
+		httpError(w, r, fmt.Sprintf("Failed to update config: %v", err), status)
+		return
+	}
+	logger(r).Info("Config updated", "audit", true)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(cfg)
+}
+
+// parseConfigUpdate decodes the body of PUT /config, refusing fields it
+// can't change
+func parseConfigUpdate(body io.Reader) (ConfigUpdate, error) {
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return ConfigUpdate{}, fmt.Errorf("invalid request body: %w", err)
+	}
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return ConfigUpdate{}, fmt.Errorf("invalid request body: %v", err)
+	}
+	for field := range fields {
+		if !slices.Contains(configFields, field) {
+			return ConfigUpdate{}, fmt.Errorf("%q cannot be changed through the API (only %s)", field, strings.Join(configFields, ", "))
+		}
+	}
+	var update ConfigUpdate
+	if err := json.Unmarshal(data, &update); err != nil {
+		return ConfigUpdate{}, fmt.Errorf("invalid request body: %v", err)
+	}
+	return update, nil
+}
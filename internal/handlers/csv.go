@@ -0,0 +1,51 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+// Values of the format query parameter of /diff and /history
+const (
+	formatJSON = "json"
+	formatCSV  = "csv"
+)
+
+// csvHeader names the columns of a CSV export of changes
+var csvHeader = []string{"type", "path", "old_path", "is_dir", "size", "modified"}
+
+// parseFormat returns the format query parameter, json by default
+func parseFormat(r *http.Request) (string, error) {
+	switch format := r.URL.Query().Get("format"); format {
+	case "", formatJSON:
+		return formatJSON, nil
+	case formatCSV:
+		return formatCSV, nil
+	default:
+		return "", fmt.Errorf("invalid format %q (expected json or csv)", format)
+	}
+}
+
+// writeChangesCSV sends changes as a CSV attachment named filename, a row per change
+func writeChangesCSV(w http.ResponseWriter, status int, filename string, changes []diff.Change) error {
+	w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	w.WriteHeader(status)
+
+	out := csv.NewWriter(w)
+	out.Write(csvHeader)
+	for _, c := range changes {
+		modified := ""
+		if !c.Modified.IsZero() {
+			modified = c.Modified.UTC().Format(time.RFC3339)
+		}
+		out.Write([]string{c.Type, c.Path, c.OldPath, strconv.FormatBool(c.IsDir), strconv.FormatInt(c.Size, 10), modified})
+	}
+	out.Flush()
+	return out.Error()
+}
@@ -0,0 +1,446 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"path"
+	"strings"
+
+	"go-nc-client/internal/webdav"
+)
+
+// Stat returns the details of one file or directory, so clients don't have
+// to list its parent to check it
+func (h *Handlers) Stat(w http.ResponseWriter, r *http.Request) {
+	filePath, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+
+	details, err := h.clientFor(r).StatDetails(filePath)
+	if err != nil {
+		logger(r).Error("Failed to stat file", "path", filePath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to stat file: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(details)
+}
+
+// Download streams a file from Nextcloud, passing Range requests through, so
+// consumers of /diff can fetch content without credentials of their own
+func (h *Handlers) Download(w http.ResponseWriter, r *http.Request) {
+	filePath, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+
+	file, err := h.clientFor(r).Open(filePath, webdav.DownloadOptions{
+		Range:   r.Header.Get("Range"),
+		IfRange: r.Header.Get("If-Range"),
+	})
+	if err != nil {
+		logger(r).Error("Failed to download file", "path", filePath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to download file: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer file.Close()
+
+	if file.ContentType == "" {
+		file.ContentType = mime.TypeByExtension(path.Ext(filePath))
+		if file.ContentType == "" {
+			file.ContentType = "application/octet-stream"
+		}
+	}
+	file.SetHeaders(w.Header())
+	w.Header().Set("Accept-Ranges", "bytes")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": path.Base(filePath)}))
+
+	// The ETag is only known once the download started; matching ones end it there
+	if match := r.Header.Get("If-None-Match"); match != "" && file.ETag != "" && etagMatches(match, file.ETag) {
+		w.Header().Del("Content-Length")
+		w.Header().Del("Content-Range")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	status := http.StatusOK
+	if file.ContentRange != "" {
+		status = http.StatusPartialContent
+	}
+	w.WriteHeader(status)
+	// HEAD only wants the headers, the file isn't read any further
+	if r.Method == http.MethodHead {
+		return
+	}
+	if _, err := io.Copy(webdav.NewFlushWriter(w), file); err != nil {
+		logger(r).Error("Failed to stream file", "path", filePath, "error", err)
+	}
+}
+
+// DeleteFile removes a file or directory from Nextcloud
+// Directories need recursive=true, so a wrong path can't wipe a tree by
+// accident. What is deleted goes to the trashbin unless permanent=true.
+func (h *Handlers) DeleteFile(w http.ResponseWriter, r *http.Request) {
+	client := h.clientFor(r)
+	query := r.URL.Query()
+	filePath, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+	if path.Clean("/"+filePath) == "/" {
+		httpError(w, r, "refusing to delete the root directory", http.StatusBadRequest)
+		return
+	}
+
+	info, err := client.Stat(filePath)
+	if err != nil {
+		logger(r).Error("Failed to stat file", "path", filePath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to delete file: %v", err), http.StatusBadGateway)
+		return
+	}
+	if info.IsDir && query.Get("recursive") != "true" {
+		httpError(w, r, fmt.Sprintf("%s is a directory, pass recursive=true to delete it with its contents", filePath), http.StatusConflict)
+		return
+	}
+
+	if err := client.Delete(filePath); err != nil {
+		logger(r).Error("Failed to delete file", "path", filePath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to delete file: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	status := "deleted"
+	if caps, err := client.Capabilities(false); err == nil && caps.Trashbin() {
+		status = "trashed"
+		if query.Get("permanent") == "true" {
+			if err := purgeDeleted(client, filePath); err != nil {
+				logger(r).Error("Failed to purge deleted file from the trashbin", "path", filePath, "error", err)
+				failWith(w, r, err, fmt.Sprintf("Deleted, but failed to purge it from the trashbin: %v", err), http.StatusBadGateway)
+				return
+			}
+			status = "deleted"
+		}
+	}
+	logger(r).Info("File deleted", "path", filePath, "is_dir", info.IsDir, "status", status)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusResponse{
+		Status: status,
+		Path:   filePath,
+	})
+}
+
+// purgeDeleted permanently deletes the latest trashbin item deleted from filePath
+func purgeDeleted(client *webdav.Client, filePath string) error {
+	items, err := client.ListTrash()
+	if err != nil {
+		return err
+	}
+	location := strings.Trim(filePath, "/")
+	var latest *webdav.TrashItem
+	for i, item := range items {
+		if strings.Trim(item.OriginalLocation, "/") == location && (latest == nil || item.DeletionTime.After(latest.DeletionTime)) {
+			latest = &items[i]
+		}
+	}
+	if latest == nil {
+		return fmt.Errorf("%w: no trashbin item from %s", webdav.ErrNotFound, filePath)
+	}
+	return client.PurgeTrash(latest.Name)
+}
+
+// Mkdir creates a directory in Nextcloud, with its missing parents when
+// parents=true, which also accepts a directory that already exists
+func (h *Handlers) Mkdir(w http.ResponseWriter, r *http.Request) {
+	client := h.clientFor(r)
+	dirPath, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+	if path.Clean("/"+dirPath) == "/" {
+		httpError(w, r, "the root directory always exists", http.StatusBadRequest)
+		return
+	}
+
+	created := true
+	if r.URL.Query().Get("parents") == "true" {
+		created, err = client.MkdirAll(dirPath)
+	} else {
+		err = client.Mkdir(dirPath)
+	}
+	if err != nil {
+		logger(r).Error("Failed to create directory", "path", dirPath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to create directory: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := StatusResponse{Status: "exists", Path: dirPath}
+	w.Header().Set("Content-Type", "application/json")
+	if created {
+		logger(r).Info("Directory created", "path", dirPath)
+		response.Status = "created"
+		w.WriteHeader(http.StatusCreated)
+	}
+	json.NewEncoder(w).Encode(response)
+}
+
+// MoveRequest is the body of POST /move
+type MoveRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Overwrite replaces what is at To instead of failing with 409
+	Overwrite bool `json:"overwrite"`
+}
+
+// MoveResponse is the body of POST /move
+type MoveResponse struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// StateEntries counts the entries of the diff state moved along
+	StateEntries int `json:"state_entries"`
+	// StateError is set when the move succeeded but the state could not
+	// follow, so the next diff reports it
+	StateError string `json:"state_error,omitempty"`
+}
+
+// Move moves or renames a file or directory in Nextcloud, then moves its
+// entries in the diff state, so the next diff doesn't report the move
+func (h *Handlers) Move(w http.ResponseWriter, r *http.Request) {
+	var req MoveRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err := checkRelocation(req.From, req.To); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := h.resolveRelocation(r, req.From, req.To)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+	req.From, req.To = from, to
+
+	if err := h.clientFor(r).Move(req.From, req.To, req.Overwrite); err != nil {
+		logger(r).Error("Failed to move file", "from", req.From, "to", req.To, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to move file: %v", err), http.StatusBadGateway)
+		return
+	}
+
+	response := MoveResponse{From: req.From, To: req.To}
+	moved, err := h.detectorFor(r).MoveState(req.From, req.To)
+	if err != nil {
+		logger(r).Error("Moved file, but failed to move its state", "from", req.From, "to", req.To, "error", err)
+		response.StateError = err.Error()
+	}
+	response.StateEntries = moved
+	logger(r).Info("File moved", "from", req.From, "to", req.To, "state_entries", moved)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// CopyRequest is the body of POST /copy
+type CopyRequest struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Overwrite replaces what is at To instead of failing with 409
+	Overwrite bool `json:"overwrite"`
+}
+
+// CopyResponse is the body of POST /copy
+type CopyResponse struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// Copy copies a file, or a directory with its contents, in Nextcloud
+// Unlike moves, copies are new files on the server and the next diff reports
+// them as created.
+func (h *Handlers) Copy(w http.ResponseWriter, r *http.Request) {
+	var req CopyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+	if err := checkRelocation(req.From, req.To); err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	from, to, err := h.resolveRelocation(r, req.From, req.To)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+	req.From, req.To = from, to
+
+	if err := h.clientFor(r).Copy(req.From, req.To, req.Overwrite); err != nil {
+		logger(r).Error("Failed to copy file", "from", req.From, "to", req.To, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to copy file: %v", err), http.StatusBadGateway)
+		return
+	}
+	logger(r).Info("File copied", "from", req.From, "to", req.To)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(CopyResponse{From: req.From, To: req.To})
+}
+
+// checkRelocation validates the paths of a move or copy
+func checkRelocation(from, to string) error {
+	if from == "" || to == "" {
+		return errors.New("'from' and 'to' are required")
+	}
+	from, to = path.Clean("/"+from), path.Clean("/"+to)
+	switch {
+	case from == "/" || to == "/":
+		return errors.New("the root directory can't be moved, copied or replaced")
+	case from == to:
+		return errors.New("'from' and 'to' are the same path")
+	case strings.HasPrefix(to, from+"/"):
+		return errors.New("'to' lies inside 'from'")
+	}
+	return nil
+}
+
+// resolveRelocation returns the clean paths of a move or copy, see resolvePath
+func (h *Handlers) resolveRelocation(r *http.Request, from, to string) (string, string, error) {
+	from, err := h.resolvePath(r, "from", from)
+	if err != nil {
+		return "", "", err
+	}
+	to, err = h.resolvePath(r, "to", to)
+	if err != nil {
+		return "", "", err
+	}
+	return from, to, nil
+}
+
+// UploadResponse is the body of PUT and POST /upload
+type UploadResponse struct {
+	Path string `json:"path"`
+	ETag string `json:"etag"`
+	Size int64  `json:"size"`
+}
+
+// Upload stores the request body, or the file of a multipart form, at path
+// It streams to Nextcloud, in chunks for big files, and honours If-Match and
+// If-None-Match: * so scripts don't clobber concurrent changes.
+func (h *Handlers) Upload(w http.ResponseWriter, r *http.Request) {
+	filePath, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+
+	var body io.Reader = r.Body
+	size := r.ContentLength
+	contentType := r.Header.Get("Content-Type")
+	if mediaType, _, _ := mime.ParseMediaType(contentType); mediaType == "multipart/form-data" {
+		part, err := formFile(r)
+		if err != nil {
+			writeInvalid(w, r, fmt.Errorf("invalid multipart form: %w", err))
+			return
+		}
+		defer part.Close()
+		body, size, contentType = part, -1, part.Header.Get("Content-Type")
+		// A path ending with a slash is the directory the file goes to
+		if strings.HasSuffix(filePath, "/") {
+			filePath += path.Base(part.FileName())
+		}
+	}
+	if strings.HasSuffix(filePath, "/") {
+		writeInvalid(w, r, fieldError("path", "must name a file"))
+		return
+	}
+	if filePath, err = h.resolvePath(r, "path", filePath); err != nil {
+		pathError(w, r, err)
+		return
+	}
+
+	counter := &countingReader{r: body}
+	etag, err := h.clientFor(r).Upload(filePath, counter, webdav.UploadOptions{
+		IfMatch:     r.Header.Get("If-Match"),
+		IfNoneMatch: r.Header.Get("If-None-Match") == "*",
+		Size:        size,
+		ContentType: contentType,
+	})
+	if err != nil {
+		var precondition *webdav.PreconditionFailedError
+		if errors.As(err, &precondition) {
+			httpError(w, r, err.Error(), http.StatusPreconditionFailed)
+			return
+		}
+		if tooLarge(err) {
+			logger(r).Warn("Upload over the body limit", "path", filePath, "error", err)
+			invalidBody(w, r, err, "")
+			return
+		}
+		logger(r).Error("Failed to upload file", "path", filePath, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to upload file: %v", err), http.StatusBadGateway)
+		return
+	}
+	logger(r).Info("File uploaded", "path", filePath, "size", counter.n)
+
+	w.Header().Set("Content-Type", "application/json")
+	if etag != "" {
+		w.Header().Set("ETag", `"`+etag+`"`)
+	}
+	json.NewEncoder(w).Encode(UploadResponse{Path: filePath, ETag: etag, Size: counter.n})
+}
+
+// formFile returns the "file" field of a multipart form, or its first file
+func formFile(r *http.Request) (*multipart.Part, error) {
+	reader, err := r.MultipartReader()
+	if err != nil {
+		return nil, err
+	}
+	for {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			return nil, errors.New("no file in the form")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if part.FormName() == "file" || part.FileName() != "" {
+			return part, nil
+		}
+		part.Close()
+	}
+}
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == "*" || strings.Trim(candidate, "\"") == etag {
+			return true
+		}
+	}
+	return false
+}
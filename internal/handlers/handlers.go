@@ -1,69 +1,358 @@
 package handlers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
 	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
 	"time"
 
 	"go-nc-client/internal/diff"
+	"go-nc-client/internal/middleware"
+	"go-nc-client/internal/schedule"
+	"go-nc-client/internal/stream"
+	"go-nc-client/internal/tenant"
+	"go-nc-client/internal/version"
 	"go-nc-client/internal/webdav"
+	"go-nc-client/internal/webhook"
+)
+
+const (
+	// maxLongPoll bounds how long a ?wait=<duration> diff holds the request
+	maxLongPoll = 5 * time.Minute
+	// longPollInterval is how often a long-polling diff rescans
+	longPollInterval = 10 * time.Second
 )
 
 type Handlers struct {
-	detector *diff.Detector
-	client   *webdav.Client
+	detector  *diff.Detector
+	client    *webdav.Client
+	stream    *stream.Hub
+	scheduler *schedule.Scheduler
+	webhooks  *webhook.Notifier
+	configs   *ConfigManager
+	tenants   *tenant.Pool
+	// accounts are the accounts of config.json, by name
+	accounts map[string]*tenant.Tenant
 }
 
-func NewHandlers(detector *diff.Detector, client *webdav.Client) *Handlers {
+func NewHandlers(detector *diff.Detector, client *webdav.Client, hub *stream.Hub, scheduler *schedule.Scheduler, webhooks *webhook.Notifier, configs *ConfigManager, tenants *tenant.Pool, accounts map[string]*tenant.Tenant) *Handlers {
 	return &Handlers{
-		detector: detector,
-		client:   client,
+		detector:  detector,
+		client:    client,
+		stream:    hub,
+		scheduler: scheduler,
+		webhooks:  webhooks,
+		configs:   configs,
+		tenants:   tenants,
+		accounts:  accounts,
 	}
 }
 
+// HealthResponse is the body of GET /health
+type HealthResponse struct {
+	// Status is "ok", or "degraded" while the circuit breaker is open
+	Status         string              `json:"status"`
+	CircuitBreaker webdav.BreakerState `json:"circuit_breaker"`
+}
+
+// Values of the status of GET /readyz and of its checks
+const (
+	statusOK       = "ok"
+	statusDegraded = "degraded"
+	statusFailing  = "failing"
+)
+
+// ReadyResponse is the body of GET /readyz
+type ReadyResponse struct {
+	// Status is "ok", "degraded" while the circuit breaker is open, or
+	// "failing" when a check failed
+	Status         string              `json:"status"`
+	Checks         []CheckResult       `json:"checks"`
+	CircuitBreaker webdav.BreakerState `json:"circuit_breaker"`
+}
+
+// CheckResult is the outcome of one readiness check
+type CheckResult struct {
+	Name      string `json:"name"`
+	Status    string `json:"status"`
+	LatencyMS int64  `json:"latency_ms"`
+	Error     string `json:"error,omitempty"`
+}
+
+// MetricsResponse is the body of GET /metrics
+type MetricsResponse struct {
+	WebDAV webdav.Stats `json:"webdav"`
+}
+
+// ListResponse is the body of GET /ls
+type ListResponse struct {
+	Path          string            `json:"path"`
+	Files         []webdav.FileInfo `json:"files"`
+	IncludeHidden bool              `json:"include_hidden"`
+	// NextPageToken continues a recursive listing; empty on its last page
+	NextPageToken string `json:"next_page_token,omitempty"`
+}
+
+// RunInProgressResponse is the body of the 409 of POST /diff when another diff is running
+type RunInProgressResponse struct {
+	middleware.ErrorResponse
+	Run *diff.RunInfo `json:"run"`
+}
+
+// StatusResponse confirms an action on a named item or a path
+type StatusResponse struct {
+	Status string `json:"status"`
+	Name   string `json:"name,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
 func (h *Handlers) Health(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	// Report degraded (not failing) while the WebDAV backend is unreachable,
+	// the process itself is still healthy
+	status := "ok"
+	circuit := h.client.CircuitState()
+	if circuit.State != "closed" {
+		status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HealthResponse{
+		Status:         status,
+		CircuitBreaker: circuit,
+	})
+}
+
+// Healthz reports that the process is alive, without checking anything else
+func (h *Handlers) Healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusResponse{Status: statusOK})
+}
+
+// Readyz checks that the WebDAV server answers and accepts the credentials,
+// and that the state can be saved; 503 when a check fails
+// An open circuit breaker only makes it degraded: every replica shares the
+// server, so taking this one out of rotation would not help.
+func (h *Handlers) Readyz(w http.ResponseWriter, r *http.Request) {
+	checks := []CheckResult{
+		runCheck("webdav", func() error {
+			_, err := h.clientFor(r).Stat("/")
+			return err
+		}),
+		runCheck("state", h.detectorFor(r).CheckState),
+	}
+
+	resp := ReadyResponse{Status: statusOK, Checks: checks, CircuitBreaker: h.clientFor(r).CircuitState()}
+	for _, check := range checks {
+		if check.Status == statusFailing {
+			resp.Status = statusFailing
+		} else if check.Status == statusDegraded && resp.Status == statusOK {
+			resp.Status = statusDegraded
+		}
+		if check.Error != "" {
+			logger(r).Warn("Readiness check failed", "check", check.Name, "error", check.Error)
+		}
+	}
+
+	status := http.StatusOK
+	if resp.Status == statusFailing {
+		status = http.StatusServiceUnavailable
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// runCheck times check; it is degraded when it failed on the open circuit breaker
+func runCheck(name string, check func() error) CheckResult {
+	start := time.Now()
+	err := check()
+	result := CheckResult{Name: name, Status: statusOK, LatencyMS: time.Since(start).Milliseconds()}
+	switch {
+	case errors.Is(err, webdav.ErrCircuitOpen):
+		result.Status = statusDegraded
+		result.Error = err.Error()
+	case err != nil:
+		result.Status = statusFailing
+		result.Error = err.Error()
+	}
+	return result
+}
+
+// Version identifies the running build
+func (h *Handlers) Version(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version.Get())
+}
+
+// Metrics reports WebDAV client request statistics
+func (h *Handlers) Metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(MetricsResponse{
+		WebDAV: h.client.Stats(),
+	})
+}
+
+// Capabilities returns the cached server capabilities, refetched with ?refresh=true
+func (h *Handlers) Capabilities(w http.ResponseWriter, r *http.Request) {
+	caps, err := h.clientFor(r).Capabilities(r.URL.Query().Get("refresh") == "true")
+	if err != nil {
+		logger(r).Error("Failed to fetch capabilities", "error", err)
+		httpError(w, r, fmt.Sprintf("Failed to fetch capabilities: %v", err), http.StatusBadGateway)
 		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	json.NewEncoder(w).Encode(caps)
 }
 
 type DiffRequest struct {
 	IncludeHidden bool     `json:"include-hidden"`
+	FavoritesOnly bool     `json:"favorites-only"`
+	MaxDepth      int      `json:"max-depth"`
 	Paths         []string `json:"paths"`
+	// Since (RFC 3339) reports changes since that time instead of since the last run
+	Since string `json:"since"`
+	// DryRun reports changes without saving the new state
+	DryRun bool `json:"dry-run"`
+	// From and To compare two snapshots, or From against the live state when To is empty
+	From string `json:"from"`
+	To   string `json:"to"`
+	// Wait queues behind a diff already running instead of returning 409
+	Wait bool `json:"wait"`
+	// LongPoll rescans until changes are found or it elapses, from ?wait=60s
+	LongPoll time.Duration `json:"-"`
+	// Profile selects a named state, e.g. one per downstream consumer
+	Profile string `json:"profile"`
+	// CollapseDeletes reports a deleted directory as a single change
+	CollapseDeletes bool `json:"collapse-deletes"`
+	// Cursor returns the results of an earlier run again instead of diffing
+	Cursor int64 `json:"cursor"`
+	// Async runs the diff as a background job, see /jobs/{id}
+	Async bool `json:"async"`
+	// Limit returns the changes a page at a time; the next pages replay the
+	// run's cursor from Offset, or read it back from the journal when cursors
+	// are disabled, so they stay the same while other runs happen
+	Limit  int `json:"limit"`
+	Offset int `json:"offset"`
+	// PageToken continues from the Next-Page-Token header of the previous page
+	PageToken string `json:"page-token"`
+	// journalFrom and journalUntil identify the journaled run a page-token
+	// continues, see diffPage
+	journalFrom, journalUntil time.Time
+
+	// Change filters, applied before the response is built
+	Types     []string `json:"types"`
+	Patterns  []string `json:"patterns"`
+	MinSize   int64    `json:"min-size"`
+	MaxSize   int64    `json:"max-size"`
+	FilesOnly bool     `json:"files-only"`
+	DirsOnly  bool     `json:"dirs-only"`
+
+	// Local is the metadata of the caller's local copies, keyed by path,
+	// used to flag conflicts against acknowledged versions (see /ack)
+	Local map[string]diff.LocalMeta `json:"local"`
+}
+
+// changeFilter returns the change filter of the request, nil when it sets none
+func (req *DiffRequest) changeFilter() *diff.ChangeFilter {
+	if len(req.Types) == 0 && len(req.Patterns) == 0 && req.MinSize == 0 && req.MaxSize == 0 && !req.FilesOnly && !req.DirsOnly {
+		return nil
+	}
+	return &diff.ChangeFilter{
+		Types:     req.Types,
+		Patterns:  req.Patterns,
+		MinSize:   req.MinSize,
+		MaxSize:   req.MaxSize,
+		FilesOnly: req.FilesOnly,
+		DirsOnly:  req.DirsOnly,
+	}
 }
 
 func (h *Handlers) Diff(w http.ResponseWriter, r *http.Request) {
 	startTime := time.Now()
 
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	req, err := parseDiffRequest(r)
+	if err != nil {
+		logger(r).Warn("Invalid diff request", "error", err)
+		writeInvalid(w, r, err)
 		return
 	}
-
-	req, err := parseDiffRequest(r)
+	format, err := parseFormat(r)
 	if err != nil {
-		log.Printf("Error parsing diff request: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		httpError(w, r, err.Error(), http.StatusBadRequest)
 		return
 	}
 
+	// Snapshot diffs default to every directory of the snapshot, replays don't scan
 	directories, err := h.resolveDirectories(r, req)
-	if err != nil {
-		log.Printf("Error resolving directories: %v", err)
-		http.Error(w, err.Error(), http.StatusBadRequest)
+	if err != nil && req.From == "" && req.Cursor == 0 && req.journalUntil.IsZero() {
+		logger(r).Warn("Could not resolve directories", "error", err)
+		writeInvalid(w, r, fieldError("paths", "%v", err))
 		return
 	}
+	for _, dir := range directories {
+		if err := h.allowPath(r, dir); err != nil {
+			pathError(w, r, err)
+			return
+		}
+	}
 
-	changes, err := h.detector.DetectChanges(directories, req.IncludeHidden)
+	if req.Async {
+		h.startDiffJob(w, r, req, directories)
+		return
+	}
+	if detector := h.detectorFor(r); req.Limit > 0 && !detector.CursorsEnabled() && (!detector.JournalEnabled() || req.Profile != "") {
+		httpError(w, r, "'limit' requires cursors (cursor_history must not be negative), or journal_file for diffs without a profile", http.StatusBadRequest)
+		return
+	}
+
+	detectOpts := diff.DetectOptions{
+		IncludeHidden:   req.IncludeHidden,
+		FavoritesOnly:   req.FavoritesOnly,
+		Progress:        logProgress(logger(r), 5*time.Second),
+		MaxDepth:        req.MaxDepth,
+		DryRun:          req.DryRun,
+		Wait:            req.Wait,
+		ChangeFilter:    req.changeFilter(),
+		Local:           req.Local,
+		Profile:         req.Profile,
+		CollapseDeletes: req.CollapseDeletes,
+		RequestID:       middleware.RequestIDFrom(r.Context()),
+	}
+	runCtx, cancel := runContext(r)
+	defer cancel()
+	detectOpts.Context = runCtx
+	changes, err := runDiff(r.Context(), h.detectorFor(r), req, directories, detectOpts)
+	if errors.Is(err, diff.ErrCancelled) {
+		logger(r).Warn("Diff cancelled, the client went away", "error", err)
+		return
+	}
+	var inProgress *diff.RunInProgressError
+	if errors.As(err, &inProgress) {
+		logger(r).Warn("Rejecting diff", "error", err)
+		middleware.WriteErrorResponse(w, http.StatusConflict, RunInProgressResponse{
+			ErrorResponse: middleware.NewErrorResponse(r, "DIFF_IN_PROGRESS", err.Error()),
+			Run:           inProgress.Run,
+		})
+		return
+	}
+	if errors.Is(err, webdav.ErrNotFound) {
+		logger(r).Warn("Directory to diff not found", "error", err)
+		middleware.WriteError(w, r, http.StatusNotFound, "DIR_NOT_FOUND", fmt.Sprintf("Failed to detect changes: %v", err))
+		return
+	}
 	if err != nil {
-		log.Printf("Error detecting changes: %v", err)
-		http.Error(w, fmt.Sprintf("Failed to detect changes: %v", err), http.StatusInternalServerError)
+		logger(r).Error("Failed to detect changes", "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to detect changes: %v", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -72,50 +361,478 @@ func (h *Handlers) Diff(w http.ResponseWriter, r *http.Request) {
 		totalChanges += len(change.Changes)
 	}
 
-	log.Printf("Diff completed: %d dirs, %d changes in %v", len(changes), totalChanges, time.Since(startTime))
+	logger(r).Info("Diff completed", "directories", len(changes), "changes", totalChanges, "duration", time.Since(startTime))
 
+	if req.Limit > 0 || req.Offset > 0 {
+		changes = h.pageDiff(w, r, req, changes, totalChanges)
+	}
+
+	// Some directories failed: their entries carry an error, the rest are valid
+	status := http.StatusOK
+	if failed := diff.Failed(changes); failed > 0 {
+		logger(r).Warn("Diff partially failed", "failed", failed, "directories", len(changes))
+		status = http.StatusMultiStatus
+	}
+	// Failed directories have no row in a CSV export, only the 207 tells of them
+	if format == formatCSV {
+		var rows []diff.Change
+		for _, result := range changes {
+			rows = append(rows, result.Changes...)
+		}
+		if err := writeChangesCSV(w, status, "diff.csv", rows); err != nil {
+			logger(r).Error("Failed to write CSV", "error", err)
+		}
+		return
+	}
+	if wantsNDJSON(r) {
+		if err := writeDiffNDJSON(w, status, changes); err != nil {
+			logger(r).Error("Failed to stream response", "error", err)
+		}
+		return
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
 	if err := json.NewEncoder(w).Encode(changes); err != nil {
-		log.Printf("Error encoding response: %v", err)
+		logger(r).Error("Failed to encode response", "error", err)
 		return
 	}
 }
 
 func (h *Handlers) List(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
+	client := h.clientFor(r)
 	path := r.URL.Query().Get("path")
 	if path == "" {
 		path = "/"
 	}
+	path, err := h.resolvePath(r, "path", path)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
 
 	includeHidden := r.URL.Query().Get("include-hidden") == "true"
+	favoritesOnly := r.URL.Query().Get("favorites-only") == "true"
+
+	maxDepth, err := parseMaxDepth(r.URL.Query().Get("max-depth"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	recursive := r.URL.Query().Get("recursive") == "true"
+	limit, err := parseLimit(r.URL.Query().Get("limit"), defaultListLimit, maxListLimit)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	after, err := decodePageToken(r.URL.Query().Get("page-token"))
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if wantsNDJSON(r) {
+		h.streamList(w, r, path, includeHidden, favoritesOnly, recursive, maxDepth)
+		return
+	}
 
-	files, err := h.client.ListDir(path, includeHidden)
+	// Beyond one level the listing becomes a depth-limited recursive walk
+	var files []webdav.FileInfo
+	var nextPageToken string
+	if recursive {
+		files, nextPageToken, err = listPage(client, path, includeHidden, maxDepth, after, limit)
+	} else if maxDepth > 1 {
+		files, err = client.ListFilesWithETagOptimization(path, includeHidden, nil, nil, nil, webdav.WalkOptions{MaxDepth: maxDepth})
+	} else {
+		files, err = client.ListDir(path, includeHidden)
+	}
+	if errors.Is(err, webdav.ErrNotFound) {
+		middleware.WriteError(w, r, http.StatusNotFound, "DIR_NOT_FOUND", fmt.Sprintf("Failed to list directory: %v", err))
+		return
+	}
 	if err != nil {
-		log.Printf("Error listing directory %s: %v", path, err)
-		http.Error(w, fmt.Sprintf("Failed to list directory: %v", err), http.StatusInternalServerError)
+		logger(r).Error("Failed to list directory", "path", path, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to list directory: %v", err), http.StatusInternalServerError)
 		return
 	}
 
+	if favoritesOnly {
+		var favorites []webdav.FileInfo
+		for _, file := range files {
+			if file.Favorite {
+				favorites = append(favorites, file)
+			}
+		}
+		files = favorites
+	}
+
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"path":           path,
-		"files":          files,
-		"include_hidden": includeHidden,
+	json.NewEncoder(w).Encode(ListResponse{
+		Path:          path,
+		Files:         files,
+		IncludeHidden: includeHidden,
+		NextPageToken: nextPageToken,
+	})
+}
+
+// streamList writes a listing as NDJSON, each item as soon as it is found
+// A recursive stream covers the whole tree, it isn't paged.
+func (h *Handlers) streamList(w http.ResponseWriter, r *http.Request, dirPath string, includeHidden, favoritesOnly, recursive bool, maxDepth int) {
+	client := h.clientFor(r)
+	out := newNDJSONWriter(w, http.StatusOK)
+	emit := func(file webdav.FileInfo) error {
+		if favoritesOnly && !file.Favorite {
+			return nil
+		}
+		return out.Encode(file)
+	}
+
+	var err error
+	if recursive || maxDepth > 1 {
+		_, err = client.ListFilesWithETagOptimization(dirPath, includeHidden, nil, nil, nil, webdav.WalkOptions{
+			MaxDepth: maxDepth, Context: r.Context(), Sorted: true, Each: emit,
+		})
+	} else {
+		var files []webdav.FileInfo
+		files, err = client.ListDir(dirPath, includeHidden)
+		for _, file := range files {
+			if err = emit(file); err != nil {
+				break
+			}
+		}
+	}
+	if err != nil {
+		logger(r).Error("Failed to list directory", "path", dirPath, "error", err)
+		if !out.started() {
+			failWith(w, r, err, fmt.Sprintf("Failed to list directory: %v", err), http.StatusInternalServerError)
+			return
+		}
+		out.Encode(StreamError{Error: fmt.Sprintf("Failed to list directory: %v", err)})
+		return
+	}
+	out.finish()
+}
+
+// Page sizes of a recursive GET /ls
+const (
+	defaultListLimit = 1000
+	maxListLimit     = 10000
+)
+
+// listPage walks dirPath in path order and returns up to limit items after
+// the path after, with the token of the next page when the walk goes on
+// Subtrees before after are pruned and the walk stops once the page is full,
+// so each page costs about as many PROPFINDs as the directories it covers.
+func listPage(client *webdav.Client, dirPath string, includeHidden bool, maxDepth int, after string, limit int) ([]webdav.FileInfo, string, error) {
+	var last string
+	seen, more := 0, false
+	skip := func(filePath string, isDir bool) bool {
+		// Everything below a hidden item is hidden too, so its subtree is
+		// pruned rather than walked for nothing
+		if !includeHidden && strings.HasPrefix(path.Base(filePath), ".") {
+			return true
+		}
+		if after != "" && webdav.ComparePaths(filePath, after) <= 0 {
+			// The previous page's end and the directories above it are walked
+			// again to reach what follows it
+			return !isDir || !strings.HasPrefix(after+"/", strings.TrimSuffix(filePath, "/")+"/")
+		}
+		if seen == limit {
+			more = true
+			return true
+		}
+		seen++
+		last = filePath
+		return false
+	}
+	files, err := client.ListFilesWithETagOptimization(dirPath, includeHidden, nil, nil, nil, webdav.WalkOptions{
+		Skip: skip, MaxDepth: maxDepth, Sorted: true,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	page := make([]webdav.FileInfo, 0, len(files))
+	for _, file := range files {
+		if after == "" || webdav.ComparePaths(file.Path, after) > 0 {
+			page = append(page, file)
+		}
+	}
+	if !more {
+		return page, "", nil
+	}
+	return page, base64.RawURLEncoding.EncodeToString([]byte(last)), nil
+}
+
+// maxDiffLimit bounds the page size of POST /diff
+const maxDiffLimit = 100000
+
+// diffPage is the content of a diff page token
+type diffPage struct {
+	Cursor  int64  `json:"cursor"`
+	Offset  int    `json:"offset"`
+	Profile string `json:"profile,omitempty"`
+	// From and Until are the first and last directory timestamps, in Unix
+	// nanoseconds, of a run without a cursor, which is read back from the journal
+	From  int64 `json:"from,omitempty"`
+	Until int64 `json:"until,omitempty"`
+}
+
+// pageDiff returns the page of results req asks for, counting changes across
+// directories, and sets the headers describing it
+// Failed directories, which have no changes, come with the first page.
+func (h *Handlers) pageDiff(w http.ResponseWriter, r *http.Request, req *DiffRequest, results []diff.Changes, total int) []diff.Changes {
+	next := diffPage{Profile: req.Profile}
+	for _, result := range results {
+		next.Cursor = max(next.Cursor, result.Cursor)
+	}
+	switch {
+	case next.Cursor != 0:
+	case !req.journalUntil.IsZero():
+		next.From, next.Until = req.journalFrom.UnixNano(), req.journalUntil.UnixNano()
+	case req.Profile == "" && h.detectorFor(r).JournalEnabled():
+		// Without a cursor, the run is found in the journal by the timestamps of its directories
+		for _, result := range results {
+			if result.Error != "" {
+				continue
+			}
+			if ts := result.Timestamp.UnixNano(); next.From == 0 || ts < next.From {
+				next.From = ts
+			}
+			next.Until = max(next.Until, result.Timestamp.UnixNano())
+		}
+	}
+	end := total
+	if req.Limit > 0 {
+		end = min(req.Offset+req.Limit, total)
+	}
+	if next.Cursor == 0 && next.Until == 0 && end < total {
+		// Recording the run failed, so the rest could never be fetched
+		logger(r).Warn("Diff results have no cursor, returning every change unpaged", "changes", total)
+		return results
+	}
+
+	page := make([]diff.Changes, 0, len(results))
+	pos := 0
+	for _, result := range results {
+		n := len(result.Changes)
+		from, to := min(max(req.Offset-pos, 0), n), min(max(end-pos, 0), n)
+		pos += n
+		if from < to || (n == 0 && req.Offset == 0) {
+			result.Changes = result.Changes[from:to]
+			page = append(page, result)
+		}
+	}
+
+	w.Header().Set("Total-Count", strconv.Itoa(total))
+	if end < total {
+		next.Offset = end
+		token, _ := json.Marshal(next)
+		w.Header().Set("Next-Page-Token", base64.RawURLEncoding.EncodeToString(token))
+	}
+	return page
+}
+
+// decodeDiffPageToken parses the page-token of POST /diff
+func decodeDiffPageToken(token string) (diffPage, error) {
+	var page diffPage
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || json.Unmarshal(data, &page) != nil || (page.Cursor < 1 && page.Until <= 0) || page.Offset < 0 {
+		return diffPage{}, fmt.Errorf("invalid page-token %q", token)
+	}
+	return page, nil
+}
+
+// decodePageToken returns the path a page token continues after, "" for none
+func decodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+	after, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(after) == 0 {
+		return "", fmt.Errorf("invalid page-token %q", token)
+	}
+	return string(after), nil
+}
+
+// errClientGone is the cause of runs cancelled by runContext
+var errClientGone = errors.New("client disconnected")
+
+// runContext returns the context of a diff run for r: it ends when the client
+// goes away, but not when the server shuts down, which lets runs in progress
+// finish (see diff.Detector.Shutdown)
+// The server cancels requests at shutdown with diff.ErrShuttingDown as cause.
+func runContext(r *http.Request) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancelCause(context.WithoutCancel(r.Context()))
+	stop := context.AfterFunc(r.Context(), func() {
+		if !errors.Is(context.Cause(r.Context()), diff.ErrShuttingDown) {
+			cancel(errClientGone)
+		}
+	})
+	return ctx, func() {
+		stop()
+		cancel(nil)
+	}
+}
+
+// runDiff computes the changes a diff request asks for
+func runDiff(ctx context.Context, detector *diff.Detector, req *DiffRequest, directories []string, opts diff.DetectOptions) ([]diff.Changes, error) {
+	switch {
+	case req.Cursor != 0:
+		results, err := detector.Replay(req.Cursor, req.Profile)
+		if err != nil {
+			return nil, err
+		}
+		return opts.ChangeFilter.ApplyResults(results), nil
+	case !req.journalUntil.IsZero():
+		results, err := detector.ReplayJournal(req.journalFrom, req.journalUntil, opts)
+		if err != nil {
+			return nil, err
+		}
+		return opts.ChangeFilter.ApplyResults(results), nil
+	case req.From != "":
+		return detector.DiffSnapshots(req.From, req.To, directories, opts)
+	case req.Since != "":
+		since, _ := time.Parse(time.RFC3339, req.Since)
+		return detector.ChangesSince(directories, since, opts)
+	case req.LongPoll > 0:
+		return longPoll(ctx, detector, directories, opts, req.LongPoll)
+	}
+	return detector.DetectChanges(directories, opts)
+}
+
+// longPoll rescans directories until a run reports changes or a failure, the
+// timeout elapses, or ctx is done; it returns the last run's results
+// Long polls queue behind diffs already running rather than failing with 409.
+func longPoll(ctx context.Context, detector *diff.Detector, directories []string, opts diff.DetectOptions, timeout time.Duration) ([]diff.Changes, error) {
+	opts.Wait = true
+	deadline := time.Now().Add(timeout)
+	for {
+		changes, err := detector.DetectChanges(directories, opts)
+		if err != nil || hasChanges(changes) {
+			return changes, err
+		}
+		remaining := time.Until(deadline)
+		if remaining <= 0 {
+			return changes, nil
+		}
+		select {
+		case <-ctx.Done():
+			// Client gone, or the server is shutting down
+			return changes, nil
+		case <-time.After(min(remaining, longPollInterval)):
+		}
+	}
+}
+
+// hasChanges reports whether a result holds a change or a failed directory
+func hasChanges(results []diff.Changes) bool {
+	for _, result := range results {
+		if len(result.Changes) > 0 || result.Error != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// apiErrors maps the errors handlers fail with to their status and code,
+// listed in README.md; clients rely on the codes, so they never change
+var apiErrors = []struct {
+	err    error
+	status int
+	code   string
+}{
+	{webdav.ErrNotFound, http.StatusNotFound, "PATH_NOT_FOUND"},
+	{webdav.ErrUnauthorized, http.StatusUnauthorized, "NEXTCLOUD_UNAUTHORIZED"},
+	{webdav.ErrLocked, http.StatusLocked, "PATH_LOCKED"},
+	{webdav.ErrInsufficientStorage, http.StatusInsufficientStorage, "INSUFFICIENT_STORAGE"},
+	{webdav.ErrExists, http.StatusConflict, "PATH_EXISTS"},
+	{webdav.ErrRangeNotSatisfiable, http.StatusRequestedRangeNotSatisfiable, "RANGE_NOT_SATISFIABLE"},
+	{webdav.ErrCircuitOpen, http.StatusServiceUnavailable, "CIRCUIT_OPEN"},
+	{diff.ErrSnapshotNotFound, http.StatusNotFound, "SNAPSHOT_NOT_FOUND"},
+	{diff.ErrNoSnapshots, http.StatusNotFound, "SNAPSHOTS_DISABLED"},
+	{diff.ErrNoProfiles, http.StatusNotFound, "PROFILES_DISABLED"},
+	{diff.ErrUnknownProfile, http.StatusNotFound, "PROFILE_NOT_FOUND"},
+	{diff.ErrCursorNotFound, http.StatusNotFound, "CURSOR_NOT_FOUND"},
+	{diff.ErrRunNotJournaled, http.StatusNotFound, "RUN_NOT_FOUND"},
+	{diff.ErrJobNotFound, http.StatusNotFound, "JOB_NOT_FOUND"},
+	{schedule.ErrUnknownSchedule, http.StatusNotFound, "SCHEDULE_NOT_FOUND"},
+	{diff.ErrSnapshotExists, http.StatusConflict, "SNAPSHOT_EXISTS"},
+	{diff.ErrDiffInProgress, http.StatusConflict, "DIFF_IN_PROGRESS"},
+	{diff.ErrAccountChanged, http.StatusConflict, "ACCOUNT_CHANGED"},
+	{diff.ErrJobNotFinished, http.StatusConflict, "JOB_NOT_FINISHED"},
+	{diff.ErrJobFailed, http.StatusConflict, "JOB_FAILED"},
+	{diff.ErrJobCancelled, http.StatusConflict, "JOB_CANCELLED"},
+	{diff.ErrJobFinished, http.StatusConflict, "JOB_FINISHED"},
+	{schedule.ErrScheduleRunning, http.StatusConflict, "SCHEDULE_RUNNING"},
+	{diff.ErrInvalidSnapshotName, http.StatusBadRequest, "INVALID_SNAPSHOT_NAME"},
+	{diff.ErrInvalidProfileName, http.StatusBadRequest, "INVALID_PROFILE_NAME"},
+	{diff.ErrInvalidState, http.StatusBadRequest, "INVALID_STATE"},
+	{errPathNotAllowed, http.StatusForbidden, "PATH_NOT_ALLOWED"},
+	{tenant.ErrUnknownAccount, http.StatusNotFound, "ACCOUNT_NOT_FOUND"},
+	{diff.ErrShuttingDown, http.StatusServiceUnavailable, "SHUTTING_DOWN"},
+}
+
+// errorStatus maps known errors to an HTTP status, using fallback for anything unrecognized
+func errorStatus(err error, fallback int) int {
+	for _, known := range apiErrors {
+		if errors.Is(err, known.err) {
+			return known.status
+		}
+	}
+	return fallback
+}
+
+// errorCode returns the code of err, or that of status for unknown errors
+func errorCode(err error, status int) string {
+	for _, known := range apiErrors {
+		if errors.Is(err, known.err) {
+			return known.code
+		}
+	}
+	return middleware.StatusCode(status)
+}
+
+// httpError answers status with message as an ErrorResponse, the
+// counterpart of http.Error
+func httpError(w http.ResponseWriter, r *http.Request, message string, status int) {
+	middleware.WriteError(w, r, status, middleware.StatusCode(status), message)
+}
+
+// failWith answers with message as an ErrorResponse, with the status and
+// code of err (see apiErrors), or fallback when it isn't known
+func failWith(w http.ResponseWriter, r *http.Request, err error, message string, fallback int) {
+	status := errorStatus(err, fallback)
+	middleware.WriteError(w, r, status, errorCode(err, status), message)
+}
+
+// logProgress returns a progress hook that logs scan progress at most once per interval
+func logProgress(logger *slog.Logger, interval time.Duration) webdav.ProgressHook {
+	lastLog := time.Now()
+	return webdav.ProgressFunc(func(event webdav.ProgressEvent) {
+		if time.Since(lastLog) < interval {
+			return
+		}
+		lastLog = time.Now()
+		logger.Info("Scan progress", "dirs_visited", event.DirsVisited, "files_found", event.FilesFound, "path", event.Path)
 	})
 }
 
 func parseDiffRequest(r *http.Request) (*DiffRequest, error) {
 	req := &DiffRequest{IncludeHidden: false}
 
-	// Try to decode JSON body, but don't fail if body is empty
-	if r.ContentLength > 0 {
-		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
-			req.IncludeHidden = false
+	// The body is optional, everything can be set through the query
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil && err != io.EOF {
+			if tooLarge(err) {
+				return nil, err
+			}
+			return nil, fieldError("body", "invalid JSON: %v", err)
+		}
+	}
+	if pathParam := r.URL.Query().Get("path"); pathParam != "" {
+		if err := checkPath(pathParam); err != nil {
+			return nil, fieldError("path", "%v", err)
 		}
 	}
 
@@ -126,9 +843,203 @@ func parseDiffRequest(r *http.Request) (*DiffRequest, error) {
 		req.IncludeHidden = false
 	}
 
+	if maxDepth := r.URL.Query().Get("max-depth"); maxDepth != "" {
+		depth, err := parseMaxDepth(maxDepth)
+		if err != nil {
+			return nil, err
+		}
+		req.MaxDepth = depth
+	}
+	if req.MaxDepth < 0 {
+		return nil, fmt.Errorf("invalid max-depth %d: must be positive", req.MaxDepth)
+	}
+
+	if since := r.URL.Query().Get("since"); since != "" {
+		req.Since = since
+	}
+	if req.Since != "" {
+		if _, err := time.Parse(time.RFC3339, req.Since); err != nil {
+			return nil, fmt.Errorf("invalid since %q: must be an RFC 3339 timestamp", req.Since)
+		}
+	}
+
+	query := r.URL.Query()
+	if types := query.Get("types"); types != "" {
+		req.Types = strings.Split(types, ",")
+	}
+	if patterns := query["pattern"]; len(patterns) > 0 {
+		req.Patterns = patterns
+	}
+	for param, size := range map[string]*int64{"min-size": &req.MinSize, "max-size": &req.MaxSize} {
+		if value := query.Get(param); value != "" {
+			n, err := strconv.ParseInt(value, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s %q: must be a number of bytes", param, value)
+			}
+			*size = n
+		}
+	}
+	for param, flag := range map[string]*bool{"files-only": &req.FilesOnly, "dirs-only": &req.DirsOnly} {
+		if value := query.Get(param); value == "true" {
+			*flag = true
+		} else if value == "false" {
+			*flag = false
+		}
+	}
+	if err := req.changeFilter().Validate(); err != nil {
+		return nil, err
+	}
+
+	if from := r.URL.Query().Get("from"); from != "" {
+		req.From = from
+	}
+	if to := r.URL.Query().Get("to"); to != "" {
+		req.To = to
+	}
+	if req.To != "" && req.From == "" {
+		return nil, fmt.Errorf("'to' requires 'from'")
+	}
+	if req.From != "" && req.Since != "" {
+		return nil, fmt.Errorf("'from' and 'since' cannot be combined")
+	}
+
+	if profile := r.URL.Query().Get("profile"); profile != "" {
+		req.Profile = profile
+	}
+	if req.Profile != "" && (req.From != "" || req.Since != "") {
+		return nil, fmt.Errorf("'profile' cannot be combined with 'from' or 'since', which don't use the stored state")
+	}
+
+	if cursor := r.URL.Query().Get("cursor"); cursor != "" {
+		n, err := strconv.ParseInt(cursor, 10, 64)
+		if err != nil || n < 1 {
+			return nil, fmt.Errorf("invalid cursor %q: must be a positive integer", cursor)
+		}
+		req.Cursor = n
+	}
+	if req.Cursor != 0 && (req.From != "" || req.Since != "") {
+		return nil, fmt.Errorf("'cursor' cannot be combined with 'from' or 'since'")
+	}
+
+	// Same override rule for favorites-only and dry-run
+	if r.URL.Query().Get("favorites-only") == "true" {
+		req.FavoritesOnly = true
+	} else if r.URL.Query().Get("favorites-only") == "false" {
+		req.FavoritesOnly = false
+	}
+	// wait is a flag, or a duration to long-poll for changes
+	switch wait := r.URL.Query().Get("wait"); wait {
+	case "":
+	case "true":
+		req.Wait = true
+	case "false":
+		req.Wait = false
+	default:
+		d, err := time.ParseDuration(wait)
+		if err != nil || d <= 0 || d > maxLongPoll {
+			return nil, fmt.Errorf("invalid wait %q: must be true, false or a duration up to %s, e.g. 60s", wait, maxLongPoll)
+		}
+		if req.Cursor != 0 || req.From != "" || req.Since != "" {
+			return nil, fmt.Errorf("'wait' with a duration cannot be combined with 'cursor', 'from' or 'since'")
+		}
+		req.LongPoll = d
+	}
+	if async := r.URL.Query().Get("async"); async == "true" {
+		req.Async = true
+	} else if async == "false" {
+		req.Async = false
+	}
+	if r.URL.Query().Get("dry-run") == "true" {
+		req.DryRun = true
+	} else if r.URL.Query().Get("dry-run") == "false" {
+		req.DryRun = false
+	}
+	if r.URL.Query().Get("collapse-deletes") == "true" {
+		req.CollapseDeletes = true
+	} else if r.URL.Query().Get("collapse-deletes") == "false" {
+		req.CollapseDeletes = false
+	}
+	if err := req.parsePage(query); err != nil {
+		return nil, err
+	}
+	if err := req.validate(); err != nil {
+		return nil, err
+	}
+
 	return req, nil
 }
 
+// parsePage reads limit, offset and page-token, which page through the
+// results recorded under a cursor
+func (req *DiffRequest) parsePage(query url.Values) error {
+	if limit := query.Get("limit"); limit != "" {
+		n, err := parseLimit(limit, 0, maxDiffLimit)
+		if err != nil {
+			return err
+		}
+		req.Limit = n
+	}
+	if offset := query.Get("offset"); offset != "" {
+		n, err := strconv.Atoi(offset)
+		if err != nil {
+			return fmt.Errorf("invalid offset %q: must be a non-negative integer", offset)
+		}
+		req.Offset = n
+	}
+	if token := query.Get("page-token"); token != "" {
+		req.PageToken = token
+	}
+	if req.PageToken != "" {
+		page, err := decodeDiffPageToken(req.PageToken)
+		if err != nil {
+			return err
+		}
+		if (req.Cursor != 0 && req.Cursor != page.Cursor) || (req.Profile != "" && req.Profile != page.Profile) {
+			return fmt.Errorf("'page-token' belongs to another cursor or profile")
+		}
+		req.Cursor, req.Offset, req.Profile = page.Cursor, page.Offset, page.Profile
+		if page.Cursor == 0 {
+			req.journalFrom, req.journalUntil = time.Unix(0, page.From), time.Unix(0, page.Until)
+		}
+	}
+
+	switch {
+	case req.Limit < 0 || req.Limit > maxDiffLimit:
+		return fmt.Errorf("invalid limit %d: must be between 1 and %d", req.Limit, maxDiffLimit)
+	case req.Offset < 0:
+		return fmt.Errorf("invalid offset %d: must be a non-negative integer", req.Offset)
+	case req.Offset > 0 && req.Cursor == 0 && req.journalUntil.IsZero():
+		return fmt.Errorf("'offset' requires the 'cursor' of the run to page through")
+	case req.Limit > 0 && (req.DryRun || req.From != "" || req.Since != "" || req.Async):
+		return fmt.Errorf("'limit' cannot be combined with 'dry-run', 'from', 'since' or 'async', whose results can't be replayed to page through")
+	}
+	return nil
+}
+
+// parseMaxDepth parses the max-depth query parameter, 0 when absent
+func parseMaxDepth(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	depth, err := strconv.Atoi(value)
+	if err != nil || depth < 1 {
+		return 0, fmt.Errorf("invalid max-depth %q: must be a positive integer", value)
+	}
+	return depth, nil
+}
+
+// parseLimit parses a page size, fallback when empty
+func parseLimit(value string, fallback, max int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit < 1 || limit > max {
+		return 0, fmt.Errorf("invalid limit %q: must be an integer between 1 and %d", value, max)
+	}
+	return limit, nil
+}
+
 func (h *Handlers) resolveDirectories(r *http.Request, req *DiffRequest) ([]string, error) {
 	// Priority: query parameter > request body
 	if pathParam := r.URL.Query().Get("path"); pathParam != "" {
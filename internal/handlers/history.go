@@ -0,0 +1,75 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+// HistoryResponse is the body of GET /history
+type HistoryResponse struct {
+	Changes []diff.JournalEntry `json:"changes"`
+	Count   int                 `json:"count"`
+}
+
+// History lists journaled changes, optionally restricted to a path and a time range
+func (h *Handlers) History(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	q := diff.HistoryQuery{Path: query.Get("path")}
+	format, err := parseFormat(r)
+	if err != nil {
+		httpError(w, r, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if q.Since, err = parseTime(query.Get("since")); err != nil {
+		httpError(w, r, fmt.Sprintf("invalid 'since': %v", err), http.StatusBadRequest)
+		return
+	}
+	if q.Until, err = parseTime(query.Get("until")); err != nil {
+		httpError(w, r, fmt.Sprintf("invalid 'until': %v", err), http.StatusBadRequest)
+		return
+	}
+
+	entries, err := h.detectorFor(r).History(q)
+	if err != nil {
+		if errors.Is(err, diff.ErrNoJournal) {
+			httpError(w, r, "Change journal is disabled (set journal_file)", http.StatusNotFound)
+			return
+		}
+		logger(r).Error("Failed to read journal", "error", err)
+		httpError(w, r, fmt.Sprintf("Failed to read journal: %v", err), http.StatusInternalServerError)
+		return
+	}
+	if entries == nil {
+		entries = []diff.JournalEntry{}
+	}
+
+	if format == formatCSV {
+		rows := make([]diff.Change, len(entries))
+		for i, entry := range entries {
+			rows[i] = entry.Change
+		}
+		if err := writeChangesCSV(w, http.StatusOK, "history.csv", rows); err != nil {
+			logger(r).Error("Failed to write CSV", "error", err)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(HistoryResponse{
+		Changes: entries,
+		Count:   len(entries),
+	})
+}
+
+// parseTime parses an RFC 3339 timestamp, returning the zero time when empty
+func parseTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
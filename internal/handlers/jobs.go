@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/middleware"
+	"go-nc-client/internal/webdav"
+)
+
+// startDiffJob runs a diff request in the background and answers 202 with the job
+// Jobs queue behind diffs already running instead of failing with 409.
+func (h *Handlers) startDiffJob(w http.ResponseWriter, r *http.Request, req *DiffRequest, directories []string) {
+	detector := h.detectorFor(r)
+	jobs := detector.Jobs()
+	if jobs == nil {
+		httpError(w, r, "Async jobs are disabled", http.StatusNotFound)
+		return
+	}
+
+	requestID := middleware.RequestIDFrom(r.Context())
+	job, err := jobs.Start(directories, requestID, func(ctx context.Context, progress webdav.ProgressHook) ([]diff.Changes, error) {
+		return runDiff(ctx, detector, req, directories, diff.DetectOptions{
+			IncludeHidden:   req.IncludeHidden,
+			FavoritesOnly:   req.FavoritesOnly,
+			Progress:        progress,
+			MaxDepth:        req.MaxDepth,
+			DryRun:          req.DryRun,
+			Wait:            true,
+			ChangeFilter:    req.changeFilter(),
+			Local:           req.Local,
+			Profile:         req.Profile,
+			CollapseDeletes: req.CollapseDeletes,
+			RequestID:       requestID,
+			Context:         ctx,
+		})
+	})
+	if err != nil {
+		logger(r).Error("Failed to start diff job", "error", err)
+		httpError(w, r, fmt.Sprintf("Failed to start diff job: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger(r).Info("Diff job started", "job_id", job.ID)
+
+	location := "/jobs/" + job.ID
+	if version := middleware.APIVersionFrom(r.Context()); version != "" {
+		location = "/" + version + location
+	}
+	w.Header().Set("Location", location)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(job)
+}
+
+// Job reports the status and progress of a background diff (GET) or cancels it (DELETE)
+func (h *Handlers) Job(w http.ResponseWriter, r *http.Request) {
+	jobs := h.detectorFor(r).Jobs()
+	if jobs == nil {
+		httpError(w, r, "Async jobs are disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		job, err := jobs.Get(r.PathValue("id"))
+		if err != nil {
+			logger(r).Warn("Failed to get job", "error", err)
+			failWith(w, r, err, fmt.Sprintf("Failed to get job: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(job)
+
+	case http.MethodDelete:
+		job, err := jobs.Cancel(r.Context(), r.PathValue("id"))
+		if err != nil {
+			logger(r).Warn("Failed to cancel job", "error", err)
+			failWith(w, r, err, fmt.Sprintf("Failed to cancel job: %v", err), http.StatusInternalServerError)
+			return
+		}
+		logger(r).Info("Job cancelled", "job_id", job.ID, "status", job.Status)
+
+		w.Header().Set("Content-Type", "application/json")
+		// Still stopping, e.g. while queued behind another diff
+		if job.Status == diff.JobRunning {
+			w.WriteHeader(http.StatusAccepted)
+		}
+		json.NewEncoder(w).Encode(job)
+
+	}
+}
+
+// JobResult returns the changes of a succeeded background diff, like POST /diff would have
+func (h *Handlers) JobResult(w http.ResponseWriter, r *http.Request) {
+	jobs := h.detectorFor(r).Jobs()
+	if jobs == nil {
+		httpError(w, r, "Async jobs are disabled", http.StatusNotFound)
+		return
+	}
+
+	changes, err := jobs.Result(r.PathValue("id"))
+	if err != nil {
+		logger(r).Warn("Failed to get job result", "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to get job result: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if diff.Failed(changes) > 0 {
+		w.WriteHeader(http.StatusMultiStatus)
+	}
+	json.NewEncoder(w).Encode(changes)
+}
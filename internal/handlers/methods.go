@@ -0,0 +1,63 @@
+package handlers
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"go-nc-client/internal/middleware"
+)
+
+// Methods returns the methods the route answers: those of its operations,
+// HEAD along with GET, and OPTIONS
+func (rt Route) Methods() []string {
+	var methods []string
+	for _, op := range rt.Operations {
+		methods = append(methods, op.Method)
+		if op.Method == http.MethodGet {
+			methods = append(methods, http.MethodHead)
+		}
+	}
+	return append(methods, http.MethodOptions)
+}
+
+// MethodHandler serves the route, answering OPTIONS with its Allow header and
+// other methods it has no operation for with 405
+// HEAD reaches Handler as it is, with the body it writes discarded.
+func (rt Route) MethodHandler() http.Handler {
+	methods := rt.Methods()
+	allow := strings.Join(methods, ", ")
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodOptions:
+			w.Header().Set("Allow", allow)
+			w.WriteHeader(http.StatusNoContent)
+		case !slices.Contains(methods, r.Method):
+			w.Header().Set("Allow", allow)
+			httpError(w, r, "Method not allowed", http.StatusMethodNotAllowed)
+		case r.Method == http.MethodHead:
+			rt.Handler(headWriter{w}, r)
+		default:
+			rt.Handler(w, r)
+		}
+	})
+}
+
+// NotFound answers requests for paths no route serves
+func NotFound(w http.ResponseWriter, r *http.Request) {
+	middleware.WriteError(w, r, http.StatusNotFound, "ROUTE_NOT_FOUND", "No endpoint at "+r.URL.Path)
+}
+
+// headWriter drops the body of a HEAD response, keeping its headers and status
+type headWriter struct {
+	http.ResponseWriter
+}
+
+func (w headWriter) Write(p []byte) (int, error) {
+	return len(p), nil
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w headWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
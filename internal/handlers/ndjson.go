@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/webdav"
+)
+
+// ndjsonContentType, when accepted, makes /ls and /diff stream one JSON object per line
+const ndjsonContentType = "application/x-ndjson"
+
+// DiffRecord is one line of an NDJSON diff: a change of a directory, or the
+// error of a directory that could not be scanned
+type DiffRecord struct {
+	Directory string `json:"directory"`
+	Cursor    int64  `json:"cursor,omitempty"`
+	Error     string `json:"error,omitempty"`
+	*diff.Change
+}
+
+// StreamError is the last line of an NDJSON response that failed after it started
+type StreamError struct {
+	Error string `json:"error"`
+}
+
+// wantsNDJSON reports whether the Accept header asks for NDJSON
+func wantsNDJSON(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.EqualFold(strings.TrimSpace(mediaType), ndjsonContentType) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ndjsonWriter writes one JSON value per line, flushing each so the client
+// gets them as they are produced
+// The status is sent with the first line, so a failure before it can still
+// be answered with a plain error.
+type ndjsonWriter struct {
+	w      http.ResponseWriter
+	status int
+	enc    *json.Encoder
+}
+
+func newNDJSONWriter(w http.ResponseWriter, status int) *ndjsonWriter {
+	return &ndjsonWriter{w: w, status: status}
+}
+
+// started reports whether the response is under way
+func (n *ndjsonWriter) started() bool {
+	return n.enc != nil
+}
+
+func (n *ndjsonWriter) start() {
+	if n.started() {
+		return
+	}
+	n.w.Header().Set("Content-Type", ndjsonContentType)
+	n.w.WriteHeader(n.status)
+	n.enc = json.NewEncoder(webdav.NewFlushWriter(n.w))
+}
+
+// Encode writes v as the next line
+func (n *ndjsonWriter) Encode(v any) error {
+	n.start()
+	return n.enc.Encode(v)
+}
+
+// finish sends the headers of a response that has no lines
+func (n *ndjsonWriter) finish() {
+	n.start()
+}
+
+// writeDiffNDJSON streams the changes of a diff, a line per change
+func writeDiffNDJSON(w http.ResponseWriter, status int, results []diff.Changes) error {
+	out := newNDJSONWriter(w, status)
+	for _, result := range results {
+		if result.Error != "" {
+			if err := out.Encode(DiffRecord{Directory: result.Directory, Cursor: result.Cursor, Error: result.Error}); err != nil {
+				return err
+			}
+		}
+		for i := range result.Changes {
+			if err := out.Encode(DiffRecord{Directory: result.Directory, Cursor: result.Cursor, Change: &result.Changes[i]}); err != nil {
+				return err
+			}
+		}
+	}
+	out.finish()
+	return nil
+}
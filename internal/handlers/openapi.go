@@ -0,0 +1,294 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/middleware"
+)
+
+// Route is a path served by the API together with the documentation of its
+// methods, from which /openapi.json is generated
+type Route struct {
+	Path       string
+	Handler    http.HandlerFunc
+	Operations []Operation
+}
+
+// Operation documents one method of a route
+type Operation struct {
+	Method  string
+	Summary string
+	Params  []Param
+	// Body is a value of the JSON request body type, nil without a body
+	Body any
+	// Status is the success status (0 = 200)
+	Status int
+	// Response is a value of the type wrapped in the envelope's data, nil
+	// without a body
+	Response any
+	// ContentType replaces the JSON envelope for responses of other content,
+	// e.g. images
+	ContentType string
+	// Errors lists the statuses the operation fails with besides 401, 403,
+	// 429 and 500
+	Errors []int
+	// Public operations need no API key
+	Public bool
+}
+
+// Param is a query parameter, or a {name} wildcard of the route path
+type Param struct {
+	Name        string
+	Type        string // "string", "integer" or "boolean"
+	Description string
+	Required    bool
+	// InPath marks a wildcard of the path, which is always required
+	InPath bool
+	// Repeated parameters may be given several times, e.g. pattern=*.md&pattern=*.txt
+	Repeated bool
+}
+
+var (
+	specOnce sync.Once
+	spec     []byte
+)
+
+// OpenAPI serves the OpenAPI 3 document of the routes
+func (h *Handlers) OpenAPI(w http.ResponseWriter, r *http.Request) {
+	specOnce.Do(func() {
+		spec, _ = json.MarshalIndent(openAPIDocument(h.Routes()), "", "  ")
+	})
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// openAPIDocument describes routes under the /v1 server, with schemas derived
+// from the Go types the handlers encode
+func openAPIDocument(routes []Route) map[string]any {
+	schemas := &schemaSet{defs: map[string]any{}}
+	envelopeMeta := schemas.ref(reflect.TypeOf(middleware.EnvelopeMeta{}))
+	errorEnvelope := map[string]any{
+		"type": "object",
+		"properties": map[string]any{
+			"error": schemas.ref(reflect.TypeOf(middleware.EnvelopeError{})),
+			"meta":  envelopeMeta,
+		},
+		"required": []string{"error", "meta"},
+	}
+	schemas.defs["ErrorEnvelope"] = errorEnvelope
+	errorRef := map[string]any{"$ref": "#/components/schemas/ErrorEnvelope"}
+
+	paths := map[string]any{}
+	for _, route := range routes {
+		item := map[string]any{}
+		for _, op := range route.Operations {
+			item[strings.ToLower(op.Method)] = operationObject(route.Path, op, schemas, envelopeMeta, errorRef)
+		}
+		paths[route.Path] = item
+	}
+
+	return map[string]any{
+		"openapi": "3.0.3",
+		"info": map[string]any{
+			"title":       "go-nc-client",
+			"version":     middleware.APIVersion,
+			"description": "Change detection and file access for a Nextcloud WebDAV server. Every JSON response is wrapped in an envelope with data or error, and meta.",
+		},
+		"servers": []any{map[string]any{"url": "/" + middleware.APIVersion}},
+		"paths":   paths,
+		"components": map[string]any{
+			"schemas": schemas.defs,
+			"securitySchemes": map[string]any{
+				"bearer": map[string]any{"type": "http", "scheme": "bearer"},
+				"apiKey": map[string]any{"type": "apiKey", "in": "header", "name": "X-API-Key"},
+			},
+		},
+		// Keys are only checked when api_keys is configured
+		"security": []any{map[string]any{"bearer": []string{}}, map[string]any{"apiKey": []string{}}, map[string]any{}},
+	}
+}
+
+func operationObject(path string, op Operation, schemas *schemaSet, envelopeMeta, errorRef map[string]any) map[string]any {
+	// e.g. "postStateReset" for POST /state/reset, "getJobsById" for GET /jobs/{id}
+	operationID := strings.ToLower(op.Method)
+	for _, word := range strings.FieldsFunc(path, func(r rune) bool { return r == '/' || r == '-' || r == '.' }) {
+		if wildcard, ok := strings.CutPrefix(word, "{"); ok {
+			word = "by" + strings.TrimSuffix(wildcard, "}")
+		}
+		operationID += strings.ToUpper(word[:1]) + word[1:]
+	}
+	result := map[string]any{
+		"summary":     op.Summary,
+		"operationId": operationID,
+	}
+	if op.Public {
+		result["security"] = []any{}
+	}
+
+	opParams := op.Params
+	// Every route serving an account can serve the named ones
+	if !op.Public && !configuredAccountRoutes[path] {
+		opParams = append(slices.Clip(opParams), accountParam)
+	}
+	var params []any
+	for _, p := range opParams {
+		schema := map[string]any{"type": p.Type}
+		if p.Repeated {
+			schema = map[string]any{"type": "array", "items": schema}
+		}
+		param := map[string]any{"name": p.Name, "in": "query", "schema": schema}
+		if p.InPath {
+			param["in"] = "path"
+		}
+		if p.Description != "" {
+			param["description"] = p.Description
+		}
+		if p.Required || p.InPath {
+			param["required"] = true
+		}
+		params = append(params, param)
+	}
+	if len(params) > 0 {
+		result["parameters"] = params
+	}
+
+	if op.Body != nil {
+		result["requestBody"] = map[string]any{
+			"content": map[string]any{
+				"application/json": map[string]any{"schema": schemas.ref(reflect.TypeOf(op.Body))},
+			},
+		}
+	}
+
+	status := op.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	success := map[string]any{"description": http.StatusText(status)}
+	switch {
+	case op.ContentType == "application/json":
+		success["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "object"}}}
+	case op.ContentType != "":
+		success["content"] = map[string]any{op.ContentType: map[string]any{"schema": map[string]any{"type": "string", "format": "binary"}}}
+	case op.Response != nil:
+		success["content"] = map[string]any{"application/json": map[string]any{"schema": map[string]any{
+			"type": "object",
+			"properties": map[string]any{
+				"data": schemas.ref(reflect.TypeOf(op.Response)),
+				"meta": envelopeMeta,
+			},
+			"required": []string{"data", "meta"},
+		}}}
+	}
+	responses := map[string]any{strconv.Itoa(status): success}
+
+	errorStatuses := append([]int{http.StatusInternalServerError, http.StatusTooManyRequests}, op.Errors...)
+	if !op.Public {
+		errorStatuses = append(errorStatuses, http.StatusUnauthorized, http.StatusForbidden)
+	}
+	for _, code := range errorStatuses {
+		responses[strconv.Itoa(code)] = map[string]any{
+			"description": http.StatusText(code),
+			"content":     map[string]any{"application/json": map[string]any{"schema": errorRef}},
+		}
+	}
+	result["responses"] = responses
+	return result
+}
+
+// schemaSet collects the schemas of named struct types under components
+type schemaSet struct {
+	defs map[string]any
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+// ref returns the schema of t, a $ref for named structs
+func (s *schemaSet) ref(t reflect.Type) map[string]any {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == timeType:
+		return map[string]any{"type": "string", "format": "date-time"}
+	case t == reflect.TypeOf(json.RawMessage{}):
+		return map[string]any{}
+	}
+
+	switch t.Kind() {
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return map[string]any{"type": "string", "format": "byte"}
+		}
+		return map[string]any{"type": "array", "items": s.ref(t.Elem())}
+	case reflect.Map:
+		return map[string]any{"type": "object", "additionalProperties": s.ref(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s.defs[t.Name()]; !ok {
+			s.defs[t.Name()] = nil // Placeholder for recursive types
+			s.defs[t.Name()] = s.object(t)
+		}
+		return map[string]any{"$ref": "#/components/schemas/" + t.Name()}
+	}
+	return map[string]any{}
+}
+
+// object builds the schema of a struct the way encoding/json encodes it
+func (s *schemaSet) object(t reflect.Type) map[string]any {
+	properties := map[string]any{}
+	var required []string
+	s.addFields(t, properties, &required)
+	sort.Strings(required)
+	schema := map[string]any{"type": "object", "properties": properties}
+	if len(required) > 0 {
+		schema["required"] = required
+	}
+	return schema
+}
+
+func (s *schemaSet) addFields(t reflect.Type, properties map[string]any, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, opts, _ := strings.Cut(tag, ",")
+		// Embedded structs without a name are flattened, like encoding/json does
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.ref(field.Type)
+		if !strings.Contains(opts, "omitempty") && !strings.Contains(opts, "omitzero") {
+			*required = append(*required, name)
+		}
+	}
+}
@@ -0,0 +1,62 @@
+package handlers
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	defaultPreviewSize = 256
+	maxPreviewSize     = 2048
+)
+
+// Preview streams a thumbnail of a file from the Nextcloud preview API
+func (h *Handlers) Preview(w http.ResponseWriter, r *http.Request) {
+	path, err := h.pathParam(r)
+	if err != nil {
+		pathError(w, r, err)
+		return
+	}
+
+	width, err := parsePreviewSize(r.URL.Query().Get("w"))
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("invalid 'w': %v", err), http.StatusBadRequest)
+		return
+	}
+	height, err := parsePreviewSize(r.URL.Query().Get("h"))
+	if err != nil {
+		httpError(w, r, fmt.Sprintf("invalid 'h': %v", err), http.StatusBadRequest)
+		return
+	}
+
+	preview, err := h.clientFor(r).Preview(path, width, height)
+	if err != nil {
+		logger(r).Error("Failed to fetch preview", "path", path, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to fetch preview: %v", err), http.StatusBadGateway)
+		return
+	}
+	defer preview.Close()
+
+	preview.SetHeaders(w.Header())
+	w.Header().Set("Cache-Control", "private, max-age=3600")
+	if _, err := io.Copy(w, preview); err != nil {
+		logger(r).Error("Failed to stream preview", "path", path, "error", err)
+	}
+}
+
+// parsePreviewSize parses a thumbnail dimension, defaulting when empty
+func parsePreviewSize(value string) (int, error) {
+	if value == "" {
+		return defaultPreviewSize, nil
+	}
+	size, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if size <= 0 || size > maxPreviewSize {
+		return 0, fmt.Errorf("must be between 1 and %d", maxPreviewSize)
+	}
+	return size, nil
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"net/http"
+
+	"go-nc-client/internal/config"
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/schedule"
+	"go-nc-client/internal/version"
+	"go-nc-client/internal/webdav"
+)
+
+// Query parameters shared by several routes
+var (
+	includeHiddenParam = Param{Name: "include-hidden", Type: "boolean", Description: "Include files and directories starting with a dot"}
+	favoritesOnlyParam = Param{Name: "favorites-only", Type: "boolean", Description: "Only include files marked as favorite"}
+	maxDepthParam      = Param{Name: "max-depth", Type: "integer", Description: "How deep to walk (1 = direct children only)"}
+	profileParam       = Param{Name: "profile", Type: "string", Description: "Named state of a consumer, see profiles"}
+	dryRunParam        = Param{Name: "dry-run", Type: "boolean", Description: "Report what would change without saving"}
+	formatParam        = Param{Name: "format", Type: "string", Description: "json (default) or csv for type,path,old_path,is_dir,size,modified rows"}
+	jobIDParam         = Param{Name: "id", Type: "string", Description: "Job ID returned by POST /diff?async=true", InPath: true}
+	accountParam       = Param{Name: "account", Type: "string", Description: "Account of config.json to use instead of the top-level one, see accounts"}
+)
+
+// Routes returns every route of the API with its documentation; main
+// registers them and /openapi.json is generated from them, so they can't drift
+func (h *Handlers) Routes() []Route {
+	return []Route{
+		{Path: "/health", Handler: h.Health, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Health check, degraded while the WebDAV circuit breaker is open",
+			Response: HealthResponse{}, Public: true,
+		}}},
+		{Path: "/healthz", Handler: h.Healthz, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Liveness probe: the process is up",
+			Response: StatusResponse{}, Public: true,
+		}}},
+		{Path: "/readyz", Handler: h.Readyz, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Readiness probe: WebDAV reachable with valid credentials and state writable, with each check's latency",
+			Response: ReadyResponse{}, Errors: []int{http.StatusServiceUnavailable}, Public: true,
+		}}},
+		{Path: "/version", Handler: h.Version, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Version, commit and build date of the running build",
+			Response: version.Info{}, Public: true,
+		}}},
+		{Path: "/metrics", Handler: h.Metrics, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "WebDAV client request statistics",
+			Response: MetricsResponse{},
+		}}},
+		{Path: "/capabilities", Handler: h.Capabilities, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Cached capabilities of the Nextcloud server",
+			Params:   []Param{{Name: "refresh", Type: "boolean", Description: "Fetch them again from the server"}},
+			Response: webdav.Capabilities{}, Errors: []int{http.StatusBadGateway},
+		}}},
+		{Path: "/diff", Handler: h.Diff, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Detect changes since the last run, a time, a snapshot or replay a cursor; 207 when some directories failed",
+			Params: []Param{
+				{Name: "path", Type: "string", Description: "Directory to diff, instead of paths in the body"},
+				includeHiddenParam, favoritesOnlyParam, maxDepthParam,
+				{Name: "since", Type: "string", Description: "RFC 3339 time to report changes since"},
+				{Name: "from", Type: "string", Description: "Snapshot to compare from"},
+				{Name: "to", Type: "string", Description: "Snapshot to compare to (requires from)"},
+				profileParam,
+				{Name: "cursor", Type: "integer", Description: "Replay the results of an earlier run"},
+				{Name: "wait", Type: "string", Description: "true to wait for a diff in progress instead of failing with 409, or a duration (e.g. 60s) to long-poll for changes"},
+				{Name: "async", Type: "boolean", Description: "Run the diff as a background job and answer 202 with it, see /jobs/{id}"},
+				dryRunParam,
+				{Name: "collapse-deletes", Type: "boolean", Description: "Report a deleted directory as a single change"},
+				{Name: "types", Type: "string", Description: "Comma-separated change types to include"},
+				{Name: "pattern", Type: "string", Description: "Glob the changed paths must match", Repeated: true},
+				{Name: "min-size", Type: "integer", Description: "Smallest file size to include, in bytes"},
+				{Name: "max-size", Type: "integer", Description: "Largest file size to include, in bytes"},
+				{Name: "files-only", Type: "boolean"},
+				{Name: "dirs-only", Type: "boolean"},
+				formatParam,
+				{Name: "limit", Type: "integer", Description: "Return at most this many changes, with the Next-Page-Token header when more remain"},
+				{Name: "offset", Type: "integer", Description: "Skip this many changes of the run given by cursor"},
+				{Name: "page-token", Type: "string", Description: "Next-Page-Token of the previous page"},
+			},
+			Body: DiffRequest{}, Response: []diff.Changes{},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestEntityTooLarge, http.StatusConflict, http.StatusServiceUnavailable},
+		}}},
+		{Path: "/jobs/{id}", Handler: h.Job, Operations: []Operation{
+			{
+				Method: http.MethodGet, Summary: "Status and progress of a background diff",
+				Params:   []Param{jobIDParam},
+				Response: diff.Job{}, Errors: []int{http.StatusNotFound},
+			},
+			{
+				Method: http.MethodDelete, Summary: "Cancel a running background diff without saving its state; 202 while it is still stopping",
+				Params:   []Param{jobIDParam},
+				Response: diff.Job{}, Errors: []int{http.StatusNotFound, http.StatusConflict},
+			},
+		}},
+		{Path: "/jobs/{id}/result", Handler: h.JobResult, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Changes found by a succeeded background diff; 207 when some directories failed",
+			Params:   []Param{jobIDParam},
+			Response: []diff.Changes{}, Errors: []int{http.StatusNotFound, http.StatusConflict},
+		}}},
+		{Path: "/schedules", Handler: h.Schedules, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Configured diff schedules with their next and last runs",
+			Response: SchedulesResponse{},
+		}}},
+		{Path: "/schedules/trigger", Handler: h.ScheduleTrigger, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Run the diff of a schedule now, in the background",
+			Params: []Param{{Name: "name", Type: "string", Required: true, Description: "Schedule name: a directory with its own schedule, or default"}},
+			Status: http.StatusAccepted, Response: schedule.Status{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict},
+		}}},
+		{Path: "/webhooks/deliveries", Handler: h.WebhookDeliveries, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Recent webhook deliveries and their status, newest first",
+			Params: []Param{
+				{Name: "webhook", Type: "string", Description: "Only deliveries to this webhook"},
+				{Name: "status", Type: "string", Description: "Only deliveries with this status: pending, delivered or failed"},
+			},
+			Response: DeliveriesResponse{}, Errors: []int{http.StatusBadRequest},
+		}}},
+		{Path: "/config", Handler: h.Config, Operations: []Operation{
+			{
+				Method: http.MethodGet, Summary: "Running configuration, with passwords, keys and secrets redacted",
+				Response: config.Config{}, Errors: []int{http.StatusNotFound},
+			},
+			{
+				Method: http.MethodPut, Summary: "Change the directories, schedule or include/exclude filters, saved to config.json",
+				Body: ConfigUpdate{}, Response: config.Config{},
+				Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+			},
+		}},
+		{Path: "/ls", Handler: h.List, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "List a directory",
+			Params: []Param{
+				{Name: "path", Type: "string", Description: "Directory to list (default /)"},
+				includeHiddenParam, favoritesOnlyParam, maxDepthParam,
+				{Name: "recursive", Type: "boolean", Description: "Walk the whole tree in path order, in pages"},
+				{Name: "limit", Type: "integer", Description: "Items per page of a recursive listing (default 1000, at most 10000)"},
+				{Name: "page-token", Type: "string", Description: "next_page_token of the previous page"},
+			},
+			Response: ListResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+		}}},
+		{Path: "/history", Handler: h.History, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Journaled changes",
+			Params: []Param{
+				{Name: "path", Type: "string", Description: "Only changes under this path"},
+				{Name: "since", Type: "string", Description: "RFC 3339 start of the time range"},
+				{Name: "until", Type: "string", Description: "RFC 3339 end of the time range"},
+				formatParam,
+			},
+			Response: HistoryResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}}},
+		{Path: "/snapshots", Handler: h.Snapshots, Operations: []Operation{
+			{
+				Method: http.MethodGet, Summary: "List snapshots",
+				Response: SnapshotsResponse{}, Errors: []int{http.StatusNotFound},
+			},
+			{
+				Method: http.MethodPost, Summary: "Take a snapshot of directories",
+				Params: []Param{
+					{Name: "name", Type: "string"},
+					{Name: "path", Type: "string", Description: "Directory to snapshot, instead of paths in the body"},
+					includeHiddenParam,
+				},
+				Body: SnapshotRequest{}, Status: http.StatusCreated, Response: diff.SnapshotInfo{},
+				Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict},
+			},
+			{
+				Method: http.MethodDelete, Summary: "Delete a snapshot",
+				Params:   []Param{{Name: "name", Type: "string", Required: true}},
+				Response: StatusResponse{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+			},
+		}},
+		{Path: "/ack", Handler: h.Ack, Operations: []Operation{
+			{
+				Method: http.MethodGet, Summary: "List acknowledged file versions",
+				Params:   []Param{{Name: "path", Type: "string", Description: "Only acknowledgements under this path"}},
+				Response: AcksResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+			},
+			{
+				Method: http.MethodPost, Summary: "Acknowledge or remove file versions",
+				Body: AckRequest{}, Response: AckResult{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+			},
+			{
+				Method: http.MethodDelete, Summary: "Remove the acknowledgement of a file",
+				Params:   []Param{{Name: "path", Type: "string", Required: true}},
+				Response: StatusResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+			},
+		}},
+		{Path: "/state/compact", Handler: h.CompactState, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Drop the state of directories that are no longer tracked",
+			Params: []Param{
+				{Name: "keep", Type: "string", Description: "Tracked directory whose state is kept", Repeated: true},
+				dryRunParam,
+			},
+			Body: CompactRequest{}, Response: diff.CompactResult{},
+			Errors: []int{http.StatusBadRequest, http.StatusConflict, http.StatusServiceUnavailable},
+		}}},
+		{Path: "/state/reset", Handler: h.ResetState, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Drop the state of one directory, so the next diff starts over",
+			Params:   []Param{{Name: "path", Type: "string", Required: true}, profileParam},
+			Response: diff.ResetResult{},
+			Errors:   []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable},
+		}}},
+		{Path: "/state/summary", Handler: h.StateSummary, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "File counts, sizes and last scan of each tracked directory in the state",
+			Params:   []Param{profileParam},
+			Response: diff.StateSummary{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}}},
+		{Path: "/state/export", Handler: h.ExportState, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Download the whole state, to import it elsewhere",
+			Params:   []Param{profileParam},
+			Response: diff.State{}, Errors: []int{http.StatusBadRequest, http.StatusNotFound},
+		}}},
+		{Path: "/state/import", Handler: h.ImportState, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Replace the state with an exported one",
+			Params: []Param{profileParam},
+			Body:   diff.State{}, Response: diff.ImportResult{},
+			Errors: []int{http.StatusBadRequest, http.StatusNotFound, http.StatusConflict, http.StatusServiceUnavailable},
+		}}},
+		{Path: "/stat", Handler: h.Stat, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Details of one file or directory",
+			Params:   []Param{{Name: "path", Type: "string", Required: true}},
+			Response: webdav.FileDetails{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusBadGateway},
+		}}},
+		{Path: "/download", Handler: h.Download, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Stream a file, or the byte range of the Range header (206)",
+			Params:      []Param{{Name: "path", Type: "string", Required: true}},
+			ContentType: "application/octet-stream",
+			Errors:      []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusRequestedRangeNotSatisfiable, http.StatusBadGateway},
+		}}},
+		{Path: "/upload", Handler: h.Upload, Operations: []Operation{
+			{
+				Method: http.MethodPut, Summary: "Store the raw body as a file; big files are sent to Nextcloud in chunks",
+				Params:   []Param{{Name: "path", Type: "string", Required: true}},
+				Response: UploadResponse{},
+				Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge, http.StatusBadGateway},
+			},
+			{
+				Method: http.MethodPost, Summary: "Store the raw body, or the file field of a multipart form, as a file",
+				Params:   []Param{{Name: "path", Type: "string", Required: true, Description: "File to write, or directory ending with / to keep the form's file name"}},
+				Response: UploadResponse{},
+				Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusPreconditionFailed, http.StatusRequestEntityTooLarge, http.StatusBadGateway},
+			},
+		}},
+		{Path: "/files", Handler: h.DeleteFile, Operations: []Operation{{
+			Method: http.MethodDelete, Summary: "Delete a file or directory, to the trashbin unless permanent",
+			Params: []Param{
+				{Name: "path", Type: "string", Required: true},
+				{Name: "recursive", Type: "boolean", Description: "Confirm deleting a directory with its contents"},
+				{Name: "permanent", Type: "boolean", Description: "Purge it from the trashbin too"},
+			},
+			Response: StatusResponse{},
+			Errors:   []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway},
+		}}},
+		{Path: "/mkdir", Handler: h.Mkdir, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Create a directory; 200 when parents=true finds it already there",
+			Params: []Param{
+				{Name: "path", Type: "string", Required: true},
+				{Name: "parents", Type: "boolean", Description: "Create missing parent directories too, and accept an existing directory"},
+			},
+			Status: http.StatusCreated, Response: StatusResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway},
+		}}},
+		{Path: "/move", Handler: h.Move, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Move or rename a file or directory, moving its diff state along so the next diff doesn't report it",
+			Body: MoveRequest{}, Response: MoveResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway},
+		}}},
+		{Path: "/copy", Handler: h.Copy, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Copy a file, or a directory with its contents",
+			Body: CopyRequest{}, Status: http.StatusCreated, Response: CopyResponse{},
+			Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusConflict, http.StatusBadGateway},
+		}}},
+		{Path: "/preview", Handler: h.Preview, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "Thumbnail of a file",
+			Params: []Param{
+				{Name: "path", Type: "string", Required: true},
+				{Name: "w", Type: "integer", Description: "Width in pixels (default 256)"},
+				{Name: "h", Type: "integer", Description: "Height in pixels (default 256)"},
+			},
+			ContentType: "image/*", Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound, http.StatusBadGateway},
+		}}},
+		{Path: "/trash", Handler: h.Trash, Operations: []Operation{
+			{
+				Method: http.MethodGet, Summary: "List trashbin items",
+				Response: TrashResponse{},
+			},
+			{
+				Method: http.MethodDelete, Summary: "Permanently delete a trashbin item, or empty the trashbin without a name",
+				Params: []Param{{Name: "name", Type: "string"}},
+				Status: http.StatusNoContent, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+			},
+		}},
+		{Path: "/trash/restore", Handler: h.TrashRestore, Operations: []Operation{{
+			Method: http.MethodPost, Summary: "Restore a trashbin item to its original location",
+			Params:   []Param{{Name: "name", Type: "string", Required: true}},
+			Response: StatusResponse{}, Errors: []int{http.StatusBadRequest, http.StatusForbidden, http.StatusNotFound},
+		}}},
+		{Path: "/ws", Handler: h.WebSocket, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "WebSocket pushing the change batches of every saved diff run under the subscribed path prefixes",
+			Params: []Param{{Name: "api_key", Type: "string", Description: "API key, for browsers, which can't set headers on WebSocket handshakes"}},
+			Status: http.StatusSwitchingProtocols, Errors: []int{http.StatusBadRequest, http.StatusForbidden},
+		}}},
+		{Path: "/openapi.json", Handler: h.OpenAPI, Operations: []Operation{{
+			Method: http.MethodGet, Summary: "This OpenAPI document, not wrapped in an envelope",
+			ContentType: "application/json", Public: true,
+		}}},
+	}
+}
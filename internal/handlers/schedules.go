@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/schedule"
+)
+
+// SchedulesResponse is the body of GET /schedules
+type SchedulesResponse struct {
+	Schedules []schedule.Status `json:"schedules"`
+}
+
+// Schedules lists the configured schedules with their next and last runs
+func (h *Handlers) Schedules(w http.ResponseWriter, r *http.Request) {
+	schedules := []schedule.Status{}
+	if h.scheduler != nil {
+		schedules = h.scheduler.List()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(SchedulesResponse{
+		Schedules: schedules,
+	})
+}
+
+// ScheduleTrigger runs the diff of a schedule now, in the background
+func (h *Handlers) ScheduleTrigger(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		httpError(w, r, "missing 'name' query parameter", http.StatusBadRequest)
+		return
+	}
+	if h.scheduler == nil {
+		httpError(w, r, fmt.Sprintf("Failed to trigger schedule: %v: %s", schedule.ErrUnknownSchedule, name), http.StatusNotFound)
+		return
+	}
+
+	status, err := h.scheduler.Trigger(name)
+	if err != nil {
+		logger(r).Warn("Failed to trigger schedule", "name", name, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to trigger schedule: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger(r).Info("Schedule triggered", "name", name)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(status)
+}
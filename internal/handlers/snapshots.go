@@ -0,0 +1,115 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+// SnapshotRequest is the body of POST /snapshots
+type SnapshotRequest struct {
+	Name          string   `json:"name"`
+	Paths         []string `json:"paths"`
+	IncludeHidden bool     `json:"include-hidden"`
+}
+
+// SnapshotsResponse is the body of GET /snapshots
+type SnapshotsResponse struct {
+	Snapshots []diff.SnapshotInfo `json:"snapshots"`
+}
+
+// Snapshots lists (GET), takes (POST) or deletes (DELETE) named snapshots
+func (h *Handlers) Snapshots(w http.ResponseWriter, r *http.Request) {
+	store := h.detectorFor(r).Snapshots()
+	if store == nil {
+		httpError(w, r, "Snapshots are disabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		snapshots, err := store.List()
+		if err != nil {
+			logger(r).Error("Failed to list snapshots", "error", err)
+			httpError(w, r, fmt.Sprintf("Failed to list snapshots: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(SnapshotsResponse{
+			Snapshots: snapshots,
+		})
+
+	case http.MethodPost:
+		req := &SnapshotRequest{}
+		if r.ContentLength > 0 {
+			if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+				invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+				return
+			}
+		}
+		query := r.URL.Query()
+		if name := query.Get("name"); name != "" {
+			req.Name = name
+		}
+		if path := query.Get("path"); path != "" {
+			req.Paths = []string{path}
+		}
+		if query.Get("include-hidden") == "true" {
+			req.IncludeHidden = true
+		} else if query.Get("include-hidden") == "false" {
+			req.IncludeHidden = false
+		}
+		if req.Name == "" || len(req.Paths) == 0 {
+			httpError(w, r, "a snapshot needs a 'name' and at least one path ('path' query parameter or 'paths' in the body)", http.StatusBadRequest)
+			return
+		}
+		for i, p := range req.Paths {
+			cleaned, err := h.resolvePath(r, fmt.Sprintf("paths[%d]", i), p)
+			if err != nil {
+				pathError(w, r, err)
+				return
+			}
+			req.Paths[i] = cleaned
+		}
+
+		runCtx, cancel := runContext(r)
+		defer cancel()
+		info, err := h.detectorFor(r).TakeSnapshot(req.Name, req.Paths, diff.DetectOptions{
+			IncludeHidden: req.IncludeHidden,
+			Progress:      logProgress(logger(r), 5*time.Second),
+			Context:       runCtx,
+		})
+		if err != nil {
+			logger(r).Error("Failed to take snapshot", "name", req.Name, "error", err)
+			failWith(w, r, err, fmt.Sprintf("Failed to take snapshot: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if name == "" {
+			httpError(w, r, "missing 'name' query parameter", http.StatusBadRequest)
+			return
+		}
+		if err := store.Delete(name); err != nil {
+			logger(r).Error("Failed to delete snapshot", "name", name, "error", err)
+			failWith(w, r, err, fmt.Sprintf("Failed to delete snapshot: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(StatusResponse{
+			Status: "deleted",
+			Name:   name,
+		})
+
+	}
+}
@@ -0,0 +1,125 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/diff"
+)
+
+// CompactRequest is the body of POST /state/compact
+type CompactRequest struct {
+	// Keep lists the tracked directories whose state is kept
+	Keep   []string `json:"keep"`
+	DryRun bool     `json:"dry-run"`
+}
+
+// CompactState drops the state of directories that are no longer tracked
+func (h *Handlers) CompactState(w http.ResponseWriter, r *http.Request) {
+	req := &CompactRequest{}
+	if r.ContentLength > 0 {
+		if err := json.NewDecoder(r.Body).Decode(req); err != nil {
+			invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+			return
+		}
+	}
+	query := r.URL.Query()
+	if keep := query["keep"]; len(keep) > 0 {
+		req.Keep = keep
+	}
+	if query.Get("dry-run") == "true" {
+		req.DryRun = true
+	}
+	// An empty list would wipe the whole state, which /state/compact is not for
+	if len(req.Keep) == 0 {
+		httpError(w, r, "list the tracked directories to keep ('keep' query parameter or body field)", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.detectorFor(r).CompactState(req.Keep, req.DryRun)
+	if err != nil {
+		logger(r).Error("Failed to compact state", "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to compact state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// ResetState drops the stored state of one tracked directory
+func (h *Handlers) ResetState(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	path := query.Get("path")
+	if path == "" {
+		httpError(w, r, "missing 'path' query parameter", http.StatusBadRequest)
+		return
+	}
+
+	result, err := h.detectorFor(r).ResetState(path, query.Get("profile"))
+	if err != nil {
+		logger(r).Error("Failed to reset state", "directory", path, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to reset state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
+
+// StateSummary counts the stored files and directories, per tracked directory
+func (h *Handlers) StateSummary(w http.ResponseWriter, r *http.Request) {
+	profile := r.URL.Query().Get("profile")
+	summary, err := h.detectorFor(r).StateSummary(profile)
+	if err != nil {
+		logger(r).Error("Failed to summarize state", "profile", profile, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to summarize state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(summary)
+}
+
+// ExportState sends the whole stored state as a JSON attachment, for
+// POST /state/import on another host
+func (h *Handlers) ExportState(w http.ResponseWriter, r *http.Request) {
+	profile := r.URL.Query().Get("profile")
+	state, err := h.detectorFor(r).ExportState(profile)
+	if err != nil {
+		logger(r).Error("Failed to export state", "profile", profile, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to export state: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	filename := "state.json"
+	if profile != "" {
+		filename = "state-" + profile + ".json"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", filename))
+	json.NewEncoder(w).Encode(state)
+}
+
+// ImportState replaces the stored state with the one in the body, as sent by
+// GET /state/export
+func (h *Handlers) ImportState(w http.ResponseWriter, r *http.Request) {
+	state := &diff.State{}
+	if err := json.NewDecoder(r.Body).Decode(state); err != nil {
+		invalidBody(w, r, err, fmt.Sprintf("invalid request body: %v", err))
+		return
+	}
+
+	profile := r.URL.Query().Get("profile")
+	result, err := h.detectorFor(r).ImportState(profile, state)
+	if err != nil {
+		logger(r).Error("Failed to import state", "profile", profile, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to import state: %v", err), http.StatusInternalServerError)
+		return
+	}
+	logger(r).Info("State imported", "audit", true, "profile", profile, "directories", result.Directories, "files", result.Files)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
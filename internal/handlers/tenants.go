@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/middleware"
+	"go-nc-client/internal/tenant"
+	"go-nc-client/internal/webdav"
+)
+
+// configuredAccountRoutes serve the top-level account of config.json
+// whatever the request asks for, so they refuse the account parameter and
+// per-request credentials
+var configuredAccountRoutes = map[string]bool{
+	"/metrics":             true,
+	"/schedules":           true,
+	"/schedules/trigger":   true,
+	"/webhooks/deliveries": true,
+	"/config":              true,
+	"/ws":                  true,
+}
+
+// Handler serves route for the account of config.json named by the account
+// query parameter, or the one whose credentials the request sends in
+// X-Nextcloud-User and X-Nextcloud-Password, the top-level one without either
+// Inside authentication: an API key is still needed to use the service.
+func (h *Handlers) Handler(rt Route) http.Handler {
+	next := rt.MethodHandler()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		username, password := r.Header.Get(tenant.UserHeader), r.Header.Get(tenant.PasswordHeader)
+		name := r.URL.Query().Get("account")
+		switch {
+		case name != "" && (username != "" || password != ""):
+			httpError(w, r, "'account' can't be combined with per-request credentials", http.StatusBadRequest)
+		case name != "" && configuredAccountRoutes[rt.Path]:
+			httpError(w, r, rt.Path+" only serves the top-level account, it takes no 'account'", http.StatusBadRequest)
+		case name != "":
+			t, ok := h.accounts[name]
+			if !ok {
+				failWith(w, r, fmt.Errorf("%w: %s", tenant.ErrUnknownAccount, name), fmt.Sprintf("Unknown account %q", name), http.StatusNotFound)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), t)))
+		case username == "" && password == "":
+			next.ServeHTTP(w, r)
+		case username == "" || password == "":
+			httpError(w, r, tenant.ErrIncompleteCredentials.Error(), http.StatusBadRequest)
+		case h.tenants == nil:
+			httpError(w, r, "Per-request credentials are disabled (see tenants in config.json)", http.StatusBadRequest)
+		case configuredAccountRoutes[rt.Path]:
+			httpError(w, r, rt.Path+" only serves the top-level account, it takes no per-request credentials", http.StatusBadRequest)
+		default:
+			t, err := h.tenants.Get(username, password)
+			if errors.Is(err, webdav.ErrUnauthorized) {
+				logger(r).Warn("Rejected per-request credentials", "user", username, "error", err)
+				failWith(w, r, err, "Nextcloud rejected the credentials", http.StatusUnauthorized)
+				return
+			}
+			if err != nil {
+				logger(r).Error("Failed to set up account", "user", username, "error", err)
+				failWith(w, r, err, fmt.Sprintf("Failed to set up account: %v", err), http.StatusInternalServerError)
+				return
+			}
+			next.ServeHTTP(w, r.WithContext(tenant.NewContext(r.Context(), t)))
+		}
+	})
+}
+
+// clientFor returns the WebDAV client of the account of r, bound to its
+// context so its requests stop when the caller goes away
+func (h *Handlers) clientFor(r *http.Request) *webdav.Client {
+	if t := tenant.FromContext(r.Context()); t != nil {
+		return t.Client.WithContext(r.Context())
+	}
+	return h.client.WithContext(r.Context())
+}
+
+// detectorFor returns the detector of the account of r
+func (h *Handlers) detectorFor(r *http.Request) *diff.Detector {
+	if t := tenant.FromContext(r.Context()); t != nil {
+		return t.Detector
+	}
+	return h.detector
+}
+
+// accountName returns the name of the account of config.json r is for, empty
+// for the top-level account and per-request credentials
+func accountName(r *http.Request) string {
+	if t := tenant.FromContext(r.Context()); t != nil {
+		return t.Name
+	}
+	return ""
+}
+
+// logger returns the logger of a request, whose lines carry the request ID
+// and the account when it isn't the top-level one
+func logger(r *http.Request) *slog.Logger {
+	l := middleware.Logger(r.Context())
+	if t := tenant.FromContext(r.Context()); t != nil {
+		l = l.With("account", t.ID)
+	}
+	return l
+}
@@ -0,0 +1,142 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode"
+
+	"go-nc-client/internal/webdav"
+)
+
+// TrashResponse is the body of GET /trash
+type TrashResponse struct {
+	Items []webdav.TrashItem `json:"items"`
+}
+
+// checkTrashName rejects names that aren't a single item of the trashbin, so
+// they can't reach other paths of the server
+func checkTrashName(name string) error {
+	switch {
+	case strings.ContainsAny(name, `/\`):
+		return errors.New("must be the name of a trashbin item, not a path")
+	case strings.Contains(name, ".."):
+		return errors.New("must not contain '..'")
+	case strings.ContainsFunc(name, unicode.IsControl):
+		return errors.New("contains control characters")
+	}
+	return nil
+}
+
+// allowTrashItem rejects a trashbin item whose original location is outside
+// the roots of restrict_to_directories
+func (h *Handlers) allowTrashItem(r *http.Request, client *webdav.Client, name string) error {
+	if h.configs.AllowedRoots(accountName(r)) == nil {
+		return nil
+	}
+	items, err := client.ListTrash()
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		if item.Name == name {
+			return h.allowPath(r, item.OriginalLocation)
+		}
+	}
+	return fmt.Errorf("%w: trashbin item %s", webdav.ErrNotFound, name)
+}
+
+// Trash lists trashbin items (GET) or permanently deletes them (DELETE)
+// DELETE without a name empties the whole trashbin. With
+// restrict_to_directories, only items deleted from the allowed directories
+// are listed and deleted, and the trashbin can't be emptied.
+func (h *Handlers) Trash(w http.ResponseWriter, r *http.Request) {
+	client := h.clientFor(r)
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		items, err := client.ListTrash()
+		if err != nil {
+			logger(r).Error("Failed to list trashbin", "error", err)
+			httpError(w, r, fmt.Sprintf("Failed to list trashbin: %v", err), http.StatusInternalServerError)
+			return
+		}
+		allowed := items[:0]
+		for _, item := range items {
+			if h.allowPath(r, item.OriginalLocation) == nil {
+				allowed = append(allowed, item)
+			}
+		}
+		items = allowed
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(TrashResponse{
+			Items: items,
+		})
+
+	case http.MethodDelete:
+		name := r.URL.Query().Get("name")
+		if err := checkTrashName(name); err != nil {
+			writeInvalid(w, r, fieldError("name", "%v", err))
+			return
+		}
+
+		var err error
+		if name == "" {
+			if h.configs.AllowedRoots(accountName(r)) != nil {
+				err = fmt.Errorf("%w: emptying the whole trashbin, delete items by name instead", errPathNotAllowed)
+			} else {
+				err = client.EmptyTrash()
+			}
+		} else if err = h.allowTrashItem(r, client, name); err == nil {
+			err = client.PurgeTrash(name)
+		}
+		if errors.Is(err, errPathNotAllowed) {
+			pathError(w, r, err)
+			return
+		}
+		if err != nil {
+			logger(r).Error("Failed to purge trashbin item", "name", name, "error", err)
+			failWith(w, r, err, fmt.Sprintf("Failed to purge trashbin: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+
+	}
+}
+
+// TrashRestore restores a trashbin item to its original location
+func (h *Handlers) TrashRestore(w http.ResponseWriter, r *http.Request) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		httpError(w, r, "missing 'name' query parameter", http.StatusBadRequest)
+		return
+	}
+	if err := checkTrashName(name); err != nil {
+		writeInvalid(w, r, fieldError("name", "%v", err))
+		return
+	}
+
+	client := h.clientFor(r)
+	err := h.allowTrashItem(r, client, name)
+	if errors.Is(err, errPathNotAllowed) {
+		pathError(w, r, err)
+		return
+	}
+	if err == nil {
+		err = client.RestoreTrash(name)
+	}
+	if err != nil {
+		logger(r).Error("Failed to restore trashbin item", "name", name, "error", err)
+		failWith(w, r, err, fmt.Sprintf("Failed to restore item: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(StatusResponse{
+		Status: "restored",
+		Name:   name,
+	})
+}
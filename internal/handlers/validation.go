@@ -0,0 +1,197 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"go-nc-client/internal/middleware"
+)
+
+const (
+	// maxPathLength bounds the paths a request may name, in bytes
+	maxPathLength = 4096
+	// maxDiffPaths bounds the directories of one /diff
+	maxDiffPaths = 100
+	// maxDiffPatterns bounds the pattern filters of one /diff
+	maxDiffPatterns = 100
+)
+
+// FieldError is one invalid input of a request
+type FieldError struct {
+	// Field is the query parameter or body field, e.g. "paths[2]"; empty
+	// when the error is about the request as a whole
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+// ValidationErrorResponse is the body of the 400 of a request with invalid inputs
+type ValidationErrorResponse struct {
+	middleware.ErrorResponse
+	Errors []FieldError `json:"errors"`
+}
+
+// ValidationError lists the invalid inputs of a request
+type ValidationError []FieldError
+
+func (e ValidationError) Error() string {
+	messages := make([]string, len(e))
+	for i, fe := range e {
+		messages[i] = fe.Message
+		if fe.Field != "" {
+			messages[i] = fe.Field + ": " + fe.Message
+		}
+	}
+	return strings.Join(messages, "; ")
+}
+
+// fieldError returns a ValidationError of one field
+func fieldError(field, format string, args ...any) ValidationError {
+	return ValidationError{{Field: field, Message: fmt.Sprintf(format, args...)}}
+}
+
+// checkPath rejects paths that can't name a file on the server
+func checkPath(p string) error {
+	switch {
+	case p == "":
+		return errors.New("must not be empty")
+	case len(p) > maxPathLength:
+		return fmt.Errorf("longer than %d bytes", maxPathLength)
+	case !utf8.ValidString(p):
+		return errors.New("not valid UTF-8")
+	case strings.ContainsFunc(p, unicode.IsControl):
+		return errors.New("contains control characters")
+	case strings.Contains(p, `\`):
+		return errors.New("contains a backslash")
+	}
+	for _, segment := range strings.Split(p, "/") {
+		if segment == ".." {
+			return errors.New("contains a '..' segment")
+		}
+	}
+	return nil
+}
+
+// errPathNotAllowed is returned for paths outside the configured directories
+// when restrict_to_directories is set
+var errPathNotAllowed = errors.New("path outside the directories the API may access")
+
+// cleanPath checks p with checkPath and makes it absolute, without '.'
+// segments or repeated slashes; a trailing slash is kept
+func cleanPath(p string) (string, error) {
+	if err := checkPath(p); err != nil {
+		return "", err
+	}
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned, nil
+}
+
+// allowPath rejects a path outside the roots of restrict_to_directories for
+// the account of r
+func (h *Handlers) allowPath(r *http.Request, p string) error {
+	roots := h.configs.AllowedRoots(accountName(r))
+	if roots == nil {
+		return nil
+	}
+	p = path.Clean("/" + p)
+	for _, root := range roots {
+		if root == "/" || p == root || strings.HasPrefix(p, root+"/") {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s", errPathNotAllowed, p)
+}
+
+// resolvePath returns the clean form of the path given as field, failing
+// with a ValidationError when it is invalid and errPathNotAllowed when it is
+// out of bounds
+func (h *Handlers) resolvePath(r *http.Request, field, p string) (string, error) {
+	cleaned, err := cleanPath(p)
+	if err != nil {
+		return "", fieldError(field, "%v", err)
+	}
+	if err := h.allowPath(r, cleaned); err != nil {
+		return "", err
+	}
+	return cleaned, nil
+}
+
+// pathParam returns the required path query parameter, see resolvePath
+func (h *Handlers) pathParam(r *http.Request) (string, error) {
+	p := r.URL.Query().Get("path")
+	if p == "" {
+		return "", fieldError("path", "missing 'path' query parameter")
+	}
+	return h.resolvePath(r, "path", p)
+}
+
+// pathError answers a request whose path failed resolvePath
+func pathError(w http.ResponseWriter, r *http.Request, err error) {
+	if errors.Is(err, errPathNotAllowed) {
+		failWith(w, r, err, err.Error(), http.StatusForbidden)
+		return
+	}
+	writeInvalid(w, r, err)
+}
+
+// validate checks the paths and the counts of a diff request
+func (req *DiffRequest) validate() error {
+	var errs ValidationError
+	if len(req.Paths) > maxDiffPaths {
+		errs = append(errs, FieldError{Field: "paths", Message: fmt.Sprintf("at most %d paths per diff, got %d", maxDiffPaths, len(req.Paths))})
+	}
+	for i, p := range req.Paths {
+		if err := checkPath(p); err != nil {
+			errs = append(errs, FieldError{Field: fmt.Sprintf("paths[%d]", i), Message: err.Error()})
+		}
+	}
+	if len(req.Patterns) > maxDiffPatterns {
+		errs = append(errs, FieldError{Field: "patterns", Message: fmt.Sprintf("at most %d patterns per diff, got %d", maxDiffPatterns, len(req.Patterns))})
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// tooLarge reports whether err comes from reading past the body limit
+func tooLarge(err error) bool {
+	var maxBytes *http.MaxBytesError
+	return errors.As(err, &maxBytes)
+}
+
+// invalidBody answers a request whose body couldn't be read or decoded with
+// message, or with 413 when the body is over the limit
+func invalidBody(w http.ResponseWriter, r *http.Request, err error, message string) {
+	var maxBytes *http.MaxBytesError
+	if errors.As(err, &maxBytes) {
+		middleware.WriteError(w, r, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", fmt.Sprintf("Request body larger than %d bytes", maxBytes.Limit))
+		return
+	}
+	middleware.WriteError(w, r, http.StatusBadRequest, "INVALID_BODY", message)
+}
+
+// writeInvalid answers 400 with the invalid inputs of err as a
+// ValidationErrorResponse, or 413 when the body is over the limit
+// An err that isn't a ValidationError is reported as one about the whole request.
+func writeInvalid(w http.ResponseWriter, r *http.Request, err error) {
+	if tooLarge(err) {
+		invalidBody(w, r, err, "")
+		return
+	}
+	var invalid ValidationError
+	if !errors.As(err, &invalid) {
+		invalid = ValidationError{{Message: err.Error()}}
+	}
+	middleware.WriteErrorResponse(w, http.StatusBadRequest, ValidationErrorResponse{
+		ErrorResponse: middleware.NewErrorResponse(r, "INVALID_INPUT", invalid.Error()),
+		Errors:        invalid,
+	})
+}
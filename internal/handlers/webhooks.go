@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/webhook"
+)
+
+// DeliveriesResponse is the body of GET /webhooks/deliveries
+type DeliveriesResponse struct {
+	Deliveries []webhook.Delivery `json:"deliveries"`
+}
+
+// WebhookDeliveries lists the recent webhook deliveries, newest first
+func (h *Handlers) WebhookDeliveries(w http.ResponseWriter, r *http.Request) {
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed:
+	default:
+		httpError(w, r, fmt.Sprintf("invalid status %q: must be %s, %s or %s", status, webhook.StatusPending, webhook.StatusDelivered, webhook.StatusFailed), http.StatusBadRequest)
+		return
+	}
+
+	deliveries := []webhook.Delivery{}
+	if h.webhooks != nil {
+		deliveries = h.webhooks.Deliveries(r.URL.Query().Get("webhook"), status)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(DeliveriesResponse{
+		Deliveries: deliveries,
+	})
+}
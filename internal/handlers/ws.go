@@ -0,0 +1,30 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+
+	"go-nc-client/internal/stream"
+)
+
+// WebSocket streams the changes of saved diff runs to a client, which
+// subscribes to path prefixes and can pause the stream or filter it
+func (h *Handlers) WebSocket(w http.ResponseWriter, r *http.Request) {
+	conn, err := stream.Upgrade(w, r, h.configs.AllowedOrigins())
+	if errors.Is(err, stream.ErrOriginNotAllowed) {
+		logger(r).Warn("Refused WebSocket from another origin", "origin", r.Header.Get("Origin"))
+		httpError(w, r, fmt.Sprintf("WebSocket refused: %v", err), http.StatusForbidden)
+		return
+	}
+	if errors.Is(err, stream.ErrNotWebSocket) {
+		w.Header().Set("Sec-WebSocket-Version", "13")
+		httpError(w, r, fmt.Sprintf("Expected a WebSocket handshake: %v", err), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		logger(r).Error("Failed to upgrade to WebSocket", "error", err)
+		return
+	}
+	h.stream.Serve(conn, logger(r))
+}
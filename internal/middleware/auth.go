@@ -0,0 +1,135 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// Scopes an API key or route can have; admin keys may call read routes too
+const (
+	// ScopePublic routes need no key
+	ScopePublic = "public"
+	// ScopeRead covers listing and diffing
+	ScopeRead = "read"
+	// ScopeAdmin covers changing state, files and settings
+	ScopeAdmin = "admin"
+)
+
+// APIKey is a key clients send as "Authorization: Bearer <key>" or "X-API-Key: <key>"
+// Browsers can't set headers on WebSocket handshakes, which may pass it as
+// the api_key query parameter instead.
+type APIKey struct {
+	// Name identifies the key in audit logs
+	Name  string
+	Key   string
+	Scope string
+}
+
+type apiKeyNameKey struct{}
+
+// APIKeyNameFrom returns the name of the key the request was authorized
+// with, empty for public routes or without authentication
+func APIKeyNameFrom(ctx context.Context) string {
+	name, _ := ctx.Value(apiKeyNameKey{}).(string)
+	return name
+}
+
+// Auth rejects requests whose API key lacks the scope of the route
+type Auth struct {
+	keys atomic.Pointer[[]APIKey]
+	// routes maps "METHOD /path" or "/path" to the scope it needs, "/path/"
+	// covering the paths below; routes not listed need admin
+	routes map[string]string
+}
+
+// NewAuth checks keys and routes for unknown scopes
+func NewAuth(keys []APIKey, routes map[string]string) (*Auth, error) {
+	for route, scope := range routes {
+		if scope != ScopePublic && scope != ScopeRead && scope != ScopeAdmin {
+			return nil, fmt.Errorf("route %s: unknown scope %q", route, scope)
+		}
+	}
+	a := &Auth{routes: routes}
+	if err := a.SetKeys(keys); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// SetKeys replaces the keys requests are checked against, e.g. once a key
+// read from a file was rotated; invalid keys leave the previous ones in place
+func (a *Auth) SetKeys(keys []APIKey) error {
+	for i, key := range keys {
+		if key.Key == "" {
+			return fmt.Errorf("api key %d (%s) is empty", i, key.Name)
+		}
+		if key.Scope != ScopeRead && key.Scope != ScopeAdmin {
+			return fmt.Errorf("api key %s: unknown scope %q (expected %q or %q)", key.Name, key.Scope, ScopeRead, ScopeAdmin)
+		}
+	}
+	a.keys.Store(&keys)
+	return nil
+}
+
+// scope returns the scope a request needs
+func (a *Auth) scope(r *http.Request) string {
+	for _, route := range routeKeys(r) {
+		if scope, ok := a.routes[route]; ok {
+			return scope
+		}
+	}
+	return ScopeAdmin
+}
+
+// key returns the configured key the request carries, nil when there is none
+func (a *Auth) key(r *http.Request) *APIKey {
+	presented := r.Header.Get("X-API-Key")
+	if bearer, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer "); ok {
+		presented = bearer
+	}
+	if presented == "" && strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+		presented = r.URL.Query().Get("api_key")
+	}
+	if presented == "" {
+		return nil
+	}
+	keys := *a.keys.Load()
+	for i := range keys {
+		if subtle.ConstantTimeCompare([]byte(presented), []byte(keys[i].Key)) == 1 {
+			return &keys[i]
+		}
+	}
+	return nil
+}
+
+// Handler enforces the route scopes and logs every authorized call with its key and scope
+func (a *Auth) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// OPTIONS only tells the allowed methods, load balancers probe it without a key
+		required := a.scope(r)
+		if required == ScopePublic || r.Method == http.MethodOptions {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		key := a.key(r)
+		if key == nil {
+			Logger(r.Context()).Warn("Request denied: missing or unknown API key", "audit", true, "method", r.Method, "path", r.URL.Path, "required_scope", required)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="go-nc-client"`)
+			WriteError(w, r, http.StatusUnauthorized, "INVALID_API_KEY", "missing or invalid API key")
+			return
+		}
+		if required == ScopeAdmin && key.Scope != ScopeAdmin {
+			Logger(r.Context()).Warn("Request denied: insufficient scope", "audit", true, "method", r.Method, "path", r.URL.Path, "key", key.Name, "scope", key.Scope, "required_scope", required)
+			WriteError(w, r, http.StatusForbidden, "INSUFFICIENT_SCOPE", fmt.Sprintf("API key scope %s cannot call this endpoint (needs %s)", key.Scope, required))
+			return
+		}
+
+		Logger(r.Context()).Info("Request authorized", "audit", true, "method", r.Method, "path", r.URL.Path, "key", key.Name, "scope", key.Scope)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), apiKeyNameKey{}, key.Name)))
+	})
+}
@@ -0,0 +1,46 @@
+package middleware
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// DefaultMaxBodyBytes is the body limit of routes without one of their own
+const DefaultMaxBodyBytes = 1 << 20
+
+// BodyLimits caps the size of request bodies, so a runaway client can't make
+// the server read gigabytes of JSON
+type BodyLimits struct {
+	limit int64
+	// routes maps "METHOD /path", "/path" or "/path/" (the paths below), as
+	// for RateLimiter, to the limit of the matching requests
+	routes map[string]int64
+}
+
+// NewBodyLimits limits bodies to limit bytes, or to that of their route;
+// a negative limit lifts it
+func NewBodyLimits(limit int64, routes map[string]int64) *BodyLimits {
+	return &BodyLimits{limit: limit, routes: routes}
+}
+
+// Handler answers 413 to requests announcing a body over their limit, and
+// makes reading past it fail for the others (see http.MaxBytesReader)
+func (b *BodyLimits) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limit := b.limit
+		for _, route := range routeKeys(r) {
+			if routeLimit, ok := b.routes[route]; ok {
+				limit = routeLimit
+				break
+			}
+		}
+		if limit >= 0 {
+			if r.ContentLength > limit {
+				WriteError(w, r, http.StatusRequestEntityTooLarge, "BODY_TOO_LARGE", fmt.Sprintf("Request body larger than %d bytes", limit))
+				return
+			}
+			r.Body = http.MaxBytesReader(w, r.Body, limit)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+package middleware
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// Defaults of CORSOptions fields left empty
+var (
+	defaultCORSMethods = []string{http.MethodGet, http.MethodPost, http.MethodDelete, http.MethodOptions}
+	defaultCORSHeaders = []string{"Content-Type", "Authorization", "X-API-Key", RequestIDHeader}
+)
+
+// exposedHeaders are the response headers browsers let scripts read, besides the simple ones
+// Next-Page-Token and Total-Count describe the pages of POST /diff.
+const exposedHeaders = RequestIDHeader + ", Next-Page-Token, Total-Count"
+
+// CORSOptions selects which browser origins may call the API
+type CORSOptions struct {
+	// AllowedOrigins are origins like "https://dash.example.com"; "*" allows any
+	AllowedOrigins []string
+	// AllowedMethods defaults to GET, POST, DELETE and OPTIONS
+	AllowedMethods []string
+	// AllowedHeaders defaults to the headers the API reads
+	AllowedHeaders []string
+	// MaxAge is how long browsers may cache a preflight, in seconds (0 = not sent)
+	MaxAge int
+}
+
+// CORS answers preflight requests and adds CORS headers for allowed origins
+// Preflights are answered before authentication, since browsers send them
+// without credentials.
+func CORS(opts CORSOptions) func(http.Handler) http.Handler {
+	if len(opts.AllowedMethods) == 0 {
+		opts.AllowedMethods = defaultCORSMethods
+	}
+	if len(opts.AllowedHeaders) == 0 {
+		opts.AllowedHeaders = defaultCORSHeaders
+	}
+	methods := strings.Join(opts.AllowedMethods, ", ")
+	headers := strings.Join(opts.AllowedHeaders, ", ")
+
+	allowAny := false
+	origins := make(map[string]bool, len(opts.AllowedOrigins))
+	for _, origin := range opts.AllowedOrigins {
+		if origin == "*" {
+			allowAny = true
+		}
+		origins[strings.TrimSuffix(origin, "/")] = true
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Origin")
+			if !allowAny && !origins[origin] {
+				// Without the headers the browser blocks the response itself
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			if allowAny {
+				w.Header().Set("Access-Control-Allow-Origin", "*")
+			} else {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+			}
+			w.Header().Set("Access-Control-Expose-Headers", exposedHeaders)
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", methods)
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+				if opts.MaxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(opts.MaxAge))
+				}
+				w.WriteHeader(http.StatusNoContent)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
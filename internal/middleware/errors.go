@@ -0,0 +1,45 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorResponse is the body of every error response
+type ErrorResponse struct {
+	// Code identifies the error for clients to branch on, e.g.
+	// "PATH_NOT_FOUND"; unlike Message it doesn't change between releases
+	Code      string `json:"code"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// NewErrorResponse returns the ErrorResponse of a request
+func NewErrorResponse(r *http.Request, code, message string) ErrorResponse {
+	return ErrorResponse{Code: code, Message: message, RequestID: RequestIDFrom(r.Context())}
+}
+
+// StatusCode returns the code of errors with status that have no more
+// specific one, e.g. "NOT_FOUND" for 404
+func StatusCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		text = "Error"
+	}
+	return strings.ToUpper(strings.ReplaceAll(text, " ", "_"))
+}
+
+// WriteError answers status with an ErrorResponse, the JSON counterpart of http.Error
+func WriteError(w http.ResponseWriter, r *http.Request, status int, code, message string) {
+	WriteErrorResponse(w, status, NewErrorResponse(r, code, message))
+}
+
+// WriteErrorResponse answers status with body, an ErrorResponse or a struct embedding one
+func WriteErrorResponse(w http.ResponseWriter, status int, body any) {
+	w.Header().Del("Content-Length")
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
@@ -0,0 +1,145 @@
+package middleware
+
+import (
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimitDefaultRoute is the route key whose limit applies to routes not listed
+const RateLimitDefaultRoute = "*"
+
+// sweepInterval is how often buckets that refilled completely are dropped
+const sweepInterval = 10 * time.Minute
+
+// RateLimit is a token bucket: Burst requests at once, refilled at PerMinute
+type RateLimit struct {
+	PerMinute float64
+	// Burst defaults to PerMinute (at least 1)
+	Burst int
+}
+
+// RateLimiter limits requests per client, i.e. per API key or remote IP, and route
+type RateLimiter struct {
+	// limits maps "METHOD /path", "/path", "/path/" (the paths below) or "*"
+	// to its limit; routes not matched are unlimited
+	limits map[string]RateLimit
+
+	mu        sync.Mutex
+	buckets   map[bucketKey]*bucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	route  string
+	client string
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimiter checks the limits and fills in default bursts
+func NewRateLimiter(limits map[string]RateLimit) (*RateLimiter, error) {
+	normalized := make(map[string]RateLimit, len(limits))
+	for route, limit := range limits {
+		if limit.PerMinute <= 0 {
+			return nil, fmt.Errorf("route %s: requests per minute must be positive, got %v", route, limit.PerMinute)
+		}
+		if limit.Burst < 0 {
+			return nil, fmt.Errorf("route %s: burst must not be negative, got %d", route, limit.Burst)
+		}
+		if limit.Burst == 0 {
+			limit.Burst = max(1, int(limit.PerMinute))
+		}
+		normalized[route] = limit
+	}
+	return &RateLimiter{limits: normalized, buckets: make(map[bucketKey]*bucket), lastSweep: time.Now()}, nil
+}
+
+// limit returns the limit of a request and the route key it was found under
+func (l *RateLimiter) limit(r *http.Request) (string, RateLimit, bool) {
+	for _, route := range append(routeKeys(r), RateLimitDefaultRoute) {
+		if limit, ok := l.limits[route]; ok {
+			return route, limit, true
+		}
+	}
+	return "", RateLimit{}, false
+}
+
+// client identifies the caller by the API key it was authorized with, or by its IP
+func client(r *http.Request) string {
+	if name := APIKeyNameFrom(r.Context()); name != "" {
+		return "key:" + name
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return "ip:" + host
+}
+
+// take removes a token from the bucket of key, returning how long to wait
+// for the next one when it is empty
+func (l *RateLimiter) take(key bucketKey, limit RateLimit, now time.Time) (bool, time.Duration) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if now.Sub(l.lastSweep) >= sweepInterval {
+		l.sweep(now)
+	}
+
+	rate := limit.PerMinute / 60
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), last: now}
+		l.buckets[key] = b
+	} else {
+		b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.last).Seconds()*rate)
+		b.last = now
+	}
+
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rate * float64(time.Second))
+}
+
+// sweep drops buckets that are full again, as they behave like new ones
+func (l *RateLimiter) sweep(now time.Time) {
+	for key, b := range l.buckets {
+		limit := l.limits[key.route]
+		if b.tokens+now.Sub(b.last).Seconds()*limit.PerMinute/60 >= float64(limit.Burst) {
+			delete(l.buckets, key)
+		}
+	}
+	l.lastSweep = now
+}
+
+// Handler answers 429 with Retry-After to clients over their limit
+// It runs inside Auth, so clients are told apart by their API key when there is one.
+func (l *RateLimiter) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, limit, ok := l.limit(r)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		key := bucketKey{route: route, client: client(r)}
+		allowed, wait := l.take(key, limit, time.Now())
+		if !allowed {
+			retryAfter := int(math.Ceil(wait.Seconds()))
+			Logger(r.Context()).Warn("Request rate limited", "method", r.Method, "path", r.URL.Path, "client", key.client, "retry_after", retryAfter)
+			w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+			WriteError(w, r, http.StatusTooManyRequests, "RATE_LIMITED", fmt.Sprintf("rate limit exceeded, retry in %ds", retryAfter))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
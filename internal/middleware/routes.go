@@ -0,0 +1,28 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+)
+
+// routeKeys returns the keys a request matches in a route map, most specific
+// first: "METHOD /path" and "/path", then the same for each enclosing subtree
+// A key ending in a slash, like "/jobs/", matches every path below it, as with http.ServeMux.
+// HEAD requests match the keys of GET.
+func routeKeys(r *http.Request) []string {
+	method := r.Method
+	if method == http.MethodHead {
+		method = http.MethodGet
+	}
+	path := r.URL.Path
+	keys := []string{method + " " + path, path}
+	for path != "/" {
+		i := strings.LastIndex(strings.TrimSuffix(path, "/"), "/")
+		if i < 0 {
+			break
+		}
+		path = path[:i+1]
+		keys = append(keys, method+" "+path, path)
+	}
+	return keys
+}
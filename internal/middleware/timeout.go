@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"net/http"
+	"time"
+)
+
+// Timeout bounds reading the body of a request and writing its response,
+// counted from when the handler starts
+// Zero keeps the server's timeout, negative lifts it.
+type Timeout struct {
+	Read  time.Duration
+	Write time.Duration
+}
+
+// Timeouts overrides the server's read and write timeouts per route, e.g. to
+// give downloads and long polls more time than the rest of the API
+type Timeouts struct {
+	// routes maps "METHOD /path", "/path" or "/path/" (the paths below), as
+	// for RateLimiter, to the timeout of the matching requests
+	routes map[string]Timeout
+}
+
+func NewTimeouts(routes map[string]Timeout) *Timeouts {
+	return &Timeouts{routes: routes}
+}
+
+func (t *Timeouts) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		for _, route := range routeKeys(r) {
+			timeout, ok := t.routes[route]
+			if !ok {
+				continue
+			}
+			// Writers that can't take deadlines, e.g. in tests, keep the server's
+			rc := http.NewResponseController(w)
+			if timeout.Read != 0 {
+				rc.SetReadDeadline(deadline(timeout.Read))
+			}
+			if timeout.Write != 0 {
+				rc.SetWriteDeadline(deadline(timeout.Write))
+			}
+			break
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deadline returns the deadline d from now, or no deadline for a negative d
+func deadline(d time.Duration) time.Time {
+	if d < 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(d)
+}
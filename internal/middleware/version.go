@@ -0,0 +1,203 @@
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// APIVersion is the current API version, served under /v1
+const APIVersion = "v1"
+
+const versionPrefix = "/" + APIVersion
+
+type apiVersionKey struct{}
+
+// APIVersionFrom returns the version the request was made under, empty for
+// the deprecated unversioned paths
+func APIVersionFrom(ctx context.Context) string {
+	version, _ := ctx.Value(apiVersionKey{}).(string)
+	return version
+}
+
+// Envelope wraps every /v1 response; exactly one of Data and Error is set
+type Envelope struct {
+	Data  json.RawMessage `json:"data,omitempty"`
+	Error *EnvelopeError  `json:"error,omitempty"`
+	Meta  EnvelopeMeta    `json:"meta"`
+}
+
+// EnvelopeError describes a failed request
+type EnvelopeError struct {
+	Status int `json:"status"`
+	// Code is that of the ErrorResponse, see StatusCode for those without
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	// Details holds the extra fields of JSON error responses, e.g. the run in progress
+	Details json.RawMessage `json:"details,omitempty"`
+}
+
+// EnvelopeMeta carries information about the request rather than its result
+type EnvelopeMeta struct {
+	APIVersion string `json:"api_version"`
+	RequestID  string `json:"request_id,omitempty"`
+}
+
+// Versioned serves /v1/<route> from the handler of <route> with its response
+// wrapped in an Envelope. Unversioned paths keep their plain responses and are
+// marked deprecated with a link to their /v1 successor, except the paths
+// listed in unversioned, which are served as they are.
+func Versioned(next http.Handler, unversioned ...string) http.Handler {
+	plain := make(map[string]bool, len(unversioned))
+	for _, path := range unversioned {
+		plain[path] = true
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if plain[r.URL.Path] {
+			next.ServeHTTP(w, r)
+			return
+		}
+		route, ok := strings.CutPrefix(r.URL.Path, versionPrefix)
+		if !ok || (route != "" && !strings.HasPrefix(route, "/")) {
+			w.Header().Set("Deprecation", "true")
+			w.Header().Set("Link", "<"+versionPrefix+r.URL.Path+`>; rel="successor-version"`)
+			next.ServeHTTP(w, r)
+			return
+		}
+		if route == "" {
+			route = "/"
+		}
+
+		r2 := r.Clone(context.WithValue(r.Context(), apiVersionKey{}, APIVersion))
+		r2.URL.Path = route
+		r2.URL.RawPath = ""
+		// Upgraded connections, e.g. WebSockets, have no response to wrap
+		if r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r2)
+			return
+		}
+		ew := &envelopeWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(ew, r2)
+		ew.finish(RequestIDFrom(r.Context()))
+	})
+}
+
+// envelopeWriter buffers JSON and error responses to wrap them once complete;
+// other content, such as file previews, passes through unchanged
+type envelopeWriter struct {
+	http.ResponseWriter
+	status      int
+	wroteHeader bool
+	passthrough bool
+	buf         bytes.Buffer
+}
+
+// decide picks between wrapping and passing through once the handler has set its headers
+// Files, sent with a Content-Disposition, pass through even when they hold JSON.
+func (w *envelopeWriter) decide() {
+	if w.wroteHeader {
+		return
+	}
+	w.wroteHeader = true
+	contentType := w.Header().Get("Content-Type")
+	isJSON := strings.HasPrefix(contentType, "application/json") && w.Header().Get("Content-Disposition") == ""
+	plainError := w.status >= http.StatusBadRequest && strings.HasPrefix(contentType, "text/plain")
+	if !isJSON && !plainError {
+		w.passthrough = true
+		w.ResponseWriter.WriteHeader(w.status)
+	}
+}
+
+func (w *envelopeWriter) WriteHeader(status int) {
+	if w.wroteHeader {
+		return
+	}
+	w.status = status
+	w.decide()
+}
+
+func (w *envelopeWriter) Write(p []byte) (int, error) {
+	w.decide()
+	if w.passthrough {
+		return w.ResponseWriter.Write(p)
+	}
+	return w.buf.Write(p)
+}
+
+// finish writes the buffered response inside an envelope
+func (w *envelopeWriter) finish(requestID string) {
+	if w.passthrough {
+		return
+	}
+	if !w.wroteHeader && w.buf.Len() == 0 {
+		// Nothing was written, e.g. a 204
+		w.ResponseWriter.WriteHeader(w.status)
+		return
+	}
+
+	env := Envelope{Meta: EnvelopeMeta{APIVersion: APIVersion, RequestID: requestID}}
+	body := bytes.TrimSpace(w.buf.Bytes())
+	isJSON := strings.HasPrefix(w.Header().Get("Content-Type"), "application/json")
+	switch {
+	case w.status < http.StatusBadRequest:
+		env.Data = body
+	case isJSON:
+		env.Error = envelopeError(w.status, body)
+	default:
+		env.Error = &EnvelopeError{Status: w.status, Code: StatusCode(w.status), Message: string(body)}
+	}
+
+	out, err := json.Marshal(env)
+	if err != nil {
+		// The handler wrote invalid JSON, send it as it is rather than nothing
+		w.ResponseWriter.WriteHeader(w.status)
+		w.ResponseWriter.Write(w.buf.Bytes())
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.ResponseWriter.WriteHeader(w.status)
+	w.ResponseWriter.Write(append(out, '\n'))
+}
+
+// envelopeError moves the code and message of a JSON error response to the
+// envelope, its other fields to Details; the request ID is already in Meta
+func envelopeError(status int, body []byte) *EnvelopeError {
+	env := &EnvelopeError{Status: status, Code: StatusCode(status), Message: http.StatusText(status)}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(body, &fields) != nil {
+		env.Details = body
+		return env
+	}
+	var code, message string
+	if json.Unmarshal(fields["code"], &code) == nil && code != "" {
+		env.Code = code
+	}
+	if json.Unmarshal(fields["message"], &message) == nil && message != "" {
+		env.Message = message
+	}
+	delete(fields, "code")
+	delete(fields, "message")
+	delete(fields, "request_id")
+	if len(fields) > 0 {
+		env.Details, _ = json.Marshal(fields)
+	}
+	return env
+}
+
+// Flush only reaches the client for passed through responses, wrapped ones
+// are sent once complete
+func (w *envelopeWriter) Flush() {
+	if !w.passthrough {
+		return
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap lets http.ResponseController reach the underlying writer
+func (w *envelopeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
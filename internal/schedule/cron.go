@@ -0,0 +1,156 @@
+package schedule
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression: five fields (minute, hour, day of month,
+// month, day of week) or a macro such as "@hourly" or "@every 10m"
+type Cron struct {
+	minute, hour, dom, month, dow uint64
+	// domAny and dowAny record a "*" day field; when both day fields are
+	// restricted, either matching is enough, as in Vixie cron
+	domAny, dowAny bool
+	// every is the interval of "@every", which ignores the fields
+	every time.Duration
+}
+
+// maxNextSearch bounds how far Next looks ahead, e.g. for "0 0 30 2 *"
+const maxNextSearch = 5 * 366 * 24 * time.Hour
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+// ParseCron parses a cron expression
+// Fields accept "*", numbers, ranges ("1-5"), lists ("1,15") and steps
+// ("*/15", "0-30/10"); day of week runs from 0 (Sunday) to 7 (Sunday again).
+func ParseCron(expr string) (*Cron, error) {
+	expr = strings.TrimSpace(expr)
+	if interval, ok := strings.CutPrefix(expr, "@every "); ok {
+		d, err := time.ParseDuration(strings.TrimSpace(interval))
+		if err != nil || d < time.Minute {
+			return nil, fmt.Errorf("invalid cron %q: @every needs a duration of at least 1m", expr)
+		}
+		return &Cron{every: d}, nil
+	}
+	if macro, ok := cronMacros[expr]; ok {
+		expr = macro
+	} else if strings.HasPrefix(expr, "@") {
+		return nil, fmt.Errorf("invalid cron %q: unknown macro", expr)
+	}
+
+	fields := strings.Fields(expr)
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("invalid cron %q: expected 5 fields (minute hour day-of-month month day-of-week), got %d", expr, len(fields))
+	}
+	c := &Cron{domAny: fields[2] == "*", dowAny: fields[4] == "*"}
+	for i, spec := range []struct {
+		name     string
+		min, max int
+		bits     *uint64
+	}{
+		{"minute", 0, 59, &c.minute},
+		{"hour", 0, 23, &c.hour},
+		{"day of month", 1, 31, &c.dom},
+		{"month", 1, 12, &c.month},
+		{"day of week", 0, 7, &c.dow},
+	} {
+		bits, err := parseField(fields[i], spec.min, spec.max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cron %q: %s: %w", expr, spec.name, err)
+		}
+		*spec.bits = bits
+	}
+	// 7 is another name for Sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	return c, nil
+}
+
+// parseField returns the values a field matches as a bit set
+func parseField(field string, min, max int) (uint64, error) {
+	var bits uint64
+	for _, part := range strings.Split(field, ",") {
+		rangePart, stepPart, hasStep := strings.Cut(part, "/")
+		step := 1
+		if hasStep {
+			n, err := strconv.Atoi(stepPart)
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("invalid step %q", stepPart)
+			}
+			step = n
+		}
+
+		lo, hi := min, max
+		if rangePart != "*" {
+			from, to, isRange := strings.Cut(rangePart, "-")
+			var err error
+			if lo, err = strconv.Atoi(from); err != nil {
+				return 0, fmt.Errorf("invalid value %q", from)
+			}
+			hi = lo
+			if isRange {
+				if hi, err = strconv.Atoi(to); err != nil {
+					return 0, fmt.Errorf("invalid value %q", to)
+				}
+			} else if hasStep {
+				// "5/15" means from 5 to the end in steps of 15
+				hi = max
+			}
+		}
+		if lo < min || hi > max || lo > hi {
+			return 0, fmt.Errorf("%q out of range %d-%d", rangePart, min, max)
+		}
+		for v := lo; v <= hi; v += step {
+			bits |= 1 << v
+		}
+	}
+	return bits, nil
+}
+
+// Next returns the first time after t the expression matches, in t's
+// location; it is zero when nothing matches within five years
+func (c *Cron) Next(t time.Time) time.Time {
+	if c.every > 0 {
+		return t.Add(c.every)
+	}
+
+	next := t.Truncate(time.Minute).Add(time.Minute)
+	limit := t.Add(maxNextSearch)
+	for next.Before(limit) {
+		switch {
+		case c.month&(1<<int(next.Month())) == 0:
+			next = time.Date(next.Year(), next.Month()+1, 1, 0, 0, 0, 0, next.Location())
+		case !c.dayMatches(next):
+			next = time.Date(next.Year(), next.Month(), next.Day()+1, 0, 0, 0, 0, next.Location())
+		case c.hour&(1<<next.Hour()) == 0:
+			next = time.Date(next.Year(), next.Month(), next.Day(), next.Hour()+1, 0, 0, 0, next.Location())
+		case c.minute&(1<<next.Minute()) == 0:
+			next = next.Add(time.Minute)
+		default:
+			return next
+		}
+	}
+	return time.Time{}
+}
+
+// dayMatches applies the day of month and day of week fields to t
+func (c *Cron) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	if c.domAny || c.dowAny {
+		return dom && dow
+	}
+	return dom || dow
+}
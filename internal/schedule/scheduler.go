@@ -0,0 +1,311 @@
+package schedule
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+var (
+	// ErrUnknownSchedule is returned by Trigger for names that aren't configured
+	ErrUnknownSchedule = errors.New("unknown schedule")
+	// ErrScheduleRunning is returned by Trigger while the schedule's diff runs
+	ErrScheduleRunning = errors.New("schedule is already running")
+)
+
+// Trigger values of RunResult
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+// Schedule runs a diff of Directories whenever Cron matches
+type Schedule struct {
+	Name        string
+	Cron        string
+	Directories []string
+}
+
+// RunFunc runs the diff of a schedule
+type RunFunc func(directories []string) ([]diff.Changes, error)
+
+// Status describes a schedule and its last run
+type Status struct {
+	Name        string     `json:"name"`
+	Cron        string     `json:"cron"`
+	Directories []string   `json:"directories"`
+	Next        time.Time  `json:"next,omitzero"`
+	Running     bool       `json:"running"`
+	LastRun     *RunResult `json:"last_run,omitempty"`
+}
+
+// RunResult is the outcome of a scheduled diff
+type RunResult struct {
+	// Trigger is "schedule" or "manual"
+	Trigger  string    `json:"trigger"`
+	Started  time.Time `json:"started"`
+	Finished time.Time `json:"finished"`
+	Changes  int       `json:"changes"`
+	// FailedDirectories counts the directories that could not be scanned
+	FailedDirectories int    `json:"failed_directories,omitempty"`
+	Error             string `json:"error,omitempty"`
+}
+
+// Scheduler runs diffs on cron schedules, so no external cron has to call
+// the API; the runs go through the detector like any other, so they are
+// journaled and reach its observers
+type Scheduler struct {
+	run RunFunc
+
+	mu      sync.Mutex
+	entries []*entry
+	started bool
+	cancel  context.CancelFunc
+	wg      sync.WaitGroup
+}
+
+// entry is a schedule with its state; fields below cron are guarded by Scheduler.mu
+type entry struct {
+	Schedule
+	cron *Cron
+
+	next    time.Time
+	running bool
+	last    *RunResult
+}
+
+// New checks the schedules; Start runs them
+func New(schedules []Schedule, run RunFunc) (*Scheduler, error) {
+	entries, err := newEntries(schedules)
+	if err != nil {
+		return nil, err
+	}
+	return &Scheduler{run: run, entries: entries}, nil
+}
+
+// Validate checks schedules the way New and Replace do
+func Validate(schedules []Schedule) error {
+	_, err := newEntries(schedules)
+	return err
+}
+
+// newEntries checks the schedules and returns them sorted by name
+func newEntries(schedules []Schedule) ([]*entry, error) {
+	var entries []*entry
+	names := make(map[string]bool)
+	for _, schedule := range schedules {
+		if names[schedule.Name] {
+			return nil, fmt.Errorf("schedule %s: defined twice", schedule.Name)
+		}
+		names[schedule.Name] = true
+		cron, err := ParseCron(schedule.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("schedule %s: %w", schedule.Name, err)
+		}
+		if len(schedule.Directories) == 0 {
+			return nil, fmt.Errorf("schedule %s: no directories", schedule.Name)
+		}
+		entries = append(entries, &entry{Schedule: schedule, cron: cron})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Start runs every schedule in the background until Stop
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.started = true
+	s.startLocked()
+}
+
+// startLocked starts the loops of the current entries, under s.mu
+func (s *Scheduler) startLocked() {
+	ctx, cancel := context.WithCancel(context.Background())
+	s.cancel = cancel
+	for _, e := range s.entries {
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			s.loop(ctx, e)
+		}()
+		slog.Info("Schedule started", "schedule", e.Name, "cron", e.Cron, "directories", e.Directories)
+	}
+}
+
+// Replace checks schedules and, when they are valid, runs them instead of
+// the current ones
+// Diffs in progress finish; the last run of a schedule kept under the same
+// name stays in its status.
+func (s *Scheduler) Replace(schedules []Schedule) error {
+	entries, err := newEntries(schedules)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range entries {
+		for _, old := range s.entries {
+			if old.Name == e.Name {
+				e.last = old.last
+			}
+		}
+	}
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.entries = entries
+	if s.started {
+		s.startLocked()
+	}
+	return nil
+}
+
+// loop waits for each time the schedule matches and runs its diff
+func (s *Scheduler) loop(ctx context.Context, e *entry) {
+	for {
+		next := e.cron.Next(time.Now())
+		s.mu.Lock()
+		e.next = next
+		s.mu.Unlock()
+		if next.IsZero() {
+			slog.Warn("Schedule never matches, stopping it", "schedule", e.Name, "cron", e.Cron)
+			return
+		}
+
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		if err := s.start(e); err != nil {
+			// The previous run is still going; this occurrence is skipped
+			slog.Warn("Skipping scheduled diff", "schedule", e.Name, "error", err)
+			continue
+		}
+		s.execute(e, TriggerSchedule)
+	}
+}
+
+// start marks e as running, failing when it already is
+func (s *Scheduler) start(e *entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if e.running {
+		return fmt.Errorf("%w: %s", ErrScheduleRunning, e.Name)
+	}
+	e.running = true
+	return nil
+}
+
+// execute runs the diff of e, which start marked as running, and records the outcome
+func (s *Scheduler) execute(e *entry, trigger string) {
+	logger := slog.With("schedule", e.Name, "trigger", trigger)
+	logger.Info("Scheduled diff started", "directories", e.Directories)
+	result := &RunResult{Trigger: trigger, Started: time.Now()}
+
+	changes, err := s.run(e.Directories)
+	result.Finished = time.Now()
+	if err != nil {
+		result.Error = err.Error()
+		logger.Error("Scheduled diff failed", "error", err)
+	} else {
+		for _, c := range changes {
+			result.Changes += len(c.Changes)
+		}
+		result.FailedDirectories = diff.Failed(changes)
+		logger.Info("Scheduled diff completed", "changes", result.Changes, "failed", result.FailedDirectories, "duration", result.Finished.Sub(result.Started))
+	}
+
+	s.mu.Lock()
+	e.running = false
+	e.last = result
+	s.mu.Unlock()
+}
+
+// Trigger runs the diff of a schedule now, in the background
+func (s *Scheduler) Trigger(name string) (*Status, error) {
+	e := s.find(name)
+	if e == nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownSchedule, name)
+	}
+	if err := s.start(e); err != nil {
+		return nil, err
+	}
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		s.execute(e, TriggerManual)
+	}()
+	status := s.status(e)
+	return &status, nil
+}
+
+// List returns the status of every schedule, sorted by name
+func (s *Scheduler) List() []Status {
+	s.mu.Lock()
+	entries := s.entries
+	s.mu.Unlock()
+	statuses := make([]Status, 0, len(entries))
+	for _, e := range entries {
+		statuses = append(statuses, s.status(e))
+	}
+	return statuses
+}
+
+func (s *Scheduler) find(name string) *entry {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, e := range s.entries {
+		if e.Name == name {
+			return e
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) status(e *entry) Status {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	status := Status{
+		Name:        e.Name,
+		Cron:        e.Cron,
+		Directories: e.Directories,
+		Next:        e.next,
+		Running:     e.running,
+	}
+	if e.last != nil {
+		last := *e.last
+		status.LastRun = &last
+	}
+	return status
+}
+
+// Stop ends the schedules and waits for the diffs they are running, until ctx ends
+func (s *Scheduler) Stop(ctx context.Context) {
+	s.mu.Lock()
+	s.started = false
+	if s.cancel != nil {
+		s.cancel()
+	}
+	s.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		s.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+}
@@ -0,0 +1,75 @@
+// Package secrets reads credentials from files, such as Docker or Kubernetes
+// secrets mounted into the container, and notices when they change
+package secrets
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"strings"
+	"time"
+)
+
+// Read returns the secret in a file, without the line break editors and
+// echo leave at its end
+func Read(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	secret := strings.TrimRight(string(data), "\r\n")
+	if secret == "" {
+		return "", fmt.Errorf("%s is empty", path)
+	}
+	return secret, nil
+}
+
+// File is a secret file and what to do with its new content
+type File struct {
+	Path  string
+	Apply func(secret string)
+}
+
+// Watch checks files every interval until ctx is done, calling Apply with the
+// content of those that changed
+// A file that can't be read keeps its previous secret in use, with a warning.
+func Watch(ctx context.Context, interval time.Duration, files []File) {
+	modTimes := make([]time.Time, len(files))
+	secrets := make([]string, len(files))
+	for i, f := range files {
+		if info, err := os.Stat(f.Path); err == nil {
+			modTimes[i] = info.ModTime()
+		}
+		secrets[i], _ = Read(f.Path)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		for i, f := range files {
+			// Kubernetes swaps a symlink, which Stat follows to the new file
+			info, err := os.Stat(f.Path)
+			if err != nil || info.ModTime().Equal(modTimes[i]) {
+				continue
+			}
+			modTimes[i] = info.ModTime()
+			secret, err := Read(f.Path)
+			if err != nil {
+				slog.Warn("Failed to reload secret file, keeping the previous secret", "path", f.Path, "error", err)
+				continue
+			}
+			if secret == secrets[i] {
+				continue
+			}
+			secrets[i] = secret
+			f.Apply(secret)
+			slog.Info("Secret file reloaded", "path", f.Path)
+		}
+	}
+}
@@ -0,0 +1,52 @@
+package secrets
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+// Provider fetches a secret from an external store, such as Vault, so it can
+// be rotated there without touching the deployment
+type Provider interface {
+	// Secret returns the current value of the secret
+	Secret(ctx context.Context) (string, error)
+}
+
+// fetchTimeout bounds each read of a provider
+const fetchTimeout = 30 * time.Second
+
+// Fetch reads the secret of p, giving up after fetchTimeout
+func Fetch(ctx context.Context, p Provider) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	return p.Secret(ctx)
+}
+
+// Poll reads the secret of p every interval until ctx is done, calling apply
+// when it differs from the last one, starting from current
+// A failed read keeps the previous secret in use, with a warning.
+func Poll(ctx context.Context, interval time.Duration, p Provider, current string, apply func(secret string)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		secret, err := Fetch(ctx, p)
+		if err != nil {
+			if ctx.Err() == nil {
+				slog.Warn("Failed to refresh secret, keeping the previous one", "error", err)
+			}
+			continue
+		}
+		if secret == current {
+			continue
+		}
+		current = secret
+		apply(secret)
+		slog.Info("Secret refreshed from provider")
+	}
+}
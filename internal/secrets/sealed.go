@@ -0,0 +1,73 @@
+package secrets
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// sealedPrefix starts every sealed value, followed by the base64 of the
+// nonce and the sealed secret
+const sealedPrefix = "enc:v1:"
+
+// Sealer encrypts secrets kept in config.json with AES-256-GCM
+type Sealer struct {
+	aead cipher.AEAD
+}
+
+// NewSealer returns a sealer for a key given as base64 (e.g. from
+// "openssl rand -base64 32") or as 32 raw bytes
+func NewSealer(key []byte) (*Sealer, error) {
+	if decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(key))); err == nil && len(decoded) == 32 {
+		key = decoded
+	}
+	if len(key) != 32 {
+		return nil, errors.New("config encryption key must be 32 bytes, base64-encoded")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &Sealer{aead: aead}, nil
+}
+
+// IsSealed reports whether value was returned by Seal
+func IsSealed(value string) bool {
+	return strings.HasPrefix(value, sealedPrefix)
+}
+
+// Seal returns secret encrypted, as text that can stand in its place
+func (s *Sealer) Seal(secret string) (string, error) {
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	// The prefix is authenticated too, so a value can't be moved to another format
+	sealed := s.aead.Seal(nonce, nonce, []byte(secret), []byte(sealedPrefix))
+	return sealedPrefix + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open returns the secret of a sealed value
+func (s *Sealer) Open(value string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(value, sealedPrefix))
+	if err != nil {
+		return "", fmt.Errorf("invalid encrypted value: %w", err)
+	}
+	if len(data) < s.aead.NonceSize() {
+		return "", errors.New("encrypted value is truncated")
+	}
+	nonce, sealed := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	secret, err := s.aead.Open(nil, nonce, sealed, []byte(sealedPrefix))
+	if err != nil {
+		return "", errors.New("failed to decrypt (wrong key?)")
+	}
+	return string(secret), nil
+}
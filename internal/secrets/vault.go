@@ -0,0 +1,209 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// VaultOptions locate a secret in HashiCorp Vault and how to log in
+type VaultOptions struct {
+	// Address of the server, e.g. "https://vault.example.com:8200" (empty = VAULT_ADDR)
+	Address string
+	// Path of the secret below /v1/, e.g. "secret/data/nextcloud" for a KV
+	// version 2 engine mounted at secret
+	Path string
+	// Field of the secret holding the value (empty = "password")
+	Field string
+	// Namespace of Vault Enterprise (empty = VAULT_NAMESPACE, if any)
+	Namespace string
+	// Token authenticates the reads (empty = VAULT_TOKEN), unless RoleID is set
+	Token string
+	// RoleID and SecretID log in with AppRole, again whenever the token expires
+	RoleID   string
+	SecretID string
+}
+
+// Vault reads a secret from a KV engine of HashiCorp Vault
+type Vault struct {
+	opts   VaultOptions
+	client *http.Client
+
+	mu sync.Mutex
+	// token is the token of the last AppRole login, valid until expires
+	// (zero when it doesn't expire)
+	token   string
+	expires time.Time
+}
+
+// NewVault checks opts, filling what is missing from the VAULT_* environment variables
+func NewVault(opts VaultOptions) (*Vault, error) {
+	if opts.Address == "" {
+		opts.Address = os.Getenv("VAULT_ADDR")
+	}
+	if opts.Namespace == "" {
+		opts.Namespace = os.Getenv("VAULT_NAMESPACE")
+	}
+	if opts.Token == "" && opts.RoleID == "" {
+		opts.Token = os.Getenv("VAULT_TOKEN")
+	}
+	if opts.Field == "" {
+		opts.Field = "password"
+	}
+	opts.Address = strings.TrimSuffix(opts.Address, "/")
+	opts.Path = strings.Trim(opts.Path, "/")
+
+	switch {
+	case opts.Address == "":
+		return nil, errors.New("vault: address is required (or VAULT_ADDR)")
+	case opts.Path == "":
+		return nil, errors.New("vault: path is required")
+	case opts.RoleID != "" && opts.SecretID == "":
+		return nil, errors.New("vault: role_id needs a secret_id")
+	case opts.RoleID == "" && opts.Token == "":
+		return nil, errors.New("vault: a token (or VAULT_TOKEN) or role_id and secret_id are required")
+	}
+	return &Vault{opts: opts, client: &http.Client{Timeout: fetchTimeout}}, nil
+}
+
+// Secret reads the field of the secret; with AppRole, a token Vault no longer
+// accepts is replaced by a new login once
+func (v *Vault) Secret(ctx context.Context) (string, error) {
+	token, err := v.clientToken(ctx)
+	if err != nil {
+		return "", err
+	}
+	fields, err := v.read(ctx, token)
+	var status *vaultError
+	if errors.As(err, &status) && status.code == http.StatusForbidden && v.opts.RoleID != "" {
+		v.mu.Lock()
+		v.token = ""
+		v.mu.Unlock()
+		if token, err = v.clientToken(ctx); err != nil {
+			return "", err
+		}
+		fields, err = v.read(ctx, token)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	value, ok := fields[v.opts.Field].(string)
+	if !ok || value == "" {
+		return "", fmt.Errorf("vault: %s has no %q field", v.opts.Path, v.opts.Field)
+	}
+	return value, nil
+}
+
+// read returns the fields of the secret
+func (v *Vault) read(ctx context.Context, token string) (map[string]any, error) {
+	var body struct {
+		Data map[string]any `json:"data"`
+	}
+	if err := v.do(ctx, http.MethodGet, v.opts.Path, token, nil, &body); err != nil {
+		return nil, err
+	}
+	// KV version 2 nests the fields under data.data, next to data.metadata
+	if nested, ok := body.Data["data"].(map[string]any); ok {
+		return nested, nil
+	}
+	return body.Data, nil
+}
+
+// clientToken returns the token to read with, logging in with AppRole when
+// there is none or it expired
+func (v *Vault) clientToken(ctx context.Context) (string, error) {
+	if v.opts.RoleID == "" {
+		return v.opts.Token, nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if v.token != "" && (v.expires.IsZero() || time.Now().Before(v.expires)) {
+		return v.token, nil
+	}
+
+	var login struct {
+		Auth struct {
+			ClientToken   string `json:"client_token"`
+			LeaseDuration int    `json:"lease_duration"`
+		} `json:"auth"`
+	}
+	credentials := map[string]string{"role_id": v.opts.RoleID, "secret_id": v.opts.SecretID}
+	if err := v.do(ctx, http.MethodPost, "auth/approle/login", "", credentials, &login); err != nil {
+		return "", fmt.Errorf("AppRole login failed: %w", err)
+	}
+	if login.Auth.ClientToken == "" {
+		return "", errors.New("vault: AppRole login returned no token")
+	}
+	v.token = login.Auth.ClientToken
+	v.expires = time.Time{}
+	if lease := time.Duration(login.Auth.LeaseDuration) * time.Second; lease > 0 {
+		// Early enough that a read doesn't race the expiry
+		v.expires = time.Now().Add(lease * 9 / 10)
+	}
+	return v.token, nil
+}
+
+// vaultError is a response of Vault with an error status
+type vaultError struct {
+	code   int
+	errors []string
+}
+
+func (e *vaultError) Error() string {
+	msg := fmt.Sprintf("vault answered %d", e.code)
+	if len(e.errors) > 0 {
+		msg += ": " + strings.Join(e.errors, "; ")
+	}
+	return msg
+}
+
+// do sends a request to the API of Vault and decodes its JSON answer into out
+func (v *Vault) do(ctx context.Context, method, apiPath, token string, in, out any) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.opts.Address+"/v1/"+apiPath, body)
+	if err != nil {
+		return err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+	if v.opts.Namespace != "" {
+		req.Header.Set("X-Vault-Namespace", v.opts.Namespace)
+	}
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("vault: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		failure := &vaultError{code: resp.StatusCode}
+		var errBody struct {
+			Errors []string `json:"errors"`
+		}
+		if json.NewDecoder(io.LimitReader(resp.Body, 64<<10)).Decode(&errBody) == nil {
+			failure.errors = errBody.Errors
+		}
+		return failure
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("vault: invalid response: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,315 @@
+package stream
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+const (
+	// sendBuffer is how many messages may wait for a client before it is
+	// dropped as too slow
+	sendBuffer = 64
+	// maxPending is how many batches a paused client keeps for its resume;
+	// later ones are only counted as missed
+	maxPending = 100
+	// pingInterval keeps idle connections alive through proxies
+	pingInterval = 30 * time.Second
+	// idleTimeout closes connections whose client stopped answering pings
+	idleTimeout = 3 * pingInterval
+)
+
+// ClientMessage is a command sent by a WebSocket client
+type ClientMessage struct {
+	// Type is "subscribe", "unsubscribe", "pause", "resume" or "filter"
+	Type string `json:"type"`
+	// Prefix is the path prefix to (un)subscribe, e.g. "/Documents"
+	Prefix string `json:"prefix"`
+
+	// Change filter of "filter" messages; an empty one removes the filter
+	Types     []string `json:"types"`
+	Patterns  []string `json:"patterns"`
+	MinSize   int64    `json:"min-size"`
+	MaxSize   int64    `json:"max-size"`
+	FilesOnly bool     `json:"files-only"`
+	DirsOnly  bool     `json:"dirs-only"`
+}
+
+// ServerMessage is pushed to WebSocket clients
+type ServerMessage struct {
+	// Type is "changes" for a batch, the type of the command it answers, or "error"
+	Type string `json:"type"`
+
+	// Batches carry the changes of one directory of a run
+	RunID     string        `json:"run_id,omitempty"`
+	Directory string        `json:"directory,omitempty"`
+	Changes   []diff.Change `json:"changes,omitempty"`
+
+	// Prefixes are the subscriptions after a subscribe or unsubscribe
+	Prefixes []string `json:"prefixes,omitempty"`
+	// Missed counts the batches dropped while paused, reported on resume
+	Missed int `json:"missed,omitempty"`
+	// Message explains an error
+	Message string `json:"message,omitempty"`
+}
+
+// Hub pushes the changes of every saved diff run to the WebSocket clients
+// subscribed to their paths; register it as a diff.Observer
+type Hub struct {
+	diff.NopObserver
+
+	mu      sync.Mutex
+	clients map[*client]struct{}
+	closed  bool
+}
+
+// NewHub returns a hub without clients
+func NewHub() *Hub {
+	return &Hub{clients: make(map[*client]struct{})}
+}
+
+// client is a connected subscriber; its fields are guarded by Hub.mu
+type client struct {
+	conn *Conn
+	send chan []byte
+
+	prefixes []string
+	filter   *diff.ChangeFilter
+	paused   bool
+	pending  [][]byte
+	missed   int
+}
+
+// OnRunComplete queues the matching changes of a run for every client
+func (h *Hub) OnRunComplete(run *diff.RunInfo, results []diff.Changes) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	for c := range h.clients {
+		for _, result := range results {
+			changes := c.matching(result.Directory, result.Changes)
+			if len(changes) == 0 {
+				continue
+			}
+			msg, err := json.Marshal(ServerMessage{Type: "changes", RunID: run.ID, Directory: result.Directory, Changes: changes})
+			if err != nil {
+				slog.Error("Failed to encode change batch", "error", err)
+				continue
+			}
+			h.deliver(c, msg)
+		}
+	}
+}
+
+// matching returns the changes under the client's prefixes that pass its filter
+func (c *client) matching(dir string, changes []diff.Change) []diff.Change {
+	var result []diff.Change
+	for _, change := range changes {
+		for _, prefix := range c.prefixes {
+			if underPrefix(change.Path, prefix) || (change.OldPath != "" && underPrefix(change.OldPath, prefix)) {
+				result = append(result, change)
+				break
+			}
+		}
+	}
+	if c.filter != nil && len(result) > 0 {
+		result = c.filter.Apply(dir, result)
+	}
+	return result
+}
+
+// underPrefix reports whether path is prefix or below it
+func underPrefix(path, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || path == prefix || strings.HasPrefix(path, prefix+"/")
+}
+
+// deliver queues msg for c, holding it back while c is paused; h.mu must be held
+func (h *Hub) deliver(c *client, msg []byte) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	if c.paused {
+		if len(c.pending) < maxPending {
+			c.pending = append(c.pending, msg)
+		} else {
+			c.missed++
+		}
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		// A client that can't keep up would hold back the diff runs
+		slog.Warn("Dropping slow WebSocket client", "remote_addr", c.conn.conn.RemoteAddr().String())
+		h.remove(c)
+		c.conn.closeWith(closePolicyError)
+	}
+}
+
+// remove forgets c and stops its writer; h.mu must be held
+func (h *Hub) remove(c *client) {
+	if _, ok := h.clients[c]; ok {
+		delete(h.clients, c)
+		close(c.send)
+	}
+}
+
+// Serve runs the connection of a client until it disconnects or the hub is closed
+func (h *Hub) Serve(conn *Conn, logger *slog.Logger) {
+	c := &client{conn: conn, send: make(chan []byte, sendBuffer)}
+	h.mu.Lock()
+	if h.closed {
+		h.mu.Unlock()
+		conn.Close()
+		return
+	}
+	h.clients[c] = struct{}{}
+	h.mu.Unlock()
+	logger.Info("WebSocket client connected")
+
+	writerDone := make(chan struct{})
+	go func() {
+		defer close(writerDone)
+		h.write(c)
+	}()
+
+	for {
+		data, err := conn.ReadMessage(idleTimeout)
+		if err != nil {
+			break
+		}
+		var msg ClientMessage
+		if err := json.Unmarshal(data, &msg); err != nil {
+			h.reply(c, ServerMessage{Type: "error", Message: "invalid message: " + err.Error()})
+			continue
+		}
+		h.handle(c, msg)
+	}
+
+	h.mu.Lock()
+	h.remove(c)
+	h.mu.Unlock()
+	<-writerDone
+	conn.Close()
+	logger.Info("WebSocket client disconnected")
+}
+
+// write sends queued messages and pings until the client is removed
+func (h *Hub) write(c *client) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case msg, ok := <-c.send:
+			if !ok {
+				return
+			}
+			if err := c.conn.WriteText(msg); err != nil {
+				// The reader notices the broken connection and removes the client
+				c.conn.closeWith(closeGoingAway)
+				for range c.send {
+				}
+				return
+			}
+		case <-ticker.C:
+			c.conn.Ping()
+		}
+	}
+}
+
+// handle applies a client command and acknowledges it
+func (h *Hub) handle(c *client, msg ClientMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	switch msg.Type {
+	case "subscribe", "unsubscribe":
+		if !strings.HasPrefix(msg.Prefix, "/") {
+			h.replyLocked(c, ServerMessage{Type: "error", Message: "'prefix' must be an absolute path, e.g. \"/Documents\""})
+			return
+		}
+		var prefixes []string
+		for _, prefix := range c.prefixes {
+			if prefix != msg.Prefix {
+				prefixes = append(prefixes, prefix)
+			}
+		}
+		if msg.Type == "subscribe" {
+			prefixes = append(prefixes, msg.Prefix)
+		}
+		c.prefixes = prefixes
+		h.replyLocked(c, ServerMessage{Type: msg.Type, Prefixes: c.prefixes})
+
+	case "pause":
+		c.paused = true
+		h.replyLocked(c, ServerMessage{Type: "pause"})
+
+	case "resume":
+		pending, missed := c.pending, c.missed
+		c.paused, c.pending, c.missed = false, nil, 0
+		h.replyLocked(c, ServerMessage{Type: "resume", Missed: missed})
+		for _, batch := range pending {
+			h.deliver(c, batch)
+		}
+
+	case "filter":
+		filter := &diff.ChangeFilter{
+			Types:     msg.Types,
+			Patterns:  msg.Patterns,
+			MinSize:   msg.MinSize,
+			MaxSize:   msg.MaxSize,
+			FilesOnly: msg.FilesOnly,
+			DirsOnly:  msg.DirsOnly,
+		}
+		if err := filter.Validate(); err != nil {
+			h.replyLocked(c, ServerMessage{Type: "error", Message: err.Error()})
+			return
+		}
+		if len(msg.Types) == 0 && len(msg.Patterns) == 0 && msg.MinSize == 0 && msg.MaxSize == 0 && !msg.FilesOnly && !msg.DirsOnly {
+			filter = nil
+		}
+		c.filter = filter
+		h.replyLocked(c, ServerMessage{Type: "filter"})
+
+	default:
+		h.replyLocked(c, ServerMessage{Type: "error", Message: fmt.Sprintf("unknown message type %q (expected subscribe, unsubscribe, pause, resume or filter)", msg.Type)})
+	}
+}
+
+// reply sends msg to c, even while paused
+func (h *Hub) reply(c *client, msg ServerMessage) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.replyLocked(c, msg)
+}
+
+// replyLocked is reply with h.mu held
+func (h *Hub) replyLocked(c *client, msg ServerMessage) {
+	if _, ok := h.clients[c]; !ok {
+		return
+	}
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return
+	}
+	paused := c.paused
+	c.paused = false
+	h.deliver(c, data)
+	c.paused = paused
+}
+
+// Close disconnects every client, e.g. at shutdown
+func (h *Hub) Close() {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.closed = true
+	for c := range h.clients {
+		h.remove(c)
+		c.conn.Close()
+	}
+}
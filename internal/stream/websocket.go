@@ -0,0 +1,294 @@
+package stream
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// websocketGUID is appended to the client key to compute Sec-WebSocket-Accept (RFC 6455)
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// maxMessageSize bounds the messages clients may send, which are small commands
+const maxMessageSize = 64 << 10
+
+// writeTimeout bounds how long a frame may take to reach a client
+const writeTimeout = 10 * time.Second
+
+// Frame opcodes
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xA
+)
+
+// Close status codes
+const (
+	closeNormal      = 1000
+	closeGoingAway   = 1001
+	closeProtocol    = 1002
+	closeTooBig      = 1009
+	closePolicyError = 1008
+)
+
+// ErrNotWebSocket is returned by Upgrade for requests that are not a WebSocket handshake
+var ErrNotWebSocket = errors.New("not a WebSocket handshake")
+
+// ErrOriginNotAllowed is returned by Upgrade for handshakes from a web page
+// of an origin that may not connect
+var ErrOriginNotAllowed = errors.New("origin not allowed")
+
+// Conn is a server side WebSocket connection; reads must come from one
+// goroutine, writes may come from any
+type Conn struct {
+	conn net.Conn
+	br   *bufio.Reader
+
+	writeMu sync.Mutex
+	closed  bool
+}
+
+// IsHandshake reports whether r asks to upgrade to a WebSocket
+func IsHandshake(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		headerHasToken(r.Header, "Connection", "upgrade") &&
+		headerHasToken(r.Header, "Upgrade", "websocket")
+}
+
+// Upgrade completes the WebSocket handshake of r, taking over the connection
+// Browsers send the Origin of the page opening the socket, which must be the
+// server's own or one of allowedOrigins ("*" allows any); other clients send none.
+// Headers already set on w, such as X-Request-ID, are sent with the 101 response.
+func Upgrade(w http.ResponseWriter, r *http.Request, allowedOrigins []string) (*Conn, error) {
+	if !IsHandshake(r) {
+		return nil, ErrNotWebSocket
+	}
+	if origin := r.Header.Get("Origin"); origin != "" && !originAllowed(origin, r.Host, allowedOrigins) {
+		return nil, fmt.Errorf("%w: %s", ErrOriginNotAllowed, origin)
+	}
+	if r.Header.Get("Sec-WebSocket-Version") != "13" {
+		return nil, fmt.Errorf("%w: unsupported version %q (expected 13)", ErrNotWebSocket, r.Header.Get("Sec-WebSocket-Version"))
+	}
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("%w: missing Sec-WebSocket-Key", ErrNotWebSocket)
+	}
+
+	netConn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		return nil, fmt.Errorf("failed to take over connection: %w", err)
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	var response strings.Builder
+	response.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\n")
+	response.WriteString("Sec-WebSocket-Accept: " + base64.StdEncoding.EncodeToString(sum[:]) + "\r\n")
+	for name, values := range w.Header() {
+		for _, value := range values {
+			response.WriteString(name + ": " + value + "\r\n")
+		}
+	}
+	response.WriteString("\r\n")
+
+	netConn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := netConn.Write([]byte(response.String())); err != nil {
+		netConn.Close()
+		return nil, fmt.Errorf("failed to complete handshake: %w", err)
+	}
+	return &Conn{conn: netConn, br: brw.Reader}, nil
+}
+
+// originAllowed reports whether a page of origin may open a socket to host
+func originAllowed(origin, host string, allowedOrigins []string) bool {
+	if u, err := url.Parse(origin); err == nil && strings.EqualFold(u.Host, host) {
+		return true
+	}
+	for _, allowed := range allowedOrigins {
+		if allowed == "*" || strings.TrimSuffix(allowed, "/") == origin {
+			return true
+		}
+	}
+	return false
+}
+
+// headerHasToken reports whether a comma-separated header contains token
+func headerHasToken(h http.Header, name, token string) bool {
+	for _, value := range h.Values(name) {
+		for _, part := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(part), token) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// ReadMessage returns the next text or binary message, answering pings on
+// the way; it returns io.EOF once the client closed the connection
+// The connection fails when no frame arrives within idle.
+func (c *Conn) ReadMessage(idle time.Duration) ([]byte, error) {
+	var message []byte
+	// started is set once the first frame of a fragmented message arrived
+	started := false
+	for {
+		c.conn.SetReadDeadline(time.Now().Add(idle))
+		fin, opcode, payload, err := c.readFrame()
+		if err != nil {
+			return nil, err
+		}
+
+		switch opcode {
+		case opPing:
+			if err := c.writeFrame(opPong, payload); err != nil {
+				return nil, err
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			c.closeWith(closeNormal)
+			return nil, io.EOF
+		case opText, opBinary:
+			// A new message can't start inside a fragmented one
+			if started {
+				c.closeWith(closeProtocol)
+				return nil, errors.New("new message inside a fragmented message")
+			}
+		case opContinuation:
+			if !started {
+				c.closeWith(closeProtocol)
+				return nil, errors.New("continuation frame outside a fragmented message")
+			}
+		default:
+			c.closeWith(closeProtocol)
+			return nil, fmt.Errorf("unknown opcode %#x", opcode)
+		}
+
+		started = true
+		message = append(message, payload...)
+		if len(message) > maxMessageSize {
+			c.closeWith(closeTooBig)
+			return nil, fmt.Errorf("message larger than %d bytes", maxMessageSize)
+		}
+		if fin {
+			return message, nil
+		}
+	}
+}
+
+// readFrame reads a single frame and unmasks its payload
+func (c *Conn) readFrame() (fin bool, opcode byte, payload []byte, err error) {
+	var header [2]byte
+	if _, err := io.ReadFull(c.br, header[:]); err != nil {
+		return false, 0, nil, err
+	}
+	fin = header[0]&0x80 != 0
+	opcode = header[0] & 0x0F
+	masked := header[1]&0x80 != 0
+	length := uint64(header[1] & 0x7F)
+
+	switch length {
+	case 126:
+		var ext [2]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = uint64(binary.BigEndian.Uint16(ext[:]))
+	case 127:
+		var ext [8]byte
+		if _, err := io.ReadFull(c.br, ext[:]); err != nil {
+			return false, 0, nil, err
+		}
+		length = binary.BigEndian.Uint64(ext[:])
+	}
+	// Clients must mask their frames
+	if !masked {
+		c.closeWith(closeProtocol)
+		return false, 0, nil, errors.New("unmasked frame from client")
+	}
+	if length > maxMessageSize {
+		c.closeWith(closeTooBig)
+		return false, 0, nil, fmt.Errorf("frame larger than %d bytes", maxMessageSize)
+	}
+
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return false, 0, nil, err
+	}
+	payload = make([]byte, length)
+	if _, err := io.ReadFull(c.br, payload); err != nil {
+		return false, 0, nil, err
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return fin, opcode, payload, nil
+}
+
+// WriteText sends a text message
+func (c *Conn) WriteText(data []byte) error {
+	return c.writeFrame(opText, data)
+}
+
+// Ping sends a ping, which clients answer to keep the connection alive
+func (c *Conn) Ping() error {
+	return c.writeFrame(opPing, nil)
+}
+
+// writeFrame sends an unmasked frame holding the whole payload
+func (c *Conn) writeFrame(opcode byte, payload []byte) error {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return net.ErrClosed
+	}
+
+	header := make([]byte, 2, 10)
+	header[0] = 0x80 | opcode
+	switch n := len(payload); {
+	case n < 126:
+		header[1] = byte(n)
+	case n <= 0xFFFF:
+		header[1] = 126
+		header = binary.BigEndian.AppendUint16(header, uint16(n))
+	default:
+		header[1] = 127
+		header = binary.BigEndian.AppendUint64(header, uint64(n))
+	}
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	if _, err := c.conn.Write(append(header, payload...)); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Close sends a going away close frame and closes the connection
+func (c *Conn) Close() error {
+	return c.closeWith(closeGoingAway)
+}
+
+// closeWith sends a close frame with code, unless the connection is closed already
+func (c *Conn) closeWith(code uint16) error {
+	c.writeFrame(opClose, binary.BigEndian.AppendUint16(nil, code))
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	if c.closed {
+		return nil
+	}
+	c.closed = true
+	return c.conn.Close()
+}
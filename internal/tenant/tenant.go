@@ -0,0 +1,216 @@
+// Package tenant lets API requests use other Nextcloud accounts than the
+// configured one, named in config.json or by their own credentials, so one
+// deployment can serve several users
+// Each account gets its own WebDAV client and detector, whose state is kept
+// apart from the others'.
+package tenant
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/webdav"
+)
+
+// Headers carrying the credentials of a request
+const (
+	UserHeader     = "X-Nextcloud-User"
+	PasswordHeader = "X-Nextcloud-Password"
+)
+
+var (
+	// ErrIncompleteCredentials is returned for requests with only one of the headers
+	ErrIncompleteCredentials = errors.New(UserHeader + " and " + PasswordHeader + " must be sent together")
+	// ErrUnknownAccount is returned for account names that are not configured
+	ErrUnknownAccount = errors.New("unknown account")
+)
+
+// Tenant is the client and detector of one account
+type Tenant struct {
+	// ID identifies the account in logs: its name for the accounts of
+	// config.json, else diff.AccountFingerprint, which its state is kept under
+	ID string
+	// Name is the name of an account of config.json, empty for per-request credentials
+	Name     string
+	Client   *webdav.Client
+	Detector *diff.Detector
+}
+
+// Factory builds the tenant of an account from its credentials
+type Factory func(username, password string) (*Tenant, error)
+
+// recheckAfter is how long the credentials of a pooled tenant are trusted
+// before Nextcloud is asked again, so a revoked app password stops working
+// for the endpoints that don't reach the server too
+const recheckAfter = 5 * time.Minute
+
+// Pool keeps the tenants of the accounts used most recently
+// Tenants are keyed by their credentials, not only by account, so a request
+// with a wrong password never gets the client of the right one.
+type Pool struct {
+	factory   Factory
+	maxCached int
+
+	// building is held for reading while tenants are built or rechecked,
+	// and for writing by Each
+	building sync.RWMutex
+
+	mu      sync.Mutex
+	tenants map[[sha256.Size]byte]*list.Element
+	// lru holds the tenants, most recently used first
+	lru *list.List
+	// loads are the builds and rechecks in flight, which requests with the
+	// same credentials wait for rather than repeat
+	loads map[[sha256.Size]byte]*load
+}
+
+type entry struct {
+	key    [sha256.Size]byte
+	tenant *Tenant
+	// checked is when Nextcloud last accepted the credentials
+	checked time.Time
+}
+
+// load is a build or recheck of a tenant, done once done is closed
+type load struct {
+	done   chan struct{}
+	tenant *Tenant
+	err    error
+}
+
+// NewPool keeps up to maxCached tenants built by factory
+func NewPool(maxCached int, factory Factory) *Pool {
+	return &Pool{
+		factory:   factory,
+		maxCached: maxCached,
+		tenants:   make(map[[sha256.Size]byte]*list.Element),
+		lru:       list.New(),
+		loads:     make(map[[sha256.Size]byte]*load),
+	}
+}
+
+// Get returns the tenant of an account, building it on first use and
+// checking its credentials again once they are older than recheckAfter
+// Slow builds only hold up requests with the same credentials. The least
+// recently used tenant is dropped when the pool is full; requests still using
+// it finish, but its background diffs can no longer be looked up.
+func (p *Pool) Get(username, password string) (*Tenant, error) {
+	key := sha256.Sum256([]byte(username + "\x00" + password))
+
+	p.mu.Lock()
+	var cached *Tenant
+	if elem, ok := p.tenants[key]; ok {
+		e := elem.Value.(*entry)
+		if time.Since(e.checked) < recheckAfter {
+			p.lru.MoveToFront(elem)
+			p.mu.Unlock()
+			return e.tenant, nil
+		}
+		cached = e.tenant
+	}
+	if l, ok := p.loads[key]; ok {
+		p.mu.Unlock()
+		<-l.done
+		return l.tenant, l.err
+	}
+	l := &load{done: make(chan struct{})}
+	p.loads[key] = l
+	p.mu.Unlock()
+
+	p.building.RLock()
+	if cached != nil {
+		l.tenant, l.err = cached, recheck(cached)
+	} else {
+		l.tenant, l.err = p.factory(username, password)
+	}
+	p.building.RUnlock()
+
+	p.mu.Lock()
+	delete(p.loads, key)
+	switch {
+	case l.err == nil:
+		p.store(key, l.tenant)
+	case errors.Is(l.err, webdav.ErrUnauthorized):
+		// The password was changed or revoked
+		if elem, ok := p.tenants[key]; ok {
+			p.lru.Remove(elem)
+			delete(p.tenants, key)
+		}
+	}
+	p.mu.Unlock()
+	if l.err != nil {
+		l.tenant = nil
+	}
+	close(l.done)
+	return l.tenant, l.err
+}
+
+// recheck asks Nextcloud whether the credentials of t are still accepted
+func recheck(t *Tenant) error {
+	if _, err := t.Client.Stat("/"); err != nil {
+		return fmt.Errorf("failed to check credentials: %w", err)
+	}
+	return nil
+}
+
+// store pools t as checked now, dropping the least recently used tenants
+// beyond maxCached; p.mu must be held
+func (p *Pool) store(key [sha256.Size]byte, t *Tenant) {
+	if elem, ok := p.tenants[key]; ok {
+		elem.Value.(*entry).checked = time.Now()
+		p.lru.MoveToFront(elem)
+		return
+	}
+	p.tenants[key] = p.lru.PushFront(&entry{key: key, tenant: t, checked: time.Now()})
+	for p.lru.Len() > p.maxCached {
+		oldest := p.lru.Back()
+		p.lru.Remove(oldest)
+		delete(p.tenants, oldest.Value.(*entry).key)
+	}
+}
+
+// Each calls fn with every pooled tenant; tenants built meanwhile wait for it
+func (p *Pool) Each(fn func(t *Tenant)) {
+	p.building.Lock()
+	defer p.building.Unlock()
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		fn(elem.Value.(*entry).tenant)
+	}
+}
+
+// Shutdown shuts the detectors of the pooled tenants down, see diff.Detector.Shutdown
+func (p *Pool) Shutdown(ctx context.Context) error {
+	p.mu.Lock()
+	var detectors []*diff.Detector
+	for elem := p.lru.Front(); elem != nil; elem = elem.Next() {
+		detectors = append(detectors, elem.Value.(*entry).tenant.Detector)
+	}
+	p.mu.Unlock()
+
+	var errs []error
+	for _, d := range detectors {
+		errs = append(errs, d.Shutdown(ctx))
+	}
+	return errors.Join(errs...)
+}
+
+type contextKey struct{}
+
+// NewContext returns ctx carrying the tenant of a request
+func NewContext(ctx context.Context, t *Tenant) context.Context {
+	return context.WithValue(ctx, contextKey{}, t)
+}
+
+// FromContext returns the tenant of a request, nil for the configured account
+func FromContext(ctx context.Context) *Tenant {
+	t, _ := ctx.Value(contextKey{}).(*Tenant)
+	return t
+}
@@ -0,0 +1,71 @@
+// Package version identifies the running build
+// Set its variables at build time:
+//
+//	go build -ldflags "-X go-nc-client/internal/version.Version=1.4.0 -X go-nc-client/internal/version.Commit=$(git rev-parse HEAD) -X go-nc-client/internal/version.Date=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+package version
+
+import (
+	"runtime"
+	"runtime/debug"
+	"strings"
+)
+
+// Build information, set with -ldflags -X
+var (
+	Version = "dev"
+	Commit  = ""
+	Date    = ""
+)
+
+// Info describes the running build
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit,omitempty"`
+	BuildDate string `json:"build_date,omitempty"`
+	GoVersion string `json:"go_version"`
+	// Modified is set when the binary was built from a tree with uncommitted changes
+	Modified bool `json:"modified,omitempty"`
+}
+
+// String formats the build as "1.4.0 (commit 3f2a9c1, built 2025-01-15T10:00:00Z, go1.25.5)"
+func (i Info) String() string {
+	details := []string{}
+	if i.Commit != "" {
+		commit := i.Commit
+		if i.Modified {
+			commit += "+modified"
+		}
+		details = append(details, "commit "+commit)
+	}
+	if i.BuildDate != "" {
+		details = append(details, "built "+i.BuildDate)
+	}
+	details = append(details, i.GoVersion)
+	return i.Version + " (" + strings.Join(details, ", ") + ")"
+}
+
+// Get returns the build information
+// Commit and date not set through -ldflags fall back to those go build
+// records from git.
+func Get() Info {
+	info := Info{Version: Version, Commit: Commit, BuildDate: Date, GoVersion: runtime.Version()}
+	build, ok := debug.ReadBuildInfo()
+	if !ok {
+		return info
+	}
+	for _, setting := range build.Settings {
+		switch setting.Key {
+		case "vcs.revision":
+			if info.Commit == "" {
+				info.Commit = setting.Value
+			}
+		case "vcs.time":
+			if info.BuildDate == "" {
+				info.BuildDate = setting.Value
+			}
+		case "vcs.modified":
+			info.Modified = setting.Value == "true"
+		}
+	}
+	return info
+}
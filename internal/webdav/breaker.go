@@ -0,0 +1,94 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBreakerThreshold = 5
+	defaultBreakerCooldown  = 30 * time.Second
+)
+
+// ErrCircuitOpen is returned without contacting the server while the circuit
+// breaker is open after repeated failures
+var ErrCircuitOpen = errors.New("circuit breaker open: WebDAV server is failing")
+
+// BreakerState describes the circuit breaker for health reporting
+type BreakerState struct {
+	State               string    `json:"state"` // "closed", "open" or "half-open"
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	OpenUntil           time.Time `json:"open_until,omitzero"`
+}
+
+// breaker trips after threshold consecutive failures and rejects requests
+// until the cool-down elapses; the next request then probes the server
+type breaker struct {
+	mu        sync.Mutex
+	threshold int // <= 0 disables the breaker
+	cooldown  time.Duration
+	failures  int
+	openUntil time.Time
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.threshold <= 0 || b.failures < b.threshold {
+		return nil
+	}
+	if time.Now().Before(b.openUntil) {
+		return fmt.Errorf("%w (retry after %s)", ErrCircuitOpen, b.openUntil.Format(time.RFC3339))
+	}
+	// Half-open: let requests through, a single failure reopens the circuit
+	return nil
+}
+
+func (b *breaker) record(success bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if success {
+		b.failures = 0
+		b.openUntil = time.Time{}
+		return
+	}
+
+	b.failures++
+	if b.threshold > 0 && b.failures >= b.threshold {
+		b.openUntil = time.Now().Add(b.cooldown)
+	}
+}
+
+func (b *breaker) state() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	state := BreakerState{State: "closed", ConsecutiveFailures: b.failures}
+	if b.threshold > 0 && b.failures >= b.threshold {
+		state.OpenUntil = b.openUntil
+		state.State = "half-open"
+		if time.Now().Before(b.openUntil) {
+			state.State = "open"
+		}
+	}
+	return state
+}
+
+// SetCircuitBreaker configures how many consecutive failures trip the breaker
+// and how long it then fails fast. A threshold <= 0 disables it.
+func (c *Client) SetCircuitBreaker(threshold int, cooldown time.Duration) {
+	c.breaker.mu.Lock()
+	defer c.breaker.mu.Unlock()
+
+	c.breaker.threshold = threshold
+	c.breaker.cooldown = cooldown
+}
+
+// CircuitState returns the current state of the circuit breaker
+func (c *Client) CircuitState() BreakerState {
+	return c.breaker.state()
+}
@@ -0,0 +1,133 @@
+package webdav
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// Capabilities is the server's OCS capabilities document
+type Capabilities struct {
+	Version struct {
+		Major   int    `json:"major"`
+		Minor   int    `json:"minor"`
+		Micro   int    `json:"micro"`
+		String  string `json:"string"`
+		Edition string `json:"edition"`
+	} `json:"version"`
+	Capabilities map[string]json.RawMessage `json:"capabilities"`
+	FetchedAt    time.Time                  `json:"fetched_at"`
+}
+
+// capabilityCache holds the last fetched capabilities
+type capabilityCache struct {
+	mu   sync.Mutex
+	caps *Capabilities
+}
+
+type ocsCapabilitiesResponse struct {
+	OCS struct {
+		Meta struct {
+			Status     string `json:"status"`
+			StatusCode int    `json:"statuscode"`
+			Message    string `json:"message"`
+		} `json:"meta"`
+		Data Capabilities `json:"data"`
+	} `json:"ocs"`
+}
+
+// Capabilities returns the server capabilities, fetching them from
+// ocs/v2.php/cloud/capabilities on first use or when refresh is set
+func (c *Client) Capabilities(refresh bool) (*Capabilities, error) {
+	c.capabilities.mu.Lock()
+	defer c.capabilities.mu.Unlock()
+
+	if c.capabilities.caps != nil && !refresh {
+		return c.capabilities.caps, nil
+	}
+
+	req, err := http.NewRequestWithContext(c.context(), "GET", serverRoot(c.baseURL)+"/ocs/v2.php/cloud/capabilities?format=json", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, *c.password.Load())
+	req.Header.Set("OCS-APIRequest", "true")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET capabilities failed with status %d", resp.StatusCode)
+	}
+
+	var ocs ocsCapabilitiesResponse
+	if err := json.NewDecoder(resp.Body).Decode(&ocs); err != nil {
+		return nil, fmt.Errorf("failed to parse capabilities: %w", err)
+	}
+	if ocs.OCS.Meta.Status != "" && ocs.OCS.Meta.Status != "ok" {
+		return nil, fmt.Errorf("capabilities request failed: %s", ocs.OCS.Meta.Message)
+	}
+
+	caps := ocs.OCS.Data
+	caps.FetchedAt = time.Now()
+	c.capabilities.caps = &caps
+	return &caps, nil
+}
+
+// filesCapabilities is the subset of the "files" capability we branch on
+type filesCapabilities struct {
+	BigFileChunking bool `json:"bigfilechunking"`
+	Undelete        bool `json:"undelete"`
+	Versioning      bool `json:"versioning"`
+	ChunkedUpload   struct {
+		MaxSize          int64 `json:"max_size"`
+		MaxParallelCount int   `json:"max_parallel_count"`
+	} `json:"chunked_upload"`
+}
+
+type davCapabilities struct {
+	Chunking string `json:"chunking"`
+}
+
+func (caps *Capabilities) files() filesCapabilities {
+	var files filesCapabilities
+	if raw, ok := caps.Capabilities["files"]; ok {
+		json.Unmarshal(raw, &files)
+	}
+	return files
+}
+
+// BigFileChunking reports whether chunked uploads are supported
+func (caps *Capabilities) BigFileChunking() bool {
+	return caps.files().BigFileChunking
+}
+
+// ChunkingVersion returns the DAV chunking version, e.g. "1.0"; empty if unsupported
+func (caps *Capabilities) ChunkingVersion() string {
+	var dav davCapabilities
+	if raw, ok := caps.Capabilities["dav"]; ok {
+		json.Unmarshal(raw, &dav)
+	}
+	return dav.Chunking
+}
+
+// MaxChunkSize returns the server's preferred upload chunk size, 0 if unspecified
+func (caps *Capabilities) MaxChunkSize() int64 {
+	return caps.files().ChunkedUpload.MaxSize
+}
+
+// Trashbin reports whether deleted files go to the trashbin
+func (caps *Capabilities) Trashbin() bool {
+	return caps.files().Undelete
+}
+
+// Versioning reports whether file versions are kept
+func (caps *Capabilities) Versioning() bool {
+	return caps.files().Versioning
+}
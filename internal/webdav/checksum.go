@@ -0,0 +1,33 @@
+package webdav
+
+import "strings"
+
+// ParseChecksums splits an oc:checksums value like "SHA1:abc MD5:def" into
+// a map of upper-case algorithm to lower-case digest
+func ParseChecksums(value string) map[string]string {
+	checksums := make(map[string]string)
+	for _, field := range strings.Fields(value) {
+		algo, digest, found := strings.Cut(field, ":")
+		if !found || digest == "" {
+			continue
+		}
+		checksums[strings.ToUpper(algo)] = strings.ToLower(digest)
+	}
+	return checksums
+}
+
+// CompareChecksums compares two oc:checksums values on the algorithms both carry
+// known is false when they have no algorithm in common
+func CompareChecksums(a, b string) (equal, known bool) {
+	checksumsA := ParseChecksums(a)
+	checksumsB := ParseChecksums(b)
+	for algo, digestA := range checksumsA {
+		if digestB, ok := checksumsB[algo]; ok {
+			if digestA != digestB {
+				return false, true
+			}
+			known = true
+		}
+	}
+	return known, known
+}
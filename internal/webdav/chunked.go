@@ -0,0 +1,189 @@
+package webdav
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultChunkSize is the size of the chunks of big uploads when none is configured
+	DefaultChunkSize = 10 << 20
+	// maxChunks is how many chunks Nextcloud accepts in one upload
+	maxChunks = 10000
+)
+
+// SetChunkSize sets the size of the chunks uploads bigger than it are sent in,
+// through Nextcloud's chunked upload API; a size <= 0 sends every upload in a
+// single PUT
+func (c *Client) SetChunkSize(size int64) {
+	c.chunkSize = size
+}
+
+// uploadChunkSize returns the chunk size for an upload of size bytes (<= 0
+// when unknown), 0 when it goes in a single PUT
+func (c *Client) uploadChunkSize(size int64) int64 {
+	if c.chunkSize <= 0 || (size > 0 && size <= c.chunkSize) {
+		return 0
+	}
+	caps, err := c.Capabilities(false)
+	if err != nil || caps.ChunkingVersion() == "" {
+		return 0
+	}
+	chunkSize := c.chunkSize
+	if max := caps.MaxChunkSize(); max > 0 && max < chunkSize {
+		chunkSize = max
+	}
+	if size > 0 && size/chunkSize >= maxChunks {
+		chunkSize = size/(maxChunks-1) + 1
+	}
+	return chunkSize
+}
+
+// uploadChunked sends body in chunks to an upload directory below /uploads,
+// then moves the assembled file to filePath
+// A body that turns out to fit in one chunk is sent with a single PUT.
+func (c *Client) uploadChunked(filePath string, body io.Reader, opts UploadOptions, chunkSize int64) (string, error) {
+	buf := make([]byte, chunkSize)
+	n, err := io.ReadFull(body, buf)
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		opts.Size = int64(n)
+		return c.put(filePath, bytes.NewReader(buf[:n]), opts)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	// The final MOVE can't carry If-Match, so the conditions are checked first
+	if err := c.checkUploadConditions(filePath, opts); err != nil {
+		return "", err
+	}
+
+	uploadDir := "/uploads/" + c.username + "/" + newUploadID()
+	destination := c.davURL(c.buildWebDAVPath(filePath))
+	if _, err := c.chunkRequest("MKCOL", uploadDir, nil, destination, opts, http.StatusCreated); err != nil {
+		return "", fmt.Errorf("failed to start chunked upload: %w", err)
+	}
+
+	etag, err := c.sendChunks(uploadDir, destination, buf[:n], body, opts)
+	if err != nil {
+		// Drop the chunks sent so far; the server would expire them later
+		c.chunkRequest("DELETE", uploadDir, nil, "", UploadOptions{}, http.StatusNoContent)
+		if errors.Is(err, errUploadPrecondition) {
+			return "", &PreconditionFailedError{Path: filePath, IfMatch: opts.IfMatch}
+		}
+		return "", err
+	}
+	return etag, nil
+}
+
+// errUploadPrecondition marks a final MOVE refused because the file exists
+var errUploadPrecondition = errors.New("precondition failed")
+
+// sendChunks uploads first and the rest of body as numbered chunks, then
+// assembles them at destination
+func (c *Client) sendChunks(uploadDir, destination string, first []byte, body io.Reader, opts UploadOptions) (string, error) {
+	chunk := first
+	for number := 1; ; number++ {
+		if number > maxChunks {
+			return "", fmt.Errorf("upload exceeds %d chunks", maxChunks)
+		}
+		chunkPath := uploadDir + "/" + strconv.Itoa(number)
+		if _, err := c.chunkRequest("PUT", chunkPath, chunk, destination, opts, http.StatusCreated, http.StatusNoContent); err != nil {
+			return "", fmt.Errorf("failed to upload chunk %d: %w", number, err)
+		}
+
+		n, err := io.ReadFull(body, chunk[:cap(chunk)])
+		if err == io.EOF {
+			break
+		}
+		if err != nil && err != io.ErrUnexpectedEOF {
+			return "", err
+		}
+		chunk = chunk[:n]
+	}
+
+	resp, err := c.chunkRequest("MOVE", uploadDir+"/.file", nil, destination, opts, http.StatusCreated, http.StatusNoContent)
+	if err != nil {
+		return "", fmt.Errorf("failed to assemble chunks: %w", err)
+	}
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = resp.Header.Get("OC-ETag")
+	}
+	return strings.Trim(etag, "\""), nil
+}
+
+// chunkRequest sends one request of a chunked upload and checks its status
+func (c *Client) chunkRequest(method, webdavPath string, body []byte, destination string, opts UploadOptions, expected ...int) (*http.Response, error) {
+	var reader io.Reader = http.NoBody
+	if body != nil {
+		// A bytes.Reader lets retries resend the chunk
+		reader = bytes.NewReader(body)
+	}
+	req, err := c.newRequest(method, webdavPath, reader)
+	if err != nil {
+		return nil, err
+	}
+	if destination != "" {
+		req.Header.Set("Destination", destination)
+	}
+	if opts.Size > 0 {
+		req.Header.Set("OC-Total-Length", strconv.FormatInt(opts.Size, 10))
+	}
+	if method == "MOVE" && opts.IfNoneMatch {
+		req.Header.Set("Overwrite", "F")
+	}
+
+	resp, err := c.doTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if method == "MOVE" && resp.StatusCode == http.StatusPreconditionFailed {
+		return nil, errUploadPrecondition
+	}
+	for _, status := range expected {
+		if resp.StatusCode == status {
+			return resp, nil
+		}
+	}
+	return nil, statusError(method, resp.StatusCode)
+}
+
+// checkUploadConditions applies IfMatch and IfNoneMatch to the current file
+func (c *Client) checkUploadConditions(filePath string, opts UploadOptions) error {
+	switch {
+	case opts.IfNoneMatch:
+		exists, err := c.Exists(filePath)
+		if err != nil {
+			return err
+		}
+		if exists {
+			return &PreconditionFailedError{Path: filePath}
+		}
+	case opts.IfMatch != "":
+		info, err := c.Stat(filePath)
+		if errors.Is(err, ErrNotFound) || (err == nil && info.ETag != strings.Trim(opts.IfMatch, "\"")) {
+			return &PreconditionFailedError{Path: filePath, IfMatch: opts.IfMatch}
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func newUploadID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return "go-nc-client-" + hex.EncodeToString(b)
+}
@@ -1,32 +1,80 @@
 package webdav
 
 import (
+	"context"
 	"fmt"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
+	"net/http/cookiejar"
+	"path"
+	"slices"
+	"sort"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
 type Client struct {
-	baseURL    string
-	username   string
-	password   string
+	baseURL  string
+	username string
+	// password is shared with the clients WithContext returns, so
+	// SetPassword reaches them all
+	password   *atomic.Pointer[string]
+	pathPrefix string // e.g. "/files/username", empty when files live at the base URL
 	httpClient *http.Client
+
+	// transferClient has no overall timeout so large bodies can stream;
+	// only the wait for response headers is bounded
+	transferClient *http.Client
+
+	hooks        *requestHooks
+	metrics      *metrics
+	breaker      *breaker
+	capabilities *capabilityCache
+	// chunkSize is the size of upload chunks, <= 0 to upload in a single PUT
+	chunkSize int64
+
+	// ctx is the context of the requests made, see WithContext
+	ctx context.Context
 }
 
 func NewClient(baseURL, username, password string) *Client {
-	httpClient := &http.Client{
-		Timeout: 30 * time.Second,
+	c := &Client{
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		username:     username,
+		password:     &atomic.Pointer[string]{},
+		hooks:        &requestHooks{},
+		metrics:      newMetrics(),
+		breaker:      &breaker{threshold: defaultBreakerThreshold, cooldown: defaultBreakerCooldown},
+		capabilities: &capabilityCache{},
+		chunkSize:    DefaultChunkSize,
 	}
+	c.password.Store(&password)
+	c.SetPathPrefix(DefaultPathPrefix)
+	c.AddRequestHook(c.metrics.record)
+
+	baseTransport := http.DefaultTransport.(*http.Transport).Clone()
+	baseTransport.ResponseHeaderTimeout = 30 * time.Second
+	transport := NewInstrumentedTransport(baseTransport, c.fireRequestHooks)
 
-	return &Client{
-		baseURL:    strings.TrimSuffix(baseURL, "/"),
-		username:   username,
-		password:   password,
-		httpClient: httpClient,
+	// Redirects are followed by do() so DAV verbs are not turned into GETs
+	noRedirect := func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	c.httpClient = &http.Client{
+		Timeout:       30 * time.Second,
+		Transport:     transport,
+		CheckRedirect: noRedirect,
+	}
+	c.transferClient = &http.Client{
+		Transport:     transport,
+		CheckRedirect: noRedirect,
 	}
+	c.SetSessionCookies(true)
+
+	return c
 }
 
 // FileInfo represents information about a file or directory
@@ -36,8 +84,26 @@ type FileInfo struct {
 	Size         int64
 	ModifiedTime time.Time
 	ETag         string
+	Favorite     bool
+	Checksum     string // oc:checksums, e.g. "SHA1:... MD5:...", empty if the server has none
 }
 
+// propfindBody requests the standard DAV properties plus the Nextcloud
+// extensions we track, which are not part of an allprop response
+const propfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:prop>
+    <d:displayname/>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getcontenttype/>
+    <d:getlastmodified/>
+    <d:getetag/>
+    <oc:favorite/>
+    <oc:checksums/>
+  </d:prop>
+</d:propfind>`
+
 // isHidden checks if a file or directory path contains hidden components
 // Hidden files/directories are those starting with "."
 func isHidden(path string) bool {
@@ -57,26 +123,102 @@ type SubdirETagChecker func(subdirPath string) (bool, string, []FileInfo, error)
 // SubdirETagStorer is a function that stores a subdirectory's ETag
 type SubdirETagStorer func(subdirPath string, etag string)
 
+// WalkFilter reports whether an item should be left out of a recursive listing
+// A skipped directory is not descended into
+type WalkFilter func(filePath string, isDir bool) bool
+
+// WalkOptions restricts a recursive listing
+type WalkOptions struct {
+	// Skip prunes items, and whole subtrees, from the walk
+	Skip WalkFilter
+	// MaxDepth limits how many levels below the listed directory are walked
+	// (1 = direct children only, 0 = unlimited)
+	MaxDepth int
+	// Stats, when set, is incremented with the work the walk did
+	Stats *WalkStats
+	// Context cancels the walk, aborting the listing in flight; nil never cancels
+	Context context.Context
+	// Sorted visits the children of each directory in name order, so the
+	// listing comes out in path order (see ComparePaths)
+	Sorted bool
+	// Each, when set, receives the items as the walk finds them instead of
+	// the returned slice, which stays empty; an error aborts the walk
+	Each func(FileInfo) error
+}
+
+// WalkStats counts the work done by a recursive listing
+type WalkStats struct {
+	// Listed is the number of directories listed, one PROPFIND each
+	Listed int
+	// Reused is the number of subtrees taken from the previous state because
+	// their ETag was unchanged
+	Reused int
+}
+
+// ComparePaths orders paths component by component, the order of a Sorted
+// walk: a directory comes right before everything below it
+func ComparePaths(a, b string) int {
+	return slices.Compare(strings.Split(strings.Trim(a, "/"), "/"), strings.Split(strings.Trim(b, "/"), "/"))
+}
+
 // ListFiles lists all files in a directory recursively
 func (c *Client) ListFiles(dirPath string, includeHidden bool) ([]FileInfo, error) {
-	return c.ListFilesWithETagOptimization(dirPath, includeHidden, nil, nil)
+	return c.ListFilesWithETagOptimization(dirPath, includeHidden, nil, nil, nil, WalkOptions{})
+}
+
+// ListFilesWithProgress lists all files recursively, reporting scan progress to hook
+func (c *Client) ListFilesWithProgress(dirPath string, includeHidden bool, hook ProgressHook) ([]FileInfo, error) {
+	return c.ListFilesWithETagOptimization(dirPath, includeHidden, nil, nil, hook, WalkOptions{})
+}
+
+// WithContext returns a client making its requests with ctx, e.g. that of an
+// API request so the work stops when its caller goes away
+// It shares everything else with c: settings, metrics, circuit breaker and caches.
+func (c *Client) WithContext(ctx context.Context) *Client {
+	bound := *c
+	bound.ctx = ctx
+	return &bound
+}
+
+// context returns the context requests are made with
+func (c *Client) context() context.Context {
+	if c.ctx == nil {
+		return context.Background()
+	}
+	return c.ctx
 }
 
 // ListFilesWithETagOptimization lists files with ETag-based optimization for subdirectories
-func (c *Client) ListFilesWithETagOptimization(dirPath string, includeHidden bool, etagChecker SubdirETagChecker, etagStorer SubdirETagStorer) ([]FileInfo, error) {
+// The optional hook is notified of each visited directory
+func (c *Client) ListFilesWithETagOptimization(dirPath string, includeHidden bool, etagChecker SubdirETagChecker, etagStorer SubdirETagStorer, hook ProgressHook, walk WalkOptions) ([]FileInfo, error) {
 	webdavPath := c.buildWebDAVPath(dirPath)
 	var files []FileInfo
 
-	err := c.walkDirWithProgress(webdavPath, dirPath, &files, includeHidden, func(int) {}, etagChecker, etagStorer)
+	w := &walker{
+		includeHidden: includeHidden,
+		etagChecker:   etagChecker,
+		etagStorer:    etagStorer,
+		skip:          walk.Skip,
+		maxDepth:      walk.MaxDepth,
+		stats:         walk.Stats,
+		ctx:           walk.Context,
+		sorted:        walk.Sorted,
+		each:          walk.Each,
+	}
+	if w.ctx == nil {
+		w.ctx = c.ctx
+	}
+	progress := &scanTracker{hook: hook}
+	err := c.walkDirWithProgress(webdavPath, dirPath, &files, w, progress, 0)
 	if err != nil {
-		log.Printf("Error scanning %s: %v", dirPath, err)
+		slog.Error("Failed to scan directory", "directory", dirPath, "error", err)
 	}
 	return files, err
 }
 
 // ListDir lists only the immediate children of a directory (non-recursive)
 func (c *Client) ListDir(dirPath string, includeHidden bool) ([]FileInfo, error) {
-	// Construct Nextcloud WebDAV path: /files/username/directory
+	// Construct WebDAV path, e.g. Nextcloud: /files/username/directory
 	webdavPath := c.buildWebDAVPath(dirPath)
 
 	// Ensure path ends with / for directories
@@ -84,22 +226,22 @@ func (c *Client) ListDir(dirPath string, includeHidden bool) ([]FileInfo, error)
 		webdavPath += "/"
 	}
 
-	req, err := http.NewRequest("PROPFIND", c.baseURL+webdavPath, nil)
+	req, err := c.newRequest("PROPFIND", webdavPath, strings.NewReader(propfindBody))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Depth", "1")
-	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("PROPFIND failed with status %d", resp.StatusCode)
+		return nil, statusError("PROPFIND", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -141,42 +283,154 @@ func (c *Client) ListDir(dirPath string, includeHidden bool) ([]FileInfo, error)
 	return files, nil
 }
 
-// buildWebDAVPath constructs the full WebDAV path for Nextcloud
-// Input: "/Obsidian" -> Output: "/files/username/Obsidian"
+// SetPassword replaces the password of the requests made from now on, e.g.
+// once the app password was rotated
+func (c *Client) SetPassword(password string) {
+	c.password.Store(&password)
+}
+
+// SetSessionCookies enables or disables the cookie jar
+// Nextcloud hands out a session cookie after the first authenticated request;
+// replaying it skips the comparatively expensive basic-auth password check
+// on every subsequent request.
+func (c *Client) SetSessionCookies(enabled bool) {
+	var jar http.CookieJar
+	if enabled {
+		// cookiejar.New only fails with a non-nil options argument
+		jar, _ = cookiejar.New(nil)
+	}
+	c.httpClient.Jar = jar
+	c.transferClient.Jar = jar
+}
+
+// newRequest builds an authenticated request against a WebDAV path
+// webdavPath is unescaped; every segment is percent-encoded here
+func (c *Client) newRequest(method, webdavPath string, body io.Reader) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(c.context(), method, c.davURL(webdavPath), body)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, *c.password.Load())
+
+	// Multistatus bodies of big trees compress very well
+	if method == "PROPFIND" || method == "REPORT" {
+		req.Header.Set("Accept-Encoding", "gzip")
+	}
+	return req, nil
+}
+
+// davURL returns the absolute, escaped URL of an unescaped WebDAV path
+func (c *Client) davURL(webdavPath string) string {
+	return c.baseURL + escapePath(webdavPath)
+}
+
+// DefaultPathPrefix is the Nextcloud/ownCloud layout below /remote.php/dav
+const DefaultPathPrefix = "/files/{username}"
+
+// SetPathPrefix sets where user files live below the base URL
+// "{username}" is replaced by the configured username. Use "/" or "" for
+// plain WebDAV servers (SabreDAV, Apache mod_dav, rclone) serving files at the root.
+func (c *Client) SetPathPrefix(template string) {
+	prefix := strings.ReplaceAll(template, "{username}", c.username)
+	prefix = strings.TrimSuffix(prefix, "/")
+	if prefix != "" && !strings.HasPrefix(prefix, "/") {
+		prefix = "/" + prefix
+	}
+	c.pathPrefix = prefix
+}
+
+// buildWebDAVPath constructs the full WebDAV path for the configured layout
+// Input: "/Obsidian" -> Output: "/files/username/Obsidian" (Nextcloud)
 func (c *Client) buildWebDAVPath(dirPath string) string {
 	dirPath = strings.TrimPrefix(dirPath, "/")
 	if dirPath == "" {
-		return "/files/" + c.username + "/"
+		return c.pathPrefix + "/"
 	}
-	return "/files/" + c.username + "/" + dirPath
+	return c.pathPrefix + "/" + dirPath
+}
+
+// stripPathPrefix removes the server layout prefix from a WebDAV path
+// Handles both /remote.php/dav/files/username/ and /files/username/ forms
+func (c *Client) stripPathPrefix(webdavPath string) string {
+	// Remove /remote.php/dav/files/username/ prefix if present
+	webdavPath = strings.TrimPrefix(webdavPath, "/remote.php/dav"+c.pathPrefix+"/")
+
+	// Also handle /files/username/ prefix (without remote.php/dav)
+	if c.pathPrefix != "" {
+		webdavPath = strings.TrimPrefix(webdavPath, c.pathPrefix+"/")
+	}
+
+	// Ensure it starts with /
+	if !strings.HasPrefix(webdavPath, "/") {
+		webdavPath = "/" + webdavPath
+	}
+
+	return webdavPath
+}
+
+// walker holds the settings of one recursive listing
+type walker struct {
+	includeHidden bool
+	etagChecker   SubdirETagChecker
+	etagStorer    SubdirETagStorer
+	skip          WalkFilter
+	maxDepth      int
+	stats         *WalkStats
+	ctx           context.Context
+	sorted        bool
+	each          func(FileInfo) error
+}
+
+// add hands an item of the listing to each, or appends it to files
+func (w *walker) add(files *[]FileInfo, item FileInfo) error {
+	if w.each != nil {
+		return w.each(item)
+	}
+	*files = append(*files, item)
+	return nil
+}
+
+// descend reports whether children of a directory at depth are walked
+func (w *walker) descend(depth int) bool {
+	return w.maxDepth <= 0 || depth < w.maxDepth
 }
 
 // walkDirWithProgress is the internal recursive function with progress tracking and ETag optimization
-func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, files *[]FileInfo, includeHidden bool, progressTracker func(int), etagChecker SubdirETagChecker, etagStorer SubdirETagStorer) error {
+// depth is the number of levels webdavPath lies below the listed directory
+func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, files *[]FileInfo, w *walker, progress *scanTracker, depth int) error {
 	// Ensure path ends with / for directories
 	if !strings.HasSuffix(webdavPath, "/") {
 		webdavPath += "/"
 	}
 
 	// Call progress tracker
-	progressTracker(len(*files))
+	progress.visit(originalPath, len(*files))
+	if w.stats != nil {
+		w.stats.Listed++
+	}
 
-	req, err := http.NewRequest("PROPFIND", c.baseURL+webdavPath, nil)
+	req, err := c.newRequest("PROPFIND", webdavPath, strings.NewReader(propfindBody))
 	if err != nil {
 		return err
 	}
+	if w.ctx != nil {
+		if err := w.ctx.Err(); err != nil {
+			return err
+		}
+		req = req.WithContext(w.ctx)
+	}
 
 	req.Header.Set("Depth", "1")
-	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("PROPFIND failed with status %d", resp.StatusCode)
+		return statusError("PROPFIND", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
@@ -189,6 +443,11 @@ func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, fil
 	if err != nil {
 		return err
 	}
+	if w.sorted {
+		sort.SliceStable(items, func(i, j int) bool {
+			return path.Base(strings.TrimSuffix(items[i].Path, "/")) < path.Base(strings.TrimSuffix(items[j].Path, "/"))
+		})
+	}
 
 	for _, item := range items {
 		// Normalize paths for comparison
@@ -209,28 +468,37 @@ func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, fil
 		relativePath := c.extractRelativePath(item.Path, originalPath)
 		item.Path = relativePath
 
+		if w.skip != nil && w.skip(relativePath, item.IsDir) {
+			continue
+		}
+
 		// Filter hidden files if not including them
-		if !includeHidden && isHidden(relativePath) {
+		if !w.includeHidden && isHidden(relativePath) {
 			// Still need to recurse into hidden directories if they exist
 			// but skip adding them to the results
 			if item.IsDir {
 				if !strings.HasSuffix(fullWebDAVPath, "/") {
 					fullWebDAVPath += "/"
 				}
-				// For hidden directories, we still need to recurse but use a no-op progress tracker
+				// For hidden directories, we still need to recurse but use a nil progress tracker
 				// to avoid spam (hidden dirs are filtered out anyway)
-				noOpProgress := func(int) {}
-				if err := c.walkDirWithProgress(fullWebDAVPath, relativePath, files, includeHidden, noOpProgress, etagChecker, etagStorer); err != nil {
-					return err
+				if w.descend(depth + 1) {
+					if err := c.walkDirWithProgress(fullWebDAVPath, relativePath, files, w, nil, depth+1); err != nil {
+						return err
+					}
 				}
 			}
 			continue
 		}
 
-		*files = append(*files, item)
+		if err := w.add(files, item); err != nil {
+			return err
+		}
 
 		// Recursively walk subdirectories using the full WebDAV path
-		if item.IsDir {
+		// Directories at the depth limit are listed but neither walked nor
+		// ETag-recorded, since their stored content would be incomplete
+		if item.IsDir && w.descend(depth+1) {
 			// Ensure the path ends with / for directories
 			if !strings.HasSuffix(fullWebDAVPath, "/") {
 				fullWebDAVPath += "/"
@@ -241,29 +509,38 @@ func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, fil
 			currentETag := item.ETag // ETag is already available from PROPFIND response
 
 			// Store ETag for this subdirectory (always, so it's available for next run)
-			if etagStorer != nil && currentETag != "" {
-				etagStorer(relativePath, currentETag)
+			if w.etagStorer != nil && currentETag != "" {
+				w.etagStorer(relativePath, currentETag)
 			}
 
 			// Check if we can optimize by reusing previous state
-			if etagChecker != nil && currentETag != "" {
-				hasPrevETag, prevETag, prevFiles, err := etagChecker(relativePath)
+			if w.etagChecker != nil && currentETag != "" {
+				hasPrevETag, prevETag, prevFiles, err := w.etagChecker(relativePath)
 				if err == nil && hasPrevETag && prevETag == currentETag {
 					// Subdirectory unchanged, reuse files from previous state
 					for _, prevFile := range prevFiles {
-						if includeHidden || !isHidden(prevFile.Path) {
-							*files = append(*files, prevFile)
+						if !w.includeHidden && isHidden(prevFile.Path) {
+							continue
 						}
+						if w.skip != nil && w.skip(prevFile.Path, prevFile.IsDir) {
+							continue
+						}
+						if err := w.add(files, prevFile); err != nil {
+							return err
+						}
+					}
+					if w.stats != nil {
+						w.stats.Reused++
 					}
 					shouldScan = false
 				}
 			}
 
 			if shouldScan {
-				if err := c.walkDirWithProgress(fullWebDAVPath, relativePath, files, includeHidden, progressTracker, etagChecker, etagStorer); err != nil {
+				if err := c.walkDirWithProgress(fullWebDAVPath, relativePath, files, w, progress, depth+1); err != nil {
 					return err
 				}
-				progressTracker(len(*files))
+				progress.report(relativePath, len(*files))
 			}
 		}
 	}
@@ -271,40 +548,16 @@ func (c *Client) walkDirWithProgress(webdavPath string, originalPath string, fil
 	return nil
 }
 
-// normalizePathForComparison normalizes a path by removing Nextcloud prefixes
+// normalizePathForComparison normalizes a path by removing the layout prefix
 // Used for comparing paths regardless of their format
 func (c *Client) normalizePathForComparison(path string) string {
-	// Remove /remote.php/dav/files/username/ prefix if present
-	nextcloudPrefix := "/remote.php/dav/files/" + c.username + "/"
-	path = strings.TrimPrefix(path, nextcloudPrefix)
-
-	// Also handle /files/username/ prefix
-	filesPrefix := "/files/" + c.username + "/"
-	path = strings.TrimPrefix(path, filesPrefix)
-
-	// Ensure it starts with /
-	if !strings.HasPrefix(path, "/") {
-		path = "/" + path
-	}
-
-	return path
+	return c.stripPathPrefix(path)
 }
 
 // extractRelativePath extracts the relative path from a full WebDAV path
-// Removes Nextcloud-specific prefixes like /remote.php/dav/files/username/
+// Removes layout prefixes like /remote.php/dav/files/username/
 func (c *Client) extractRelativePath(webdavPath, baseDir string) string {
-	// Remove /remote.php/dav/files/username/ prefix if present
-	nextcloudPrefix := "/remote.php/dav/files/" + c.username + "/"
-	webdavPath = strings.TrimPrefix(webdavPath, nextcloudPrefix)
-
-	// Also handle /files/username/ prefix (without remote.php/dav)
-	filesPrefix := "/files/" + c.username + "/"
-	webdavPath = strings.TrimPrefix(webdavPath, filesPrefix)
-
-	// Ensure it starts with /
-	if !strings.HasPrefix(webdavPath, "/") {
-		webdavPath = "/" + webdavPath
-	}
+	webdavPath = c.stripPathPrefix(webdavPath)
 
 	// Remove trailing slash for files (but keep / for root)
 	webdavPath = strings.TrimSuffix(webdavPath, "/")
@@ -315,29 +568,65 @@ func (c *Client) extractRelativePath(webdavPath, baseDir string) string {
 	return webdavPath
 }
 
-// Stat gets information about a specific file
-func (c *Client) Stat(filePath string) (*FileInfo, error) {
-	webdavPath := c.buildWebDAVPath(filePath)
-
-	req, err := http.NewRequest("PROPFIND", c.baseURL+webdavPath, nil)
+// propfindPath returns the multistatus of a Depth:0 PROPFIND of filePath
+func (c *Client) propfindPath(filePath, propfind string) ([]byte, error) {
+	req, err := c.newRequest("PROPFIND", c.buildWebDAVPath(filePath), strings.NewReader(propfind))
 	if err != nil {
 		return nil, err
 	}
 
 	req.Header.Set("Depth", "0")
-	req.SetBasicAuth(c.username, c.password)
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
 
-	resp, err := c.httpClient.Do(req)
+	resp, err := c.do(req)
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("PROPFIND failed with status %d", resp.StatusCode)
+		return nil, statusError("PROPFIND", resp.StatusCode)
 	}
+	return io.ReadAll(resp.Body)
+}
 
-	body, err := io.ReadAll(resp.Body)
+const existsPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:resourcetype/>
+  </d:prop>
+</d:propfind>`
+
+// Exists reports whether a file or directory exists using a minimal Depth:0 PROPFIND
+func (c *Client) Exists(filePath string) (bool, error) {
+	req, err := c.newRequest("PROPFIND", c.buildWebDAVPath(filePath), strings.NewReader(existsPropfindBody))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusMultiStatus, http.StatusOK:
+		return true, nil
+	case http.StatusNotFound:
+		return false, nil
+	default:
+		return false, statusError("PROPFIND", resp.StatusCode)
+	}
+}
+
+// Stat gets information about a specific file
+func (c *Client) Stat(filePath string) (*FileInfo, error) {
+	body, err := c.propfindPath(filePath, propfindBody)
 	if err != nil {
 		return nil, err
 	}
@@ -348,7 +637,7 @@ func (c *Client) Stat(filePath string) (*FileInfo, error) {
 	}
 
 	if len(items) == 0 {
-		return nil, fmt.Errorf("file not found: %s", filePath)
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, filePath)
 	}
 
 	result := items[0]
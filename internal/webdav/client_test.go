@@ -0,0 +1,73 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// passwordCheckCost stands in for Nextcloud verifying a basic-auth password
+// against its hash, which a valid session cookie skips
+const passwordCheckCost = 2 * time.Millisecond
+
+// newSessionServer returns a server answering PROPFIND like Nextcloud: it
+// checks the password of requests without a session cookie and hands one out
+func newSessionServer(tb testing.TB) (*httptest.Server, *atomic.Int64) {
+	var passwordChecks atomic.Int64
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if cookie, err := r.Cookie("nc_session_id"); err != nil || cookie.Value != "session" {
+			if _, _, ok := r.BasicAuth(); !ok {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			passwordChecks.Add(1)
+			time.Sleep(passwordCheckCost)
+			http.SetCookie(w, &http.Cookie{Name: "nc_session_id", Value: "session", Path: "/"})
+		}
+		w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+		w.WriteHeader(http.StatusMultiStatus)
+		fmt.Fprintf(w, `<?xml version="1.0"?><d:multistatus xmlns:d="DAV:"><d:response><d:href>%s</d:href><d:propstat><d:prop><d:resourcetype><d:collection/></d:resourcetype><d:getetag>"1"</d:getetag></d:prop><d:status>HTTP/1.1 200 OK</d:status></d:propstat></d:response></d:multistatus>`, r.URL.Path)
+	}))
+	tb.Cleanup(srv.Close)
+	return srv, &passwordChecks
+}
+
+// BenchmarkPropfindSessionCookies compares PROPFIND throughput with the
+// session cookie replayed (the default) and with the cookie jar disabled
+func BenchmarkPropfindSessionCookies(b *testing.B) {
+	for _, enabled := range []bool{true, false} {
+		b.Run(fmt.Sprintf("cookies=%t", enabled), func(b *testing.B) {
+			srv, passwordChecks := newSessionServer(b)
+			client := NewClient(srv.URL+"/remote.php/dav", "alice", "secret")
+			client.SetPathPrefix("/")
+			client.SetSessionCookies(enabled)
+
+			b.ResetTimer()
+			for b.Loop() {
+				if _, err := client.Stat("/Documents"); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(passwordChecks.Load())/float64(b.N), "password-checks/op")
+		})
+	}
+}
+
+func TestSessionCookiesSkipPasswordChecks(t *testing.T) {
+	srv, passwordChecks := newSessionServer(t)
+	client := NewClient(srv.URL+"/remote.php/dav", "alice", "secret")
+	client.SetPathPrefix("/")
+	client.SetSessionCookies(true)
+
+	for range 5 {
+		if _, err := client.Stat("/Documents"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if got := passwordChecks.Load(); got != 1 {
+		t.Errorf("server checked the password %d times over 5 requests, want 1", got)
+	}
+}
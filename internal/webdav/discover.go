@@ -0,0 +1,175 @@
+package webdav
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ServerInfo is what Discover learned about the server
+type ServerInfo struct {
+	BaseURL        string   `json:"base_url"`
+	Nextcloud      bool     `json:"nextcloud"` // Nextcloud or ownCloud, detected via status.php
+	ProductName    string   `json:"product_name,omitempty"`
+	Version        string   `json:"version,omitempty"`
+	Maintenance    bool     `json:"maintenance"`
+	DAVClasses     []string `json:"dav_classes,omitempty"`
+	AllowedMethods []string `json:"allowed_methods,omitempty"`
+	Features       Features `json:"features"`
+}
+
+// Features lists optional server capabilities relevant to this client
+type Features struct {
+	Chunking       bool `json:"chunking"`
+	Trashbin       bool `json:"trashbin"`
+	Search         bool `json:"search"`
+	SyncCollection bool `json:"sync_collection"`
+	Versioning     bool `json:"versioning"`
+}
+
+type statusResponse struct {
+	Installed     bool   `json:"installed"`
+	Maintenance   bool   `json:"maintenance"`
+	VersionString string `json:"versionstring"`
+	ProductName   string `json:"productname"`
+}
+
+// Discover probes the server to find the DAV base path and supported features
+// If status.php identifies a Nextcloud server and the configured base URL does
+// not already point at /remote.php/dav, the client switches to it.
+func (c *Client) Discover() (*ServerInfo, error) {
+	info := &ServerInfo{}
+
+	root := serverRoot(c.baseURL)
+	if status, err := c.fetchStatus(root); err == nil && status.Installed {
+		info.Nextcloud = true
+		info.ProductName = status.ProductName
+		info.Version = status.VersionString
+		info.Maintenance = status.Maintenance
+
+		if !strings.HasSuffix(c.baseURL, "/remote.php/dav") {
+			c.baseURL = root + "/remote.php/dav"
+		}
+	}
+	info.BaseURL = c.baseURL
+
+	// OPTIONS on the user's files collection reports DAV classes and verbs
+	status, header, err := c.probe("OPTIONS", c.buildWebDAVPath("/"))
+	if err != nil {
+		return nil, fmt.Errorf("OPTIONS probe failed: %w", err)
+	}
+	if status == http.StatusUnauthorized {
+		return nil, fmt.Errorf("OPTIONS probe failed: credentials rejected by %s", c.baseURL)
+	}
+	if status >= 400 {
+		return nil, fmt.Errorf("OPTIONS failed with status %d, check webdav_url and path_prefix", status)
+	}
+
+	info.DAVClasses = splitHeaderList(header.Values("DAV"))
+	info.AllowedMethods = splitHeaderList(header.Values("Allow"))
+
+	info.Features.SyncCollection = containsFold(info.AllowedMethods, "REPORT")
+	info.Features.Search = containsFold(info.AllowedMethods, "SEARCH")
+	if info.Nextcloud {
+		// Prefer what the server advertises; probe only if capabilities are unavailable
+		if caps, err := c.Capabilities(true); err == nil {
+			info.Features.Trashbin = caps.Trashbin()
+			info.Features.Chunking = caps.BigFileChunking() || caps.ChunkingVersion() != ""
+			info.Features.Versioning = caps.Versioning()
+		} else {
+			info.Features.Trashbin = c.probeOK("PROPFIND", "/trashbin/"+c.username+"/trash/")
+			info.Features.Chunking = c.probeOK("PROPFIND", "/uploads/"+c.username+"/")
+		}
+
+		// Nextcloud answers SEARCH on the DAV root rather than on files/<user>
+		if _, rootHeader, err := c.probe("OPTIONS", "/"); err == nil {
+			info.Features.Search = info.Features.Search || containsFold(splitHeaderList(rootHeader.Values("Allow")), "SEARCH")
+		}
+	}
+
+	return info, nil
+}
+
+// probe sends a body-less Depth:0 request and returns its status and headers
+func (c *Client) probe(method, webdavPath string) (int, http.Header, error) {
+	req, err := c.newRequest(method, webdavPath, nil)
+	if err != nil {
+		return 0, nil, err
+	}
+	req.Header.Set("Depth", "0")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return 0, nil, err
+	}
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	return resp.StatusCode, resp.Header, nil
+}
+
+// probeOK reports whether a probe request succeeds
+func (c *Client) probeOK(method, webdavPath string) bool {
+	status, _, err := c.probe(method, webdavPath)
+	return err == nil && status < 400
+}
+
+func (c *Client) fetchStatus(root string) (*statusResponse, error) {
+	req, err := http.NewRequestWithContext(c.context(), "GET", root+"/status.php", nil)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET status.php failed with status %d", resp.StatusCode)
+	}
+
+	var status statusResponse
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("failed to parse status.php: %w", err)
+	}
+	return &status, nil
+}
+
+// serverRoot strips any /remote.php/... suffix from a base URL
+// "https://cloud.example.com/nc/remote.php/dav" -> "https://cloud.example.com/nc"
+func serverRoot(baseURL string) string {
+	u, err := url.Parse(baseURL)
+	if err != nil {
+		return baseURL
+	}
+	if i := strings.Index(u.Path, "/remote.php"); i >= 0 {
+		u.Path = u.Path[:i]
+	}
+	u.RawPath = ""
+	return strings.TrimSuffix(u.String(), "/")
+}
+
+func splitHeaderList(values []string) []string {
+	var result []string
+	for _, value := range values {
+		for _, part := range strings.Split(value, ",") {
+			if part = strings.TrimSpace(part); part != "" {
+				result = append(result, part)
+			}
+		}
+	}
+	return result
+}
+
+func containsFold(list []string, value string) bool {
+	for _, item := range list {
+		if strings.EqualFold(item, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,44 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ErrNotFound is returned (wrapped) when the requested path does not exist
+// Use errors.Is(err, ErrNotFound) to tell it apart from server failures
+var ErrNotFound = errors.New("file not found")
+
+// ErrExists is returned (wrapped) when creating something that already exists
+var ErrExists = errors.New("already exists")
+
+// ErrRangeNotSatisfiable is returned (wrapped) when a download range lies
+// outside the file
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ErrUnauthorized is returned (wrapped) when the server rejects the credentials
+var ErrUnauthorized = errors.New("credentials rejected")
+
+// ErrLocked is returned (wrapped) when the target is locked, e.g. by a
+// client editing the file
+var ErrLocked = errors.New("locked")
+
+// ErrInsufficientStorage is returned (wrapped) when the account's quota or
+// the server's disk is full
+var ErrInsufficientStorage = errors.New("insufficient storage")
+
+// statusError builds the error for an unexpected response status
+func statusError(method string, status int) error {
+	switch status {
+	case http.StatusNotFound:
+		return fmt.Errorf("%s failed with status %d: %w", method, status, ErrNotFound)
+	case http.StatusUnauthorized:
+		return fmt.Errorf("%s failed with status %d: %w", method, status, ErrUnauthorized)
+	case http.StatusLocked:
+		return fmt.Errorf("%s failed with status %d: %w", method, status, ErrLocked)
+	case http.StatusInsufficientStorage:
+		return fmt.Errorf("%s failed with status %d: %w", method, status, ErrInsufficientStorage)
+	}
+	return fmt.Errorf("%s failed with status %d", method, status)
+}
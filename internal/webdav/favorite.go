@@ -0,0 +1,42 @@
+package webdav
+
+import (
+	"net/http"
+	"strings"
+)
+
+// SetFavorite marks or unmarks a file or directory as a favorite (oc:favorite)
+func (c *Client) SetFavorite(filePath string, favorite bool) error {
+	value := "0"
+	if favorite {
+		value = "1"
+	}
+
+	reqBody := `<?xml version="1.0" encoding="utf-8"?>
+<d:propertyupdate xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:set>
+    <d:prop>
+      <oc:favorite>` + value + `</oc:favorite>
+    </d:prop>
+  </d:set>
+</d:propertyupdate>`
+
+	req, err := c.newRequest("PROPPATCH", c.buildWebDAVPath(filePath), strings.NewReader(reqBody))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return statusError("PROPPATCH", resp.StatusCode)
+	}
+
+	return nil
+}
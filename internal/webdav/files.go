@@ -0,0 +1,132 @@
+package webdav
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// Delete removes a file, or a directory with everything below it
+// Nextcloud moves what is deleted to the trashbin when it is enabled.
+func (c *Client) Delete(filePath string) error {
+	req, err := c.newRequest("DELETE", c.buildWebDAVPath(filePath), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return statusError("DELETE", resp.StatusCode)
+	}
+	return nil
+}
+
+// Mkdir creates a directory whose parent exists
+func (c *Client) Mkdir(dirPath string) error {
+	req, err := c.newRequest("MKCOL", c.buildWebDAVPath(dirPath), nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusCreated:
+		return nil
+	case http.StatusMethodNotAllowed:
+		// MKCOL is not allowed on a path that is taken
+		return fmt.Errorf("%w: %s", ErrExists, dirPath)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: parent of %s", ErrNotFound, dirPath)
+	default:
+		return statusError("MKCOL", resp.StatusCode)
+	}
+}
+
+// MkdirAll creates a directory along with its missing parents, and reports
+// whether the directory itself was created rather than already there
+func (c *Client) MkdirAll(dirPath string) (bool, error) {
+	created := false
+	current := ""
+	for _, segment := range strings.Split(strings.Trim(dirPath, "/"), "/") {
+		if segment == "" {
+			continue
+		}
+		current += "/" + segment
+		err := c.Mkdir(current)
+		if err != nil && !errors.Is(err, ErrExists) {
+			return false, err
+		}
+		created = err == nil
+	}
+	if !created {
+		// MKCOL refuses files as well as directories
+		info, err := c.Stat(dirPath)
+		if err != nil {
+			return false, err
+		}
+		if !info.IsDir {
+			return false, fmt.Errorf("%w: %s is a file", ErrExists, dirPath)
+		}
+	}
+	return created, nil
+}
+
+// Move moves or renames a file or directory; without overwrite an existing
+// destination fails with ErrExists
+func (c *Client) Move(from, to string, overwrite bool) error {
+	return c.relocate("MOVE", from, to, overwrite)
+}
+
+// Copy copies a file, or a directory with everything below it; without
+// overwrite an existing destination fails with ErrExists
+func (c *Client) Copy(from, to string, overwrite bool) error {
+	return c.relocate("COPY", from, to, overwrite)
+}
+
+// relocate sends a MOVE or COPY of from to to
+func (c *Client) relocate(method, from, to string, overwrite bool) error {
+	req, err := c.newRequest(method, c.buildWebDAVPath(from), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Destination", c.davURL(c.buildWebDAVPath(to)))
+	req.Header.Set("Overwrite", "F")
+	if overwrite {
+		req.Header.Set("Overwrite", "T")
+	}
+	if method == "COPY" {
+		req.Header.Set("Depth", "infinity")
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	switch resp.StatusCode {
+	case http.StatusCreated, http.StatusNoContent:
+		return nil
+	case http.StatusPreconditionFailed:
+		return fmt.Errorf("%w: %s", ErrExists, to)
+	case http.StatusConflict:
+		return fmt.Errorf("%w: parent of %s", ErrNotFound, to)
+	default:
+		return statusError(method, resp.StatusCode)
+	}
+}
@@ -0,0 +1,162 @@
+package webdav
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RequestInfo describes a single completed WebDAV request
+type RequestInfo struct {
+	Method        string
+	Path          string
+	Status        int // 0 if the request failed before a response was received
+	Duration      time.Duration
+	BytesSent     int64
+	BytesReceived int64 // bytes on the wire, compressed if the response was gzipped
+	BytesDecoded  int64 // bytes after decompression
+	Err           error
+}
+
+// RequestHook is called once per WebDAV request, after the response body is closed
+type RequestHook func(info RequestInfo)
+
+// requestHooks are the hooks of a client, shared with its WithContext copies
+type requestHooks struct {
+	mu    sync.RWMutex
+	hooks []RequestHook
+}
+
+// AddRequestHook registers a hook notified of every request the client makes
+func (c *Client) AddRequestHook(hook RequestHook) {
+	c.hooks.mu.Lock()
+	defer c.hooks.mu.Unlock()
+	c.hooks.hooks = append(c.hooks.hooks, hook)
+}
+
+func (c *Client) fireRequestHooks(info RequestInfo) {
+	c.hooks.mu.RLock()
+	hooks := c.hooks.hooks
+	c.hooks.mu.RUnlock()
+
+	for _, hook := range hooks {
+		hook(info)
+	}
+}
+
+// NewInstrumentedTransport wraps base so that hook is called for every request
+// It can be used on its own around any http.RoundTripper
+func NewInstrumentedTransport(base http.RoundTripper, hook RequestHook) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return &instrumentedTransport{base: base, hook: hook}
+}
+
+type instrumentedTransport struct {
+	base http.RoundTripper
+	hook RequestHook
+}
+
+func (t *instrumentedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	info := RequestInfo{
+		Method: req.Method,
+		Path:   req.URL.Path,
+	}
+
+	var sent *countingReadCloser
+	if req.Body != nil && req.Body != http.NoBody {
+		req = req.Clone(req.Context())
+		sent = &countingReadCloser{ReadCloser: req.Body}
+		req.Body = sent
+	}
+
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		info.Duration = time.Since(start)
+		info.Err = err
+		if sent != nil {
+			info.BytesSent = sent.n
+		}
+		t.hook(info)
+		return nil, err
+	}
+
+	info.Status = resp.StatusCode
+
+	// Count wire bytes before decompressing gzip responses ourselves
+	// (Go's transparent gzip would hide the compressed size)
+	wire := &countingReadCloser{ReadCloser: resp.Body}
+	var body io.ReadCloser = wire
+	if strings.EqualFold(resp.Header.Get("Content-Encoding"), "gzip") {
+		body = &gzipReadCloser{wire: wire}
+		resp.Header.Del("Content-Encoding")
+		resp.Header.Del("Content-Length")
+		resp.ContentLength = -1
+		resp.Uncompressed = true
+	}
+
+	resp.Body = &countingReadCloser{
+		ReadCloser: body,
+		onClose: func(decoded int64) {
+			info.Duration = time.Since(start)
+			info.BytesReceived = wire.n
+			info.BytesDecoded = decoded
+			if sent != nil {
+				info.BytesSent = sent.n
+			}
+			t.hook(info)
+		},
+	}
+	return resp, nil
+}
+
+// gzipReadCloser decompresses lazily so empty bodies don't fail on a missing header
+type gzipReadCloser struct {
+	wire io.ReadCloser
+	zr   *gzip.Reader
+}
+
+func (g *gzipReadCloser) Read(p []byte) (int, error) {
+	if g.zr == nil {
+		zr, err := gzip.NewReader(g.wire)
+		if err != nil {
+			return 0, err
+		}
+		g.zr = zr
+	}
+	return g.zr.Read(p)
+}
+
+func (g *gzipReadCloser) Close() error {
+	if g.zr != nil {
+		g.zr.Close()
+	}
+	return g.wire.Close()
+}
+
+// countingReadCloser counts bytes read and calls onClose once when closed
+type countingReadCloser struct {
+	io.ReadCloser
+	n       int64
+	onClose func(n int64)
+	once    sync.Once
+}
+
+func (c *countingReadCloser) Read(p []byte) (int, error) {
+	n, err := c.ReadCloser.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReadCloser) Close() error {
+	err := c.ReadCloser.Close()
+	if c.onClose != nil {
+		c.once.Do(func() { c.onClose(c.n) })
+	}
+	return err
+}
@@ -0,0 +1,106 @@
+package webdav
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// latencyWindow is how many recent request durations percentiles are computed over
+const latencyWindow = 1024
+
+// Stats is a snapshot of the client's request counters
+type Stats struct {
+	Requests      map[string]int64 `json:"requests"` // by HTTP method
+	Errors        map[string]int64 `json:"errors"`   // transport errors and 4xx/5xx, by HTTP method
+	LatencyP50Ms  float64          `json:"latency_p50_ms"`
+	LatencyP95Ms  float64          `json:"latency_p95_ms"`
+	BytesSent     int64            `json:"bytes_sent"`
+	BytesReceived int64            `json:"bytes_received"` // on the wire
+	BytesDecoded  int64            `json:"bytes_decoded"`  // after gzip decompression
+}
+
+type metrics struct {
+	mu            sync.Mutex
+	requests      map[string]int64
+	errors        map[string]int64
+	latencies     []time.Duration // ring buffer of the most recent durations
+	next          int
+	bytesSent     int64
+	bytesReceived int64
+	bytesDecoded  int64
+}
+
+func newMetrics() *metrics {
+	return &metrics{
+		requests: make(map[string]int64),
+		errors:   make(map[string]int64),
+	}
+}
+
+func (m *metrics) record(info RequestInfo) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[info.Method]++
+	if info.Err != nil || info.Status >= 400 {
+		m.errors[info.Method]++
+	}
+
+	if len(m.latencies) < latencyWindow {
+		m.latencies = append(m.latencies, info.Duration)
+	} else {
+		m.latencies[m.next] = info.Duration
+		m.next = (m.next + 1) % latencyWindow
+	}
+
+	m.bytesSent += info.BytesSent
+	m.bytesReceived += info.BytesReceived
+	m.bytesDecoded += info.BytesDecoded
+}
+
+func (m *metrics) snapshot() Stats {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	stats := Stats{
+		Requests:      make(map[string]int64, len(m.requests)),
+		Errors:        make(map[string]int64, len(m.errors)),
+		BytesSent:     m.bytesSent,
+		BytesReceived: m.bytesReceived,
+		BytesDecoded:  m.bytesDecoded,
+	}
+	for method, n := range m.requests {
+		stats.Requests[method] = n
+	}
+	for method, n := range m.errors {
+		stats.Errors[method] = n
+	}
+
+	if len(m.latencies) > 0 {
+		sorted := make([]time.Duration, len(m.latencies))
+		copy(sorted, m.latencies)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		stats.LatencyP50Ms = percentile(sorted, 0.50)
+		stats.LatencyP95Ms = percentile(sorted, 0.95)
+	}
+
+	return stats
+}
+
+// percentile returns the nearest-rank percentile of sorted durations in milliseconds
+func percentile(sorted []time.Duration, p float64) float64 {
+	index := int(p*float64(len(sorted)) + 0.5)
+	if index > 0 {
+		index--
+	}
+	if index >= len(sorted) {
+		index = len(sorted) - 1
+	}
+	return float64(sorted[index]) / float64(time.Millisecond)
+}
+
+// Stats returns request counts, error counts, latency percentiles and byte totals
+func (c *Client) Stats() Stats {
+	return c.metrics.snapshot()
+}
@@ -4,6 +4,7 @@ import (
 	"encoding/xml"
 	"fmt"
 	"net/url"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -11,11 +12,38 @@ import (
 type propfindResponse struct {
 	XMLName   xml.Name   `xml:"multistatus"`
 	Responses []response `xml:"response"`
+	SyncToken string     `xml:"sync-token"`
 }
 
 type response struct {
-	Href     string   `xml:"href"`
-	PropStat propStat `xml:"propstat"`
+	Href      string     `xml:"href"`
+	Status    string     `xml:"status"`
+	PropStats []propStat `xml:"propstat"`
+}
+
+// okProp returns the properties of the propstat block with a 200 status
+// Servers report unknown properties in a separate 404 block whose empty
+// values must not be mistaken for real ones
+func (r response) okProp() prop {
+	for _, ps := range r.PropStats {
+		if ps.Status == "" || statusCode(ps.Status) == 200 {
+			return ps.Prop
+		}
+	}
+	return prop{}
+}
+
+// statusCode extracts the code from a status line like "HTTP/1.1 404 Not Found"
+func statusCode(status string) int {
+	fields := strings.Fields(status)
+	if len(fields) < 2 {
+		return 0
+	}
+	code, err := strconv.Atoi(fields[1])
+	if err != nil {
+		return 0
+	}
+	return code
 }
 
 type propStat struct {
@@ -30,6 +58,21 @@ type prop struct {
 	ContentType   string  `xml:"getcontenttype"`
 	LastModified  string  `xml:"getlastmodified"`
 	ETag          string  `xml:"getetag"`
+	SyncToken     string  `xml:"sync-token"`
+	Favorite      string  `xml:"favorite"`
+	Checksums     string  `xml:"checksums>checksum"`
+
+	// Nextcloud properties only requested for single paths, see StatDetails
+	FileID           string `xml:"fileid"`
+	Permissions      string `xml:"permissions"`
+	FolderSize       string `xml:"size"`
+	OwnerDisplayName string `xml:"owner-display-name"`
+	HasPreview       string `xml:"has-preview"`
+
+	// Nextcloud trashbin properties
+	TrashbinFilename         string `xml:"trashbin-filename"`
+	TrashbinOriginalLocation string `xml:"trashbin-original-location"`
+	TrashbinDeletionTime     string `xml:"trashbin-deletion-time"`
 }
 
 type resType struct {
@@ -44,58 +87,96 @@ func parsePropfindResponse(body []byte, baseURL string) ([]FileInfo, error) {
 
 	var files []FileInfo
 	for _, r := range resp.Responses {
-		// Handle both absolute URLs and relative paths
-		path := r.Href
-
-		// If it's an absolute URL, extract the path part
-		if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
-			parsedURL, err := url.Parse(path)
-			if err == nil {
-				path = parsedURL.Path
-			}
-		}
+		files = append(files, fileInfoFromResponse(r, baseURL))
+	}
 
-		// Normalize path - remove baseURL path prefix if present
-		// baseURL might be "https://domain.com/remote.php/dav", so extract just the path part
-		if parsedBaseURL, err := url.Parse(baseURL); err == nil {
-			basePath := parsedBaseURL.Path
-			path = strings.TrimPrefix(path, basePath)
-		} else {
-			// Fallback: try direct string prefix removal
-			path = strings.TrimPrefix(path, baseURL)
-		}
+	return files, nil
+}
 
-		if !strings.HasPrefix(path, "/") {
-			path = "/" + path
-		}
+// parseSyncCollectionResponse parses a sync-collection REPORT multistatus
+// Responses carrying a 404 status are members removed since the previous token
+func parseSyncCollectionResponse(body []byte, baseURL string) (string, []FileInfo, []string, error) {
+	var resp propfindResponse
+	if err := xml.Unmarshal(body, &resp); err != nil {
+		return "", nil, nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
 
-		info := FileInfo{
-			Path:  path,
-			IsDir: r.PropStat.Prop.ResourceType.Collection != nil,
+	var changed []FileInfo
+	var deleted []string
+	for _, r := range resp.Responses {
+		if statusCode(r.Status) == 404 {
+			deleted = append(deleted, hrefToPath(r.Href, baseURL))
+			continue
 		}
+		changed = append(changed, fileInfoFromResponse(r, baseURL))
+	}
 
-		// Parse size
-		if r.PropStat.Prop.ContentLength != "" {
-			var size int64
-			fmt.Sscanf(r.PropStat.Prop.ContentLength, "%d", &size)
-			info.Size = size
-		}
+	return resp.SyncToken, changed, deleted, nil
+}
+
+// hrefToPath converts a response href into a path relative to the base URL
+func hrefToPath(href string, baseURL string) string {
+	// Handle both absolute URLs and relative paths
+	path := href
 
-		// Parse modified time
-		if r.PropStat.Prop.LastModified != "" {
-			// WebDAV uses RFC1123 format
-			if t, err := time.Parse(time.RFC1123, r.PropStat.Prop.LastModified); err == nil {
-				info.ModifiedTime = t
-			} else if t, err := time.Parse(time.RFC1123Z, r.PropStat.Prop.LastModified); err == nil {
-				info.ModifiedTime = t
-			}
+	// If it's an absolute URL, extract the path part (already unescaped by url.Parse)
+	if strings.HasPrefix(path, "http://") || strings.HasPrefix(path, "https://") {
+		parsedURL, err := url.Parse(path)
+		if err == nil {
+			path = parsedURL.Path
 		}
+	} else {
+		path = unescapePath(path)
+	}
 
-		// Parse ETag
-		info.ETag = strings.Trim(r.PropStat.Prop.ETag, "\"")
+	// Normalize path - remove baseURL path prefix if present
+	// baseURL might be "https://domain.com/remote.php/dav", so extract just the path part
+	if parsedBaseURL, err := url.Parse(baseURL); err == nil {
+		basePath := parsedBaseURL.Path
+		path = strings.TrimPrefix(path, basePath)
+	} else {
+		// Fallback: try direct string prefix removal
+		path = strings.TrimPrefix(path, baseURL)
+	}
 
-		files = append(files, info)
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
 	}
 
-	return files, nil
+	return path
+}
+
+func fileInfoFromResponse(r response, baseURL string) FileInfo {
+	p := r.okProp()
+	info := FileInfo{
+		Path:  hrefToPath(r.Href, baseURL),
+		IsDir: p.ResourceType.Collection != nil,
+	}
+
+	// Parse size
+	if p.ContentLength != "" {
+		var size int64
+		fmt.Sscanf(p.ContentLength, "%d", &size)
+		info.Size = size
+	}
+
+	// Parse modified time
+	if p.LastModified != "" {
+		// WebDAV uses RFC1123 format
+		if t, err := time.Parse(time.RFC1123, p.LastModified); err == nil {
+			info.ModifiedTime = t
+		} else if t, err := time.Parse(time.RFC1123Z, p.LastModified); err == nil {
+			info.ModifiedTime = t
+		}
+	}
+
+	// Parse ETag
+	info.ETag = strings.Trim(p.ETag, "\"")
+
+	// oc:favorite is "1" for favorited items
+	info.Favorite = p.Favorite == "1"
+
+	info.Checksum = strings.TrimSpace(p.Checksums)
+
+	return info
 }
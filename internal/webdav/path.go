@@ -0,0 +1,28 @@
+package webdav
+
+import (
+	"net/url"
+	"strings"
+)
+
+// escapePath percent-encodes every segment of a slash-separated path
+// Characters such as ' ', '#', '?', '%' and '+' are escaped so names like
+// "Budget #3 (copy).xlsx" survive the trip to the server unchanged.
+// '+' is escaped too because some servers decode it as a space.
+func escapePath(p string) string {
+	segments := strings.Split(p, "/")
+	for i, segment := range segments {
+		segments[i] = strings.ReplaceAll(url.PathEscape(segment), "+", "%2B")
+	}
+	return strings.Join(segments, "/")
+}
+
+// unescapePath decodes a percent-encoded href path
+// Invalid escapes are left untouched rather than failing the whole listing
+func unescapePath(p string) string {
+	decoded, err := url.PathUnescape(p)
+	if err != nil {
+		return p
+	}
+	return decoded
+}
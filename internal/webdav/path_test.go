@@ -0,0 +1,64 @@
+package webdav
+
+import (
+	"strings"
+	"testing"
+)
+
+// trickyPaths are names that broke, or could break, the trip to the server and back
+var trickyPaths = []struct {
+	name string
+	path string
+}{
+	{"parentheses and hash", "/Budget #3 (copy).xlsx"},
+	{"plus", "/a+b"},
+	{"percent", "/100%"},
+	{"percent escape lookalike", "/50%20off"},
+	{"spaces", "/My Documents/notes  final .md"},
+	{"question mark", "/what?.txt"},
+	{"ampersand and equals", "/a&b=c"},
+	{"semicolon and colon", "/a;b:c"},
+	{"NFC", "/Caf\u00e9/r\u00e9sum\u00e9.pdf"},
+	{"NFD", "/Cafe\u0301/re\u0301sume\u0301.pdf"},
+	{"non-Latin", "/文档/报告 2024.docx"},
+	{"emoji", "/📁/🎉.png"},
+	{"trailing slash", "/Photos/2024/"},
+}
+
+func TestEscapePathRoundTrip(t *testing.T) {
+	for _, tt := range trickyPaths {
+		t.Run(tt.name, func(t *testing.T) {
+			escaped := escapePath(tt.path)
+			if strings.ContainsAny(escaped, " #?+") {
+				t.Errorf("escapePath(%q) = %q, still holds characters servers misread", tt.path, escaped)
+			}
+			if got := unescapePath(escaped); got != tt.path {
+				t.Errorf("unescapePath(escapePath(%q)) = %q", tt.path, got)
+			}
+		})
+	}
+}
+
+func TestHrefToPathRoundTrip(t *testing.T) {
+	const baseURL = "https://cloud.example.com/remote.php/dav"
+	for _, tt := range trickyPaths {
+		t.Run(tt.name, func(t *testing.T) {
+			want := strings.TrimSuffix(tt.path, "/")
+			for _, href := range []string{
+				"/remote.php/dav" + escapePath(tt.path),
+				baseURL + escapePath(tt.path),
+			} {
+				got := strings.TrimSuffix(hrefToPath(href, baseURL), "/")
+				if got != want {
+					t.Errorf("hrefToPath(%q) = %q, want %q", href, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestUnescapePathKeepsInvalidEscapes(t *testing.T) {
+	if got := unescapePath("/100%"); got != "/100%" {
+		t.Errorf("unescapePath(%q) = %q, want it unchanged", "/100%", got)
+	}
+}
@@ -0,0 +1,51 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Preview fetches a thumbnail of filePath from the Nextcloud preview API,
+// scaled to fit within width x height while keeping the aspect ratio
+func (c *Client) Preview(filePath string, width, height int) (*RemoteFile, error) {
+	query := url.Values{}
+	query.Set("file", "/"+strings.TrimPrefix(filePath, "/"))
+	query.Set("x", strconv.Itoa(width))
+	query.Set("y", strconv.Itoa(height))
+	query.Set("a", "1") // keep aspect ratio
+	query.Set("forceIcon", "0")
+
+	previewURL := serverRoot(c.baseURL) + "/index.php/core/preview.png?" + query.Encode()
+	req, err := http.NewRequestWithContext(c.context(), "GET", previewURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.SetBasicAuth(c.username, *c.password.Load())
+
+	resp, err := c.doTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusNotFound {
+			return nil, fmt.Errorf("no preview available for %s: %w", filePath, ErrNotFound)
+		}
+		return nil, statusError("GET preview", resp.StatusCode)
+	}
+
+	file := &RemoteFile{
+		ReadCloser:    resp.Body,
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ETag:          strings.Trim(resp.Header.Get("ETag"), "\""),
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		file.ModifiedTime = modified
+	}
+	return file, nil
+}
@@ -0,0 +1,83 @@
+package webdav
+
+import "io"
+
+// ProgressEvent describes how far a scan or transfer has come
+type ProgressEvent struct {
+	Path string // directory being scanned or file being transferred
+
+	// Scan progress
+	DirsVisited int
+	FilesFound  int
+
+	// Transfer progress; TotalBytes is -1 when unknown
+	BytesTransferred int64
+	TotalBytes       int64
+}
+
+// ProgressHook receives progress updates during long scans and transfers
+// Implementations are called synchronously and should return quickly
+type ProgressHook interface {
+	OnProgress(event ProgressEvent)
+}
+
+// ProgressFunc adapts a plain function to the ProgressHook interface
+type ProgressFunc func(event ProgressEvent)
+
+func (f ProgressFunc) OnProgress(event ProgressEvent) {
+	f(event)
+}
+
+// scanTracker counts visited directories and found files during a walk
+type scanTracker struct {
+	hook        ProgressHook
+	dirsVisited int
+}
+
+func (t *scanTracker) visit(path string, filesFound int) {
+	if t == nil {
+		return
+	}
+	t.dirsVisited++
+	t.report(path, filesFound)
+}
+
+func (t *scanTracker) report(path string, filesFound int) {
+	if t == nil || t.hook == nil {
+		return
+	}
+	t.hook.OnProgress(ProgressEvent{
+		Path:        path,
+		DirsVisited: t.dirsVisited,
+		FilesFound:  filesFound,
+		TotalBytes:  -1,
+	})
+}
+
+// progressReader reports bytes read through it, used for uploads and downloads
+type progressReader struct {
+	r     io.Reader
+	hook  ProgressHook
+	path  string
+	n     int64
+	total int64
+}
+
+func (p *progressReader) Read(buf []byte) (int, error) {
+	n, err := p.r.Read(buf)
+	if n > 0 {
+		p.n += int64(n)
+		p.hook.OnProgress(ProgressEvent{Path: p.path, BytesTransferred: p.n, TotalBytes: p.total})
+	}
+	return n, err
+}
+
+// progressReadCloser reports bytes read from a download body
+type progressReadCloser struct {
+	progressReader
+	closer io.Closer
+}
+
+func (p *progressReadCloser) Close() error {
+	return p.closer.Close()
+}
@@ -0,0 +1,165 @@
+package webdav
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	maxRedirects = 5
+	maxRetries   = 3
+	maxRetryWait = 30 * time.Second
+)
+
+// MaintenanceError is returned when the server stays unavailable (503) or keeps
+// rate limiting (429), e.g. while Nextcloud is in maintenance mode
+type MaintenanceError struct {
+	StatusCode int
+	RetryAfter time.Duration // zero if the server gave no hint
+}
+
+func (e *MaintenanceError) Error() string {
+	if e.RetryAfter > 0 {
+		return fmt.Sprintf("server unavailable (status %d), retry after %v", e.StatusCode, e.RetryAfter)
+	}
+	return fmt.Sprintf("server unavailable (status %d)", e.StatusCode)
+}
+
+// do sends a metadata request bounded by the client timeout
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	return c.send(c.httpClient, req)
+}
+
+// doTransfer sends a request whose body may take arbitrarily long to stream
+func (c *Client) doTransfer(req *http.Request) (*http.Response, error) {
+	return c.send(c.transferClient, req)
+}
+
+// send issues a request through the circuit breaker
+// Transport errors and 5xx responses count as failures; 4xx do not
+func (c *Client) send(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	if err := c.breaker.allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := c.sendWithRetries(httpClient, req)
+	if err != nil && req.Context().Err() != nil {
+		// Cancelled by the caller, which says nothing about the server
+		return nil, err
+	}
+	c.breaker.record(err == nil && resp.StatusCode < 500)
+	return resp, err
+}
+
+// sendWithRetries follows same-host redirects without downgrading DAV verbs
+// to GET, and retries 429/503 responses that carry a Retry-After header
+func (c *Client) sendWithRetries(httpClient *http.Client, req *http.Request) (*http.Response, error) {
+	redirects, retries := 0, 0
+	for {
+		resp, err := httpClient.Do(req)
+		if err != nil {
+			return nil, err
+		}
+
+		switch resp.StatusCode {
+		case http.StatusMovedPermanently, http.StatusFound, http.StatusTemporaryRedirect, http.StatusPermanentRedirect:
+			resp.Body.Close()
+			if redirects >= maxRedirects {
+				return nil, fmt.Errorf("%s stopped after %d redirects", req.Method, redirects)
+			}
+			next, err := redirectRequest(req, resp)
+			if err != nil {
+				return nil, err
+			}
+			req = next
+			redirects++
+
+		case http.StatusTooManyRequests, http.StatusServiceUnavailable:
+			wait, hasWait := retryAfter(resp)
+			resp.Body.Close()
+
+			maintenanceErr := &MaintenanceError{StatusCode: resp.StatusCode, RetryAfter: wait}
+			if !hasWait || retries >= maxRetries {
+				return nil, maintenanceErr
+			}
+			next, err := rewindRequest(req)
+			if err != nil {
+				return nil, maintenanceErr
+			}
+
+			if wait > maxRetryWait {
+				wait = maxRetryWait
+			}
+			select {
+			case <-time.After(wait):
+			case <-req.Context().Done():
+				return nil, req.Context().Err()
+			}
+			req = next
+			retries++
+
+		default:
+			return resp, nil
+		}
+	}
+}
+
+// redirectRequest builds the follow-up request for a redirect response
+// Only redirects to the same host are followed so credentials never leak
+func redirectRequest(req *http.Request, resp *http.Response) (*http.Request, error) {
+	location, err := resp.Location()
+	if err != nil {
+		return nil, fmt.Errorf("%s redirect with status %d has no usable Location: %w", req.Method, resp.StatusCode, err)
+	}
+	if location.Host != req.URL.Host {
+		return nil, fmt.Errorf("%s refused redirect from %s to other host %s", req.Method, req.URL.Host, location.Host)
+	}
+	if req.URL.Scheme == "https" && location.Scheme != "https" {
+		return nil, fmt.Errorf("%s refused redirect from https to %s", req.Method, location.Scheme)
+	}
+
+	next, err := rewindRequest(req)
+	if err != nil {
+		return nil, err
+	}
+	next.URL = location
+	next.Host = ""
+	return next, nil
+}
+
+// rewindRequest clones req with a fresh copy of its body so it can be resent
+func rewindRequest(req *http.Request) (*http.Request, error) {
+	next := req.Clone(req.Context())
+	if req.Body != nil && req.Body != http.NoBody {
+		if req.GetBody == nil {
+			return nil, fmt.Errorf("%s %s cannot be resent: body is not replayable", req.Method, req.URL.Path)
+		}
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		next.Body = body
+	}
+	return next, nil
+}
+
+// retryAfter parses the Retry-After header (delay in seconds or HTTP date)
+func retryAfter(resp *http.Response) (time.Duration, bool) {
+	value := resp.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		wait := time.Until(date)
+		if wait < 0 {
+			wait = 0
+		}
+		return wait, true
+	}
+	return 0, false
+}
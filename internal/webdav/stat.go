@@ -0,0 +1,84 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// FileDetails describes a single file or directory, with the Nextcloud
+// properties that are too costly to request for every file of a scan
+type FileDetails struct {
+	Path  string `json:"path"`
+	IsDir bool   `json:"is_dir"`
+	// Size of a directory is the total size of its contents
+	Size        int64     `json:"size"`
+	Modified    time.Time `json:"modified"`
+	ETag        string    `json:"etag"`
+	ContentType string    `json:"content_type,omitempty"`
+	Favorite    bool      `json:"favorite"`
+	Checksum    string    `json:"checksum,omitempty"`
+	FileID      string    `json:"file_id,omitempty"`
+	// Permissions are Nextcloud's letters, e.g. "RGDNVW" (share, read, delete, rename, move, write)
+	Permissions string `json:"permissions,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	HasPreview  bool   `json:"has_preview"`
+}
+
+const detailsPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns" xmlns:nc="http://nextcloud.org/ns">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getcontenttype/>
+    <d:getlastmodified/>
+    <d:getetag/>
+    <oc:favorite/>
+    <oc:checksums/>
+    <oc:fileid/>
+    <oc:permissions/>
+    <oc:size/>
+    <oc:owner-display-name/>
+    <nc:has-preview/>
+  </d:prop>
+</d:propfind>`
+
+// StatDetails gets the details of a single file or directory
+func (c *Client) StatDetails(filePath string) (*FileDetails, error) {
+	body, err := c.propfindPath(filePath, detailsPropfindBody)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms propfindResponse
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+	if len(ms.Responses) == 0 {
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, filePath)
+	}
+
+	r := ms.Responses[0]
+	info := fileInfoFromResponse(r, c.baseURL)
+	p := r.okProp()
+	details := &FileDetails{
+		Path:        c.extractRelativePath(info.Path, filePath),
+		IsDir:       info.IsDir,
+		Size:        info.Size,
+		Modified:    info.ModifiedTime,
+		ETag:        info.ETag,
+		ContentType: p.ContentType,
+		Favorite:    info.Favorite,
+		Checksum:    info.Checksum,
+		FileID:      p.FileID,
+		Permissions: p.Permissions,
+		Owner:       p.OwnerDisplayName,
+		HasPreview:  p.HasPreview == "true",
+	}
+	// Directories have no content length, only oc:size
+	if size, err := strconv.ParseInt(p.FolderSize, 10, 64); err == nil && info.IsDir {
+		details.Size = size
+	}
+	return details, nil
+}
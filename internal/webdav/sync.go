@@ -0,0 +1,144 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// ErrInvalidSyncToken is returned when the server no longer accepts a sync token
+// Callers should discard the token and fall back to a full scan
+var ErrInvalidSyncToken = errors.New("invalid sync token")
+
+// SyncResult holds the members changed since a previous sync token (RFC 6578)
+type SyncResult struct {
+	Token   string
+	Changed []FileInfo
+	Deleted []string
+}
+
+const syncTokenPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:">
+  <d:prop>
+    <d:sync-token/>
+  </d:prop>
+</d:propfind>`
+
+// SyncToken fetches the current DAV:sync-token of a collection
+func (c *Client) SyncToken(dirPath string) (string, error) {
+	webdavPath := c.buildWebDAVPath(dirPath)
+	if !strings.HasSuffix(webdavPath, "/") {
+		webdavPath += "/"
+	}
+
+	req, err := c.newRequest("PROPFIND", webdavPath, strings.NewReader(syncTokenPropfindBody))
+	if err != nil {
+		return "", err
+	}
+
+	req.Header.Set("Depth", "0")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return "", statusError("PROPFIND", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
+
+	var ms propfindResponse
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		return "", fmt.Errorf("failed to parse XML: %w", err)
+	}
+	if len(ms.Responses) == 0 || ms.Responses[0].okProp().SyncToken == "" {
+		return "", fmt.Errorf("server did not return a sync token for %s", dirPath)
+	}
+
+	return ms.Responses[0].okProp().SyncToken, nil
+}
+
+// SyncCollection issues a sync-collection REPORT returning everything that
+// changed below dirPath since syncToken. An empty token requests a full listing.
+func (c *Client) SyncCollection(dirPath, syncToken string) (*SyncResult, error) {
+	webdavPath := c.buildWebDAVPath(dirPath)
+	if !strings.HasSuffix(webdavPath, "/") {
+		webdavPath += "/"
+	}
+
+	var token strings.Builder
+	xml.EscapeText(&token, []byte(syncToken))
+
+	reqBody := `<?xml version="1.0" encoding="utf-8"?>
+<d:sync-collection xmlns:d="DAV:" xmlns:oc="http://owncloud.org/ns">
+  <d:sync-token>` + token.String() + `</d:sync-token>
+  <d:sync-level>infinite</d:sync-level>
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <d:getlastmodified/>
+    <d:getetag/>
+    <oc:favorite/>
+    <oc:checksums/>
+  </d:prop>
+</d:sync-collection>`
+
+	req, err := c.newRequest("REPORT", webdavPath, strings.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 6578 3.2: an expired or unknown token yields 403/409 with a valid-sync-token precondition
+	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusConflict {
+		if strings.Contains(string(body), "valid-sync-token") {
+			return nil, ErrInvalidSyncToken
+		}
+	}
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return nil, statusError("REPORT sync-collection", resp.StatusCode)
+	}
+
+	newToken, items, deleted, err := parseSyncCollectionResponse(body, c.baseURL)
+	if err != nil {
+		return nil, err
+	}
+
+	result := &SyncResult{Token: newToken}
+	normalizedWebDAVPath := c.normalizePathForComparison(webdavPath)
+	for _, item := range items {
+		// Skip the collection itself
+		if strings.TrimSuffix(c.normalizePathForComparison(item.Path), "/") == strings.TrimSuffix(normalizedWebDAVPath, "/") {
+			continue
+		}
+		item.Path = c.extractRelativePath(item.Path, dirPath)
+		result.Changed = append(result.Changed, item)
+	}
+	for _, deletedPath := range deleted {
+		result.Deleted = append(result.Deleted, c.extractRelativePath(deletedPath, dirPath))
+	}
+
+	return result, nil
+}
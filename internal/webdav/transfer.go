@@ -0,0 +1,234 @@
+package webdav
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PreconditionFailedError is returned when a conditional request is rejected
+// because the remote file changed (or already exists) since its ETag was recorded
+type PreconditionFailedError struct {
+	Path    string
+	IfMatch string // the ETag we expected, empty for create-only requests
+}
+
+func (e *PreconditionFailedError) Error() string {
+	if e.IfMatch == "" {
+		return fmt.Sprintf("precondition failed: %s already exists", e.Path)
+	}
+	return fmt.Sprintf("precondition failed: %s no longer matches ETag %s", e.Path, e.IfMatch)
+}
+
+// UploadOptions controls conditional uploads
+type UploadOptions struct {
+	// IfMatch only overwrites the file if its current ETag equals this value
+	IfMatch string
+	// IfNoneMatch only creates the file if nothing exists at the path yet
+	IfNoneMatch bool
+	// Size is the body length if known, used for Content-Length and progress (-1 or 0 when unknown)
+	Size int64
+	// ContentType is sent as the Content-Type header when set
+	ContentType string
+	// Progress is notified as the body is sent
+	Progress ProgressHook
+}
+
+// DownloadOptions controls downloads
+type DownloadOptions struct {
+	// Progress is notified as the body is received
+	Progress ProgressHook
+	// Range requests part of the file, as in the Range header, e.g. "bytes=0-1023"
+	Range string
+	// IfRange makes the server send the whole file instead of the range when
+	// the file no longer matches this ETag or date
+	IfRange string
+}
+
+// Upload writes body to filePath and returns the new ETag
+// Conditional headers make the server refuse to clobber concurrent changes.
+// Bodies bigger than the chunk size, or of unknown size, are sent in chunks
+// when the server supports it (see SetChunkSize).
+func (c *Client) Upload(filePath string, body io.Reader, opts UploadOptions) (string, error) {
+	if opts.Progress != nil {
+		body = &progressReader{r: body, hook: opts.Progress, path: filePath, total: sizeOrUnknown(opts.Size)}
+	}
+	if chunkSize := c.uploadChunkSize(opts.Size); chunkSize > 0 {
+		return c.uploadChunked(filePath, body, opts, chunkSize)
+	}
+	return c.put(filePath, body, opts)
+}
+
+// put writes body to filePath with a single PUT
+func (c *Client) put(filePath string, body io.Reader, opts UploadOptions) (string, error) {
+	req, err := c.newRequest("PUT", c.buildWebDAVPath(filePath), body)
+	if err != nil {
+		return "", err
+	}
+	if opts.Size > 0 {
+		req.ContentLength = opts.Size
+	}
+
+	if opts.IfMatch != "" {
+		req.Header.Set("If-Match", quoteETag(opts.IfMatch))
+	}
+	if opts.IfNoneMatch {
+		req.Header.Set("If-None-Match", "*")
+	}
+	if opts.ContentType != "" {
+		req.Header.Set("Content-Type", opts.ContentType)
+	}
+
+	resp, err := c.doTransfer(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusPreconditionFailed {
+		return "", &PreconditionFailedError{Path: filePath, IfMatch: opts.IfMatch}
+	}
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return "", statusError("PUT", resp.StatusCode)
+	}
+
+	// Nextcloud returns the new ETag in OC-ETag as well as the standard header
+	etag := resp.Header.Get("ETag")
+	if etag == "" {
+		etag = resp.Header.Get("OC-ETag")
+	}
+	return strings.Trim(etag, "\""), nil
+}
+
+// RemoteFile is an open download stream along with the metadata the server sent
+// Callers must Close it
+type RemoteFile struct {
+	io.ReadCloser
+	ContentLength int64 // -1 if unknown
+	ContentType   string
+	ETag          string
+	ModifiedTime  time.Time
+	// ContentRange is set when only the requested range was sent, as in the
+	// Content-Range header
+	ContentRange string
+}
+
+// SetHeaders copies the file metadata onto response headers so a proxied
+// download keeps its Content-Length, Content-Type, ETag and Last-Modified
+func (f *RemoteFile) SetHeaders(h http.Header) {
+	if f.ContentType != "" {
+		h.Set("Content-Type", f.ContentType)
+	}
+	if f.ContentLength >= 0 {
+		h.Set("Content-Length", strconv.FormatInt(f.ContentLength, 10))
+	}
+	if f.ETag != "" {
+		h.Set("ETag", quoteETag(f.ETag))
+	}
+	if !f.ModifiedTime.IsZero() {
+		h.Set("Last-Modified", f.ModifiedTime.UTC().Format(http.TimeFormat))
+	}
+	if f.ContentRange != "" {
+		h.Set("Content-Range", f.ContentRange)
+	}
+}
+
+// Open starts a streaming download of filePath without buffering it
+func (c *Client) Open(filePath string, opts DownloadOptions) (*RemoteFile, error) {
+	req, err := c.newRequest("GET", c.buildWebDAVPath(filePath), nil)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Range != "" {
+		req.Header.Set("Range", opts.Range)
+		if opts.IfRange != "" {
+			req.Header.Set("If-Range", opts.IfRange)
+		}
+	}
+
+	resp, err := c.doTransfer(req)
+	if err != nil {
+		return nil, err
+	}
+
+	partial := resp.StatusCode == http.StatusPartialContent && opts.Range != ""
+	if resp.StatusCode != http.StatusOK && !partial {
+		resp.Body.Close()
+		if resp.StatusCode == http.StatusRequestedRangeNotSatisfiable {
+			return nil, fmt.Errorf("%w: %s of %s", ErrRangeNotSatisfiable, opts.Range, filePath)
+		}
+		return nil, statusError("GET", resp.StatusCode)
+	}
+
+	file := &RemoteFile{
+		ReadCloser:    resp.Body,
+		ContentLength: resp.ContentLength,
+		ContentType:   resp.Header.Get("Content-Type"),
+		ETag:          strings.Trim(resp.Header.Get("ETag"), "\""),
+	}
+	if partial {
+		file.ContentRange = resp.Header.Get("Content-Range")
+	}
+	if modified, err := http.ParseTime(resp.Header.Get("Last-Modified")); err == nil {
+		file.ModifiedTime = modified
+	}
+	if opts.Progress != nil {
+		file.ReadCloser = &progressReadCloser{
+			progressReader: progressReader{r: resp.Body, hook: opts.Progress, path: filePath, total: sizeOrUnknown(resp.ContentLength)},
+			closer:         resp.Body,
+		}
+	}
+
+	return file, nil
+}
+
+// Download streams the content of filePath into w and returns the number of bytes written
+func (c *Client) Download(filePath string, w io.Writer, opts DownloadOptions) (int64, error) {
+	file, err := c.Open(filePath, opts)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	return io.Copy(w, file)
+}
+
+// FlushWriter flushes after every write when the underlying writer supports it
+// Wrapping an http.ResponseWriter makes proxied downloads reach the client
+// as they arrive instead of sitting in the server's buffer
+type FlushWriter struct {
+	w       io.Writer
+	flusher http.Flusher
+}
+
+func NewFlushWriter(w io.Writer) *FlushWriter {
+	flusher, _ := w.(http.Flusher)
+	return &FlushWriter{w: w, flusher: flusher}
+}
+
+func (f *FlushWriter) Write(p []byte) (int, error) {
+	n, err := f.w.Write(p)
+	if f.flusher != nil && n > 0 {
+		f.flusher.Flush()
+	}
+	return n, err
+}
+
+func sizeOrUnknown(size int64) int64 {
+	if size <= 0 {
+		return -1
+	}
+	return size
+}
+
+// quoteETag wraps an ETag in double quotes as required by If-Match
+// Stored ETags have their quotes stripped by the parser
+func quoteETag(etag string) string {
+	if strings.HasPrefix(etag, "\"") || strings.HasPrefix(etag, "W/") {
+		return etag
+	}
+	return "\"" + etag + "\""
+}
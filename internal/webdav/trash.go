@@ -0,0 +1,153 @@
+package webdav
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// TrashItem represents an entry in the Nextcloud trashbin
+type TrashItem struct {
+	Name             string    `json:"name"` // trashbin identifier, e.g. "notes.md.d1700000000"
+	Filename         string    `json:"filename"`
+	OriginalLocation string    `json:"original_location"`
+	DeletionTime     time.Time `json:"deletion_time"`
+	IsDir            bool      `json:"is_dir"`
+	Size             int64     `json:"size"`
+}
+
+const trashPropfindBody = `<?xml version="1.0" encoding="utf-8"?>
+<d:propfind xmlns:d="DAV:" xmlns:nc="http://nextcloud.org/ns">
+  <d:prop>
+    <d:resourcetype/>
+    <d:getcontentlength/>
+    <nc:trashbin-filename/>
+    <nc:trashbin-original-location/>
+    <nc:trashbin-deletion-time/>
+  </d:prop>
+</d:propfind>`
+
+// trashPath returns the WebDAV path of the user's trashbin
+// Output: "/trashbin/username/trash/"
+func (c *Client) trashPath() string {
+	return "/trashbin/" + c.username + "/trash/"
+}
+
+// ListTrash lists the items currently in the trashbin
+func (c *Client) ListTrash() ([]TrashItem, error) {
+	webdavPath := c.trashPath()
+
+	req, err := c.newRequest("PROPFIND", webdavPath, strings.NewReader(trashPropfindBody))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Depth", "1")
+	req.Header.Set("Content-Type", "application/xml; charset=utf-8")
+
+	resp, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusMultiStatus && resp.StatusCode != http.StatusOK {
+		return nil, statusError("PROPFIND", resp.StatusCode)
+	}
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var ms propfindResponse
+	if err := xml.Unmarshal(body, &ms); err != nil {
+		return nil, fmt.Errorf("failed to parse XML: %w", err)
+	}
+
+	var items []TrashItem
+	for _, r := range ms.Responses {
+		itemPath := strings.TrimSuffix(hrefToPath(r.Href, c.baseURL), "/")
+
+		// Skip the trash collection itself
+		if itemPath+"/" == c.trashPath() {
+			continue
+		}
+
+		p := r.okProp()
+		item := TrashItem{
+			Name:             path.Base(itemPath),
+			Filename:         p.TrashbinFilename,
+			OriginalLocation: p.TrashbinOriginalLocation,
+			IsDir:            p.ResourceType.Collection != nil,
+		}
+		if p.ContentLength != "" {
+			item.Size, _ = strconv.ParseInt(p.ContentLength, 10, 64)
+		}
+		if p.TrashbinDeletionTime != "" {
+			if ts, err := strconv.ParseInt(p.TrashbinDeletionTime, 10, 64); err == nil {
+				item.DeletionTime = time.Unix(ts, 0).UTC()
+			}
+		}
+
+		items = append(items, item)
+	}
+
+	return items, nil
+}
+
+// RestoreTrash moves a trashbin item back to its original location
+func (c *Client) RestoreTrash(name string) error {
+	req, err := c.newRequest("MOVE", c.trashPath()+name, nil)
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Destination", c.davURL("/trashbin/"+c.username+"/restore/"+name))
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated && resp.StatusCode != http.StatusNoContent {
+		return statusError("MOVE", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// PurgeTrash permanently deletes a single trashbin item
+func (c *Client) PurgeTrash(name string) error {
+	return c.deleteTrashPath(c.trashPath() + name)
+}
+
+// EmptyTrash permanently deletes every item in the trashbin
+func (c *Client) EmptyTrash() error {
+	return c.deleteTrashPath(c.trashPath())
+}
+
+func (c *Client) deleteTrashPath(webdavPath string) error {
+	req, err := c.newRequest("DELETE", webdavPath, nil)
+	if err != nil {
+		return err
+	}
+
+	resp, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent && resp.StatusCode != http.StatusOK {
+		return statusError("DELETE", resp.StatusCode)
+	}
+
+	return nil
+}
@@ -0,0 +1,426 @@
+// Package webdavtest provides an in-memory WebDAV client for exercising the
+// diff detector without an HTTP server.
+package webdavtest
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"go-nc-client/internal/diff"
+	"go-nc-client/internal/webdav"
+)
+
+var _ diff.Client = (*Client)(nil)
+
+// ErrUnavailable is a convenience error for simulating an unreachable server
+var ErrUnavailable = errors.New("webdavtest: server unavailable")
+
+// Client is an in-memory fake of the WebDAV client
+// Mutations bump the ETag of the item and all its ancestors, like Nextcloud does
+type Client struct {
+	mu       sync.Mutex
+	files    map[string]webdav.FileInfo
+	contents map[string][]byte
+	seq      int
+
+	// Sync-collection change log; tokens below minToken are rejected
+	changes  []syncEntry
+	minToken int
+
+	// Errors forces a method ("Stat", "ListFiles", "SyncCollection", "SyncToken", "Open") to fail
+	Errors map[string]error
+	// Calls counts invocations per method name
+	Calls map[string]int
+}
+
+type syncEntry struct {
+	seq     int
+	path    string
+	deleted bool
+}
+
+// New returns an empty fake containing only the root directory
+func New() *Client {
+	c := &Client{
+		files:    make(map[string]webdav.FileInfo),
+		contents: make(map[string][]byte),
+		Errors:   make(map[string]error),
+		Calls:    make(map[string]int),
+	}
+	c.files["/"] = webdav.FileInfo{Path: "/", IsDir: true, ETag: c.nextETag()}
+	return c
+}
+
+// PutFile creates or overwrites a file, creating missing parent directories
+func (c *Client) PutFile(filePath string, size int64, modified time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filePath = clean(filePath)
+	c.mkdirAll(path.Dir(filePath))
+	c.files[filePath] = webdav.FileInfo{
+		Path:         filePath,
+		Size:         size,
+		ModifiedTime: modified,
+		ETag:         c.nextETag(),
+	}
+	delete(c.contents, filePath)
+	c.touch(filePath, false)
+}
+
+// WriteFile is PutFile with content that Open returns
+func (c *Client) WriteFile(filePath string, data []byte, modified time.Time) {
+	c.PutFile(filePath, int64(len(data)), modified)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.contents[clean(filePath)] = data
+}
+
+// Mkdir creates a directory and any missing parents
+func (c *Client) Mkdir(dirPath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.mkdirAll(clean(dirPath))
+}
+
+// Remove deletes a file or a directory with everything below it
+func (c *Client) Remove(filePath string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(clean(filePath))
+}
+
+// Move renames a file or directory, keeping the ETags of moved items
+func (c *Client) Move(from, to string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	from, to = clean(from), clean(to)
+	c.mkdirAll(path.Dir(to))
+
+	var moved []webdav.FileInfo
+	for p, info := range c.files {
+		if p == from || strings.HasPrefix(p, from+"/") {
+			moved = append(moved, info)
+		}
+	}
+
+	c.seq++
+	for _, info := range moved {
+		delete(c.files, info.Path)
+		c.changes = append(c.changes, syncEntry{seq: c.seq, path: info.Path, deleted: true})
+	}
+	for _, info := range moved {
+		oldPath := info.Path
+		info.Path = to + strings.TrimPrefix(info.Path, from)
+		c.files[info.Path] = info
+		if data, ok := c.contents[oldPath]; ok {
+			delete(c.contents, oldPath)
+			c.contents[info.Path] = data
+		}
+		c.changes = append(c.changes, syncEntry{seq: c.seq, path: info.Path})
+	}
+	c.touch(path.Dir(from), true)
+	c.touch(to, false)
+}
+
+// SetFavorite flags an existing item as favorite without changing its ETag
+func (c *Client) SetFavorite(filePath string, favorite bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	filePath = clean(filePath)
+	if info, ok := c.files[filePath]; ok {
+		info.Favorite = favorite
+		c.files[filePath] = info
+	}
+}
+
+// InvalidateSyncTokens makes every previously issued sync token invalid
+func (c *Client) InvalidateSyncTokens() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.seq++
+	c.minToken = c.seq
+}
+
+// WithContext returns c; the fake doesn't block, so there is nothing to cancel
+func (c *Client) WithContext(ctx context.Context) diff.Client {
+	return c
+}
+
+// Stat returns the item at filePath
+func (c *Client) Stat(filePath string) (*webdav.FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("Stat"); err != nil {
+		return nil, err
+	}
+
+	info, ok := c.files[clean(filePath)]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", webdav.ErrNotFound, filePath)
+	}
+	return &info, nil
+}
+
+// Open returns the content written with WriteFile, empty for files created with PutFile
+func (c *Client) Open(filePath string, opts webdav.DownloadOptions) (*webdav.RemoteFile, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("Open"); err != nil {
+		return nil, err
+	}
+
+	filePath = clean(filePath)
+	info, ok := c.files[filePath]
+	if !ok || info.IsDir {
+		return nil, fmt.Errorf("GET failed with status 404: %w", webdav.ErrNotFound)
+	}
+	data := c.contents[filePath]
+	return &webdav.RemoteFile{
+		ReadCloser:    io.NopCloser(bytes.NewReader(data)),
+		ContentLength: int64(len(data)),
+		ETag:          info.ETag,
+		ModifiedTime:  info.ModifiedTime,
+	}, nil
+}
+
+// ListFilesWithETagOptimization walks dirPath with the same semantics as the real client
+func (c *Client) ListFilesWithETagOptimization(dirPath string, includeHidden bool, etagChecker webdav.SubdirETagChecker, etagStorer webdav.SubdirETagStorer, hook webdav.ProgressHook, opts webdav.WalkOptions) ([]webdav.FileInfo, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("ListFiles"); err != nil {
+		return nil, err
+	}
+
+	dirPath = clean(dirPath)
+	if info, ok := c.files[dirPath]; !ok || !info.IsDir {
+		return nil, fmt.Errorf("PROPFIND failed with status 404: %w", webdav.ErrNotFound)
+	}
+
+	skip := opts.Skip
+	descend := func(depth int) bool {
+		return opts.MaxDepth <= 0 || depth < opts.MaxDepth
+	}
+
+	var files []webdav.FileInfo
+	dirsVisited := 0
+	var walk func(dir string, report bool, depth int)
+	walk = func(dir string, report bool, depth int) {
+		dirsVisited++
+		if opts.Stats != nil {
+			opts.Stats.Listed++
+		}
+		if report && hook != nil {
+			hook.OnProgress(webdav.ProgressEvent{Path: dir, DirsVisited: dirsVisited, FilesFound: len(files), TotalBytes: -1})
+		}
+
+		for _, child := range c.children(dir) {
+			if skip != nil && skip(child.Path, child.IsDir) {
+				continue
+			}
+			if !includeHidden && isHidden(child.Path) {
+				if child.IsDir && descend(depth+1) {
+					walk(child.Path, false, depth+1)
+				}
+				continue
+			}
+
+			files = append(files, child)
+			if !child.IsDir || !descend(depth+1) {
+				continue
+			}
+
+			if etagStorer != nil && child.ETag != "" {
+				etagStorer(child.Path, child.ETag)
+			}
+			if etagChecker != nil && child.ETag != "" {
+				hasPrev, prevETag, prevFiles, err := etagChecker(child.Path)
+				if err == nil && hasPrev && prevETag == child.ETag {
+					for _, prevFile := range prevFiles {
+						if !includeHidden && isHidden(prevFile.Path) {
+							continue
+						}
+						if skip != nil && skip(prevFile.Path, prevFile.IsDir) {
+							continue
+						}
+						files = append(files, prevFile)
+					}
+					if opts.Stats != nil {
+						opts.Stats.Reused++
+					}
+					continue
+				}
+			}
+			walk(child.Path, report, depth+1)
+		}
+	}
+	walk(dirPath, true, 0)
+
+	return files, nil
+}
+
+// SyncToken returns a token covering all changes made so far
+func (c *Client) SyncToken(dirPath string) (string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("SyncToken"); err != nil {
+		return "", err
+	}
+	return c.token(), nil
+}
+
+// SyncCollection returns the items below dirPath changed since syncToken
+func (c *Client) SyncCollection(dirPath, syncToken string) (*webdav.SyncResult, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.call("SyncCollection"); err != nil {
+		return nil, err
+	}
+
+	since := 0
+	if syncToken != "" {
+		n, err := strconv.Atoi(strings.TrimPrefix(syncToken, "mock-token-"))
+		if err != nil || n < c.minToken || n > c.seq {
+			return nil, webdav.ErrInvalidSyncToken
+		}
+		since = n
+	}
+
+	dirPath = clean(dirPath)
+	prefix := strings.TrimSuffix(dirPath, "/") + "/"
+
+	// Keep only the latest entry per path
+	latest := make(map[string]syncEntry)
+	for _, entry := range c.changes {
+		if entry.seq > since && strings.HasPrefix(entry.path, prefix) {
+			latest[entry.path] = entry
+		}
+	}
+
+	result := &webdav.SyncResult{Token: c.token()}
+	for _, p := range sortedKeys(latest) {
+		if info, ok := c.files[p]; ok {
+			result.Changed = append(result.Changed, info)
+		} else {
+			result.Deleted = append(result.Deleted, p)
+		}
+	}
+	return result, nil
+}
+
+func (c *Client) call(method string) error {
+	c.Calls[method]++
+	if err := c.Errors[method]; err != nil {
+		return err
+	}
+	return nil
+}
+
+func (c *Client) token() string {
+	return "mock-token-" + strconv.Itoa(c.seq)
+}
+
+func (c *Client) nextETag() string {
+	c.seq++
+	return fmt.Sprintf("etag-%d", c.seq)
+}
+
+// touch records a change and bumps the ETags of p's ancestors (and p itself if asked)
+func (c *Client) touch(p string, self bool) {
+	c.changes = append(c.changes, syncEntry{seq: c.seq, path: p})
+	if self {
+		if info, ok := c.files[p]; ok {
+			info.ETag = c.nextETag()
+			c.files[p] = info
+		}
+	}
+	for dir := p; dir != "/"; {
+		dir = path.Dir(dir)
+		if info, ok := c.files[dir]; ok {
+			info.ETag = c.nextETag()
+			c.files[dir] = info
+			c.changes = append(c.changes, syncEntry{seq: c.seq, path: dir})
+		}
+	}
+}
+
+func (c *Client) mkdirAll(dirPath string) {
+	if dirPath == "/" {
+		return
+	}
+	if _, ok := c.files[dirPath]; ok {
+		return
+	}
+	c.mkdirAll(path.Dir(dirPath))
+	c.files[dirPath] = webdav.FileInfo{Path: dirPath, IsDir: true, ModifiedTime: time.Now(), ETag: c.nextETag()}
+	c.touch(dirPath, false)
+}
+
+func (c *Client) remove(p string) {
+	if _, ok := c.files[p]; !ok || p == "/" {
+		return
+	}
+	for other := range c.files {
+		if other == p || strings.HasPrefix(other, p+"/") {
+			delete(c.files, other)
+			delete(c.contents, other)
+		}
+	}
+	c.seq++
+	c.changes = append(c.changes, syncEntry{seq: c.seq, path: p, deleted: true})
+	c.touch(path.Dir(p), true)
+}
+
+// children returns the direct children of dir sorted by path
+func (c *Client) children(dir string) []webdav.FileInfo {
+	var result []webdav.FileInfo
+	for p, info := range c.files {
+		if p != "/" && path.Dir(p) == dir {
+			result = append(result, info)
+		}
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].Path < result[j].Path })
+	return result
+}
+
+func sortedKeys(m map[string]syncEntry) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func clean(p string) string {
+	return path.Clean("/" + p)
+}
+
+func isHidden(p string) bool {
+	for _, part := range strings.Split(strings.Trim(p, "/"), "/") {
+		if strings.HasPrefix(part, ".") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,133 @@
+package webhook
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"slices"
+	"strings"
+	"text/template"
+
+	"go-nc-client/internal/diff"
+)
+
+// Filter narrows the changes sent to a target; empty fields match everything
+type Filter struct {
+	// PathPrefixes keeps changes to these paths or below them; moves match on
+	// both the old and new path
+	PathPrefixes []string
+	// Types keeps only these change types
+	Types []string
+	// Extensions keeps only files with these extensions, e.g. ".pdf"
+	// (case-insensitive); moves match on both names
+	Extensions []string
+}
+
+// TemplateData is what payload templates are executed with: the fields of
+// Payload, and Changes listing the changes of every result in one slice
+type TemplateData struct {
+	Payload
+	Changes []diff.Change
+}
+
+// templateFuncs are available to payload templates besides the builtins
+var templateFuncs = template.FuncMap{
+	// json encodes a value, to quote strings safely inside JSON bodies
+	"json": func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	},
+	"base": path.Base,
+	"ext":  path.Ext,
+}
+
+// validate checks the change types and normalizes the extensions
+func (f *Filter) validate() error {
+	for _, t := range f.Types {
+		if !slices.Contains(diff.ChangeTypes, t) {
+			return fmt.Errorf("unknown change type %q (expected one of %v)", t, diff.ChangeTypes)
+		}
+	}
+	for i, ext := range f.Extensions {
+		ext = strings.ToLower(ext)
+		if !strings.HasPrefix(ext, ".") {
+			ext = "." + ext
+		}
+		f.Extensions[i] = ext
+	}
+	return nil
+}
+
+// apply returns the results keeping only the matching changes, without the
+// results left with none
+func (f *Filter) apply(results []diff.Changes) []diff.Changes {
+	if len(f.PathPrefixes) == 0 && len(f.Types) == 0 && len(f.Extensions) == 0 {
+		return results
+	}
+	var matched []diff.Changes
+	for _, result := range results {
+		var changes []diff.Change
+		for _, c := range result.Changes {
+			if f.match(c) {
+				changes = append(changes, c)
+			}
+		}
+		if len(changes) > 0 {
+			result.Changes = changes
+			matched = append(matched, result)
+		}
+	}
+	return matched
+}
+
+func (f *Filter) match(c diff.Change) bool {
+	if len(f.Types) > 0 && !slices.Contains(f.Types, c.Type) {
+		return false
+	}
+	paths := []string{c.Path}
+	if c.OldPath != "" {
+		paths = append(paths, c.OldPath)
+	}
+	if len(f.PathPrefixes) > 0 && !slices.ContainsFunc(paths, func(p string) bool {
+		return slices.ContainsFunc(f.PathPrefixes, func(prefix string) bool { return underPrefix(p, prefix) })
+	}) {
+		return false
+	}
+	if len(f.Extensions) > 0 && (c.IsDir || !slices.ContainsFunc(paths, func(p string) bool {
+		return slices.Contains(f.Extensions, strings.ToLower(path.Ext(p)))
+	})) {
+		return false
+	}
+	return true
+}
+
+// underPrefix reports whether p is prefix or below it
+func underPrefix(p, prefix string) bool {
+	prefix = strings.TrimSuffix(prefix, "/")
+	return prefix == "" || p == prefix || strings.HasPrefix(p, prefix+"/")
+}
+
+// parseTemplate compiles a payload template, nil for the default JSON payload
+func parseTemplate(name, text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New(name).Funcs(templateFuncs).Option("missingkey=error").Parse(text)
+}
+
+// render returns the body of a delivery of payload to t
+func (t *target) render(payload Payload) ([]byte, error) {
+	if t.template == nil {
+		return json.Marshal(payload)
+	}
+	data := TemplateData{Payload: payload}
+	for _, result := range payload.Results {
+		data.Changes = append(data.Changes, result.Changes...)
+	}
+	var buf bytes.Buffer
+	if err := t.template.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
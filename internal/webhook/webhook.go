@@ -0,0 +1,369 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"sync"
+	"text/template"
+	"time"
+
+	"go-nc-client/internal/diff"
+)
+
+const (
+	// DefaultMaxAttempts is how often a delivery is tried when no limit is configured
+	DefaultMaxAttempts = 5
+	// DefaultTimeout bounds each attempt when no timeout is configured
+	DefaultTimeout = 10 * time.Second
+	// queueSize is how many deliveries may wait for a target before new ones fail
+	queueSize = 100
+	// keepDeliveries is how many recent deliveries are kept for the status endpoint
+	keepDeliveries = 200
+	// firstBackoff is the wait before the first retry, doubled for each one after
+	firstBackoff = time.Second
+	// maxBackoff caps the wait between retries
+	maxBackoff = 5 * time.Minute
+)
+
+// Delivery statuses
+const (
+	StatusPending   = "pending"
+	StatusDelivered = "delivered"
+	StatusFailed    = "failed"
+)
+
+// Target is a URL notified of the changes of every diff run that finds some
+type Target struct {
+	// Name identifies the target in logs and delivery statuses
+	Name string
+	URL  string
+	// Secret signs each delivery with HMAC-SHA256 (empty = unsigned)
+	Secret string
+	// MaxAttempts bounds the tries of a delivery (0 = DefaultMaxAttempts)
+	MaxAttempts int
+	// Timeout bounds each attempt (0 = DefaultTimeout)
+	Timeout time.Duration
+	// Filter narrows the changes sent; a run without matching changes is not sent
+	Filter Filter
+	// Template is a text/template rendering the body from TemplateData, e.g. a
+	// chat message (empty = the JSON Payload)
+	Template string
+	// ContentType of the body (empty = application/json)
+	ContentType string
+}
+
+// Payload is the JSON body POSTed to targets without a template
+type Payload struct {
+	// Event is always "changes"
+	Event     string         `json:"event"`
+	RunID     string         `json:"run_id"`
+	Timestamp time.Time      `json:"timestamp"`
+	Results   []diff.Changes `json:"results"`
+}
+
+// Delivery is the status of a payload sent, or being sent, to a target
+type Delivery struct {
+	ID      string    `json:"id"`
+	Webhook string    `json:"webhook"`
+	RunID   string    `json:"run_id"`
+	Status  string    `json:"status"`
+	Changes int       `json:"changes"`
+	Created time.Time `json:"created"`
+	// Attempts counts the tries so far; NextAttempt is when a pending delivery is retried
+	Attempts    int       `json:"attempts"`
+	NextAttempt time.Time `json:"next_attempt,omitzero"`
+	// LastStatus is the HTTP status of the last attempt, 0 when it got no response
+	LastStatus int       `json:"last_status,omitempty"`
+	LastError  string    `json:"last_error,omitempty"`
+	Delivered  time.Time `json:"delivered,omitzero"`
+}
+
+// Notifier POSTs the changes of diff runs to webhook targets; register it as
+// a diff.Observer
+// Deliveries are queued, so a slow or failing target never holds back a run.
+type Notifier struct {
+	diff.NopObserver
+
+	targets []*target
+	client  *http.Client
+
+	mu         sync.Mutex
+	deliveries []*Delivery
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+}
+
+// target is a Target with its queue and compiled template
+type target struct {
+	Target
+	template *template.Template
+	queue    chan *queued
+}
+
+// queued is a delivery waiting for its target's worker
+type queued struct {
+	delivery *Delivery
+	body     []byte
+}
+
+// New checks the targets and starts a worker for each
+func New(targets []Target) (*Notifier, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	n := &Notifier{client: &http.Client{}, ctx: ctx, cancel: cancel}
+	names := make(map[string]bool)
+	for i, t := range targets {
+		if t.Name == "" {
+			t.Name = strconv.Itoa(i + 1)
+		}
+		if names[t.Name] {
+			cancel()
+			return nil, fmt.Errorf("webhook %s: defined twice", t.Name)
+		}
+		names[t.Name] = true
+		if u, err := url.Parse(t.URL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			cancel()
+			return nil, fmt.Errorf("webhook %s: invalid url %q (expected http or https)", t.Name, t.URL)
+		}
+		if t.MaxAttempts <= 0 {
+			t.MaxAttempts = DefaultMaxAttempts
+		}
+		if t.Timeout <= 0 {
+			t.Timeout = DefaultTimeout
+		}
+		if t.ContentType == "" {
+			t.ContentType = "application/json"
+		}
+		t.Filter.Extensions = slices.Clone(t.Filter.Extensions)
+		if err := t.Filter.validate(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("webhook %s: %w", t.Name, err)
+		}
+		tmpl, err := parseTemplate(t.Name, t.Template)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("webhook %s: invalid template: %w", t.Name, err)
+		}
+		n.targets = append(n.targets, &target{Target: t, template: tmpl, queue: make(chan *queued, queueSize)})
+	}
+	for _, t := range n.targets {
+		n.wg.Add(1)
+		go func() {
+			defer n.wg.Done()
+			n.work(t)
+		}()
+	}
+	return n, nil
+}
+
+// OnRunComplete queues the changes of a run for every target with matching ones
+func (n *Notifier) OnRunComplete(run *diff.RunInfo, results []diff.Changes) {
+	timestamp := time.Now()
+	for _, t := range n.targets {
+		matched := t.Filter.apply(results)
+		total := 0
+		for _, result := range matched {
+			total += len(result.Changes)
+		}
+		if total == 0 {
+			continue
+		}
+
+		delivery := n.record(&Delivery{
+			ID:      newDeliveryID(),
+			Webhook: t.Name,
+			RunID:   run.ID,
+			Status:  StatusPending,
+			Changes: total,
+			Created: time.Now(),
+		})
+		body, err := t.render(Payload{Event: "changes", RunID: run.ID, Timestamp: timestamp, Results: matched})
+		if err != nil {
+			n.finish(delivery, StatusFailed, func(d *Delivery) { d.LastError = "failed to render payload: " + err.Error() })
+			slog.Error("Failed to render webhook payload", "webhook", t.Name, "run_id", run.ID, "error", err)
+			continue
+		}
+		select {
+		case t.queue <- &queued{delivery: delivery, body: body}:
+		default:
+			n.finish(delivery, StatusFailed, func(d *Delivery) { d.LastError = "delivery queue full" })
+			slog.Warn("Webhook queue full, dropping delivery", "webhook", t.Name, "run_id", run.ID)
+		}
+	}
+}
+
+// work delivers the queued payloads of t in order until Close
+func (n *Notifier) work(t *target) {
+	for {
+		select {
+		case <-n.ctx.Done():
+			return
+		case q := <-t.queue:
+			n.deliver(t, q)
+		}
+	}
+}
+
+// deliver tries a payload until it is accepted, fails for good, or runs out of attempts
+func (n *Notifier) deliver(t *target, q *queued) {
+	logger := slog.With("webhook", t.Name, "delivery_id", q.delivery.ID, "run_id", q.delivery.RunID)
+	backoff := firstBackoff
+	for attempt := 1; ; attempt++ {
+		status, err := n.send(t, q)
+		retry := err != nil && (status == 0 || status == http.StatusTooManyRequests || status >= 500)
+		if err == nil {
+			n.finish(q.delivery, StatusDelivered, func(d *Delivery) {
+				d.Attempts, d.LastStatus, d.LastError = attempt, status, ""
+				d.Delivered = time.Now()
+			})
+			logger.Info("Webhook delivered", "attempts", attempt, "status", status)
+			return
+		}
+		if !retry || attempt >= t.MaxAttempts {
+			n.finish(q.delivery, StatusFailed, func(d *Delivery) {
+				d.Attempts, d.LastStatus, d.LastError = attempt, status, err.Error()
+			})
+			logger.Error("Webhook delivery failed", "attempts", attempt, "status", status, "error", err)
+			return
+		}
+
+		n.update(q.delivery, func(d *Delivery) {
+			d.Attempts, d.LastStatus, d.LastError = attempt, status, err.Error()
+			d.NextAttempt = time.Now().Add(backoff)
+		})
+		logger.Warn("Webhook delivery failed, retrying", "attempt", attempt, "status", status, "retry_in", backoff, "error", err)
+		select {
+		case <-n.ctx.Done():
+			n.finish(q.delivery, StatusFailed, func(d *Delivery) { d.LastError = "shutting down: " + d.LastError })
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, maxBackoff)
+	}
+}
+
+// send makes one attempt, returning the response status (0 without response)
+func (n *Notifier) send(t *target, q *queued) (int, error) {
+	ctx, cancel := context.WithTimeout(n.ctx, t.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.URL, bytes.NewReader(q.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", t.ContentType)
+	req.Header.Set("User-Agent", "go-nc-client-webhook")
+	req.Header.Set("X-Webhook-Event", "changes")
+	req.Header.Set("X-Webhook-Delivery", q.delivery.ID)
+	req.Header.Set("X-Webhook-Timestamp", timestamp)
+	if t.Secret != "" {
+		req.Header.Set("X-Webhook-Signature", "sha256="+Sign(t.Secret, timestamp, q.body))
+	}
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign returns the hex HMAC-SHA256 of timestamp + "." + body, which receivers
+// compare with the X-Webhook-Signature header
+// Including the timestamp lets receivers reject replayed deliveries.
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// record keeps d among the recent deliveries
+func (n *Notifier) record(d *Delivery) *Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.deliveries = append(n.deliveries, d)
+	if len(n.deliveries) > keepDeliveries {
+		n.deliveries = n.deliveries[len(n.deliveries)-keepDeliveries:]
+	}
+	return d
+}
+
+// update changes a delivery under the lock
+func (n *Notifier) update(d *Delivery, fn func(d *Delivery)) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	fn(d)
+}
+
+// finish sets the final status of a delivery
+func (n *Notifier) finish(d *Delivery, status string, fn func(d *Delivery)) {
+	n.update(d, func(d *Delivery) {
+		fn(d)
+		d.Status = status
+		d.NextAttempt = time.Time{}
+	})
+}
+
+// Deliveries returns the recent deliveries, newest first, optionally only
+// those of one webhook or with one status
+func (n *Notifier) Deliveries(webhook, status string) []Delivery {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	result := []Delivery{}
+	for i := len(n.deliveries) - 1; i >= 0; i-- {
+		d := n.deliveries[i]
+		if (webhook == "" || d.Webhook == webhook) && (status == "" || d.Status == status) {
+			result = append(result, *d)
+		}
+	}
+	return result
+}
+
+// Close stops the workers, aborting attempts in progress, and waits for
+// them until ctx ends; deliveries not made yet are marked failed
+func (n *Notifier) Close(ctx context.Context) {
+	n.cancel()
+	done := make(chan struct{})
+	go func() {
+		n.wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+	}
+	for _, t := range n.targets {
+		for drained := false; !drained; {
+			select {
+			case q := <-t.queue:
+				n.finish(q.delivery, StatusFailed, func(d *Delivery) { d.LastError = "shutting down before delivery" })
+			default:
+				drained = true
+			}
+		}
+	}
+}
+
+func newDeliveryID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -1,46 +1,487 @@
 package main
 
 import (
+	"cmp"
+	"context"
+	"crypto/tls"
+	"errors"
 	"flag"
-	"log"
+	"fmt"
+	"log/slog"
+	"net"
 	"net/http"
+	"net/http/pprof"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"slices"
+	"sort"
+	"sync/atomic"
+	"syscall"
+	"time"
 
+	"go-nc-client/internal/activation"
+	"go-nc-client/internal/certs"
 	"go-nc-client/internal/config"
 	"go-nc-client/internal/diff"
+	"go-nc-client/internal/filter"
 	"go-nc-client/internal/handlers"
 	"go-nc-client/internal/middleware"
+	"go-nc-client/internal/schedule"
+	"go-nc-client/internal/secrets"
+	"go-nc-client/internal/stream"
+	"go-nc-client/internal/tenant"
+	"go-nc-client/internal/version"
 	"go-nc-client/internal/webdav"
+	"go-nc-client/internal/webhook"
 )
 
 func main() {
 	// Parse command-line flags
 	portFlag := flag.String("port", "", "Port to run the server on (default: 8080 or PORT environment variable)")
+	versionFlag := flag.Bool("version", false, "Print the version and exit")
+	encryptFlag := flag.Bool("encrypt-config", false, "Encrypt the secrets of config.json with the config encryption key and exit")
 	flag.Parse()
 
+	build := version.Get()
+	if *versionFlag {
+		fmt.Println("go-nc-client", build)
+		return
+	}
+
 	// Load configuration
 	cfg, err := config.Load("config.json")
 	if err != nil {
-		log.Fatalf("Failed to load config: %v", err)
+		fatal("Failed to load config", "error", err)
+	}
+	if *encryptFlag {
+		if !cfg.Encrypts() {
+			fatal("Failed to encrypt config", "error", `no key configured: set config_encryption_key_file or CONFIG_ENCRYPTION_KEY, e.g. to the output of "openssl rand -base64 32"`)
+		}
+		if err := config.Save(cfg, "config.json"); err != nil {
+			fatal("Failed to encrypt config", "error", err)
+		}
+		fmt.Println("Encrypted the secrets of config.json")
+		return
+	}
+	logger, err := newLogger(cfg)
+	if err != nil {
+		fatal("Invalid logging settings", "error", err)
+	}
+	slog.SetDefault(logger)
+	slog.Info("Starting go-nc-client", "version", build.Version, "commit", build.Commit, "build_date", build.BuildDate, "go_version", build.GoVersion)
+
+	// Report every problem at once, rather than the first one a request runs into
+	if err := cfg.Validate(); err != nil {
+		var problems config.ValidationError
+		if !errors.As(err, &problems) {
+			problems = config.ValidationError{err.Error()}
+		}
+		for _, problem := range problems {
+			slog.Error("Invalid config", "problem", problem)
+		}
+		os.Exit(1)
+	}
+	if err := checkStateDirs(cfg); err != nil {
+		fatal("Invalid config", "problem", err)
+	}
+
+	// Passwords of providers are read before the clients are made, then polled
+	// so a rotation in the store is picked up
+	var passwordPolls []passwordPoll
+	var passwordProvider secrets.Provider
+	if cfg.PasswordProvider != nil {
+		passwordProvider, cfg.Password, err = providerPassword(cfg.PasswordProvider)
+		if err != nil {
+			fatal("Failed to read password from password_provider", "error", err)
+		}
 	}
 
 	// Initialize WebDAV client
-	client := webdav.NewClient(cfg.WebDAVURL, cfg.Username, cfg.Password)
+	client := newClient(cfg, cfg.WebDAVURL, cfg.Username, cfg.Password)
+	// Secrets read from files follow them, e.g. when Kubernetes updates a mounted secret
+	var secretFiles []secrets.File
+	if cfg.PasswordFile != "" {
+		secretFiles = append(secretFiles, secrets.File{Path: cfg.PasswordFile, Apply: client.SetPassword})
+	}
+	if passwordProvider != nil {
+		passwordPolls = append(passwordPolls, passwordPoll{passwordProvider, cfg.PasswordProvider.Refresh(), cfg.Password, client.SetPassword})
+	}
+	if cfg.AutoDiscover {
+		info, err := client.Discover()
+		if err != nil {
+			slog.Warn("Server discovery failed, using configured URL as is", "error", err)
+		} else {
+			slog.Info("Discovered server", "base_url", info.BaseURL, "nextcloud", info.Nextcloud,
+				"version", info.Version, "features", fmt.Sprintf("%+v", info.Features))
+		}
+	}
+	if cfg.CheckConnection {
+		if err := checkConnection(client); err != nil {
+			fatal("Connection check failed", "webdav_url", cfg.WebDAVURL, "error", err)
+		}
+	}
+
+	// Cache server capabilities so features can branch on them
+	if caps, err := client.Capabilities(false); err != nil {
+		slog.Warn("Could not fetch server capabilities", "error", err)
+	} else {
+		slog.Info("Server capabilities", "version", caps.Version.String, "chunking", caps.BigFileChunking(),
+			"trashbin", caps.Trashbin(), "versioning", caps.Versioning())
+	}
 
 	// Initialize change detector
-	absStateFile, _ := filepath.Abs(cfg.StateFile)
-	log.Printf("State file configured as: %s (absolute: %s)", cfg.StateFile, absStateFile)
-	detector := diff.NewDetector(client, cfg.StateFile)
+	slog.Info("State file configured", "path", cfg.StateFile, "backend", cfg.StateBackend)
+	stateCipher, err := loadStateCipher(cfg)
+	if err != nil {
+		fatal("Invalid state encryption key", "error", err)
+	}
+	store, err := openStateStore(cfg, cfg.StateFile, stateCipher)
+	if err != nil {
+		fatal("Failed to open state store", "error", err)
+	}
+	if boltStore, ok := store.(*diff.BoltStore); ok {
+		defer boltStore.Close()
+	}
+
+	// Each profile keeps its own state next to the default one
+	var profiles func(name string) (diff.StateStore, error)
+	if len(cfg.Profiles) > 0 {
+		known := make(map[string]bool, len(cfg.Profiles))
+		for _, name := range cfg.Profiles {
+			known[name] = true
+		}
+		profiles = func(name string) (diff.StateStore, error) {
+			if !known[name] {
+				return nil, fmt.Errorf("%w: %s", diff.ErrUnknownProfile, name)
+			}
+			return openStateStore(cfg, diff.ProfileStatePath(cfg.StateFile, name), stateCipher)
+		}
+		slog.Info("State profiles", "profiles", cfg.Profiles)
+	}
+	settings, err := detectorSettings(cfg)
+	if err != nil {
+		fatal("Invalid config", "error", err)
+	}
+
+	var ignore *filter.Ignore
+	if cfg.IgnoreFile != "" {
+		f, err := os.Open(cfg.IgnoreFile)
+		if err != nil {
+			fatal("Failed to open ignore_file", "error", err)
+		}
+		ignore, err = filter.ParseIgnore(f)
+		f.Close()
+		if err != nil {
+			fatal("Failed to parse ignore_file", "path", cfg.IgnoreFile, "error", err)
+		}
+	}
+
+	var transient *diff.TransientFilter
+	if len(cfg.TransientPatterns) > 0 || cfg.TransientMinScans > 0 {
+		minScans := cfg.TransientMinScans
+		if minScans == 0 {
+			minScans = 2
+		}
+		transient, err = diff.NewTransientFilter(cfg.TransientPatterns, minScans)
+		if err != nil {
+			fatal("Invalid transient file settings", "error", err)
+		}
+	}
+
+	var contentDiff *diff.ContentDiffOptions
+	if cd := cfg.ContentDiff; cd != nil {
+		contentDiff = &diff.ContentDiffOptions{
+			MaxSize:    cd.MaxSize,
+			Extensions: cd.Extensions,
+			CacheDir:   cd.CacheDir,
+		}
+		if contentDiff.MaxSize <= 0 {
+			contentDiff.MaxSize = 64 << 10
+		}
+		if len(contentDiff.Extensions) == 0 {
+			contentDiff.Extensions = []string{".md", ".txt"}
+		}
+		if contentDiff.CacheDir == "" {
+			contentDiff.CacheDir = filepath.Join(filepath.Dir(cfg.StateFile), "content-cache")
+		}
+	}
+
+	var journal *diff.Journal
+	if cfg.JournalFile != "" {
+		retentionDays := cfg.JournalRetentionDays
+		if retentionDays == 0 {
+			retentionDays = 30
+		}
+		journal, err = diff.OpenJournal(cfg.JournalFile, time.Duration(max(retentionDays, 0))*24*time.Hour)
+		if err != nil {
+			fatal("Failed to open journal_file", "error", err)
+		}
+		slog.Info("Change journal enabled", "path", cfg.JournalFile, "retention_days", retentionDays)
+	}
+
+	scanParallelism := cfg.ScanParallelism
+	if scanParallelism <= 0 {
+		scanParallelism = 4
+	}
+
+	snapshotDir := cfg.SnapshotDir
+	if snapshotDir == "" {
+		snapshotDir = filepath.Join(filepath.Dir(cfg.StateFile), "snapshots")
+	}
+
+	ackFile := cfg.AckFile
+	if ackFile == "" {
+		ackFile = filepath.Join(filepath.Dir(cfg.StateFile), "acks.json")
+	}
+	acks, err := diff.OpenAckStore(ackFile)
+	if err != nil {
+		fatal("Failed to open ack_file", "error", err)
+	}
+
+	var cursors *diff.CursorStore
+	cursorHistory := cfg.CursorHistory
+	if cursorHistory == 0 {
+		cursorHistory = 10
+	}
+	if cursorHistory > 0 {
+		cursors = diff.NewCursorStore(filepath.Join(filepath.Dir(cfg.StateFile), "cursors.json"), cursorHistory).Encrypt(stateCipher)
+	}
+
+	var jobs *diff.JobStore
+	jobHistory := cfg.JobHistory
+	if jobHistory == 0 {
+		jobHistory = 50
+	}
+	if jobHistory > 0 {
+		jobs = diff.NewJobStore(filepath.Join(filepath.Dir(cfg.StateFile), "jobs"), jobHistory).Encrypt(stateCipher)
+	}
+
+	// Pushes the changes of every run to /ws clients
+	hub := stream.NewHub()
+
+	var targets []webhook.Target
+	for _, wh := range cfg.Webhooks {
+		tmpl := wh.Template
+		if wh.TemplateFile != "" {
+			data, err := os.ReadFile(wh.TemplateFile)
+			if err != nil {
+				fatal("Failed to read webhook template_file", "webhook", wh.Name, "error", err)
+			}
+			tmpl = string(data)
+		}
+		targets = append(targets, webhook.Target{
+			Name:        wh.Name,
+			URL:         wh.URL,
+			Secret:      wh.Secret,
+			MaxAttempts: wh.MaxAttempts,
+			Timeout:     time.Duration(wh.TimeoutSeconds) * time.Second,
+			Filter: webhook.Filter{
+				PathPrefixes: wh.PathPrefixes,
+				Types:        wh.Types,
+				Extensions:   wh.Extensions,
+			},
+			Template:    tmpl,
+			ContentType: wh.ContentType,
+		})
+	}
+	notifier, err := webhook.New(targets)
+	if err != nil {
+		fatal("Invalid webhooks", "error", err)
+	}
+	if len(targets) > 0 {
+		slog.Info("Webhooks enabled", "webhooks", len(targets))
+	}
+
+	detector := diff.NewDetector(diff.WebDAVClient(client), store, diff.Options{
+		UseSyncTokens:    cfg.UseSyncTokens,
+		NormalizeUnicode: cfg.NormalizeUnicode,
+		ConfirmChecksums: cfg.ConfirmChecksums,
+		ContentDiff:      contentDiff,
+		Filter:           settings.Filter,
+		Ignore:           ignore,
+		MaxDepths:        settings.MaxDepths,
+		MoveDetection:    settings.MoveDetection,
+		Journal:          journal,
+		Snapshots:        diff.NewSnapshotStore(snapshotDir),
+		LockFile:         filepath.Clean(cfg.StateFile) + ".lock",
+		Parallelism:      scanParallelism,
+		Acks:             acks,
+		Transient:        transient,
+		Profiles:         profiles,
+		ProfileNames:     cfg.Profiles,
+		Cursors:          cursors,
+		Jobs:             jobs,
+		Account:          diff.AccountFingerprint(cfg.WebDAVURL, cfg.Username),
+		OnAccountChange:  cfg.OnAccountChange,
+		Observers:        []diff.Observer{hub, notifier},
+	})
+
+	// Other accounts than the top-level one keep their state, cursors, jobs and
+	// snapshots next to their stateFile; their runs reach neither /ws nor the webhooks
+	newAccountDetector := func(client *webdav.Client, store diff.StateStore, stateFile, account string, settings *diff.Settings, acks *diff.AckStore) *diff.Detector {
+		dir := filepath.Dir(stateFile)
+		var accountCursors *diff.CursorStore
+		if cursors != nil {
+			accountCursors = diff.NewCursorStore(filepath.Join(dir, "cursors.json"), cursorHistory).Encrypt(stateCipher)
+		}
+		var accountJobs *diff.JobStore
+		if jobs != nil {
+			accountJobs = diff.NewJobStore(filepath.Join(dir, "jobs"), jobHistory).Encrypt(stateCipher)
+		}
+		return diff.NewDetector(diff.WebDAVClient(client), store, diff.Options{
+			UseSyncTokens:    cfg.UseSyncTokens,
+			NormalizeUnicode: cfg.NormalizeUnicode,
+			ConfirmChecksums: cfg.ConfirmChecksums,
+			Filter:           settings.Filter,
+			Ignore:           ignore,
+			MaxDepths:        settings.MaxDepths,
+			MoveDetection:    settings.MoveDetection,
+			Snapshots:        diff.NewSnapshotStore(filepath.Join(dir, "snapshots")),
+			LockFile:         filepath.Clean(stateFile) + ".lock",
+			Parallelism:      scanParallelism,
+			Acks:             acks,
+			Cursors:          accountCursors,
+			Jobs:             accountJobs,
+			Account:          account,
+			OnAccountChange:  cfg.OnAccountChange,
+		})
+	}
+
+	// Accounts of config.json are picked with ?account=<name>
+	accounts := make(map[string]*tenant.Tenant, len(cfg.Accounts))
+	for _, acc := range cfg.Accounts {
+		webdavURL := cmp.Or(acc.WebDAVURL, cfg.WebDAVURL)
+		stateFile := cfg.AccountStateFile(acc)
+		accountStore, err := openStateStore(cfg, stateFile, stateCipher)
+		if err != nil {
+			fatal("Failed to open account state store", "account", acc.Name, "error", err)
+		}
+		if boltStore, ok := accountStore.(*diff.BoltStore); ok {
+			defer boltStore.Close()
+		}
+		accountAcks, err := diff.OpenAckStore(filepath.Join(filepath.Dir(stateFile), "acks.json"))
+		if err != nil {
+			fatal("Failed to open account acks", "account", acc.Name, "error", err)
+		}
+		accountSettings, err := accountSettings(cfg, acc)
+		if err != nil {
+			fatal("Invalid account config", "account", acc.Name, "error", err)
+		}
+		password := acc.Password
+		var provider secrets.Provider
+		if acc.PasswordProvider != nil {
+			if provider, password, err = providerPassword(acc.PasswordProvider); err != nil {
+				fatal("Failed to read password from password_provider", "account", acc.Name, "error", err)
+			}
+		}
+		accountClient := newClient(cfg, webdavURL, acc.Username, password)
+		if acc.PasswordFile != "" {
+			secretFiles = append(secretFiles, secrets.File{Path: acc.PasswordFile, Apply: accountClient.SetPassword})
+		}
+		if provider != nil {
+			passwordPolls = append(passwordPolls, passwordPoll{provider, acc.PasswordProvider.Refresh(), password, accountClient.SetPassword})
+		}
+		if cfg.CheckConnection {
+			if err := checkConnection(accountClient); err != nil {
+				fatal("Connection check failed", "account", acc.Name, "webdav_url", webdavURL, "error", err)
+			}
+		}
+		accounts[acc.Name] = &tenant.Tenant{
+			ID:       acc.Name,
+			Name:     acc.Name,
+			Client:   accountClient,
+			Detector: newAccountDetector(accountClient, accountStore, stateFile, diff.AccountFingerprint(webdavURL, acc.Username), &accountSettings, accountAcks),
+		}
+		slog.Info("Account configured", "account", acc.Name, "webdav_url", webdavURL, "state_file", stateFile)
+	}
+
+	// Accounts of per-request credentials each keep their state in a directory
+	// of their own
+	var tenants *tenant.Pool
+	var tenantSettings atomic.Pointer[diff.Settings]
+	tenantSettings.Store(&settings)
+	if cfg.Tenants != nil {
+		stateDir := cfg.Tenants.StateDir
+		if stateDir == "" {
+			stateDir = filepath.Join(filepath.Dir(cfg.StateFile), "tenants")
+		}
+		maxCached := cfg.Tenants.MaxCached
+		if maxCached <= 0 {
+			maxCached = 100
+		}
+		tenants = tenant.NewPool(maxCached, func(username, password string) (*tenant.Tenant, error) {
+			// Anyone could otherwise read an account's state by its username
+			tenantClient := newClient(cfg, cfg.WebDAVURL, username, password)
+			if _, err := tenantClient.Stat("/"); err != nil {
+				return nil, fmt.Errorf("failed to check credentials: %w", err)
+			}
+			id := diff.AccountFingerprint(cfg.WebDAVURL, username)
+			dir := filepath.Join(stateDir, id)
+			if err := os.MkdirAll(dir, 0o700); err != nil {
+				return nil, err
+			}
+			stateFile := filepath.Join(dir, filepath.Base(cfg.StateFile))
+			store, err := openStateStore(cfg, stateFile, stateCipher)
+			if err != nil {
+				return nil, err
+			}
+			return &tenant.Tenant{
+				ID:       id,
+				Client:   tenantClient,
+				Detector: newAccountDetector(tenantClient, store, stateFile, id, tenantSettings.Load(), nil),
+			}, nil
+		})
+		slog.Info("Per-request credentials enabled", "state_dir", stateDir, "max_cached", maxCached)
+	}
+
+	// Scheduled diffs queue behind the ones requested through the API rather than being skipped
+	scheduler, err := schedule.New(schedules(cfg), func(directories []string) ([]diff.Changes, error) {
+		return detector.DetectChanges(directories, diff.DetectOptions{Wait: true})
+	})
+	if err != nil {
+		fatal("Invalid schedule", "error", err)
+	}
+	scheduler.Start()
 
 	// Initialize handlers
-	h := handlers.NewHandlers(detector, client)
+	configs := handlers.NewConfigManager("config.json", cfg, func(next *config.Config) (func(), error) {
+		settings, err := detectorSettings(next)
+		if err != nil {
+			return nil, err
+		}
+		if err := next.Validate(); err != nil {
+			return nil, err
+		}
+		if err := schedule.Validate(schedules(next)); err != nil {
+			return nil, err
+		}
+		accountsSettings := make(map[string]diff.Settings, len(next.Accounts))
+		for _, acc := range next.Accounts {
+			if accountsSettings[acc.Name], err = accountSettings(next, acc); err != nil {
+				return nil, fmt.Errorf("account %s: %w", acc.Name, err)
+			}
+		}
+		return func() {
+			detector.UpdateSettings(settings)
+			for name, settings := range accountsSettings {
+				accounts[name].Detector.UpdateSettings(settings)
+			}
+			if tenants != nil {
+				tenantSettings.Store(&settings)
+				tenants.Each(func(t *tenant.Tenant) { t.Detector.UpdateSettings(settings) })
+			}
+			scheduler.Replace(schedules(next))
+		}, nil
+	})
+	h := handlers.NewHandlers(detector, client, hub, scheduler, notifier, configs, tenants, accounts)
 
-	// Setup routes
+	// Setup routes, documented in handlers.Routes for /openapi.json
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health", h.Health)
-	mux.HandleFunc("/diff", h.Diff)
-	mux.HandleFunc("/ls", h.List)
+	for _, route := range h.Routes() {
+		mux.Handle(route.Path, h.Handler(route))
+	}
+	mux.HandleFunc("/", handlers.NotFound)
 
 	// Determine port: command-line flag > environment variable > default
 	port := *portFlag
@@ -51,6 +492,476 @@ func main() {
 		port = "8080"
 	}
 
-	log.Printf("Server starting on port %s", port)
-	log.Fatal(http.ListenAndServe(":"+port, middleware.Logging(mux)))
+	var handler http.Handler = mux
+	maxBodyBytes := cfg.MaxBodyBytes
+	if maxBodyBytes == 0 {
+		maxBodyBytes = middleware.DefaultMaxBodyBytes
+	}
+	maxUploadBytes := cfg.MaxUploadBytes
+	if maxUploadBytes <= 0 {
+		maxUploadBytes = -1
+	}
+	handler = middleware.NewBodyLimits(maxBodyBytes, map[string]int64{
+		"/upload":            maxUploadBytes,
+		"POST /state/import": maxUploadBytes,
+	}).Handler(handler)
+	timeouts := cfg.Timeouts
+	if timeouts == nil {
+		timeouts = &config.TimeoutsConfig{}
+	}
+	if len(timeouts.Routes) > 0 {
+		routes := make(map[string]middleware.Timeout, len(timeouts.Routes))
+		for route, timeout := range timeouts.Routes {
+			if timeout.ReadSeconds < -1 || timeout.WriteSeconds < -1 {
+				fatal("Invalid timeouts", "route", route, "error", "seconds must be -1 (no limit), 0 (server default) or positive")
+			}
+			routes[route] = middleware.Timeout{
+				Read:  time.Duration(timeout.ReadSeconds) * time.Second,
+				Write: time.Duration(timeout.WriteSeconds) * time.Second,
+			}
+		}
+		handler = middleware.NewTimeouts(routes).Handler(handler)
+	}
+	if len(cfg.RateLimits) > 0 {
+		limits := make(map[string]middleware.RateLimit, len(cfg.RateLimits))
+		for route, limit := range cfg.RateLimits {
+			limits[route] = middleware.RateLimit{PerMinute: limit.RequestsPerMinute, Burst: limit.Burst}
+		}
+		limiter, err := middleware.NewRateLimiter(limits)
+		if err != nil {
+			fatal("Invalid rate_limits", "error", err)
+		}
+		// Inside auth, to count requests per API key
+		handler = limiter.Handler(handler)
+		slog.Info("Rate limiting enabled", "routes", len(limits))
+	}
+	if len(cfg.APIKeys) > 0 {
+		keys := make([]middleware.APIKey, len(cfg.APIKeys))
+		for i, key := range cfg.APIKeys {
+			keys[i] = middleware.APIKey{Name: key.Name, Key: key.Key, Scope: key.Scope}
+		}
+		auth, err := middleware.NewAuth(keys, routeScopes)
+		if err != nil {
+			fatal("Invalid api_keys", "error", err)
+		}
+		for i, key := range cfg.APIKeys {
+			if key.TokenFile == "" {
+				continue
+			}
+			secretFiles = append(secretFiles, secrets.File{Path: key.TokenFile, Apply: func(token string) {
+				// auth keeps reading the previous slice until SetKeys
+				keys = slices.Clone(keys)
+				keys[i].Key = token
+				auth.SetKeys(keys)
+			}})
+		}
+		handler = auth.Handler(handler)
+		slog.Info("API key authentication enabled", "keys", len(keys))
+	}
+	// Outside auth, so its errors get the /v1 envelope too
+	handler = middleware.Versioned(handler, "/openapi.json")
+	if cfg.CORS != nil {
+		// Outside auth: preflights carry no API key
+		handler = middleware.CORS(middleware.CORSOptions{
+			AllowedOrigins: cfg.CORS.AllowedOrigins,
+			AllowedMethods: cfg.CORS.AllowedMethods,
+			AllowedHeaders: cfg.CORS.AllowedHeaders,
+			MaxAge:         cfg.CORS.MaxAgeSeconds,
+		})(handler)
+		slog.Info("CORS enabled", "origins", cfg.CORS.AllowedOrigins)
+	}
+	if cfg.CompressionMinSize >= 0 {
+		minSize := cfg.CompressionMinSize
+		if minSize == 0 {
+			minSize = middleware.DefaultGzipMinSize
+		}
+		handler = middleware.Gzip(minSize)(handler)
+	}
+
+	// Cancelled when shutting down, so long-polling requests return early; the
+	// cause tells diffs in progress to finish rather than abort
+	baseCtx, cancelRequests := context.WithCancelCause(context.Background())
+	defer cancelRequests(nil)
+	server := &http.Server{
+		Addr:              ":" + port,
+		Handler:           middleware.RequestID(middleware.Logging(handler)),
+		BaseContext:       func(net.Listener) context.Context { return baseCtx },
+		ReadHeaderTimeout: secondsOr(timeouts.ReadHeaderSeconds, 10*time.Second),
+		ReadTimeout:       time.Duration(timeouts.ReadSeconds) * time.Second,
+		WriteTimeout:      time.Duration(timeouts.WriteSeconds) * time.Second,
+		IdleTimeout:       secondsOr(timeouts.IdleSeconds, 120*time.Second),
+	}
+	// Under systemd socket activation, serve the sockets of the socket unit
+	// rather than the port: connections wait in them while the service restarts
+	listeners, err := activation.Listeners()
+	if err != nil {
+		fatal("Failed to use the sockets passed by systemd", "error", err)
+	}
+	activated := listeners != nil
+	if !activated {
+		l, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			fatal("Failed to listen", "port", port, "error", err)
+		}
+		listeners = []net.Listener{l}
+	}
+	serve := server.Serve
+	tlsEnabled := cfg.TLSCert != "" || cfg.TLSKey != ""
+	if tlsEnabled {
+		reloader, err := certs.NewReloader(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			fatal("Failed to load TLS certificate", "error", err)
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.GetCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+		serve = func(l net.Listener) error { return server.ServeTLS(l, "", "") }
+	}
+	addrs := make([]string, len(listeners))
+	for i, l := range listeners {
+		addrs[i] = l.Addr().String()
+	}
+	slog.Info("Server starting", "addresses", addrs, "tls", tlsEnabled, "socket_activated", activated)
+
+	if cfg.PprofAddr != "" {
+		startPprof(cfg.PprofAddr)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+	if len(secretFiles) > 0 {
+		go secrets.Watch(ctx, secretsCheckInterval, secretFiles)
+	}
+	for _, poll := range passwordPolls {
+		go secrets.Poll(ctx, poll.interval, poll.provider, poll.password, poll.apply)
+	}
+	serveErr := make(chan error, len(listeners))
+	for _, l := range listeners {
+		go func() { serveErr <- serve(l) }()
+	}
+
+	select {
+	case err := <-serveErr:
+		fatal("Server failed", "error", err)
+	case <-ctx.Done():
+	}
+	stop()
+
+	// Drain in-flight requests, then let a diff still running finish or abort before saving
+	shutdownTimeout := time.Duration(cfg.ShutdownTimeoutSeconds) * time.Second
+	if shutdownTimeout <= 0 {
+		shutdownTimeout = 30 * time.Second
+	}
+	slog.Info("Shutting down", "timeout", shutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	cancelRequests(diff.ErrShuttingDown)
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Requests still running at shutdown", "error", err)
+	}
+	// Shutdown doesn't track WebSocket connections, which were taken over
+	hub.Close()
+	scheduler.Stop(shutdownCtx)
+	if err := detector.Shutdown(shutdownCtx); err != nil {
+		slog.Warn("Diff still running at shutdown", "error", err)
+	}
+	for _, t := range accounts {
+		if err := t.Detector.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Diff still running at shutdown", "account", t.Name, "error", err)
+		}
+	}
+	if tenants != nil {
+		if err := tenants.Shutdown(shutdownCtx); err != nil {
+			slog.Warn("Diff still running at shutdown", "error", err)
+		}
+	}
+	// After the last run, so its changes are at least queued
+	notifier.Close(shutdownCtx)
+	slog.Info("Server stopped")
+}
+
+// secretsCheckInterval is how often the files of password_file and token_file
+// are checked for changes
+const secretsCheckInterval = 10 * time.Second
+
+// passwordPoll follows the password of a password_provider
+type passwordPoll struct {
+	provider secrets.Provider
+	interval time.Duration
+	// password is the one in use
+	password string
+	apply    func(password string)
+}
+
+// providerPassword returns the provider of p and the password it holds now
+func providerPassword(p *config.SecretProviderConfig) (secrets.Provider, string, error) {
+	provider, err := p.Provider()
+	if err != nil {
+		return nil, "", err
+	}
+	password, err := secrets.Fetch(context.Background(), provider)
+	if err != nil {
+		return nil, "", err
+	}
+	return provider, password, nil
+}
+
+// startPprof serves the net/http/pprof profiles under /debug/pprof/ on their
+// own listener, so they are never reachable through the API port
+func startPprof(addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+
+	slog.Info("Profiling endpoints enabled", "url", "http://"+addr+"/debug/pprof/")
+	go func() {
+		if err := http.ListenAndServe(addr, mux); err != nil {
+			slog.Error("Profiling server stopped", "error", err)
+		}
+	}()
+}
+
+// routeScopes is the API key scope each route needs; unlisted routes need admin
+var routeScopes = map[string]string{
+	"/health":       middleware.ScopePublic,
+	"/version":      middleware.ScopePublic,
+	"/healthz":      middleware.ScopePublic,
+	"/readyz":       middleware.ScopePublic,
+	"/openapi.json": middleware.ScopePublic,
+	"/metrics":      middleware.ScopeRead,
+	"/capabilities": middleware.ScopeRead,
+	"/diff":         middleware.ScopeRead,
+	// Cancelling a job leaves the state as it was
+	"/jobs/":         middleware.ScopeRead,
+	"/schedules":     middleware.ScopeRead,
+	"/ls":            middleware.ScopeRead,
+	"/history":       middleware.ScopeRead,
+	"/state/summary": middleware.ScopeRead,
+	"GET /snapshots": middleware.ScopeRead,
+	"/stat":          middleware.ScopeRead,
+	"/download":      middleware.ScopeRead,
+	"/preview":       middleware.ScopeRead,
+	"GET /trash":     middleware.ScopeRead,
+	// Acknowledging is part of consuming diffs
+	"/ack": middleware.ScopeRead,
+	"/ws":  middleware.ScopeRead,
+}
+
+// schedules returns the schedule of each directory with its own, and the
+// global schedule of the others
+func schedules(cfg *config.Config) []schedule.Schedule {
+	var result []schedule.Schedule
+	var unscheduled []string
+	for dir, dirCfg := range cfg.Directories {
+		if dirCfg.Schedule == "" {
+			unscheduled = append(unscheduled, dir)
+			continue
+		}
+		result = append(result, schedule.Schedule{Name: dir, Cron: dirCfg.Schedule, Directories: []string{dir}})
+	}
+	if cfg.Schedule != "" && len(unscheduled) > 0 {
+		sort.Strings(unscheduled)
+		result = append(result, schedule.Schedule{Name: "default", Cron: cfg.Schedule, Directories: unscheduled})
+	}
+	return result
+}
+
+// detectorSettings returns the detector settings of cfg that PUT /config can change
+func detectorSettings(cfg *config.Config) (diff.Settings, error) {
+	pathFilter, err := filter.New(cfg.Include, cfg.Exclude)
+	if err != nil {
+		return diff.Settings{}, fmt.Errorf("invalid include/exclude patterns: %w", err)
+	}
+	settings := diff.Settings{
+		Filter:        pathFilter,
+		MaxDepths:     make(map[string]int),
+		MoveDetection: make(map[string]diff.MoveDetection),
+	}
+	for dir, dirCfg := range cfg.Directories {
+		if dirCfg.MaxDepth > 0 {
+			settings.MaxDepths[dir] = dirCfg.MaxDepth
+		}
+		if md := dirCfg.MoveDetection; md != nil {
+			moves := diff.MoveDetection{
+				Mode:    md.Mode,
+				Window:  time.Duration(md.WindowSeconds) * time.Second,
+				MinSize: md.MinSize,
+			}
+			if err := moves.Validate(); err != nil {
+				return diff.Settings{}, fmt.Errorf("invalid move_detection of %s: %w", dir, err)
+			}
+			settings.MoveDetection[dir] = moves
+		}
+	}
+	return settings, nil
+}
+
+// checkStateDirs fails unless files can be created next to the state file of
+// every account, creating the directories that are missing
+func checkStateDirs(cfg *config.Config) error {
+	stateFiles := []string{cfg.StateFile}
+	for _, acc := range cfg.Accounts {
+		stateFiles = append(stateFiles, cfg.AccountStateFile(acc))
+	}
+	for _, stateFile := range stateFiles {
+		dir := filepath.Dir(stateFile)
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return fmt.Errorf("state_file %s: %w", stateFile, err)
+		}
+		f, err := os.CreateTemp(dir, ".write-check-*")
+		if err != nil {
+			return fmt.Errorf("state_file %s: directory %s is not writable: %w", stateFile, dir, err)
+		}
+		f.Close()
+		os.Remove(f.Name())
+	}
+	return nil
+}
+
+// checkConnection fetches the root of an account's files, explaining the
+// usual causes of a failure
+func checkConnection(client *webdav.Client) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
+	defer cancel()
+	_, err := client.WithContext(ctx).Stat("/")
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, webdav.ErrUnauthorized):
+		return fmt.Errorf("the server rejected username and password (Nextcloud needs an app password with two-factor authentication): %w", err)
+	case errors.Is(err, webdav.ErrNotFound):
+		return fmt.Errorf("no files at webdav_url for this user, check that it ends with /remote.php/dav and path_prefix: %w", err)
+	default:
+		return fmt.Errorf("failed to reach the server: %w", err)
+	}
+}
+
+// accountSettings returns the detector settings of an account of cfg, which
+// has directories of its own and the include and exclude patterns of cfg
+func accountSettings(cfg *config.Config, acc config.AccountConfig) (diff.Settings, error) {
+	accountCfg := *cfg
+	accountCfg.Directories = acc.Directories
+	return detectorSettings(&accountCfg)
+}
+
+// newClient returns a WebDAV client of an account on webdavURL, with the
+// client settings of cfg
+func newClient(cfg *config.Config, webdavURL, username, password string) *webdav.Client {
+	client := webdav.NewClient(webdavURL, username, password)
+	if cfg.PathPrefix != "" {
+		client.SetPathPrefix(cfg.PathPrefix)
+	}
+	if cfg.DisableSessionCookies {
+		client.SetSessionCookies(false)
+	}
+	if cfg.UploadChunkSize != 0 {
+		client.SetChunkSize(cfg.UploadChunkSize)
+	}
+	if cfg.CircuitBreakerThreshold != 0 || cfg.CircuitBreakerCooldownSeconds != 0 {
+		threshold := cfg.CircuitBreakerThreshold
+		if threshold == 0 {
+			threshold = 5
+		}
+		cooldown := time.Duration(cfg.CircuitBreakerCooldownSeconds) * time.Second
+		if cooldown == 0 {
+			cooldown = 30 * time.Second
+		}
+		client.SetCircuitBreaker(threshold, cooldown)
+	}
+	if cfg.LogWebDAVRequests {
+		client.AddRequestHook(func(info webdav.RequestInfo) {
+			slog.Info("WebDAV request", "method", info.Method, "path", info.Path, "status", info.Status, "duration", info.Duration,
+				"bytes_sent", info.BytesSent, "bytes_received", info.BytesReceived, "bytes_decoded", info.BytesDecoded)
+		})
+	}
+	return client
+}
+
+// secondsOr returns seconds as a duration, or fallback when it is not positive
+func secondsOr(seconds int, fallback time.Duration) time.Duration {
+	if seconds <= 0 {
+		return fallback
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+// fatal logs msg as an error and exits
+func fatal(msg string, args ...any) {
+	slog.Error(msg, args...)
+	os.Exit(1)
+}
+
+// newLogger builds the logger configured by log_level and log_format, which
+// the LOG_LEVEL and LOG_FORMAT environment variables override
+func newLogger(cfg *config.Config) (*slog.Logger, error) {
+	levelName := cfg.LogLevel
+	if env := os.Getenv("LOG_LEVEL"); env != "" {
+		levelName = env
+	}
+	format := cfg.LogFormat
+	if env := os.Getenv("LOG_FORMAT"); env != "" {
+		format = env
+	}
+
+	var level slog.Level
+	if levelName != "" {
+		if err := level.UnmarshalText([]byte(levelName)); err != nil {
+			return nil, fmt.Errorf("unknown log level %q (expected debug, info, warn or error)", levelName)
+		}
+	}
+	opts := &slog.HandlerOptions{Level: level}
+	switch format {
+	case "", "text":
+		return slog.New(slog.NewTextHandler(os.Stderr, opts)), nil
+	case "json":
+		return slog.New(slog.NewJSONHandler(os.Stderr, opts)), nil
+	default:
+		return nil, fmt.Errorf("unknown log format %q (expected text or json)", format)
+	}
+}
+
+// openStateStore opens the configured state backend at path
+func openStateStore(cfg *config.Config, path string, stateCipher *diff.StateCipher) (diff.StateStore, error) {
+	switch cfg.StateBackend {
+	case "", "json":
+		return diff.NewJSONStore(path, cfg.StateCompression == "gzip").Encrypt(stateCipher), nil
+	case "sharded":
+		return diff.NewShardedStore(path, cfg.StateCompression == "gzip").Encrypt(stateCipher), nil
+	case "bolt":
+		if stateCipher != nil {
+			return nil, fmt.Errorf("state encryption is not supported with the bolt backend")
+		}
+		return diff.OpenBoltStore(path)
+	default:
+		return nil, fmt.Errorf("unknown state_backend %q (expected \"json\", \"sharded\" or \"bolt\")", cfg.StateBackend)
+	}
+}
+
+// loadStateCipher returns the state cipher for the configured key, nil when encryption is off
+func loadStateCipher(cfg *config.Config) (*diff.StateCipher, error) {
+	var key []byte
+	switch {
+	case cfg.StateEncryptionKeyFile != "":
+		data, err := os.ReadFile(cfg.StateEncryptionKeyFile)
+		if err != nil {
+			return nil, err
+		}
+		key = data
+	case cfg.StateEncryptionKey != "":
+		key = []byte(cfg.StateEncryptionKey)
+	case os.Getenv("STATE_ENCRYPTION_KEY") != "":
+		key = []byte(os.Getenv("STATE_ENCRYPTION_KEY"))
+	default:
+		return nil, nil
+	}
+
+	key, err := diff.ParseStateKey(key)
+	if err != nil {
+		return nil, err
+	}
+	return diff.NewStateCipher(key)
 }